@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Set by -ACCESS_LOG and -SLOW_REQUEST.
+var (
+	accessLogOn bool
+	slowRequest time.Duration
+)
+
+// reqTrace collects what a request did on its way through the
+// coordinator, for the access log and the consistency headers.
+type reqTrace struct {
+	mu      sync.Mutex
+	key     string
+	peers   []peerTiming
+	need    int      // replicas the request waits for, counting this node
+	retries int      // replica reads re-sent to another peer after a failure
+	local   bool     // this node's copy was read or written
+	stamp   int64    // timestamp of the entry read or written, 0 for none
+	policy  string   // -UNREACHABLE_POLICY a W>1 write ran under
+	down    []string // peer=skipped|hinted, as that policy handled them
+}
+
+// peerTiming is one replication or replica read. For writes the time
+// includes the simulated per-follower delay, since that is the cost the
+// coordinator waits out.
+type peerTiming struct {
+	addr string
+	took time.Duration
+	ok   bool
+}
+
+type traceKey struct{}
+
+// traceOf returns the request's trace. It is nil for requests that did
+// not come through accessLog (e.g. RESP/memcached dispatch); reqTrace
+// methods accept a nil receiver.
+func traceOf(r *http.Request) *reqTrace {
+	t, _ := r.Context().Value(traceKey{}).(*reqTrace)
+	return t
+}
+
+func (t *reqTrace) setKey(key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.key = key
+	t.mu.Unlock()
+}
+
+// setQuorum records how many replicas, this node included, the request
+// needs.
+func (t *reqTrace) setQuorum(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.need = n
+	t.mu.Unlock()
+}
+
+// servedLocally records that the request read or wrote this node's copy.
+func (t *reqTrace) servedLocally() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.local = true
+	t.mu.Unlock()
+}
+
+// setEntry records the timestamp of the entry the request read or wrote.
+func (t *reqTrace) setEntry(ts int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.stamp = ts
+	t.mu.Unlock()
+}
+
+// setPolicy records the unreachable-peer policy a write runs under.
+func (t *reqTrace) setPolicy(p string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.policy = p
+	t.mu.Unlock()
+}
+
+// unreachable records what the write's policy did about peer.
+func (t *reqTrace) unreachable(peer, action string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.down = append(t.down, peer+"="+action)
+	t.mu.Unlock()
+}
+
+func (t *reqTrace) retried() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.retries++
+	t.mu.Unlock()
+}
+
+// peerAck records a replication to addr that started at start.
+func (t *reqTrace) peerAck(addr string, start time.Time, ok bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.peers = append(t.peers, peerTiming{addr, time.Since(start), ok})
+	t.mu.Unlock()
+}
+
+// contacted is how many replicas the request reached: this node, if its
+// copy was used, and every distinct peer asked.
+func (t *reqTrace) contacted() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seen := map[string]bool{}
+	if t.local {
+		seen[self] = true
+	}
+	for _, p := range t.peers {
+		seen[p.addr] = true
+	}
+	return len(seen)
+}
+
+// acks formats the peer timings as addr=took, marking failures.
+func (t *reqTrace) acks() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, len(t.peers))
+	for i, p := range t.peers {
+		parts[i] = fmt.Sprintf("%s=%s", p.addr, p.took.Round(time.Microsecond))
+		if !p.ok {
+			parts[i] += "(failed)"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// progress reports quorum progress as got/need, counting this node's
+// own copy, or "" when the request had no quorum to meet.
+func (t *reqTrace) progress() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.need == 0 {
+		return ""
+	}
+	got := 1
+	for _, p := range t.peers {
+		if p.ok {
+			got++
+		}
+	}
+	return fmt.Sprintf("%d/%d", min(got, t.need), t.need)
+}
+
+// accessLog attaches a reqTrace to every request and, with -ACCESS_LOG,
+// logs one line per request once it finishes. Requests slower than
+// -SLOW_REQUEST are logged in full either way.
+func accessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		tr := &reqTrace{key: r.URL.Query().Get("key")}
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), traceKey{}, tr)))
+		took := time.Since(start)
+		slow := slowRequest > 0 && took >= slowRequest
+		if !accessLogOn && !slow {
+			return
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		kind := "access"
+		if slow {
+			kind = "slow"
+		}
+		line := fmt.Sprintf("%s %s %s key=%q status=%d took=%s",
+			kind, r.Method, r.URL.Path, tr.key, rec.status, took.Round(time.Microsecond))
+		if acks := tr.acks(); acks != "" {
+			line += " acks=" + acks
+		}
+		if slow {
+			if p := tr.progress(); p != "" {
+				line += " quorum=" + p
+			}
+			line += fmt.Sprintf(" retries=%d query=%q", tr.retries, r.URL.RawQuery)
+		}
+		log.Print(line)
+	})
+}
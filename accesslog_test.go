@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogPeerTimings(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	accessLogOn = true
+	defer func() { accessLogOn = false }()
+
+	h := accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tr := traceOf(r)
+		start := time.Now().Add(-200 * time.Millisecond)
+		tr.peerAck("n1:8000", start, true)
+		tr.peerAck("n2:8000", start, false)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/set?key=a&value=1", nil))
+
+	line := out.String()
+	for _, want := range []string{"POST /set", `key="a"`, "status=201", "acks=n1:8000=200", "n2:8000=200"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("access log %q lacks %q", line, want)
+		}
+	}
+	if !strings.Contains(line, "(failed)") {
+		t.Errorf("access log %q does not mark the failed ack", line)
+	}
+}
+
+func TestSlowRequestLog(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	slowRequest = 20 * time.Millisecond
+	defer func() { slowRequest = 0 }()
+
+	h := accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tr := traceOf(r)
+		tr.setQuorum(3)
+		if r.URL.Query().Get("slow") != "" {
+			time.Sleep(30 * time.Millisecond)
+		}
+		tr.peerAck("n1:8000", time.Now(), false)
+		tr.retried()
+		tr.peerAck("n2:8000", time.Now(), true)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/get?key=fast", nil))
+	if out.Len() != 0 {
+		t.Fatalf("fast request logged: %q", out.String())
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/get?key=k&slow=1", nil))
+	line := out.String()
+	for _, want := range []string{"slow GET /get", "quorum=2/3", "retries=1", "n1:8000="} {
+		if !strings.Contains(line, want) {
+			t.Errorf("slow log %q lacks %q", line, want)
+		}
+	}
+}
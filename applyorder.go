@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replications from one coordinator can reach a follower out of order:
+// each is its own HTTP request, on its own connection and handler
+// goroutine. The coordinator therefore tags every /replicate with ?prev=,
+// the sequence number (see dedup.go) of the write it sent the same peer
+// before it, and the follower holds a replication back until the write
+// before it from that origin is applied, or for at most -APPLY_ORDER_WAIT
+// (default 250ms): prev may have failed or been abandoned, and then
+// nothing more is coming. Replications from an origin the follower has
+// not heard from since it started, and untagged ones, apply at once.
+
+var (
+	applyOrderWait = 250 * time.Millisecond // -APPLY_ORDER_WAIT (0 = apply on arrival)
+
+	orderedApplies atomic.Int64 // replications that waited and got their turn
+	orderTimeouts  atomic.Int64 // replications applied after giving up on prev
+
+	applied = struct {
+		sync.Mutex
+		byOrigin map[string]*applyQueue
+	}{byOrigin: map[string]*applyQueue{}}
+)
+
+// applyQueue is what a follower has applied from one origin.
+type applyQueue struct {
+	done    seqTracker
+	changed chan struct{} // closed, and replaced, whenever done grows
+}
+
+// nextSend records that e, coordinated here, is the next write sent to
+// peer and returns the sequence number of the one sent before it.
+func nextSend(peer string, e Entry) int64 {
+	if e.Seq == 0 {
+		return 0
+	}
+	ps := statusFor(peer)
+	ps.Lock()
+	defer ps.Unlock()
+	prev := ps.lastSent
+	ps.lastSent = e.Seq
+	return prev
+}
+
+// awaitTurn waits until the write numbered prevStr from origin is applied
+// here, -APPLY_ORDER_WAIT passes or ctx ends.
+func awaitTurn(ctx context.Context, origin, prevStr string) {
+	prev, err := strconv.ParseInt(prevStr, 10, 64)
+	if origin == "" || err != nil || prev <= 0 || applyOrderWait <= 0 {
+		return
+	}
+	var timeout <-chan time.Time
+	for {
+		applied.Lock()
+		q := applied.byOrigin[origin]
+		if q == nil || q.done.has(prev) {
+			applied.Unlock()
+			if timeout != nil {
+				orderedApplies.Add(1)
+			}
+			return
+		}
+		changed := q.changed
+		applied.Unlock()
+		if timeout == nil {
+			t := time.NewTimer(applyOrderWait)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			orderTimeouts.Add(1)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// markApplied records that the write numbered seqStr from origin has been
+// applied (or refused) here, letting the one sent after it go ahead.
+func markApplied(origin, seqStr string) {
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	if origin == "" || err != nil || seq <= 0 {
+		return
+	}
+	applied.Lock()
+	defer applied.Unlock()
+	q := applied.byOrigin[origin]
+	if q == nil {
+		q = &applyQueue{changed: make(chan struct{})}
+		applied.byOrigin[origin] = q
+	}
+	q.done.admit(seq)
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAwaitTurnWaitsForThePreviousWrite(t *testing.T) {
+	start := time.Now()
+	awaitTurn(context.Background(), "order@1", "4") // origin not heard from yet
+	if time.Since(start) > 50*time.Millisecond {
+		t.Fatal("waited for an unknown origin")
+	}
+
+	markApplied("order@1", "1")
+	order := make(chan string, 2)
+	go func() {
+		awaitTurn(context.Background(), "order@1", "2") // seq 3 arrives before 2
+		order <- "3"
+	}()
+	time.Sleep(50 * time.Millisecond)
+	order <- "2"
+	markApplied("order@1", "2")
+	if first, second := <-order, <-order; first != "2" || second != "3" {
+		t.Fatalf("applied %s before %s", first, second)
+	}
+
+	old := applyOrderWait
+	applyOrderWait = 30 * time.Millisecond
+	defer func() { applyOrderWait = old }()
+	timeouts := orderTimeouts.Load()
+	awaitTurn(context.Background(), "order@1", "9") // never arrives
+	if orderTimeouts.Load() != timeouts+1 {
+		t.Fatal("missing write did not time out")
+	}
+}
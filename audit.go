@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Writes, /config and the admin endpoints leave an audit record: when,
+// from which address and API token, which operation on which key, and the
+// status it got. The newest auditKeep records are kept in memory for
+// /admin/audit; with -AUDIT_LOG every record is also appended to a file as
+// one JSON line. Tokens (Authorization: Bearer ...) are recorded by
+// fingerprint, never in full.
+
+const auditKeep = 10000
+
+type auditRecord struct {
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"` // route path
+	Key    string    `json:"key,omitempty"`
+	Prefix string    `json:"prefix,omitempty"`
+	Remote string    `json:"remote"`
+	Token  string    `json:"token,omitempty"` // fingerprint, see tokenOf
+	Status int       `json:"status"`
+}
+
+var audit struct {
+	sync.Mutex
+	records []auditRecord // ring of the newest auditKeep
+	next    int
+	file    *os.File // -AUDIT_LOG, or nil
+}
+
+func openAuditLog(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	audit.file = f
+	return nil
+}
+
+// tokenOf returns the fingerprint of the request's bearer token, or "".
+func tokenOf(r *http.Request) string {
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || tok == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(tok))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// audited records every request to h once it has been answered.
+func audited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		q := r.URL.Query()
+		logAudit(auditRecord{
+			Time:   time.Now().UTC(),
+			Op:     r.URL.Path,
+			Key:    strings.Join(q["key"], ","),
+			Prefix: q.Get("prefix"),
+			Remote: remoteIP(r),
+			Token:  tokenOf(r),
+			Status: rec.status,
+		})
+	}
+}
+
+func logAudit(rec auditRecord) {
+	audit.Lock()
+	defer audit.Unlock()
+	if len(audit.records) < auditKeep {
+		audit.records = append(audit.records, rec)
+	} else {
+		audit.records[audit.next] = rec
+		audit.next = (audit.next + 1) % auditKeep
+	}
+	if audit.file != nil {
+		bs, _ := json.Marshal(rec)
+		if _, err := audit.file.Write(append(bs, '\n')); err != nil {
+			log.Printf("audit log: %v", err)
+		}
+	}
+}
+
+// auditHandler lists the kept records, oldest first, filtered by ?key=,
+// ?op=, ?token= and ?since= (RFC 3339), and capped to the last ?limit=.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "since", "since must be an RFC 3339 time")
+			return
+		}
+		since = t
+	}
+	limit := auditKeep
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			httpError(w, http.StatusBadRequest, "limit", "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+
+	audit.Lock()
+	ordered := append(append([]auditRecord{}, audit.records[audit.next:]...), audit.records[:audit.next]...)
+	audit.Unlock()
+	out := []auditRecord{}
+	for _, rec := range ordered {
+		if k := q.Get("key"); k != "" && rec.Key != k ||
+			q.Get("op") != "" && rec.Op != q.Get("op") ||
+			q.Get("token") != "" && rec.Token != q.Get("token") ||
+			rec.Time.Before(since) {
+			continue
+		}
+		out = append(out, rec)
+	}
+	if len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
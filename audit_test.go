@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuditTrail(t *testing.T) {
+	h := audited(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") == "bad" {
+			http.Error(w, "no", http.StatusPreconditionFailed)
+		}
+	})
+	for _, target := range []string{"/set?key=a&value=1", "/delete?key=bad", "/set?key=a&value=2"} {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		h(httptest.NewRecorder(), req)
+	}
+
+	rec := httptest.NewRecorder()
+	auditHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/audit?key=a&limit=1", nil))
+	if strings.Contains(rec.Body.String(), "s3cret") {
+		t.Fatalf("token recorded in full: %s", rec.Body)
+	}
+	var got []auditRecord
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if len(got) != 1 || got[0].Op != "/set" || got[0].Status != http.StatusOK || got[0].Token == "" || got[0].Remote == "" {
+		t.Fatalf("audit?key=a&limit=1 = %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	auditHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/audit?op=/delete", nil))
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if len(got) != 1 || got[0].Key != "bad" || got[0].Status != http.StatusPreconditionFailed {
+		t.Fatalf("audit?op=/delete = %+v", got)
+	}
+
+	// CRDT updates are writes like any other and leave a record too
+	port := 9190
+	n := startNode(t, port, nil, true, 1, 1, 1)
+	defer n.Process.Kill()
+	waitReady(t, port)
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/crdt/incr?key=hits&by=2", port), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/admin/audit?op=/crdt/incr", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if len(got) != 1 || got[0].Key != "hits" || got[0].Status != http.StatusCreated {
+		t.Fatalf("audit?op=/crdt/incr = %+v", got)
+	}
+}
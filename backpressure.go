@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// A follower with more than -REPLICATE_BUSY replications in flight answers
+// the next ones 503 without applying them, with Retry-After and an
+// X-KV-Busy hint: how many milliseconds its coordinator should hold off,
+// longer the deeper the backlog. The coordinator does not count such an
+// answer as a failure: it stops sending to that peer for the hinted time
+// and then tries again, within the write's budget and at most
+// maxBusyRetries times. /peers shows how often each peer was busy and
+// until when it is being held off.
+
+const (
+	busyHeader     = "X-KV-Busy"
+	busyBackoff    = 20 * time.Millisecond // hinted per replication over the limit
+	maxBusyBackoff = time.Second
+	maxBusyRetries = 20
+)
+
+var (
+	replicateBusyAt   int64 // -REPLICATE_BUSY (0 = never busy)
+	replicateInFlight atomic.Int64
+	busyAnswers       atomic.Int64
+
+	errPeerBusy = errors.New("peer stayed busy")
+)
+
+// backpressured answers busy instead of running h once too many
+// replications are in flight here.
+func backpressured(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := replicateInFlight.Add(1)
+		defer replicateInFlight.Add(-1)
+		if replicateBusyAt <= 0 || n <= replicateBusyAt {
+			h(w, r)
+			return
+		}
+		busyAnswers.Add(1)
+		hint := min(busyBackoff*time.Duration(n-replicateBusyAt), maxBusyBackoff)
+		w.Header().Set(busyHeader, strconv.FormatInt(hint.Milliseconds(), 10))
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, fmt.Sprintf("busy: %d replications in flight", n-1), http.StatusServiceUnavailable)
+	}
+}
+
+// busyHint reports whether resp is a busy answer and how long it asks the
+// sender to wait.
+func busyHint(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(resp.Header.Get(busyHeader), 10, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// notePeerBusy holds off sends to peer for d.
+func notePeerBusy(peer string, d time.Duration) {
+	ps := statusFor(peer)
+	ps.Lock()
+	defer ps.Unlock()
+	ps.busy++
+	if until := time.Now().Add(d); until.After(ps.busyUntil) {
+		ps.busyUntil = until
+	}
+}
+
+// awaitPeer waits out a busy hint from peer, reporting false if ctx ends
+// first.
+func awaitPeer(ctx context.Context, peer string) bool {
+	ps := statusFor(peer)
+	ps.Lock()
+	wait := time.Until(ps.busyUntil)
+	ps.Unlock()
+	return wait <= 0 || pause(ctx, wait)
+}
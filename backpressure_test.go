@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBusyFollowerAndBackingOffCoordinator(t *testing.T) {
+	oldBusy := replicateBusyAt
+	defer func() { replicateBusyAt = oldBusy }()
+	replicateBusyAt = 1
+
+	// a second replication while one is in flight is answered busy
+	unblock, running := make(chan struct{}), make(chan struct{})
+	h := backpressured(func(w http.ResponseWriter, r *http.Request) {
+		close(running)
+		<-unblock
+	})
+	go h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/replicate", nil))
+	<-running
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/replicate", nil))
+	close(unblock)
+	if d, busy := busyHint(rec.Result()); rec.Code != http.StatusServiceUnavailable || !busy || d != busyBackoff {
+		t.Fatalf("second replication = %d, hint %q", rec.Code, rec.Header().Get(busyHeader))
+	}
+
+	// the coordinator waits and retries rather than failing the write
+	var calls atomic.Int32
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.Header().Set(busyHeader, "5")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer peer.Close()
+	addr := strings.TrimPrefix(peer.URL, "http://")
+	if !replicateWithin(context.Background(), addr, "k", Entry{Value: "v", Timestamp: 1}) {
+		t.Fatal("replication to a busy peer failed")
+	}
+	ps := statusFor(addr)
+	ps.Lock()
+	defer ps.Unlock()
+	if calls.Load() != 3 || ps.busy != 2 || ps.replFailed != 0 || ps.replOK != 1 {
+		t.Fatalf("%d calls, %d busy, %d failed, %d ok", calls.Load(), ps.busy, ps.replFailed, ps.replOK)
+	}
+}
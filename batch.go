@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -BATCH_WINDOW=5ms has the leader replicate in rounds instead of one
+// /replicate per write and follower. The first write queued for a follower
+// opens a window; every write to it that arrives within the window goes
+// out with it as one /replicate_batch, which pays the simulated per-round
+// delay (LeaderDelayPerFollower) and the follower's apply sleep once for
+// the whole batch, and writes queued while a round is in flight make up
+// the next. Each write still waits for its own W acks, now from the rounds
+// carrying it, sent to every follower at once rather than one after the
+// other, so a write's latency grows by at most the window while a busy
+// leader sends far fewer requests. Followers that predate batching
+// (protocolBatches) are sent each write on its own. Leaderless, per-DC and
+// primary-backup writes are not batched.
+
+const batchMaxEntries = 512 // entries sent in one round at most
+
+var (
+	batchWindow time.Duration // -BATCH_WINDOW, 0 = off
+
+	batchBatches atomic.Int64 // /replicate_batch rounds sent
+	batchEntries atomic.Int64 // entries they carried
+)
+
+// batchItem is one write queued for a follower; done gets its answer.
+type batchItem struct {
+	key  string
+	e    Entry
+	done func(ok bool)
+}
+
+// batchEntry is a batchItem on the wire.
+type batchEntry struct {
+	Key   string `json:"key"`
+	Entry Entry  `json:"entry"`
+	Seq   int64  `json:"seq,omitempty"`
+}
+
+// peerBatcher queues writes for one follower; run sends them while any
+// are queued.
+type peerBatcher struct {
+	sync.Mutex
+	peer    string
+	pending []batchItem
+	running bool
+}
+
+var batchers sync.Map // follower address -> *peerBatcher
+
+// replicateBatched queues key's write for peer and calls done with its
+// answer once the round carrying it is acked or has failed.
+func replicateBatched(peer, key string, e Entry, done func(ok bool)) {
+	if peerProtocolFor(peer) < protocolBatches {
+		go func() { done(replicateTo(peer, key, e)) }()
+		return
+	}
+	v, _ := batchers.LoadOrStore(peer, &peerBatcher{peer: peer})
+	b := v.(*peerBatcher)
+	b.Lock()
+	b.pending = append(b.pending, batchItem{key, e, done})
+	start := !b.running
+	b.running = true
+	b.Unlock()
+	if start {
+		go b.run()
+	}
+}
+
+// run waits out the window, then sends rounds until nothing is queued.
+func (b *peerBatcher) run() {
+	nodeClock.Sleep(batchWindow)
+	for {
+		b.Lock()
+		n := min(len(b.pending), batchMaxEntries)
+		if n == 0 {
+			b.running = false
+			b.Unlock()
+			return
+		}
+		items := b.pending[:n:n]
+		b.pending = b.pending[n:]
+		b.Unlock()
+
+		nodeClock.Sleep(LeaderDelayPerFollower)
+		ok := sendBatch(b.peer, items)
+		for _, it := range items {
+			it.done(ok)
+		}
+	}
+}
+
+// sendBatch replicates items to peer in one request, in the order they
+// were queued.
+func sendBatch(peer string, items []batchItem) bool {
+	body := make([]batchEntry, len(items))
+	q := url.Values{}
+	q.Set("epoch", strconv.FormatInt(currentEpoch.Load(), 10))
+	q.Set("from", self)
+	for i, it := range items {
+		bare := it.e
+		bare.Siblings = nil // not sent
+		bare.Checksum = bare.sum(it.key)
+		body[i] = batchEntry{Key: it.key, Entry: bare, Seq: it.e.Seq}
+		if prev := nextSend(peer, it.e); i == 0 && prev > 0 {
+			q.Set("prev", strconv.FormatInt(prev, 10))
+		}
+		if it.e.Sync {
+			q.Set("durability", durabilityFsync)
+		}
+	}
+	if items[0].e.Seq > 0 {
+		q.Set("origin", originID())
+	}
+	bs, _ := json.Marshal(body)
+	if !awaitPeer(context.Background(), peer) {
+		return false
+	}
+	batchBatches.Add(1)
+	batchEntries.Add(int64(len(items)))
+	if err := postBatch(peer, "/replicate_batch?"+q.Encode(), bs); err != nil {
+		noteReplicationFailed(peer, err)
+		return false
+	}
+	noteReplicated(peer, items[len(items)-1].e.Timestamp)
+	return true
+}
+
+// batchedAcks queues key's write for every target and counts acks, on top
+// of acks already had, until there are wq of them, every target has
+// answered or ctx ends. Answers arriving after it returns still reach ws.
+func batchedAcks(ctx context.Context, tr *reqTrace, ws *writeStatus, targets []string, key string, e Entry, acks, wq int) int {
+	type answer struct {
+		peer string
+		ok   bool
+	}
+	start := time.Now()
+	answers := make(chan answer, len(targets))
+	for _, p := range targets {
+		replicateBatched(p, key, e, func(ok bool) {
+			ws.replicated(p, ok)
+			answers <- answer{p, ok}
+		})
+	}
+	for range targets {
+		if acks >= wq {
+			break
+		}
+		select {
+		case a := <-answers:
+			tr.peerAck(a.peer, start, a.ok)
+			if a.ok {
+				acks++
+			}
+		case <-ctx.Done():
+			return acks
+		}
+	}
+	return acks
+}
+
+// replicateBatchHandler applies a leader's round of writes in order, under
+// the same epoch, source, ordering and duplicate checks as /replicate.
+func replicateBatchHandler(w http.ResponseWriter, r *http.Request) {
+	epoch, err := parseEpoch(r)
+	if err != nil {
+		http.Error(w, "invalid epoch", http.StatusBadRequest)
+		return
+	}
+	newer := epoch > currentEpoch.Load()
+	if !observeEpoch(epoch) {
+		rejectStaleEpoch(w)
+		return
+	}
+	if err := checkReplicationSource(r, newer); err != nil {
+		httpError(w, http.StatusForbidden, "from", err.Error())
+		return
+	}
+	var batch []batchEntry
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, "invalid batch body", http.StatusBadRequest)
+		return
+	}
+	for _, be := range batch {
+		if be.Key == "" {
+			http.Error(w, "batch entry without a key", http.StatusBadRequest)
+			return
+		}
+		if err := checkReceived(be.Key, be.Entry); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	origin := r.URL.Query().Get("origin")
+	awaitTurn(r.Context(), origin, r.URL.Query().Get("prev"))
+
+	var applied []string
+	defer func() {
+		for _, seq := range applied {
+			markApplied(origin, seq)
+		}
+	}()
+	fresh := batch[:0]
+	for _, be := range batch {
+		seq := ""
+		if be.Seq > 0 {
+			seq = strconv.FormatInt(be.Seq, 10)
+		}
+		seen, err := seenWrite(origin, seq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !seen {
+			fresh = append(fresh, be)
+			applied = append(applied, seq)
+		}
+	}
+
+	nodeClock.Sleep(FollowerUpdateSleep)
+	svc.Lock()
+	for _, be := range fresh {
+		cur, ok := svc.intactCopy(be.Key)
+		if merged, changed := mergeEntry(be.Key, cur, ok, be.Entry); changed {
+			svc.put(be.Key, merged)
+		}
+	}
+	svc.Unlock()
+	if err := syncReplica(r); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, seq := range applied {
+		commitWrite(origin, seq)
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchWindow_GroupsLeaderWrites(t *testing.T) {
+	p1, p2, p3 := 9169, 9170, 9171
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	a := startNode(t, p1, []string{addr(p2), addr(p3)}, true, 3, 1, 3, "-BATCH_WINDOW=20ms")
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{addr(p1), addr(p3)}, false, 3, 1, 3)
+	defer b.Process.Kill()
+	c := startNode(t, p3, []string{addr(p1), addr(p2)}, false, 3, 1, 3)
+	defer c.Process.Kill()
+	waitReady(t, p1, p2, p3)
+
+	// unbatched, each of these waits out both followers one after the
+	// other; batched, they share a few rounds
+	const writes = 30
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range writes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(fmt.Sprintf("http://%s/set?key=b/%d&value=v%d", addr(p1), i, i), "", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusCreated {
+				t.Errorf("write %d = %d, want 201", i, resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("%d W=3 writes took %v", writes, took)
+	}
+
+	for _, p := range []int{p2, p3} {
+		for i := range writes {
+			e, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=b/%d", addr(p), i))
+			if code != http.StatusOK || e.Value != fmt.Sprintf("v%d", i) {
+				t.Fatalf("follower %d: b/%d = %d %q", p, i, code, e.Value)
+			}
+		}
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", addr(p1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	m := regexp.MustCompile(`kv_replication_batches_total (\d+)`).FindSubmatch(body)
+	if m == nil {
+		t.Fatal("metrics missing kv_replication_batches_total")
+	}
+	if n, _ := strconv.Atoi(string(m[1])); n == 0 || n >= 2*writes {
+		t.Errorf("%d rounds for %d writes to 2 followers", n, writes)
+	}
+}
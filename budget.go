@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A synchronous write (W>1, leaderless, LOCAL_QUORUM / EACH_QUORUM) must
+// collect its acks within -WRITE_TIMEOUT. Once the budget is spent the
+// replication in flight is cancelled, the peers not yet tried are skipped
+// (a leaderless write has tried them all and lets them finish), and the
+// client gets 504 with the acks collected so far in X-Acks; the
+// write stays applied wherever it got, as with any failed quorum.
+//
+// A failed quorum's body says where the write got: the replicas that
+// accepted it, the ones that failed, were skipped or are still pending,
+// and its timestamp, so the client can retry it, read-repair the key or
+// accept the copies it has.
+
+// writeTimeout is the budget, set by -WRITE_TIMEOUT (0 = unbounded).
+var writeTimeout = 2 * time.Second
+
+const acksHeader = "X-Acks"
+
+// writeBudget starts the clock on one write.
+func writeBudget() (context.Context, context.CancelFunc) {
+	if writeTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), writeTimeout)
+}
+
+// pause sleeps for d, or until ctx ends, reporting whether time is left.
+func pause(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-nodeClock.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// partialWrite is the body of a write that missed its quorum.
+type partialWrite struct {
+	apiError
+	WriteID    string   `json:"write_id"`
+	Key        string   `json:"key"`
+	Timestamp  int64    `json:"timestamp"`
+	Acks       int      `json:"acks"`
+	Required   int      `json:"required"`
+	Accepted   []string `json:"accepted"` // this node included
+	Failed     []string `json:"failed"`
+	Skipped    []string `json:"skipped,omitempty"`
+	Pending    []string `json:"pending,omitempty"`     // may still accept it
+	RolledBack []string `json:"rolled_back,omitempty"` // sent an undo, see rollback.go
+}
+
+// quorumFailed answers write e that collected acks of the need it wanted:
+// 504 if the budget ran out, 500 otherwise.
+func quorumFailed(w http.ResponseWriter, ctx context.Context, ws *writeStatus, e Entry, acks, need int) {
+	w.Header().Set(acksHeader, strconv.Itoa(acks))
+	body := partialWrite{
+		apiError:  apiError{Status: http.StatusInternalServerError, Error: "write quorum not met"},
+		WriteID:   ws.ID,
+		Key:       ws.Key,
+		Timestamp: e.Timestamp,
+		Acks:      acks,
+		Required:  need,
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		body.Status = http.StatusGatewayTimeout
+		body.Error = fmt.Sprintf("write quorum not met: %d of %d acks within %s", acks, need, writeTimeout)
+	}
+	byState := ws.replicasIn()
+	body.Accepted, body.Failed = byState[replicaAcked], byState[replicaFailed]
+	body.Skipped, body.Pending = byState[replicaSkipped], byState[replicaPending]
+	if body.Failed == nil {
+		body.Failed = []string{}
+	}
+	body.RolledBack = rollbackWrite(ws, e)
+	bs, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(body.Status)
+	w.Write(append(bs, '\n'))
+}
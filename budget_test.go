@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTimeoutBudget(t *testing.T) {
+	p1 := 9122
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer hung.Close()
+	a := startNode(t, p1, []string{strings.TrimPrefix(hung.URL, "http://")}, true, 2, 1, 2, "-WRITE_TIMEOUT", "500ms", "-STARTUP_SYNC", "0") // the hung peer would stall the startup sync
+	defer a.Process.Kill()
+	waitReady(t, p1)
+
+	start := time.Now()
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/set?key=k&value=v", p1), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if took := time.Since(start); took > 2*time.Second {
+		t.Fatalf("write took %s past a 500ms budget", took)
+	}
+	if resp.StatusCode != http.StatusGatewayTimeout || resp.Header.Get(acksHeader) != "1" {
+		t.Fatalf("status %d, X-Acks %q; want 504 with 1 ack", resp.StatusCode, resp.Header.Get(acksHeader))
+	}
+}
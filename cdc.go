@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -CDC=nats://host:4222/subject publishes every write this node
+// coordinated, once it is in the local store, to a NATS subject as JSON,
+// so downstream systems can follow the cluster's changes. Each write is
+// published by its coordinator only, so a consumer sees it once however
+// many replicas apply it. Delivery is best effort: a consumer may see a
+// write twice when the coordinator rewrites its own entry (e.g. a soft
+// delete expiring), and lose one sent as the connection dropped. Kafka is
+// not spoken directly; bridge the NATS subject to a topic.
+//
+// The NATS client protocol is plain text: the server greets with INFO, the
+// client sends CONNECT and then PUB <subject> <bytes>, and answers the
+// server's PING with PONG.
+
+const cdcBuffer = 4096
+
+// cdcEvent is what each message carries.
+type cdcEvent struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+	Origin    string `json:"origin"` // coordinating node
+	Deleted   bool   `json:"deleted,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Expired   bool   `json:"expired,omitempty"` // the delete is a ?ttl= running out, see ttl.go
+}
+
+// eventOf is the event for change c.
+func eventOf(c Change) cdcEvent {
+	e := c.Entry
+	return cdcEvent{c.Key, e.Value, e.Timestamp, e.Node, e.Deleted, e.Type, e.Deleted && e.Expires != 0}
+}
+
+var cdcPublished, cdcFailed atomic.Int64
+
+type natsPublisher struct {
+	addr, subject string
+
+	mu   sync.Mutex // serializes writes to conn
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+func parseCDC(spec string) (*natsPublisher, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("-CDC %q: want nats://host:port/subject", spec)
+	}
+	subject := strings.TrimPrefix(u.Path, "/")
+	if subject == "" {
+		subject = "kv.changes"
+	}
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("-CDC subject %q has whitespace", subject)
+	}
+	return &natsPublisher{addr: u.Host, subject: subject}, nil
+}
+
+// startCDC publishes this node's writes to spec until the process exits.
+func startCDC(spec string) error {
+	p, err := parseCDC(spec)
+	if err != nil {
+		return err
+	}
+	_, feed := changes.subscribe(cdcBuffer)
+	go p.run(feed)
+	return nil
+}
+
+func (p *natsPublisher) run(feed <-chan Change) {
+	backoff := 100 * time.Millisecond
+	for c := range feed {
+		if c.Entry.Node != self {
+			continue // replicated here; its coordinator publishes it
+		}
+		msg, _ := json.Marshal(eventOf(c))
+		for {
+			err := p.publish(msg)
+			if err == nil {
+				cdcPublished.Add(1)
+				backoff = 100 * time.Millisecond
+				break
+			}
+			cdcFailed.Add(1)
+			log.Printf("cdc: publishing %q to %s: %v; retrying in %s", c.Key, p.addr, err, backoff)
+			p.close()
+			time.Sleep(backoff)
+			backoff = min(2*backoff, 5*time.Second)
+		}
+	}
+}
+
+func (p *natsPublisher) publish(msg []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if err := p.connectLocked(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(p.w, "PUB %s %d\r\n", p.subject, len(msg))
+	p.w.Write(msg)
+	p.w.WriteString("\r\n")
+	return p.w.Flush()
+}
+
+// connectLocked dials the server and completes the handshake. The caller
+// holds p.mu.
+func (p *natsPublisher) connectLocked() error {
+	conn, err := net.DialTimeout("tcp", p.addr, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("no INFO from server: %q %v", line, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	hello, _ := json.Marshal(map[string]any{"verbose": false, "pedantic": false, "name": "kv " + self})
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\n", hello)
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	p.conn, p.w = conn, w
+	go p.readLoop(conn, r)
+	return nil
+}
+
+// readLoop answers the server's keep-alive PINGs and logs its errors.
+func (p *natsPublisher) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			p.closeConn(conn)
+			return
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			p.mu.Lock()
+			if p.conn == conn {
+				p.w.WriteString("PONG\r\n")
+				p.w.Flush()
+			}
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("cdc: server %s: %s", p.addr, line)
+		}
+	}
+}
+
+func (p *natsPublisher) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+// closeConn drops conn if it is still the current connection.
+func (p *natsPublisher) closeConn(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == conn {
+		conn.Close()
+		p.conn = nil
+	}
+}
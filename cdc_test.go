@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS accepts one client and sends every published payload to msgs.
+func fakeNATS(t *testing.T, msgs chan<- string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\nPING\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "PUB kv.test ") {
+				payload, _ := r.ReadString('\n')
+				msgs <- strings.TrimSpace(payload)
+			}
+		}
+	}()
+	return ln
+}
+
+func TestCDCPublishesOwnWrites(t *testing.T) {
+	msgs := make(chan string, 4)
+	ln := fakeNATS(t, msgs)
+	defer ln.Close()
+	p, err := parseCDC("nats://" + ln.Addr().String() + "/kv.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed := make(chan Change, 2)
+	go p.run(feed)
+	feed <- Change{Key: "from-peer", Entry: Entry{Value: "x", Node: "elsewhere:1"}}
+	feed <- Change{Key: "k", Entry: Entry{Value: "v", Timestamp: 7, Node: self}}
+	close(feed)
+
+	select {
+	case m := <-msgs:
+		var ev cdcEvent
+		if err := json.Unmarshal([]byte(m), &ev); err != nil || ev != (cdcEvent{Key: "k", Value: "v", Timestamp: 7, Origin: self}) {
+			t.Fatalf("published %s", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing published")
+	}
+}
+
+func TestParseCDC(t *testing.T) {
+	if p, err := parseCDC("nats://localhost:4222"); err != nil || p.subject != "kv.changes" {
+		t.Fatalf("default subject: %+v %v", p, err)
+	}
+	for _, bad := range []string{"kafka://localhost:9092/t", "nats:///x", "localhost:4222"} {
+		if _, err := parseCDC(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
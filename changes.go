@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Change is one entry applied to the local store, whether written here or
+// received through replication.
+type Change struct {
+	Key   string
+	Entry Entry
+}
+
+// changeFeed fans local store changes out to subscribers. Publishing never
+// blocks: a subscriber whose buffer is full misses the change.
+type changeFeed struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]chan Change
+
+	// revision is the newest timestamp applied locally.
+	revision atomic.Int64
+
+	// recent holds the last recentChanges changes, oldest first.
+	recent []Change
+}
+
+// recentChanges is how many changes the feed keeps for the dashboard.
+const recentChanges = 20
+
+var changes = &changeFeed{subs: make(map[int]chan Change)}
+
+// subscribe registers a subscriber with room for buf pending changes.
+func (f *changeFeed) subscribe(buf int) (int, <-chan Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	ch := make(chan Change, buf)
+	f.subs[f.nextID] = ch
+	return f.nextID, ch
+}
+
+func (f *changeFeed) unsubscribe(id int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ch, ok := f.subs[id]; ok {
+		close(ch)
+		delete(f.subs, id)
+	}
+}
+
+func (f *changeFeed) publish(key string, e Entry) {
+	for cur := f.revision.Load(); e.Timestamp > cur; cur = f.revision.Load() {
+		if f.revision.CompareAndSwap(cur, e.Timestamp) {
+			break
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.recent) == recentChanges {
+		f.recent = append(f.recent[:0], f.recent[1:]...)
+	}
+	f.recent = append(f.recent, Change{Key: key, Entry: e})
+	for id, ch := range f.subs {
+		select {
+		case ch <- Change{Key: key, Entry: e}:
+		default:
+			log.Printf("change feed subscriber %d is behind, dropped change to %q", id, key)
+		}
+	}
+}
+
+// forget drops the recent changes to keys drop matches.
+func (f *changeFeed) forget(drop func(key string) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.recent = slices.DeleteFunc(f.recent, func(c Change) bool { return drop(c.Key) })
+}
+
+// latest returns the most recent changes, newest first.
+func (f *changeFeed) latest() []Change {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]Change, len(f.recent))
+	for i, c := range f.recent {
+		out[len(out)-1-i] = c
+	}
+	return out
+}
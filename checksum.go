@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// Every stored entry carries a CRC-32C of its key and contents, set by
+// Store.put. Replication, catch-up, dumps and replica reads carry it
+// along and the receiver checks it before merging, so an entry mangled on
+// the wire is refused rather than spread. A local copy that no longer
+// matches its checksum is treated as missing: reads fetch the key again
+// from the other replicas and store what they hold in its place. A zero
+// checksum means "not checked", for entries from nodes that predate it.
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// corruptionCounters counts checksum failures of local copies and of
+// entries received from peers, and how re-fetches of corrupt local copies
+// went.
+type corruptionCounters struct {
+	local, received          atomic.Int64
+	refetched, refetchFailed atomic.Int64
+}
+
+var corruption corruptionCounters
+
+// unsealed returns e with its checksum, and its siblings', cleared.
+func (e Entry) unsealed() Entry {
+	e.Checksum = 0
+	if len(e.Siblings) > 0 {
+		sibs := make([]Entry, len(e.Siblings))
+		for i, s := range e.Siblings {
+			sibs[i] = s.unsealed()
+		}
+		e.Siblings = sibs
+	}
+	return e
+}
+
+// sum is the checksum of key and e's contents.
+func (e Entry) sum(key string) uint32 {
+	bs, _ := json.Marshal(e.unsealed())
+	return crc32.Checksum(append(append([]byte(key), 0), bs...), castagnoli)
+}
+
+// intact reports whether e still matches its checksum.
+func (e Entry) intact(key string) bool {
+	return e.Checksum == 0 || e.Checksum == e.sum(key)
+}
+
+// checkReceived verifies an entry that arrived from a peer.
+func checkReceived(key string, e Entry) error {
+	if e.intact(key) {
+		return nil
+	}
+	corruption.received.Add(1)
+	return fmt.Errorf("checksum mismatch for %q", key)
+}
+
+// intactCopy returns the local entry for key, treating one that fails its
+// checksum as missing. The caller holds the lock.
+func (s *Store) intactCopy(key string) (Entry, bool) {
+	e, ok := s.data[key]
+	if ok && !e.intact(key) {
+		corruption.local.Add(1)
+		log.Printf("local copy of %q fails its checksum", key)
+		return Entry{}, false
+	}
+	return e, ok
+}
+
+// localCopy reads key from this node. A corrupt copy is replaced by what
+// the other replicas hold, if any of them has the key.
+func localCopy(key string) (Entry, bool) {
+	svc.RLock()
+	e, ok := svc.data[key]
+	intact := !ok || e.intact(key)
+	if ok && intact {
+		wal.reseal(key, e) // lazily, after a key rotation
+	}
+	svc.RUnlock()
+	if intact {
+		return e, ok
+	}
+	corruption.local.Add(1)
+	log.Printf("local copy of %q fails its checksum; fetching it from the replicas", key)
+	return refetch(key)
+}
+
+// refetch merges every peer's copy of key into the local store in place of
+// a corrupt one.
+func refetch(key string) (Entry, bool) {
+	var best Entry
+	have := false
+	for _, p := range cluster().peers {
+		e, found, err := fetchReplica(p, key)
+		if err != nil || !found {
+			continue
+		}
+		best, _ = mergeEntry(key, best, have, e)
+		have = true
+	}
+	if !have {
+		corruption.refetchFailed.Add(1)
+		return Entry{}, false
+	}
+	repairLocal(key, best)
+	corruption.refetched.Add(1)
+	return best, true
+}
+
+// checkQueryChecksum verifies the ?crc= a peer sent with an entry encoded
+// by entryQuery.
+func checkQueryChecksum(r *http.Request, key string, e Entry) error {
+	v := r.URL.Query().Get("crc")
+	if v == "" {
+		return nil
+	}
+	crc, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid crc %q", v)
+	}
+	e.Checksum = uint32(crc)
+	return checkReceived(key, e)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChecksumCoversKeyAndContents(t *testing.T) {
+	e := Entry{Value: "v", Timestamp: 1, Node: "n1"}
+	e.Checksum = e.sum("k")
+	if !e.intact("k") {
+		t.Fatal("freshly sealed entry fails its checksum")
+	}
+	if e.intact("other") {
+		t.Fatal("checksum does not cover the key")
+	}
+	bad := e
+	bad.Value = "w"
+	if bad.intact("k") {
+		t.Fatal("checksum does not cover the value")
+	}
+	if !(Entry{Value: "w"}).intact("k") {
+		t.Fatal("an entry without a checksum should pass unchecked")
+	}
+}
+
+func TestCorruptLocalCopyIsRefetched(t *testing.T) {
+	peer := startNode(t, 9117, nil, true, 1, 1, 1)
+	defer peer.Process.Kill()
+	waitReady(t, 9117)
+	resp, err := http.Post("http://localhost:9117/set?key=k&value=good", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// a replicated write whose contents do not match its crc is refused
+	resp, err = http.Post("http://localhost:9117/replicate?key=k&value=evil&timestamp=1&epoch=1&crc=12345", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("replicate with a bad crc = %d, want 400", resp.StatusCode)
+	}
+
+	oldData := svc.data
+	defer func() { svc.data = oldData }()
+	withCluster(t, func(c *membership) { c.peers = []string{"localhost:9117"} })
+	svc.data = map[string]Entry{}
+	svc.put("k", Entry{Value: "stale", Timestamp: 1})
+	e := svc.data["k"]
+	e.Value = "b1t-fl1pped"
+	e.Timestamp = time.Now().Add(time.Hour).UnixNano()
+	svc.data["k"] = e
+
+	before := corruption.refetched.Load()
+	got, ok := readKey(nil, "k", 1)
+	if !ok || got.Value != "good" {
+		t.Fatalf("read of corrupt copy = %+v, %v; want the peer's", got, ok)
+	}
+	if corruption.refetched.Load() != before+1 {
+		t.Fatal("refetch not counted")
+	}
+	if cur := svc.data["k"]; cur.Value != "good" || !cur.intact("k") {
+		t.Fatalf("local copy after refetch = %+v", cur)
+	}
+}
@@ -0,0 +1,471 @@
+// Package client is a Go client for the replicated key-value service.
+//
+//	c := client.New("localhost:8000", "localhost:8001", "localhost:8002")
+//	err := c.Set(ctx, "username", "Alice")
+//	e, err := c.Get(ctx, "username", client.ReadQuorum(3))
+//
+// Writes are sent to the leader, which the client discovers by following
+// the X-Leader hints nodes return when they refuse a write. Reads go to any
+// endpoint. Connection failures and 503s are retried on the next endpoint.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by Get when the key does not exist.
+	ErrNotFound = errors.New("client: key not found")
+	// ErrQuorum is returned when the coordinator could not reach its quorum,
+	// wrapped in a *PartialWriteError saying where the write got.
+	ErrQuorum = errors.New("client: quorum not met")
+)
+
+// Entry is a stored value, the timestamp it was written at and the node
+// that coordinated the write.
+type Entry struct {
+	Value     string `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+	Deleted   bool   `json:"deleted,omitempty"` // a sibling may be a delete
+	Node      string `json:"node,omitempty"`    // node that coordinated the write
+	Type      string `json:"type,omitempty"`    // CRDT type when Value holds CRDT state
+
+	// Siblings are concurrent versions kept by a node running the siblings
+	// conflict resolver. To settle them, write the reconciled value with
+	// the Resolves(e.Context) option.
+	Siblings []Entry `json:"siblings,omitempty"`
+	// Context is the causal context token returned by Get.
+	Context string `json:"-"`
+}
+
+// Versions returns e and its siblings.
+func (e Entry) Versions() []Entry {
+	head := e
+	head.Siblings = nil
+	return append([]Entry{head}, e.Siblings...)
+}
+
+// Client talks to a cluster through a fixed set of endpoints.
+type Client struct {
+	HTTPClient *http.Client
+	Retries    int           // extra attempts after the first
+	Backoff    time.Duration // pause between attempts
+
+	endpoints []string
+
+	mu     sync.Mutex
+	leader string
+	next   int
+}
+
+// New returns a client for the given host:port (or http://host:port)
+// endpoints.
+func New(endpoints ...string) *Client {
+	c := &Client{
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Retries:    3,
+		Backoff:    100 * time.Millisecond,
+	}
+	for _, e := range endpoints {
+		c.endpoints = append(c.endpoints, normalize(e))
+	}
+	return c
+}
+
+func normalize(endpoint string) string {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	return strings.TrimRight(endpoint, "/")
+}
+
+// CallOption tunes a single request.
+type CallOption func(url.Values)
+
+// Consistency requests a per-datacenter write level such as
+// "LOCAL_QUORUM" or "EACH_QUORUM".
+func Consistency(level string) CallOption {
+	return func(q url.Values) { q.Set("consistency", level) }
+}
+
+// ReadQuorum overrides the node's R for one read.
+func ReadQuorum(r int) CallOption {
+	return func(q url.Values) { q.Set("R", strconv.Itoa(r)) }
+}
+
+// Resolves marks a write as descending from the versions Get returned
+// with this context token, replacing them rather than adding a sibling.
+func Resolves(context string) CallOption {
+	return func(q url.Values) {
+		if context != "" {
+			q.Set("context", context)
+		}
+	}
+}
+
+// Set stores value under key.
+func (c *Client) Set(ctx context.Context, key, value string, opts ...CallOption) error {
+	q := url.Values{"key": {key}, "value": {value}}
+	_, err := c.write(ctx, "/set", q, opts)
+	return err
+}
+
+// Delete removes key.
+func (c *Client) Delete(ctx context.Context, key string, opts ...CallOption) error {
+	_, err := c.write(ctx, "/delete", url.Values{"key": {key}}, opts)
+	return err
+}
+
+// CAS sets key to value only if its current value is expected, reporting
+// whether the swap happened.
+func (c *Client) CAS(ctx context.Context, key, expected, value string, opts ...CallOption) (bool, error) {
+	q := url.Values{"key": {key}, "value": {value}, "expected": {expected}}
+	code, err := c.write(ctx, "/cas", q, opts)
+	if code == http.StatusPreconditionFailed {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Get reads key from any endpoint.
+func (c *Client) Get(ctx context.Context, key string, opts ...CallOption) (Entry, error) {
+	q := url.Values{"key": {key}}
+	for _, o := range opts {
+		o(q)
+	}
+	var e Entry
+	err := c.retry(ctx, func() (bool, error) {
+		resp, err := c.do(ctx, http.MethodGet, c.pick()+"/get?"+q.Encode())
+		if err != nil {
+			return true, err
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			e.Context = resp.Header.Get("X-Context")
+			return false, json.NewDecoder(resp.Body).Decode(&e)
+		case http.StatusNotFound:
+			return false, ErrNotFound
+		default:
+			return resp.StatusCode >= 500, statusError(resp)
+		}
+	})
+	return e, err
+}
+
+// KV is one key and its entry in a Scan listing.
+type KV struct {
+	Key string `json:"key"`
+	Entry
+}
+
+// Scan lists the keys starting with prefix as seen by one endpoint, at
+// most limit of them when limit > 0, and every one, page by page,
+// otherwise.
+func (c *Client) Scan(ctx context.Context, prefix string, limit int) ([]KV, error) {
+	var out []KV
+	if limit <= 0 {
+		err := c.ScanEach(ctx, prefix, 0, func(kv KV) error {
+			out = append(out, kv)
+			return nil
+		})
+		return out, err
+	}
+	q := url.Values{"prefix": {prefix}, "limit": {strconv.Itoa(limit)}}
+	err := c.getJSON(ctx, func() string { return c.pick() + "/scan?" + q.Encode() }, &out)
+	return out, err
+}
+
+// ScanEach calls fn with every key starting with prefix, in key order,
+// fetching pageSize of them per request (the node's default page size
+// when pageSize <= 0) and decoding each page as it streams in, so the
+// listing never has to fit in memory. A page that fails is retried on the
+// next endpoint, carrying on from the same cursor. It stops at fn's first
+// error and returns it.
+func (c *Client) ScanEach(ctx context.Context, prefix string, pageSize int, fn func(KV) error) error {
+	q := url.Values{"prefix": {prefix}}
+	if pageSize > 0 {
+		q.Set("limit", strconv.Itoa(pageSize))
+	}
+	for {
+		var resp *http.Response
+		err := c.retry(ctx, func() (bool, error) {
+			r, err := c.do(ctx, http.MethodGet, c.pick()+"/scan?"+q.Encode())
+			if err != nil {
+				return true, err
+			}
+			if r.StatusCode != http.StatusOK {
+				defer r.Body.Close()
+				return r.StatusCode >= 500, statusError(r)
+			}
+			resp = r
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+		cursor := resp.Header.Get("X-Next-Cursor")
+		err = decodeEach(json.NewDecoder(resp.Body), fn)
+		resp.Body.Close()
+		if err != nil || cursor == "" {
+			return err
+		}
+		q.Set("cursor", cursor)
+	}
+}
+
+// decodeEach hands each element of the JSON array dec is reading to fn.
+func decodeEach(dec *json.Decoder, fn func(KV) error) error {
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		var kv KV
+		if err := dec.Decode(&kv); err != nil {
+			return err
+		}
+		if err := fn(kv); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// NodeStatus is what one node reports about itself on /leader and /peers.
+type NodeStatus struct {
+	Endpoint string     `json:"endpoint"`
+	Self     string     `json:"self"`
+	Leader   string     `json:"leader"`
+	Epoch    int64      `json:"epoch"`
+	IsLeader bool       `json:"is_leader"`
+	Peers    []PeerInfo `json:"peers"`
+	Err      string     `json:"error,omitempty"`
+}
+
+// PeerInfo is a node's view of one of its peers.
+type PeerInfo struct {
+	Addr       string  `json:"addr"`
+	LagSeconds float64 `json:"lag_seconds"`
+	RTTMillis  float64 `json:"rtt_ms"`
+	Reachable  bool    `json:"reachable"`
+}
+
+// Status asks every endpoint about itself. Unreachable nodes are reported
+// with Err set rather than failing the call.
+func (c *Client) Status(ctx context.Context) []NodeStatus {
+	out := make([]NodeStatus, len(c.endpoints))
+	for i, ep := range c.endpoints {
+		st := NodeStatus{Endpoint: ep}
+		err := c.getJSON(ctx, func() string { return ep + "/leader" }, &st)
+		if err == nil {
+			err = c.getJSON(ctx, func() string { return ep + "/peers" }, &st.Peers)
+		}
+		if err != nil {
+			st.Err = err.Error()
+		}
+		st.Endpoint = ep
+		out[i] = st
+	}
+	return out
+}
+
+// Configure sets N, W and R (those > 0) on every endpoint.
+func (c *Client) Configure(ctx context.Context, n, w, r int) error {
+	q := url.Values{}
+	for name, v := range map[string]int{"N": n, "W": w, "R": r} {
+		if v > 0 {
+			q.Set(name, strconv.Itoa(v))
+		}
+	}
+	for _, ep := range c.endpoints {
+		resp, err := c.do(ctx, http.MethodPost, ep+"/config?"+q.Encode())
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return statusError(resp)
+		}
+	}
+	return nil
+}
+
+// getJSON GETs target() into v, retrying on connection errors and 5xx.
+func (c *Client) getJSON(ctx context.Context, target func() string, v any) error {
+	return c.retry(ctx, func() (bool, error) {
+		resp, err := c.do(ctx, http.MethodGet, target())
+		if err != nil {
+			return true, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode >= 500, statusError(resp)
+		}
+		return false, json.NewDecoder(resp.Body).Decode(v)
+	})
+}
+
+// write POSTs to the leader, following X-Leader redirects, and returns the
+// final status code.
+func (c *Client) write(ctx context.Context, path string, q url.Values, opts []CallOption) (int, error) {
+	for _, o := range opts {
+		o(q)
+	}
+	// one key for every attempt, so a node that already applied the write
+	// answers a retry with the original result
+	var id [16]byte
+	rand.Read(id[:])
+	idemKey := hex.EncodeToString(id[:])
+	code := 0
+	err := c.retry(ctx, func() (bool, error) {
+		target := c.leaderEndpoint()
+		resp, err := c.do(ctx, http.MethodPost, target+path+"?"+q.Encode(), "Idempotency-Key", idemKey)
+		if err != nil {
+			c.forgetLeader(target)
+			return true, err
+		}
+		defer resp.Body.Close()
+		code = resp.StatusCode
+		if hint := resp.Header.Get("X-Leader"); hint != "" {
+			c.setLeader(normalize(hint))
+		}
+		switch {
+		case code == http.StatusOK || code == http.StatusCreated:
+			c.setLeader(target)
+			return false, nil
+		case code == http.StatusPreconditionFailed:
+			return false, statusError(resp)
+		case code == http.StatusInternalServerError || code == http.StatusGatewayTimeout:
+			return false, quorumError(resp)
+		case code == http.StatusServiceUnavailable:
+			return true, statusError(resp)
+		case code == http.StatusBadRequest && resp.Header.Get("X-Leader") != "":
+			return true, statusError(resp) // redirected; try the leader
+		case resp.Header.Get("X-Not-Leader") != "":
+			// this node does not lead and does not know who does
+			c.forgetLeader(target)
+			return true, statusError(resp)
+		default:
+			// anything else is the request's own fault, e.g. a bad key, and
+			// another node would refuse it too
+			return false, statusError(resp)
+		}
+	})
+	return code, err
+}
+
+// retry runs call until it succeeds, reports a non-retryable error, runs
+// out of attempts, or ctx is done.
+func (c *Client) retry(ctx context.Context, call func() (retryable bool, err error)) error {
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.Backoff):
+			}
+		}
+		var retryable bool
+		if retryable, err = call(); err == nil || !retryable {
+			return err
+		}
+	}
+	return err
+}
+
+// do sends a bodyless request; header holds name, value pairs to set.
+func (c *Client) do(ctx context.Context, method, target string, header ...string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// pick rotates through the endpoints.
+func (c *Client) pick() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.endpoints[c.next%len(c.endpoints)]
+	c.next++
+	return e
+}
+
+// leaderEndpoint returns the known leader, or the next endpoint to probe.
+func (c *Client) leaderEndpoint() string {
+	c.mu.Lock()
+	l := c.leader
+	c.mu.Unlock()
+	if l != "" {
+		return l
+	}
+	return c.pick()
+}
+
+func (c *Client) setLeader(l string) {
+	c.mu.Lock()
+	c.leader = l
+	c.mu.Unlock()
+}
+
+func (c *Client) forgetLeader(l string) {
+	c.mu.Lock()
+	if c.leader == l {
+		c.leader = ""
+	}
+	c.mu.Unlock()
+}
+
+// PartialWriteError is returned, wrapping ErrQuorum, when a write missed
+// its quorum. The replicas in Accepted keep the write at Timestamp, so the
+// caller can retry it, read-repair the key or settle for those copies.
+type PartialWriteError struct {
+	WriteID   string   `json:"write_id"`
+	Key       string   `json:"key"`
+	Timestamp int64    `json:"timestamp"`
+	Acks      int      `json:"acks"`
+	Required  int      `json:"required"`
+	Accepted  []string `json:"accepted"`
+	Failed    []string `json:"failed"`
+	Skipped   []string `json:"skipped,omitempty"`
+	Pending   []string `json:"pending,omitempty"`
+	// RolledBack are the replicas sent an undo, on a node started with
+	// -ROLLBACK_FAILED_WRITES.
+	RolledBack []string `json:"rolled_back,omitempty"`
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("client: quorum not met: %d of %d acks, accepted by %s", e.Acks, e.Required, strings.Join(e.Accepted, ","))
+}
+
+func (e *PartialWriteError) Unwrap() error { return ErrQuorum }
+
+// quorumError is the PartialWriteError in resp's body, or ErrQuorum from
+// a node too old to send one.
+func quorumError(resp *http.Response) error {
+	var pw PartialWriteError
+	if err := json.NewDecoder(resp.Body).Decode(&pw); err != nil || pw.Required == 0 {
+		return ErrQuorum
+	}
+	return &pw
+}
+
+func statusError(resp *http.Response) error {
+	return fmt.Errorf("client: %s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+}
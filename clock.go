@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is where the node reads the time for write timestamps and waits
+// out its simulated replication delays (LeaderDelayPerFollower and
+// friends). Tests swap nodeClock for a fake to step through those waits
+// deterministically instead of sleeping and hoping.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var nodeClock Clock = realClock{}
+
+// clockSkew offsets the timestamps this node gives the writes it
+// coordinates, set by -CLOCK_SKEW and /admin/clock_skew. It exists to show
+// what LWW does with skewed clocks: a node running behind loses its writes
+// to older ones from a node running on time.
+var clockSkew atomic.Int64 // nanoseconds
+
+// writeClock is the timestamp for a new write.
+func writeClock() int64 { return nodeClock.Now().UnixNano() + clockSkew.Load() }
+
+// clockSkewHandler shows the skew (GET) or sets it from ?skew=, a signed
+// duration such as -2s (POST).
+func clockSkewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		d, err := time.ParseDuration(r.URL.Query().Get("skew"))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "skew", "skew must be a duration such as -2s")
+			return
+		}
+		clockSkew.Store(int64(d))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"skew": time.Duration(clockSkew.Load()).String()})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A node whose clock runs behind loses an update written after one from a
+// node on time: LWW keeps the larger timestamp, not the later write.
+func TestClockSkewLosesUpdateUnderLWW(t *testing.T) {
+	p1, p2 := 9127, 9128
+	a := startNode(t, p1, []string{fmt.Sprintf("localhost:%d", p2)}, false, 2, 1, 2, "-CLOCK_SKEW", "-10s")
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{fmt.Sprintf("localhost:%d", p1)}, false, 2, 1, 2)
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+
+	set := func(port int, value string) {
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/set?key=k&value=%s", port, value), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("set %s on %d = %d", value, port, resp.StatusCode)
+		}
+	}
+	set(p2, "first")
+	set(p1, "second") // later, but stamped 10s in the past
+	for _, p := range []int{p1, p2} {
+		if e, _ := getEntry(t, fmt.Sprintf("http://localhost:%d/local_read?key=k", p)); e.Value != "first" {
+			t.Fatalf("node %d kept %q; the skewed write should have lost", p, e.Value)
+		}
+	}
+
+	// with the skew removed the next write wins again
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/admin/clock_skew?skew=0s", p1), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	set(p1, "third")
+	if e, _ := getEntry(t, fmt.Sprintf("http://localhost:%d/local_read?key=k", p2)); e.Value != "third" {
+		t.Fatalf("after clearing the skew: %q", e.Value)
+	}
+}
+
+// fakeClock is a Clock that only moves when the test advances it.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock { return &fakeClock{now: time.Unix(1_000_000, 0)} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{c.now.Add(d), ch})
+	return ch
+}
+
+func (c *fakeClock) Sleep(d time.Duration) { <-c.After(d) }
+
+// Advance moves the clock on by d, waking the sleepers it passes.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	kept := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			kept = append(kept, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = kept
+}
+
+// BlockUntil waits (in real time, briefly) for n goroutines to be asleep.
+func (c *fakeClock) BlockUntil(t *testing.T, n int) {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		waiting := len(c.waiters)
+		c.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+	}
+	t.Fatalf("fewer than %d sleepers", n)
+}
+
+// The W=1 staleness window, stepped through on a fake clock: the follower
+// gets nothing until the leader's per-follower delay has passed.
+func TestAsyncReplicationWaitsOnClock(t *testing.T) {
+	fake := newFakeClock()
+	oldClock := nodeClock
+	wasLeader := isLeader.Load()
+	defer func() {
+		nodeClock = oldClock
+		isLeader.Store(wasLeader)
+		svc.Lock()
+		delete(svc.data, "sim")
+		svc.Unlock()
+	}()
+
+	got := make(chan string, 1)
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.URL.Query().Get("value")
+	}))
+	defer follower.Close()
+	nodeClock = fake
+	withCluster(t, func(c *membership) { c.peers, c.N, c.W = []string{strings.TrimPrefix(follower.URL, "http://")}, 2, 1 })
+	isLeader.Store(true)
+
+	rec := httptest.NewRecorder()
+	coordinateWrite(rec, httptest.NewRequest(http.MethodPost, "/set?key=sim&value=v", nil), "sim", Entry{Value: "v"}, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("write = %d", rec.Code)
+	}
+	if e := svc.snapshot()["sim"]; e.Timestamp != fake.Now().UnixNano() {
+		t.Fatalf("timestamp %d not from the clock", e.Timestamp)
+	}
+
+	fake.BlockUntil(t, 1)
+	fake.Advance(LeaderDelayPerFollower - time.Millisecond)
+	select {
+	case <-got:
+		t.Fatal("replicated before the leader delay passed")
+	case <-time.After(20 * time.Millisecond):
+	}
+	fake.Advance(time.Millisecond)
+	select {
+	case v := <-got:
+		if v != "v" {
+			t.Fatalf("replicated %q", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not replicated once the delay passed")
+	}
+	asyncRepl.Wait()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runCluster implements `kv cluster`: it starts n copies of this binary on
+// sequential ports with cross-wired peer lists, prefixes their logs with
+// the node name and stops them all on Ctrl-C. Arguments after "--" are
+// passed to every node.
+func runCluster(args []string) {
+	fs := flag.NewFlagSet("cluster", flag.ExitOnError)
+	n := fs.Int("n", 3, "number of nodes")
+	w := fs.Int("w", 1, "write quorum")
+	r := fs.Int("r", 1, "read quorum")
+	base := fs.Int("port", 8000, "port of the first node; the rest follow sequentially")
+	leaderless := fs.Bool("leaderless", false, "start without a leader (first node leads otherwise)")
+	fs.Parse(args)
+	extra := fs.Args()
+	if len(extra) > 0 && extra[0] == "--" {
+		extra = extra[1:]
+	}
+	grace := drainGrace(extra)
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("cluster: locating binary: %v", err)
+	}
+	addrs := make([]string, *n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("localhost:%d", *base+i)
+	}
+
+	var outMu sync.Mutex
+	var running sync.WaitGroup
+	nodes := make([]*exec.Cmd, 0, *n)
+	for i, addr := range addrs {
+		var peerList []string
+		for j, p := range addrs {
+			if j != i {
+				peerList = append(peerList, p)
+			}
+		}
+		nodeArgs := []string{
+			"-PORT", fmt.Sprint(*base + i),
+			"-SELF", addr,
+			"-PEERS", strings.Join(peerList, ","),
+			"-N", fmt.Sprint(*n),
+			"-W", fmt.Sprint(*w),
+			"-R", fmt.Sprint(*r),
+		}
+		if *leaderless {
+			nodeArgs = append(nodeArgs, "-LEADERLESS")
+		} else if i == 0 {
+			nodeArgs = append(nodeArgs, "-LEADER")
+		}
+		nodeArgs = append(nodeArgs, extra...)
+
+		name := fmt.Sprintf("kv%d", i+1)
+		cmd := exec.Command(exe, nodeArgs...)
+		stdout, _ := cmd.StdoutPipe()
+		stderr, _ := cmd.StderrPipe()
+		if err := cmd.Start(); err != nil {
+			stopNodes(nodes, grace)
+			log.Fatalf("cluster: starting %s: %v", name, err)
+		}
+		nodes = append(nodes, cmd)
+		// Wait closes the pipes, so it must wait for the readers to finish
+		// or a node's last lines are lost
+		var reading sync.WaitGroup
+		reading.Add(2)
+		for _, pipe := range []io.Reader{stdout, stderr} {
+			go func() {
+				defer reading.Done()
+				prefixLines(name, pipe, &outMu)
+			}()
+		}
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			reading.Wait()
+			if err := cmd.Wait(); err != nil {
+				outMu.Lock()
+				fmt.Printf("[%s] exited: %v\n", name, err)
+				outMu.Unlock()
+			}
+		}()
+	}
+	fmt.Printf("cluster: %d nodes on %s (W=%d R=%d), Ctrl-C to stop\n",
+		*n, strings.Join(addrs, ","), *w, *r)
+
+	allDone := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(allDone)
+	}()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-sig:
+		fmt.Println("cluster: stopping nodes")
+		stopNodes(nodes, grace)
+		<-allDone
+	case <-allDone:
+	}
+}
+
+// drainGrace is how long stopNodes lets the nodes drain: the
+// -DRAIN_TIMEOUT passed to them (or its default), plus a second to exit.
+func drainGrace(nodeArgs []string) time.Duration {
+	d := drainTimeout
+	for i, a := range nodeArgs {
+		name, v, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if name != "DRAIN_TIMEOUT" || !strings.HasPrefix(a, "-") {
+			continue
+		}
+		if !hasValue && i+1 < len(nodeArgs) {
+			v = nodeArgs[i+1]
+		}
+		if parsed, err := time.ParseDuration(v); err == nil {
+			d = parsed
+		}
+	}
+	return d + time.Second
+}
+
+// stopNodes interrupts every node and kills any still running after
+// grace.
+func stopNodes(nodes []*exec.Cmd, grace time.Duration) {
+	for _, c := range nodes {
+		c.Process.Signal(os.Interrupt)
+	}
+	time.AfterFunc(grace, func() {
+		for _, c := range nodes {
+			c.Process.Kill()
+		}
+	})
+}
+
+// prefixLines copies r to stdout line by line, tagging each with name.
+func prefixLines(name string, r io.Reader, mu *sync.Mutex) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		mu.Lock()
+		fmt.Printf("[%s] %s\n", name, sc.Text())
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClusterLauncher(t *testing.T) {
+	var out bytes.Buffer
+	launcher := exec.Command(binName, "cluster", "-n", "2", "-port", "9182", "--", "-DRAIN_TIMEOUT", "1s")
+	launcher.Stdout, launcher.Stderr = &out, &out
+	if err := launcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer launcher.Process.Kill()
+	waitReady(t, 9182, 9183)
+
+	launcher.Process.Signal(os.Interrupt)
+	exited := make(chan error, 1)
+	go func() { exited <- launcher.Wait() }()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("launcher still running after its nodes' drain timeout")
+	}
+	// each node's last line makes it out before the launcher exits
+	for _, want := range []string{"[kv1] ", "[kv2] "} {
+		found := false
+		for _, line := range strings.Split(out.String(), "\n") {
+			if strings.HasPrefix(line, want) && strings.Contains(line, "drained; exiting") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("output lacks %sdrained; exiting:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestDrainGrace(t *testing.T) {
+	for args, want := range map[string]time.Duration{
+		"":                           drainTimeout + time.Second,
+		"-DRAIN_TIMEOUT 3s":          4 * time.Second,
+		"--DRAIN_TIMEOUT=250ms -W 2": 1250 * time.Millisecond,
+	} {
+		if got := drainGrace(strings.Fields(args)); got != want {
+			t.Errorf("drainGrace(%q) = %v, want %v", args, got, want)
+		}
+	}
+}
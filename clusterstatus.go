@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// /cluster/status, on any node, asks every peer and read-only replica for
+// its /node summary at once and puts them next to this node's own: one
+// request shows each member's health and lifecycle state, which leader
+// and epoch it follows, its key count, how far it sees each peer lag and
+// the config it runs with. The summary on top says whether the members
+// that answered agree on the leader, epoch and config, write policies
+// included. Members that do
+// not answer within ?timeout= (default 1s) are listed with their error.
+// ?tag= narrows it to the members with those tags (see tags.go).
+
+const defaultClusterStatusTimeout = time.Second
+
+type memberStatus struct {
+	NodeStats
+	Healthy   bool    `json:"healthy"` // answered and ready
+	RTTMillis float64 `json:"rtt_ms"`  // of this request
+}
+
+type clusterStatus struct {
+	Self         string         `json:"self"`
+	Members      int            `json:"members"`
+	Healthy      int            `json:"healthy"`
+	Leader       string         `json:"leader,omitempty"` // the one every healthy member follows
+	Epoch        int64          `json:"epoch"`            // highest seen
+	MaxLag       float64        `json:"max_replication_lag_seconds"`
+	Keys         int            `json:"keys"` // live keys on the member holding the most
+	Agreement    bool           `json:"agreement"`
+	Disagreement []string       `json:"disagreement,omitempty"` // what the healthy members differ on
+	Nodes        []memberStatus `json:"nodes"`
+}
+
+func clusterStatusHandler(w http.ResponseWriter, r *http.Request) {
+	timeout := defaultClusterStatusTimeout
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			httpError(w, http.StatusBadRequest, "timeout", "timeout must be a positive duration")
+			return
+		}
+		timeout = d
+	}
+	match, err := tagFilter(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "tag", err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	var others []string
+	for _, p := range append(append([]string(nil), cluster().peers...), readReplicas...) {
+		if match(tagsOf(p)) {
+			others = append(others, p)
+		}
+	}
+	nodes := make([]memberStatus, len(others))
+	var wg sync.WaitGroup
+	for i, p := range others {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			st := fetchStats(ctx, p)
+			nodes[i] = memberStatus{NodeStats: st, RTTMillis: float64(time.Since(start)) / float64(time.Millisecond)}
+		}()
+	}
+	wg.Wait()
+	if match(nodeTags()) {
+		nodes = append([]memberStatus{{NodeStats: localStats()}}, nodes...)
+	}
+	for i := range nodes {
+		nodes[i].Healthy = nodes[i].Error == "" && nodes[i].State == stateReady
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summarizeCluster(nodes))
+}
+
+// summarizeCluster totals nodes and compares what the healthy ones report.
+func summarizeCluster(nodes []memberStatus) clusterStatus {
+	cs := clusterStatus{Self: self, Members: len(nodes), Nodes: nodes}
+	differ := map[string]bool{}
+	var first *memberStatus
+	for i := range nodes {
+		n := &nodes[i]
+		cs.Epoch = max(cs.Epoch, n.Epoch)
+		if !n.Healthy {
+			continue
+		}
+		cs.Healthy++
+		cs.Keys = max(cs.Keys, n.Keys)
+		for _, lag := range n.Lag {
+			cs.MaxLag = max(cs.MaxLag, lag)
+		}
+		if first == nil {
+			first = n
+			continue
+		}
+		for field, same := range map[string]bool{
+			"leader": n.Leader == first.Leader,
+			"epoch":  n.Epoch == first.Epoch,
+			"mode":   n.Mode == first.Mode && n.Leaderless == first.Leaderless,
+			// read-only replicas take no part in quorums, so their N, R and W do not matter
+			"quorum":   n.ReadOnly || first.ReadOnly || (n.N == first.N && n.R == first.R && n.W == first.W && n.Replicas == first.Replicas),
+			"policies": n.ReadOnly || first.ReadOnly || slices.Equal(n.Policies, first.Policies),
+		} {
+			if !same {
+				differ[field] = true
+			}
+		}
+	}
+	for _, field := range []string{"leader", "epoch", "mode", "quorum", "policies"} {
+		if differ[field] {
+			cs.Disagreement = append(cs.Disagreement, field)
+		}
+	}
+	cs.Agreement = len(cs.Disagreement) == 0
+	if first != nil && !differ["leader"] {
+		cs.Leader = first.Leader
+	}
+	return cs
+}
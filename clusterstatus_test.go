@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestClusterStatusGathersEveryMember(t *testing.T) {
+	p1, p2, dead := 9154, 9155, 9156
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	a := startNode(t, p1, []string{addr(p2), addr(dead)}, true, 3, 1, 2)
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{addr(p1), addr(dead)}, false, 3, 1, 2)
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+	// the follower learns who leads from the first replicated write
+	resp, err := http.Post(fmt.Sprintf("http://%s/set?key=k&value=v", addr(p1)), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var cs clusterStatus
+	if err := getJSON(fmt.Sprintf("http://%s/cluster/status?timeout=500ms", addr(p2)), &cs); err != nil {
+		t.Fatal(err)
+	}
+	if cs.Members != 3 || cs.Healthy != 2 || !cs.Agreement || cs.Leader != addr(p1) || cs.Keys != 1 {
+		t.Fatalf("summary %+v", cs)
+	}
+	byAddr := map[string]memberStatus{}
+	for _, n := range cs.Nodes {
+		byAddr[n.Addr] = n
+	}
+	if n := byAddr[addr(p1)]; !n.Healthy || !n.IsLeader || n.State != stateReady || n.N != 3 || n.NodeID == "" {
+		t.Fatalf("leader %+v", n)
+	}
+	if n := byAddr[addr(dead)]; n.Healthy || n.Error == "" {
+		t.Fatalf("dead member %+v", n)
+	}
+}
+
+func TestClusterStatusReportsDisagreement(t *testing.T) {
+	nodes := []memberStatus{
+		{NodeStats: NodeStats{Leader: "a", Epoch: 2, N: 3, R: 1, W: 2}, Healthy: true},
+		{NodeStats: NodeStats{Leader: "b", Epoch: 2, N: 3, R: 1, W: 1}, Healthy: true},
+		{NodeStats: NodeStats{Leader: "c", Epoch: 5, Error: "down"}},
+	}
+	cs := summarizeCluster(nodes)
+	if cs.Agreement || fmt.Sprint(cs.Disagreement) != "[leader quorum]" || cs.Leader != "" || cs.Epoch != 5 || cs.Healthy != 2 {
+		t.Fatalf("%+v", cs)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// doctor checks a cluster from the outside. It asks every endpoint for
+// /cluster/status, which carries the node's own settings and clock and
+// whether it could reach each of its peers, then reads sample keys from
+// every replica holding them with /local_read. Each finding says what to
+// do about it.
+
+// doctorNode is the part of a node's /cluster/status the doctor uses.
+type doctorNode struct {
+	Addr       string `json:"addr"`
+	ClientAddr string `json:"client_addr"`
+	State      string `json:"state"`
+	ReadOnly   bool   `json:"read_only"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	W          int    `json:"w"`
+	Replicas   int    `json:"replicas"`
+	Clock      int64  `json:"clock"`
+	Error      string `json:"error"`
+}
+
+type doctorStatus struct {
+	Self  string       `json:"self"`
+	Nodes []doctorNode `json:"nodes"`
+}
+
+// finding is one line of the report.
+type finding struct {
+	Check  string `json:"check"` // config, clock, reachability or divergence
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+type doctorReport struct {
+	Findings []finding `json:"findings"`
+	Healthy  bool      `json:"healthy"`
+}
+
+type doctorOptions struct {
+	maxSkew time.Duration
+	samples int      // keys listed from each node when keys is empty
+	keys    []string // keys to compare across replicas
+}
+
+// endpointView is what one endpoint answered.
+type endpointView struct {
+	endpoint string
+	self     doctorNode
+	peers    []doctorNode
+	skew     time.Duration // of its clock against ours, allowing for the round trip
+	err      error
+}
+
+func runDoctor(ctx context.Context, endpoints []string, opts doctorOptions) doctorReport {
+	var rep doctorReport
+	add := func(check string, ok bool, detail, fix string) {
+		rep.Findings = append(rep.Findings, finding{check, ok, detail, fix})
+	}
+
+	var up []endpointView
+	for _, ep := range endpoints {
+		v := viewFrom(ctx, ep)
+		if v.err != nil {
+			add("reachability", false, fmt.Sprintf("%s does not answer: %v", ep, v.err), "start the node, or check the endpoint address and firewall")
+			continue
+		}
+		if v.self.State != "ready" {
+			add("reachability", false, fmt.Sprintf("%s is %s, not ready", ep, v.self.State), "wait for it to finish syncing; /status shows its progress")
+		}
+		up = append(up, v)
+	}
+	if len(up) == 0 {
+		return rep
+	}
+
+	checkConfig(up, add)
+	checkClocks(up, opts.maxSkew, add)
+	checkReachability(up, add)
+	checkDivergence(ctx, up, opts, add)
+
+	rep.Healthy = true
+	for _, f := range rep.Findings {
+		rep.Healthy = rep.Healthy && f.OK
+	}
+	return rep
+}
+
+func viewFrom(ctx context.Context, ep string) endpointView {
+	v := endpointView{endpoint: ep}
+	var st doctorStatus
+	before := time.Now()
+	v.err = getJSON(ctx, ep, "/cluster/status", &st)
+	after := time.Now()
+	if v.err != nil {
+		return v
+	}
+	for _, n := range st.Nodes {
+		if n.Addr == st.Self {
+			v.self = n
+		} else {
+			v.peers = append(v.peers, n)
+		}
+	}
+	if v.self.Addr == "" {
+		v.err = fmt.Errorf("its /cluster/status does not list itself")
+		return v
+	}
+	mid := before.Add(after.Sub(before) / 2)
+	v.skew = time.Duration(v.self.Clock - mid.UnixNano())
+	return v
+}
+
+func checkConfig(up []endpointView, add func(string, bool, string, string)) {
+	settings := map[string][]string{}
+	for _, v := range up {
+		if v.self.ReadOnly {
+			continue // read-only replicas take no part in quorums
+		}
+		s := fmt.Sprintf("N=%d R=%d W=%d replicas=%d", v.self.N, v.self.R, v.self.W, v.self.Replicas)
+		settings[s] = append(settings[s], v.self.Addr)
+	}
+	if len(settings) <= 1 {
+		for s := range settings {
+			add("config", true, "every node runs "+s, "")
+		}
+		return
+	}
+	var parts []string
+	for s, nodes := range settings {
+		parts = append(parts, fmt.Sprintf("%s on %s", s, strings.Join(nodes, ",")))
+	}
+	sort.Strings(parts)
+	add("config", false, "nodes disagree: "+strings.Join(parts, "; "), "set the same values everywhere with `kvctl config N=.. W=.. R=..` against every node")
+}
+
+func checkClocks(up []endpointView, maxSkew time.Duration, add func(string, bool, string, string)) {
+	lo, hi := up[0], up[0]
+	for _, v := range up[1:] {
+		if v.skew < lo.skew {
+			lo = v
+		}
+		if v.skew > hi.skew {
+			hi = v
+		}
+	}
+	spread := hi.skew - lo.skew
+	if spread <= maxSkew {
+		add("clock", true, fmt.Sprintf("write clocks within %v of each other", spread.Round(time.Millisecond)), "")
+		return
+	}
+	add("clock", false, fmt.Sprintf("%s runs %v ahead of %s", hi.self.Addr, spread.Round(time.Millisecond), lo.self.Addr),
+		"sync the hosts with NTP and clear any -CLOCK_SKEW (POST /admin/clock_skew?skew=0s); last-write-wins drops writes from the node behind")
+}
+
+// checkReachability compares what each node says of its peers, so a link
+// that works one way only shows up as such.
+func checkReachability(up []endpointView, add func(string, bool, string, string)) {
+	reaches := map[[2]string]bool{} // {from, to}
+	known := map[[2]string]bool{}
+	for _, v := range up {
+		for _, p := range v.peers {
+			// a peer that answered, if only to say it is not ready, is reachable
+			known[[2]string{v.self.Addr, p.Addr}] = true
+			reaches[[2]string{v.self.Addr, p.Addr}] = p.Error == "" || p.State != ""
+		}
+	}
+	bad := 0
+	var pairs [][2]string
+	for link := range known {
+		pairs = append(pairs, link)
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0]+pairs[i][1] < pairs[j][0]+pairs[j][1] })
+	for _, link := range pairs {
+		if reaches[link] {
+			continue
+		}
+		bad++
+		back := [2]string{link[1], link[0]}
+		switch {
+		case !known[back]:
+			add("reachability", false, fmt.Sprintf("%s cannot reach %s", link[0], link[1]), "check that "+link[1]+" is up and its peer port is open to "+link[0])
+		case reaches[back]:
+			add("reachability", false, fmt.Sprintf("%s cannot reach %s, though %s reaches %s", link[0], link[1], link[1], link[0]), "a one-way partition: check firewall rules and -PEERS addresses on "+link[0])
+		case link[0] < link[1]:
+			add("reachability", false, fmt.Sprintf("%s and %s cannot reach each other", link[0], link[1]), "check the network between them")
+		}
+	}
+	if bad == 0 {
+		add("reachability", true, fmt.Sprintf("%d peer links answer both ways", len(pairs)), "")
+	}
+}
+
+// replicaRead is one node's copy of a sample key.
+type replicaRead struct {
+	Value     string `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func checkDivergence(ctx context.Context, up []endpointView, opts doctorOptions, add func(string, bool, string, string)) {
+	keys := opts.keys
+	if len(keys) == 0 {
+		seen := map[string]bool{}
+		for _, v := range up {
+			var listed []string
+			if err := getJSON(ctx, v.endpoint, fmt.Sprintf("/keys?limit=%d", opts.samples), &listed); err != nil {
+				continue
+			}
+			for _, k := range listed {
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+		}
+		sort.Strings(keys)
+	}
+	if len(keys) == 0 {
+		add("divergence", true, "no keys to compare", "")
+		return
+	}
+	diverged := 0
+	for _, k := range keys {
+		copies := map[string]replicaRead{} // by node
+		var holders []string
+		for _, v := range up {
+			var owner struct {
+				Local bool `json:"local"`
+			}
+			if err := getJSON(ctx, v.endpoint, "/owner?key="+url.QueryEscape(k), &owner); err != nil || !owner.Local {
+				continue
+			}
+			holders = append(holders, v.self.Addr)
+			var e replicaRead
+			if err := getJSON(ctx, v.endpoint, "/local_read?key="+url.QueryEscape(k), &e); err == nil {
+				copies[v.self.Addr] = e
+			}
+		}
+		if len(copies) == 0 {
+			continue // deleted since it was listed
+		}
+		var newest replicaRead
+		for _, e := range copies {
+			if e.Timestamp > newest.Timestamp {
+				newest = e
+			}
+		}
+		var stale []string
+		for _, h := range holders {
+			if e, ok := copies[h]; !ok || e != newest {
+				stale = append(stale, h)
+			}
+		}
+		if len(stale) == 0 {
+			continue
+		}
+		diverged++
+		slices.Sort(stale)
+		add("divergence", false, fmt.Sprintf("%q differs: %s behind the newest copy (timestamp %d)", k, strings.Join(stale, ","), newest.Timestamp),
+			"if it persists past replication lag, run POST /admin/repair?key="+url.QueryEscape(k)+" or /admin/anti_entropy on a replica")
+	}
+	if diverged == 0 {
+		add("divergence", true, fmt.Sprintf("%d sample keys match on every replica", len(keys)), "")
+	}
+}
+
+func getJSON(ctx context.Context, endpoint, path string, v any) error {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(endpoint, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s: %s %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNode answers the endpoints doctor reads as one node would.
+type fakeNode struct {
+	self  doctorNode
+	peers []doctorNode
+	copy  replicaRead
+}
+
+func (f *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var v any
+	switch r.URL.Path {
+	case "/cluster/status":
+		self := f.self
+		self.Clock += time.Now().UnixNano()
+		v = doctorStatus{Self: f.self.Addr, Nodes: append([]doctorNode{self}, f.peers...)}
+	case "/keys":
+		v = []string{"k"}
+	case "/owner":
+		v = map[string]bool{"local": true}
+	case "/local_read":
+		v = f.copy
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestDoctor(t *testing.T) {
+	a := &fakeNode{
+		self:  doctorNode{Addr: "a:1", State: "ready", N: 2, R: 1, W: 2},
+		peers: []doctorNode{{Addr: "b:1", State: "ready"}},
+		copy:  replicaRead{Value: "v", Timestamp: 5},
+	}
+	b := &fakeNode{
+		self:  doctorNode{Addr: "b:1", State: "ready", N: 2, R: 1, W: 2},
+		peers: []doctorNode{{Addr: "a:1", State: "ready"}},
+		copy:  replicaRead{Value: "v", Timestamp: 5},
+	}
+	sa, sb := httptest.NewServer(a), httptest.NewServer(b)
+	defer sa.Close()
+	defer sb.Close()
+	run := func() doctorReport {
+		return runDoctor(context.Background(), []string{sa.URL, sb.URL}, doctorOptions{maxSkew: 200 * time.Millisecond, samples: 10})
+	}
+	if rep := run(); !rep.Healthy {
+		t.Fatalf("healthy cluster reported %+v", rep.Findings)
+	}
+
+	// b has other settings, runs a second fast, cannot reach a, which
+	// still reaches it, and holds an older copy of k
+	b.self.R, b.self.Clock = 2, int64(time.Second)
+	b.peers[0] = doctorNode{Addr: "a:1", Error: "connection refused"}
+	b.copy.Timestamp = 4
+	rep := run()
+	if rep.Healthy {
+		t.Fatal("broken cluster reported healthy")
+	}
+	failed := map[string]string{}
+	for _, f := range rep.Findings {
+		if !f.OK {
+			if f.Fix == "" {
+				t.Errorf("%s finding without a fix: %s", f.Check, f.Detail)
+			}
+			failed[f.Check] = f.Detail
+		}
+	}
+	for check, want := range map[string]string{
+		"config":       "N=2 R=2 W=2 replicas=0 on b:1",
+		"clock":        "b:1 runs 1s ahead of a:1",
+		"reachability": "b:1 cannot reach a:1, though a:1 reaches b:1",
+		"divergence":   `"k" differs: b:1 behind`,
+	} {
+		if !strings.Contains(failed[check], want) {
+			t.Errorf("%s: %q, want it to mention %q", check, failed[check], want)
+		}
+	}
+}
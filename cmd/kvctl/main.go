@@ -0,0 +1,228 @@
+// Command kvctl talks to a key-value cluster from the shell.
+//
+//	kvctl [-endpoints a,b,c] [-json] set KEY VALUE
+//	kvctl get KEY
+//	kvctl del KEY
+//	kvctl scan [PREFIX]
+//	kvctl status
+//	kvctl config N=5 W=3 R=2
+//	kvctl -endpoints a,b,c doctor [KEY...]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"kvstore/client"
+)
+
+func main() {
+	endpoints := flag.String("endpoints", envOr("KV_ENDPOINTS", "localhost:8000"), "comma-separated host:port list")
+	asJSON := flag.Bool("json", false, "print machine-readable JSON")
+	consistency := flag.String("consistency", "", "write consistency level (LOCAL_QUORUM, EACH_QUORUM)")
+	readQuorum := flag.Int("r", 0, "read quorum override for get")
+	limit := flag.Int("limit", 0, "maximum keys returned by scan")
+	timeout := flag.Duration("timeout", 10*time.Second, "overall deadline")
+	maxSkew := flag.Duration("max-skew", 50*time.Millisecond, "clock spread between nodes doctor reports")
+	samples := flag.Int("samples", 20, "keys doctor lists from each node to compare across replicas")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: kvctl [flags] set|get|del|scan|status|config|doctor ...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	c := client.New(strings.Split(*endpoints, ",")...)
+	out := printer{json: *asJSON, w: os.Stdout}
+
+	var writeOpts []client.CallOption
+	if *consistency != "" {
+		writeOpts = append(writeOpts, client.Consistency(*consistency))
+	}
+	var readOpts []client.CallOption
+	if *readQuorum > 0 {
+		readOpts = append(readOpts, client.ReadQuorum(*readQuorum))
+	}
+
+	args := flag.Args()
+	var err error
+	switch cmd := args[0]; {
+	case cmd == "set" && len(args) == 3:
+		err = c.Set(ctx, args[1], args[2], writeOpts...)
+		out.ok(err, "OK")
+	case cmd == "get" && len(args) == 2:
+		var e client.Entry
+		e, err = c.Get(ctx, args[1], readOpts...)
+		if err == nil {
+			out.entries([]client.KV{{Key: args[1], Entry: e}})
+		}
+	case (cmd == "del" || cmd == "delete") && len(args) == 2:
+		err = c.Delete(ctx, args[1], writeOpts...)
+		out.ok(err, "OK")
+	case cmd == "scan" && len(args) <= 2:
+		prefix := ""
+		if len(args) == 2 {
+			prefix = args[1]
+		}
+		var kvs []client.KV
+		kvs, err = c.Scan(ctx, prefix, *limit)
+		if err == nil {
+			out.entries(kvs)
+		}
+	case cmd == "status" && len(args) == 1:
+		out.status(c.Status(ctx))
+	case cmd == "config" && len(args) > 1:
+		var n, w, r int
+		if n, w, r, err = parseConfig(args[1:]); err == nil {
+			err = c.Configure(ctx, n, w, r)
+			out.ok(err, "OK")
+		}
+	case cmd == "doctor":
+		rep := runDoctor(ctx, strings.Split(*endpoints, ","), doctorOptions{maxSkew: *maxSkew, samples: *samples, keys: args[1:]})
+		out.report(rep)
+		if !rep.Healthy {
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if errors.Is(err, client.ErrNotFound) {
+		fmt.Fprintln(os.Stderr, "(not found)")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kvctl:", err)
+		os.Exit(1)
+	}
+}
+
+// parseConfig reads N=, W= and R= assignments.
+func parseConfig(args []string) (n, w, r int, err error) {
+	for _, a := range args {
+		name, v, ok := strings.Cut(a, "=")
+		i, convErr := strconv.Atoi(v)
+		if !ok || convErr != nil || i <= 0 {
+			return 0, 0, 0, fmt.Errorf("bad setting %q, want N=<n>, W=<n> or R=<n>", a)
+		}
+		switch strings.ToUpper(name) {
+		case "N":
+			n = i
+		case "W":
+			w = i
+		case "R":
+			r = i
+		default:
+			return 0, 0, 0, fmt.Errorf("unknown setting %q", name)
+		}
+	}
+	return n, w, r, nil
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// printer renders results to w as aligned text or as JSON.
+type printer struct {
+	json bool
+	w    io.Writer
+}
+
+func (p printer) emit(v any) {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func (p printer) ok(err error, msg string) {
+	if err != nil {
+		return
+	}
+	if p.json {
+		p.emit(map[string]bool{"ok": true})
+		return
+	}
+	fmt.Fprintln(p.w, msg)
+}
+
+func (p printer) entries(kvs []client.KV) {
+	if p.json {
+		p.emit(kvs)
+		return
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tWRITTEN\tTIMESTAMP")
+	for _, kv := range kvs {
+		written := time.Unix(0, kv.Timestamp).Format(time.RFC3339Nano)
+		fmt.Fprintf(tw, "%s\t%q\t%s\t%d\n", kv.Key, kv.Value, written, kv.Timestamp)
+	}
+	tw.Flush()
+}
+
+func (p printer) report(rep doctorReport) {
+	if p.json {
+		p.emit(rep)
+		return
+	}
+	for _, f := range rep.Findings {
+		mark := "ok  "
+		if !f.OK {
+			mark = "FAIL"
+		}
+		fmt.Fprintf(p.w, "%s %-12s %s\n", mark, f.Check, f.Detail)
+		if f.Fix != "" {
+			fmt.Fprintf(p.w, "     %-12s -> %s\n", "", f.Fix)
+		}
+	}
+	if rep.Healthy {
+		fmt.Fprintln(p.w, "no problems found")
+	}
+}
+
+func (p printer) status(nodes []client.NodeStatus) {
+	if p.json {
+		p.emit(nodes)
+		return
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tROLE\tEPOCH\tLEADER\tPEERS (lag / rtt)")
+	for _, n := range nodes {
+		if n.Err != "" {
+			fmt.Fprintf(tw, "%s\tunreachable\t-\t-\t%s\n", n.Endpoint, n.Err)
+			continue
+		}
+		role := "follower"
+		if n.IsLeader {
+			role = "leader"
+		}
+		var ps []string
+		for _, peer := range n.Peers {
+			state := fmt.Sprintf("%s %.3fs/%.1fms", peer.Addr, peer.LagSeconds, peer.RTTMillis)
+			if !peer.Reachable {
+				state += " (down)"
+			}
+			ps = append(ps, state)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", n.Self, role, n.Epoch, n.Leader, strings.Join(ps, ", "))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"kvstore/client"
+)
+
+func TestParseConfig(t *testing.T) {
+	cases := []struct {
+		args    []string
+		n, w, r int
+		err     string
+	}{
+		{[]string{"N=5", "W=3", "R=2"}, 5, 3, 2, ""},
+		{[]string{"w=2"}, 0, 2, 0, ""},
+		{[]string{"R=1", "R=3"}, 0, 0, 3, ""},
+		{[]string{"N"}, 0, 0, 0, `bad setting "N"`},
+		{[]string{"W=two"}, 0, 0, 0, `bad setting "W=two"`},
+		{[]string{"R=0"}, 0, 0, 0, `bad setting "R=0"`},
+		{[]string{"Q=1"}, 0, 0, 0, `unknown setting "Q"`},
+	}
+	for _, c := range cases {
+		n, w, r, err := parseConfig(c.args)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%v: err %v, want %q", c.args, err, c.err)
+			}
+			continue
+		}
+		if err != nil || n != c.n || w != c.w || r != c.r {
+			t.Errorf("%v: N=%d W=%d R=%d err %v, want N=%d W=%d R=%d", c.args, n, w, r, err, c.n, c.w, c.r)
+		}
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).UnixNano()
+	written := time.Unix(0, ts).Format(time.RFC3339Nano)
+	kvs := []client.KV{{Key: "user", Entry: client.Entry{Value: "Alice", Timestamp: ts}}}
+	nodes := []client.NodeStatus{
+		{Endpoint: "a:1", Self: "a:1", Leader: "a:1", Epoch: 2, IsLeader: true,
+			Peers: []client.PeerInfo{{Addr: "b:1", LagSeconds: 0.5, RTTMillis: 1.25, Reachable: true}, {Addr: "c:1"}}},
+		{Endpoint: "c:1", Err: "connection refused"},
+	}
+	rep := doctorReport{Findings: []finding{
+		{Check: "config", OK: true, Detail: "N=3 on every node"},
+		{Check: "clock", Detail: "b:1 runs 1s ahead of a:1", Fix: "run NTP"},
+	}}
+
+	cases := []struct {
+		name string
+		json bool
+		run  func(p printer)
+		want string
+	}{
+		{"ok", false, func(p printer) { p.ok(nil, "OK") }, "OK\n"},
+		{"ok after an error", false, func(p printer) { p.ok(errors.New("boom"), "OK") }, ""},
+		{"ok as JSON", true, func(p printer) { p.ok(nil, "OK") }, "{\n  \"ok\": true\n}\n"},
+		{"entries", false, func(p printer) { p.entries(kvs) },
+			"KEY   VALUE    WRITTEN" + strings.Repeat(" ", len(written)-5) + "TIMESTAMP\n" +
+				"user  \"Alice\"  " + written + "  " + "1714564800000000000\n"},
+		{"entries as JSON", true, func(p printer) { p.entries(kvs) },
+			"[\n  {\n    \"key\": \"user\",\n    \"value\": \"Alice\",\n    \"timestamp\": 1714564800000000000\n  }\n]\n"},
+		{"status", false, func(p printer) { p.status(nodes) },
+			"NODE  ROLE         EPOCH  LEADER  PEERS (lag / rtt)\n" +
+				"a:1   leader       2      a:1     b:1 0.500s/1.2ms, c:1 0.000s/0.0ms (down)\n" +
+				"c:1   unreachable  -      -       connection refused\n"},
+		{"report", false, func(p printer) { p.report(rep) },
+			"ok   config       N=3 on every node\n" +
+				"FAIL clock        b:1 runs 1s ahead of a:1\n" +
+				"                  -> run NTP\n"},
+		{"healthy report", false, func(p printer) { p.report(doctorReport{Healthy: true}) }, "no problems found\n"},
+	}
+	for _, c := range cases {
+		var b strings.Builder
+		c.run(printer{json: c.json, w: &b})
+		if b.String() != c.want {
+			t.Errorf("%s:\ngot\n%s\nwant\n%s", c.name, b.String(), c.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Content negotiation for /get, /set and /mget. Besides JSON, clients may
+// use MessagePack (maps with the same field names as the JSON) or
+// protobuf, with these messages:
+//
+//	message Entry        { string value = 1; int64 timestamp = 2; bool deleted = 3; string node = 4;
+//	                       string type = 5; repeated Entry siblings = 6; }
+//	message SetRequest   { string key = 1; string value = 2; }
+//	message MGetRequest  { repeated string keys = 1; }
+//	message KV           { string key = 1; Entry entry = 2; }
+//	message MGetResponse { repeated KV entries = 1; }
+
+const (
+	formatJSON     = "application/json"
+	formatMsgpack  = "application/msgpack"
+	formatProtobuf = "application/x-protobuf"
+)
+
+// maxBodyBytes bounds request bodies decoded by the negotiated handlers.
+const maxBodyBytes = 1 << 20
+
+var mediaTypes = map[string]string{
+	"application/json":       formatJSON,
+	"application/msgpack":    formatMsgpack,
+	"application/x-msgpack":  formatMsgpack,
+	"application/protobuf":   formatProtobuf,
+	"application/x-protobuf": formatProtobuf,
+}
+
+// responseFormat picks the first supported type in the Accept header,
+// falling back to JSON.
+func responseFormat(r *http.Request) string {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if f, ok := mediaTypes[mt]; ok {
+			return f
+		}
+	}
+	return formatJSON
+}
+
+// requestFormat reports the format of the request body, or "" for a
+// missing or unknown Content-Type.
+func requestFormat(r *http.Request) string {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaTypes[mt]
+}
+
+func protoEntry(e Entry) protoBuf {
+	var b protoBuf
+	b.bytes(1, []byte(e.Value))
+	b.int(2, e.Timestamp)
+	b.bool(3, e.Deleted)
+	b.bytes(4, []byte(e.Node))
+	b.bytes(5, []byte(e.Type))
+	for _, s := range e.Siblings {
+		b.message(6, protoEntry(s))
+	}
+	return b
+}
+
+func mpEntry(b []byte, e Entry) []byte {
+	n := 2
+	if e.Deleted {
+		n++
+	}
+	if e.Node != "" {
+		n++
+	}
+	if e.Type != "" {
+		n++
+	}
+	if len(e.Siblings) > 0 {
+		n++
+	}
+	b = mpMapHeader(b, n)
+	b = mpStr(mpStr(b, "value"), e.Value)
+	b = mpInt(mpStr(b, "timestamp"), e.Timestamp)
+	if e.Deleted {
+		b = mpBool(mpStr(b, "deleted"), true)
+	}
+	if e.Node != "" {
+		b = mpStr(mpStr(b, "node"), e.Node)
+	}
+	if e.Type != "" {
+		b = mpStr(mpStr(b, "type"), e.Type)
+	}
+	if len(e.Siblings) > 0 {
+		b = mpArrayHeader(mpStr(b, "siblings"), len(e.Siblings))
+		for _, s := range e.Siblings {
+			b = mpEntry(b, s)
+		}
+	}
+	return b
+}
+
+// writeEntry sends e in the negotiated format.
+func writeEntry(w http.ResponseWriter, r *http.Request, e Entry) {
+	var bs []byte
+	f := responseFormat(r)
+	switch f {
+	case formatMsgpack:
+		bs = mpEntry(nil, e)
+	case formatProtobuf:
+		bs = protoEntry(e)
+	default:
+		bs, _ = json.Marshal(e)
+	}
+	w.Header().Set("Content-Type", f)
+	w.Write(bs)
+}
+
+// writeEntries sends the result of an /mget in the negotiated format.
+func writeEntries(w http.ResponseWriter, r *http.Request, kvs []KV) {
+	var bs []byte
+	f := responseFormat(r)
+	switch f {
+	case formatMsgpack:
+		bs = mpMapHeader(nil, len(kvs))
+		for _, kv := range kvs {
+			bs = mpEntry(mpStr(bs, kv.Key), kv.Entry)
+		}
+	case formatProtobuf:
+		var resp protoBuf
+		for _, kv := range kvs {
+			var m protoBuf
+			m.bytes(1, []byte(kv.Key))
+			m.message(2, protoEntry(kv.Entry))
+			resp.message(1, m)
+		}
+		bs = resp
+	default:
+		out := make(map[string]Entry, len(kvs))
+		for _, kv := range kvs {
+			out[kv.Key] = kv.Entry
+		}
+		bs, _ = json.Marshal(out)
+	}
+	w.Header().Set("Content-Type", f)
+	w.Write(bs)
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+}
+
+// decodeSetBody reads a SetRequest body in the request's Content-Type.
+func decodeSetBody(r *http.Request) (key, value string, err error) {
+	body, err := readBody(r)
+	if err != nil {
+		return "", "", err
+	}
+	switch requestFormat(r) {
+	case formatJSON:
+		var req struct{ Key, Value string }
+		err = json.Unmarshal(body, &req)
+		return req.Key, req.Value, err
+	case formatMsgpack:
+		v, _, err := mpDecode(body)
+		m, ok := v.(map[string]any)
+		if err != nil || !ok {
+			return "", "", errBadMsgpack
+		}
+		key, _ = m["key"].(string)
+		value, _ = m["value"].(string)
+		return key, value, nil
+	case formatProtobuf:
+		fields, err := parseProto(body)
+		for _, f := range fields {
+			switch f.num {
+			case 1:
+				key = string(f.data)
+			case 2:
+				value = string(f.data)
+			}
+		}
+		return key, value, err
+	}
+	return "", "", errors.New("unsupported Content-Type")
+}
+
+// decodeKeys reads an MGetRequest body: a JSON or msgpack array of keys
+// (or {"keys": [...]}) or the protobuf message.
+func decodeKeys(r *http.Request) ([]string, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	switch requestFormat(r) {
+	case formatJSON:
+		if err := json.Unmarshal(body, &keys); err == nil {
+			return keys, nil
+		}
+		var req struct{ Keys []string }
+		err := json.Unmarshal(body, &req)
+		return req.Keys, err
+	case formatMsgpack:
+		v, _, err := mpDecode(body)
+		if err != nil {
+			return nil, err
+		}
+		if m, ok := v.(map[string]any); ok {
+			v = m["keys"]
+		}
+		arr, ok := v.([]any)
+		if !ok {
+			return nil, errBadMsgpack
+		}
+		for _, k := range arr {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", k)
+			}
+			keys = append(keys, s)
+		}
+		return keys, nil
+	case formatProtobuf:
+		fields, err := parseProto(body)
+		for _, f := range fields {
+			if f.num == 1 {
+				keys = append(keys, string(f.data))
+			}
+		}
+		return keys, err
+	}
+	return nil, errors.New("unsupported Content-Type")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMsgpackEntryRoundTrip(t *testing.T) {
+	v, rest, err := mpDecode(mpEntry(nil, Entry{Value: "v", Timestamp: 1 << 40, Deleted: true}))
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("decode: %v, %d trailing bytes", err, len(rest))
+	}
+	m := v.(map[string]any)
+	if m["value"] != "v" || m["timestamp"] != int64(1<<40) || m["deleted"] != true {
+		t.Fatalf("got %v", m)
+	}
+}
+
+func TestDecodeNegotiatedBodies(t *testing.T) {
+	var set protoBuf
+	set.bytes(1, []byte("k"))
+	set.bytes(2, []byte("v"))
+	r := httptest.NewRequest(http.MethodPost, "/set", bytes.NewReader(set))
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	if k, v, err := decodeSetBody(r); err != nil || k != "k" || v != "v" {
+		t.Fatalf("protobuf set = %q %q %v", k, v, err)
+	}
+
+	body := mpStr(mpStr(mpArrayHeader(nil, 2), "a"), "b")
+	r = httptest.NewRequest(http.MethodPost, "/mget", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/msgpack")
+	if keys, err := decodeKeys(r); err != nil || len(keys) != 2 || keys[1] != "b" {
+		t.Fatalf("msgpack keys = %v %v", keys, err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/get", nil)
+	r.Header.Set("Accept", "text/html, application/x-msgpack;q=0.9")
+	if f := responseFormat(r); f != formatMsgpack {
+		t.Fatalf("responseFormat = %q", f)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Bulk transfers between peers (/catchup for anti-entropy, repair,
+// rebalancing and leader hand-off, and /pb/resync) are Snappy-compressed
+// when the receiver speaks protocolCompression: the sender sets
+// Content-Encoding: snappy on batches of compressMinBytes or more, and the
+// receiver decodes them before the handler runs. Older peers get the
+// batch uncompressed. Responses need nothing new: peers already gzip large
+// ones for requests that accept it, as Go's client does by default.
+
+const (
+	snappyEncoding   = "snappy"
+	compressMinBytes = 1 << 10
+	maxBatchBytes    = 1 << 30 // decoded size of one compressed batch
+)
+
+// compressBatches is -PEER_COMPRESSION.
+var compressBatches = true
+
+// postBatch POSTs the JSON batch body to path on peer, compressed if the
+// peer can take it, wanting a 200.
+func postBatch(peer, path string, body []byte) error {
+	header := http.Header{"Content-Type": {"application/json"}}
+	if compressBatches && len(body) >= compressMinBytes && peerProtocolFor(peer) >= protocolCompression {
+		body = snappyEncode(body)
+		header.Set("Content-Encoding", snappyEncoding)
+	}
+	resp, err := peerNet.Send(context.Background(), peer, path, header, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		observeRejection(resp)
+		return fmt.Errorf("http://%s%s returned %d", peer, path, resp.StatusCode)
+	}
+	return nil
+}
+
+// decompressed decodes a Snappy-encoded request body for h.
+func decompressed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Content-Encoding") {
+		case "", "identity":
+		case snappyEncoding:
+			raw, err := io.ReadAll(io.LimitReader(r.Body, maxBatchBytes))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			plain, err := snappyDecode(raw, maxBatchBytes)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(plain)), int64(len(plain))
+			r.Header.Del("Content-Encoding")
+		default:
+			http.Error(w, "unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+		h(w, r)
+	}
+}
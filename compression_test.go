@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatchesAreCompressedForPeersThatSpeakIt(t *testing.T) {
+	batch := map[string]Entry{}
+	for i := 0; i < 200; i++ {
+		batch[fmt.Sprintf("user/%04d", i)] = Entry{Value: "some repeated value", Timestamp: int64(i), Node: "kv1:8000"}
+	}
+	body, _ := json.Marshal(batch)
+
+	var encoding string
+	var wire, got int
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding, wire = r.Header.Get("Content-Encoding"), int(r.ContentLength)
+		decompressed(func(w http.ResponseWriter, r *http.Request) {
+			bs, _ := io.ReadAll(r.Body)
+			if !bytes.Equal(bs, body) {
+				http.Error(w, "batch changed in transit", http.StatusBadRequest)
+				return
+			}
+			got = len(bs)
+		})(w, r)
+	}))
+	defer peer.Close()
+	addr := strings.TrimPrefix(peer.URL, "http://")
+
+	if err := postBatch(addr, "/catchup", body); err != nil {
+		t.Fatal(err)
+	}
+	if encoding != snappyEncoding || got != len(body) || wire*3 > len(body) {
+		t.Fatalf("sent %d of %d bytes as %q", wire, len(body), encoding)
+	}
+
+	// a peer on an older protocol gets it as is
+	peerProtocols.Store(addr, protocolSource)
+	defer peerProtocols.Delete(addr)
+	if err := postBatch(addr, "/catchup", body); err != nil || encoding != "" || wire != len(body) {
+		t.Fatalf("old peer got %d bytes as %q: %v", wire, encoding, err)
+	}
+}
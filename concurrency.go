@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// -CONCURRENCY_LIMITS caps how many of the expensive requests run at once,
+// so a client flood of them cannot starve replication and cheap requests
+// of CPU and sockets. Each class has a number of slots and a queue:
+// a request waits in the queue for a slot, and once the queue is full too
+// it gets 503 with Retry-After and the class's limit and depth. The
+// classes are quorum_read (R>1 /get and /mget, which fan out to peers),
+// scan (/scan) and dump (/dump, the full export).
+
+const (
+	classQuorumRead = "quorum_read"
+	classScan       = "scan"
+	classDump       = "dump"
+)
+
+var concurrencyClasses = []string{classQuorumRead, classScan, classDump}
+
+type concurrencyLimit struct {
+	slots    chan struct{}
+	queue    int64 // waiters allowed
+	waiting  atomic.Int64
+	rejected atomic.Int64
+}
+
+// limits holds the configured classes; classes without a limit are absent.
+var limits = map[string]*concurrencyLimit{}
+
+// configureLimits reads name=slots[/queue],... (queue defaults to slots).
+func configureLimits(spec string) error {
+	next := map[string]*concurrencyLimit{}
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, sizes, ok := strings.Cut(item, "=")
+		slots, queue, hasQueue := strings.Cut(sizes, "/")
+		if !ok || !slices.Contains(concurrencyClasses, name) {
+			return fmt.Errorf("concurrency limit %q: want one of %s as name=slots[/queue]", item, strings.Join(concurrencyClasses, ", "))
+		}
+		n, err := strconv.Atoi(slots)
+		if err != nil || n < 1 {
+			return fmt.Errorf("concurrency limit %q: slots must be at least 1", item)
+		}
+		q := n
+		if hasQueue {
+			if q, err = strconv.Atoi(queue); err != nil || q < 0 {
+				return fmt.Errorf("concurrency limit %q: bad queue length", item)
+			}
+		}
+		next[name] = &concurrencyLimit{slots: make(chan struct{}, n), queue: int64(q)}
+	}
+	limits = next
+	return nil
+}
+
+type saturated struct {
+	Status   int    `json:"status"`
+	Error    string `json:"error"`
+	Class    string `json:"class"`
+	Limit    int    `json:"limit"`
+	InFlight int    `json:"in_flight"`
+	Queued   int64  `json:"queued"`
+	Queue    int64  `json:"queue"`
+}
+
+// acquire takes a slot of class for r, waiting in its queue if need be.
+// It returns false, having answered 503, when the queue is full, and
+// false when the client gives up waiting; otherwise the caller must call
+// the release it returns.
+func acquire(w http.ResponseWriter, r *http.Request, class string) (release func(), ok bool) {
+	l := limits[class]
+	if l == nil {
+		return func() {}, true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, true
+	default:
+	}
+	if l.waiting.Add(1) > l.queue {
+		l.waiting.Add(-1)
+		l.rejected.Add(1)
+		w.Header().Set("Retry-After", "1")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(saturated{
+			Status: http.StatusServiceUnavailable, Error: class + " requests saturated, retry later", Class: class,
+			Limit: cap(l.slots), InFlight: len(l.slots), Queued: l.waiting.Load(), Queue: l.queue,
+		})
+		return nil, false
+	}
+	defer l.waiting.Add(-1)
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, true
+	case <-r.Context().Done():
+		return nil, false
+	}
+}
+
+// limited runs h under class's limit.
+func limited(class string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		release, ok := acquire(w, r, class)
+		if !ok {
+			return
+		}
+		defer release()
+		h(w, r)
+	}
+}
+
+// writeConcurrencyMetrics renders the limits for /metrics.
+func writeConcurrencyMetrics(w io.Writer) {
+	if len(limits) == 0 {
+		return
+	}
+	classes := make([]string, 0, len(limits))
+	for c := range limits {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+	fmt.Fprintln(w, "# HELP kv_concurrency_in_flight Expensive requests running, by -CONCURRENCY_LIMITS class.")
+	fmt.Fprintln(w, "# TYPE kv_concurrency_in_flight gauge")
+	for _, c := range classes {
+		fmt.Fprintf(w, "kv_concurrency_in_flight{class=%q} %d\n", c, len(limits[c].slots))
+	}
+	fmt.Fprintln(w, "# HELP kv_concurrency_queued Expensive requests waiting for a slot, by class.")
+	fmt.Fprintln(w, "# TYPE kv_concurrency_queued gauge")
+	for _, c := range classes {
+		fmt.Fprintf(w, "kv_concurrency_queued{class=%q} %d\n", c, limits[c].waiting.Load())
+	}
+	fmt.Fprintln(w, "# HELP kv_concurrency_rejected_total Expensive requests answered 503 with the queue full, by class.")
+	fmt.Fprintln(w, "# TYPE kv_concurrency_rejected_total counter")
+	for _, c := range classes {
+		fmt.Fprintf(w, "kv_concurrency_rejected_total{class=%q} %d\n", c, limits[c].rejected.Load())
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimitQueuesThenRejects(t *testing.T) {
+	defer configureLimits("")
+	if err := configureLimits("scan=1/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := configureLimits("export=1"); err == nil {
+		t.Fatal("unknown class accepted")
+	}
+	configureLimits("scan=1/1")
+
+	unblock := make(chan struct{})
+	running := make(chan struct{}, 2)
+	h := limited(classScan, func(w http.ResponseWriter, r *http.Request) {
+		running <- struct{}{}
+		<-unblock
+	})
+	codes := make([]int, 2)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodGet, "/scan", nil))
+			codes[i] = rec.Code
+		}()
+		if i == 0 {
+			<-running
+		}
+	}
+	// one running, one queued: the next is turned away
+	for limits[classScan].waiting.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/scan", nil))
+	var body saturated
+	if rec.Code != http.StatusServiceUnavailable || json.Unmarshal(rec.Body.Bytes(), &body) != nil ||
+		body.InFlight != 1 || body.Queued != 1 || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("saturated = %d %s", rec.Code, rec.Body)
+	}
+
+	close(unblock)
+	wg.Wait()
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK {
+		t.Fatalf("running and queued requests = %v", codes)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// CRDT values are kept as an ordinary entry whose Type names the data type
+// and whose Value is the JSON state below. When two versions of the same
+// type meet, on replication, catch-up or a quorum read, their states are
+// merged instead of one replacing the other, so concurrent updates from
+// different coordinators all survive whatever resolver the key uses.
+// A plain write or a delete replaces a CRDT like any other value.
+//
+//	gcounter   {"p":{"node":n}}                      value = Σp
+//	pncounter  {"p":{"node":n},"n":{"node":n}}       value = Σp - Σn
+//	orset      {"a":{"elem":["tag"]},"r":["tag"]}    value = elems with a tag not in r
+//
+// Each node only ever raises its own counter slots and every added
+// element gets a fresh tag, so merging by per-slot max and set union is
+// commutative and idempotent. Removed tags are remembered for good.
+const (
+	typeGCounter  = "gcounter"
+	typePNCounter = "pncounter"
+	typeORSet     = "orset"
+)
+
+type crdtState struct {
+	P map[string]int64    `json:"p,omitempty"`
+	N map[string]int64    `json:"n,omitempty"`
+	A map[string][]string `json:"a,omitempty"`
+	R []string            `json:"r,omitempty"`
+}
+
+func isCRDT(typ string) bool {
+	return typ == typeGCounter || typ == typePNCounter || typ == typeORSet
+}
+
+func parseState(e Entry) (crdtState, error) {
+	var s crdtState
+	if e.Value == "" {
+		return s, nil
+	}
+	err := json.Unmarshal([]byte(e.Value), &s)
+	return s, err
+}
+
+// encode writes the state canonically (sorted keys and tags), so replicas
+// holding the same state hold the same bytes.
+func (s crdtState) encode() string {
+	for elem, tags := range s.A {
+		sort.Strings(tags)
+		s.A[elem] = tags
+	}
+	sort.Strings(s.R)
+	bs, _ := json.Marshal(s)
+	return string(bs)
+}
+
+func maxSlots(a, b map[string]int64) map[string]int64 {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	out := make(map[string]int64, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v > out[k] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func sum(slots map[string]int64) int64 {
+	var n int64
+	for _, v := range slots {
+		n += v
+	}
+	return n
+}
+
+func mergeStates(a, b crdtState) crdtState {
+	out := crdtState{P: maxSlots(a.P, b.P), N: maxSlots(a.N, b.N)}
+	removed := map[string]bool{}
+	for _, t := range append(a.R, b.R...) {
+		if !removed[t] {
+			removed[t] = true
+			out.R = append(out.R, t)
+		}
+	}
+	for _, adds := range []map[string][]string{a.A, b.A} {
+		for elem, tags := range adds {
+			for _, t := range tags {
+				if removed[t] || contains(out.A[elem], t) {
+					continue
+				}
+				if out.A == nil {
+					out.A = map[string][]string{}
+				}
+				out.A[elem] = append(out.A[elem], t)
+			}
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeCRDT merges two live versions of the same CRDT type. It reports
+// false when they cannot be merged, leaving the key's resolver to decide.
+func mergeCRDT(cur, in Entry) (Entry, bool) {
+	if cur.Deleted || in.Deleted || cur.Type != in.Type || !isCRDT(cur.Type) {
+		return Entry{}, false
+	}
+	a, errA := parseState(cur)
+	b, errB := parseState(in)
+	if errA != nil || errB != nil {
+		return Entry{}, false
+	}
+	out := cur
+	if in.newerThan(cur) {
+		out = in
+	}
+	out.Value = mergeStates(a, b).encode()
+	out.Clock = cur.Clock.merge(in.Clock)
+	out.Siblings = nil
+	return out, true
+}
+
+// crdtValue is what /crdt/value reports for a CRDT entry.
+func crdtValue(e Entry) (any, error) {
+	s, err := parseState(e)
+	if err != nil {
+		return nil, err
+	}
+	switch e.Type {
+	case typeGCounter, typePNCounter:
+		return sum(s.P) - sum(s.N), nil
+	case typeORSet:
+		elems := make([]string, 0, len(s.A))
+		for elem := range s.A {
+			elems = append(elems, elem)
+		}
+		sort.Strings(elems)
+		return elems, nil
+	}
+	return nil, fmt.Errorf("not a CRDT: %q", e.Type)
+}
+
+// crdtUpdate returns a write condition applying op to the current state of
+// a typ key. A key holding something else, other than a tombstone, is left
+// alone and the write fails its precondition.
+func crdtUpdate(typ string, op func(s *crdtState, e *Entry)) writeCond {
+	return func(cur Entry, ok bool, e *Entry) bool {
+		var s crdtState
+		if ok && !cur.Deleted {
+			if cur.Type != typ {
+				return false
+			}
+			var err error
+			if s, err = parseState(cur); err != nil {
+				return false
+			}
+		}
+		op(&s, e)
+		e.Type, e.Value = typ, s.encode()
+		return true
+	}
+}
+
+// crdtIncrHandler adds ?by= (default 1) to a gcounter or pncounter key,
+// chosen by ?type= (default gcounter). Only a pncounter may go down.
+func crdtIncrHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key, typ := q.Get("key"), q.Get("type")
+	if typ == "" {
+		typ = typeGCounter
+	}
+	if typ != typeGCounter && typ != typePNCounter {
+		httpError(w, http.StatusBadRequest, "type", "type must be gcounter or pncounter")
+		return
+	}
+	by := int64(1)
+	if s := q.Get("by"); s != "" {
+		var err error
+		if by, err = strconv.ParseInt(s, 10, 64); err != nil {
+			httpError(w, http.StatusBadRequest, "by", "by must be an integer")
+			return
+		}
+	}
+	if by < 0 && typ == typeGCounter {
+		httpError(w, http.StatusBadRequest, "by", "a gcounter cannot be decremented")
+		return
+	}
+	coordinateWrite(w, r, key, Entry{}, crdtUpdate(typ, func(s *crdtState, _ *Entry) {
+		slots, n := &s.P, by
+		if n < 0 {
+			slots, n = &s.N, -n
+		}
+		if *slots == nil {
+			*slots = map[string]int64{}
+		}
+		(*slots)[self] += n
+	}))
+}
+
+// crdtAddHandler adds ?elem= to an orset key under a fresh tag.
+func crdtAddHandler(w http.ResponseWriter, r *http.Request) {
+	key, elem := r.URL.Query().Get("key"), r.URL.Query().Get("elem")
+	if !validElem(w, elem) {
+		return
+	}
+	coordinateWrite(w, r, key, Entry{}, crdtUpdate(typeORSet, func(s *crdtState, e *Entry) {
+		if s.A == nil {
+			s.A = map[string][]string{}
+		}
+		s.A[elem] = append(s.A[elem], fmt.Sprintf("%s/%d", originID(), e.Seq))
+	}))
+}
+
+// crdtRemoveHandler removes ?elem= from an orset key. Only the additions
+// this coordinator has seen are removed; a concurrent add elsewhere wins.
+func crdtRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	key, elem := r.URL.Query().Get("key"), r.URL.Query().Get("elem")
+	if !validElem(w, elem) {
+		return
+	}
+	coordinateWrite(w, r, key, Entry{}, crdtUpdate(typeORSet, func(s *crdtState, _ *Entry) {
+		s.R = append(s.R, s.A[elem]...)
+		delete(s.A, elem)
+	}))
+}
+
+func validElem(w http.ResponseWriter, elem string) bool {
+	switch {
+	case elem == "":
+		httpError(w, http.StatusBadRequest, "elem", "elem required")
+	case len(elem) > maxValueBytes:
+		httpError(w, http.StatusBadRequest, "elem", "elem too large")
+	default:
+		return true
+	}
+	return false
+}
+
+// crdtValueHandler reads a CRDT key with the usual read quorum and reports
+// its type and computed value.
+func crdtValueHandler(w http.ResponseWriter, r *http.Request) {
+	e, ok := readKey(traceOf(r), r.URL.Query().Get("key"), readQuorum(r))
+	if !ok || e.Deleted {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	v, err := crdtValue(e)
+	if err != nil {
+		httpError(w, http.StatusConflict, "key", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"type": e.Type, "value": v})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCRDTMergeConverges(t *testing.T) {
+	counter := func(node string, p, n int64, ts int64) Entry {
+		s := crdtState{P: map[string]int64{node: p}, N: map[string]int64{node: n}}
+		return Entry{Type: typePNCounter, Value: s.encode(), Timestamp: ts, Node: node}
+	}
+	a, b, c := counter("kv1", 5, 1, 10), counter("kv2", 3, 0, 20), counter("kv1", 7, 2, 30)
+
+	fold := func(in ...Entry) Entry {
+		cur := in[0]
+		for _, e := range in[1:] {
+			cur, _ = mergeEntry("k", cur, true, e)
+		}
+		return cur
+	}
+	x, y := fold(a, b, c), fold(c, b, a, b)
+	if !reflect.DeepEqual(x, y) {
+		t.Fatalf("order changed the merge:\n%+v\n%+v", x, y)
+	}
+	if v, _ := crdtValue(x); v != int64(8) {
+		t.Errorf("pncounter value = %v, want 7-2+3 = 8", v)
+	}
+
+	// a remove only covers the tags it saw; a concurrent add survives
+	set := func(a map[string][]string, r ...string) Entry {
+		return Entry{Type: typeORSet, Value: crdtState{A: a, R: r}.encode()}
+	}
+	removed := set(map[string][]string{"y": {"t2"}}, "t1")
+	concurrent := set(map[string][]string{"x": {"t1", "t3"}})
+	merged, _ := mergeCRDT(removed, concurrent)
+	if v, _ := crdtValue(merged); !reflect.DeepEqual(v, []string{"x", "y"}) {
+		t.Errorf("orset value = %v, want [x y]", v)
+	}
+
+	if _, ok := mergeCRDT(a, Entry{Value: "plain", Timestamp: 40}); ok {
+		t.Error("merged a CRDT with a plain value")
+	}
+}
+
+func TestCRDTConcurrentCoordinators(t *testing.T) {
+	p1, p2 := 9108, 9109
+	n1 := startNode(t, p1, []string{fmt.Sprintf("localhost:%d", p2)}, false, 2, 1, 2)
+	defer n1.Process.Kill()
+	n2 := startNode(t, p2, []string{fmt.Sprintf("localhost:%d", p1)}, false, 2, 1, 2)
+	defer n2.Process.Kill()
+	waitReady(t, p1, p2)
+
+	post := func(port int, path string) {
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d%s", port, path), "", nil)
+		if err != nil {
+			t.Errorf("POST %s: %v", path, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("POST %s = %d", path, resp.StatusCode)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		for _, port := range []int{p1, p2} {
+			wg.Add(1)
+			go func(port int) {
+				defer wg.Done()
+				post(port, "/crdt/incr?key=hits&by=2")
+			}(port)
+		}
+	}
+	wg.Add(2)
+	go func() { defer wg.Done(); post(p1, "/crdt/add?key=tags&elem=a") }()
+	go func() { defer wg.Done(); post(p2, "/crdt/add?key=tags&elem=b") }()
+	wg.Wait()
+
+	value := func(port int, key string) any {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/crdt/value?key=%s", port, key))
+		if err != nil {
+			t.Fatalf("value: %v", err)
+		}
+		defer resp.Body.Close()
+		var out struct{ Value any }
+		json.NewDecoder(resp.Body).Decode(&out)
+		return out.Value
+	}
+	for _, port := range []int{p1, p2} {
+		if v := value(port, "hits"); v != float64(12) {
+			t.Errorf("node %d: hits = %v, want 12", port, v)
+		}
+		if v := value(port, "tags"); !reflect.DeepEqual(v, []any{"a", "b"}) {
+			t.Errorf("node %d: tags = %v, want [a b]", port, v)
+		}
+	}
+
+	post(p1, "/crdt/remove?key=tags&elem=a")
+	if v := value(p2, "tags"); !reflect.DeepEqual(v, []any{"b"}) {
+		t.Errorf("after remove: tags = %v, want [b]", v)
+	}
+}
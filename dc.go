@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Consistency levels a client can request per write with ?consistency=.
+// Without one, the node's W applies as before.
+const (
+	LocalQuorum = "LOCAL_QUORUM" // quorum of this node's datacenter
+	EachQuorum  = "EACH_QUORUM"  // quorum in every datacenter
+)
+
+var (
+	localDC   = "default"
+	localZone string
+)
+
+// parsePeers splits -PEERS into addresses, returning any "@dc" or
+// "@dc/zone" suffix as that peer's location. Untagged peers share the
+// default datacenter; a peer without a zone is its datacenter's only zone.
+func parsePeers(s string) (addrs []string, dcs, zones map[string]string) {
+	dcs, zones = map[string]string{}, map[string]string{}
+	for _, p := range strings.Split(s, ",") {
+		addr, dc, zone := splitPeer(p)
+		dcs[addr], zones[addr] = dc, zone
+		addrs = append(addrs, addr)
+	}
+	return addrs, dcs, zones
+}
+
+// splitPeer splits one host:port[@dc[/zone]] entry.
+func splitPeer(p string) (addr, dc, zone string) {
+	addr, loc, _ := strings.Cut(p, "@")
+	dc, zone, _ = strings.Cut(loc, "/")
+	if dc == "" {
+		dc = "default"
+	}
+	return addr, dc, zone
+}
+
+// zoneOf names the failure domain member lives in, qualified by its
+// datacenter so equally named racks in different DCs stay distinct.
+func zoneOf(member string) string {
+	zone := localZone
+	if member != self {
+		zone = cluster().peerZone[member]
+	}
+	return dcOf(member) + "/" + zone
+}
+
+func dcOf(member string) string {
+	if member == self {
+		return localDC
+	}
+	if dc, ok := cluster().peerDC[member]; ok {
+		return dc
+	}
+	return "default"
+}
+
+// peersByDC groups list by datacenter, keeping its order.
+func peersByDC(list []string) map[string][]string {
+	out := make(map[string][]string)
+	for _, p := range list {
+		out[dcOf(p)] = append(out[dcOf(p)], p)
+	}
+	return out
+}
+
+// dcQuorum is the majority of key's replicas in dc, counting this node if
+// it lives there and holds key.
+func dcQuorum(dc, key string, dcPeers []string) int {
+	n := len(dcPeers)
+	if dc == localDC {
+		n += selfAcks(key)
+	}
+	return n/2 + 1
+}
+
+func parseConsistency(r *http.Request) (string, error) {
+	switch level := strings.ToUpper(r.URL.Query().Get("consistency")); level {
+	case "", LocalQuorum, EachQuorum:
+		return level, nil
+	default:
+		return "", fmt.Errorf("unknown consistency level %q", level)
+	}
+}
+
+// replicateDC replicates an already-applied local write according to a
+// per-datacenter consistency level. Datacenters that must reach quorum are
+// replicated synchronously until they do; everything else is replicated in
+// the background, as are the required ones left once ctx ends. It reports
+// the acks collected, the acks required and whether every required quorum
+// was met.
+func replicateDC(ctx context.Context, tr *reqTrace, ws *writeStatus, level, key string, e Entry) (acks, need int, ok bool) {
+	groups := peersByDC(replicaPeers(key))
+	if _, ok := groups[localDC]; !ok && ownedBy(key, self) {
+		groups[localDC] = nil
+	}
+	dcs := make([]string, 0, len(groups))
+	for dc := range groups {
+		dcs = append(dcs, dc)
+	}
+	sort.Strings(dcs)
+
+	ok = true
+	var rest []string
+	for _, dc := range dcs {
+		members := groups[dc]
+		if level == LocalQuorum && dc != localDC {
+			rest = append(rest, members...)
+			continue
+		}
+		got := 0
+		if dc == localDC {
+			got = selfAcks(key)
+		}
+		want := dcQuorum(dc, key, members)
+		i := 0
+		for ; i < len(members) && got < want; i++ {
+			start := time.Now()
+			if !pause(ctx, LeaderDelayPerFollower) {
+				break
+			}
+			ok := replicateWithin(ctx, members[i], key, e)
+			tr.peerAck(members[i], start, ok)
+			ws.replicated(members[i], ok)
+			if ok {
+				got++
+			}
+		}
+		rest = append(rest, members[i:]...)
+		acks, need = acks+got, need+want
+		if got < want {
+			ok = false
+		}
+	}
+
+	for _, peer := range rest {
+		asyncRepl.Add(1)
+		go func(p string) {
+			defer asyncRepl.Done()
+			nodeClock.Sleep(LeaderDelayPerFollower)
+			ws.replicated(p, replicateTo(p, key, e))
+		}(peer)
+	}
+	return acks, need, ok
+}
+
+// writeDC finishes a write under a per-datacenter consistency level,
+// answering done on success.
+func writeDC(w http.ResponseWriter, tr *reqTrace, ws *writeStatus, level, key string, e Entry, done int) {
+	ctx, cancel := writeBudget()
+	defer cancel()
+	if acks, need, ok := replicateDC(ctx, tr, ws, level, key, e); !ok {
+		quorumFailed(w, ctx, ws, e, acks, need)
+		return
+	}
+	w.WriteHeader(done)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDC_LocalQuorumVsEachQuorum(t *testing.T) {
+	lPort, eastPort, westPort := 9061, 9062, 9063
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	peerList := []string{addr(eastPort) + "@east", addr(westPort) + "@west"}
+
+	leader := startNode(t, lPort, peerList, true, 3, 1, 3, "-DC", "east")
+	east := startNode(t, eastPort, []string{addr(lPort)}, false, 3, 1, 3, "-DC", "east")
+	west := startNode(t, westPort, []string{addr(lPort)}, false, 3, 1, 3, "-DC", "west")
+	defer leader.Process.Kill()
+	defer east.Process.Kill()
+	defer west.Process.Kill()
+	waitReady(t, lPort, eastPort, westPort)
+
+	set := func(key, level string) {
+		url := fmt.Sprintf("http://%s/set?key=%s&value=v&consistency=%s", addr(lPort), key, level)
+		resp, err := http.Post(url, "", nil)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", url, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("%s write: expected 201, got %d", level, resp.StatusCode)
+		}
+	}
+	localRead := func(port int, key string) int {
+		_, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=%s", addr(port), key))
+		return code
+	}
+
+	// LOCAL_QUORUM acks once the east pair has it; west catches up later
+	set("local", LocalQuorum)
+	if code := localRead(eastPort, "local"); code != http.StatusOK {
+		t.Errorf("LOCAL_QUORUM: east replica missing write (code %d)", code)
+	}
+	if code := localRead(westPort, "local"); code == http.StatusOK {
+		t.Errorf("LOCAL_QUORUM: expected west to still be stale")
+	}
+	time.Sleep(500 * time.Millisecond)
+	if code := localRead(westPort, "local"); code != http.StatusOK {
+		t.Errorf("LOCAL_QUORUM: west never received async write (code %d)", code)
+	}
+
+	// EACH_QUORUM needs west's lone replica before acking
+	set("each", EachQuorum)
+	if code := localRead(westPort, "each"); code != http.StatusOK {
+		t.Errorf("EACH_QUORUM: west replica missing write (code %d)", code)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Every write a node coordinates is tagged with the node's origin ID and
+// the next number in its sequence. Followers remember, per origin, which
+// recent sequence numbers they have applied and drop repeats, so
+// replication can be retried or batched without applying a write twice.
+// A follower checks a delivery with seenWrite and marks it with
+// commitWrite only once it is stored and synced: a delivery refused or
+// lost on the way in is not yet applied, and its retry must not be
+// acknowledged as a repeat. Two copies of one write racing in may both
+// be applied, which merging makes harmless.
+//
+// A node's sequence starts from zero when it restarts, so the origin ID
+// includes the start time to keep incarnations apart.
+
+// seqWindow is how many sequence numbers below the highest seen are
+// tracked per origin. Deliveries older than that are dropped as stale:
+// the entry they carry has long been superseded or resent by catch-up.
+const seqWindow = 1024
+
+var (
+	writeSeq        atomic.Int64
+	duplicateWrites atomic.Int64
+
+	dedupMu sync.Mutex
+	seenBy  = make(map[string]*seqTracker)
+)
+
+func originID() string { return fmt.Sprintf("%s@%d", self, startedAt.UnixNano()) }
+
+// nextSeq numbers a write coordinated by this node.
+func nextSeq() int64 { return writeSeq.Add(1) }
+
+// seqTracker is a sliding window over one origin's sequence numbers:
+// bit i of seen is set when high-i has been applied.
+type seqTracker struct {
+	high int64
+	seen [seqWindow / 64]uint64
+}
+
+func (t *seqTracker) bit(i int64) (*uint64, uint64) { return &t.seen[i/64], 1 << (i % 64) }
+
+// admit reports whether seq is new, marking it applied if so.
+func (t *seqTracker) admit(seq int64) bool {
+	if t.has(seq) {
+		return false
+	}
+	t.mark(seq)
+	return true
+}
+
+// mark records seq as applied. Numbers below the window need no mark.
+func (t *seqTracker) mark(seq int64) {
+	if seq > t.high {
+		t.shift(seq - t.high)
+		t.high = seq
+	}
+	if off := t.high - seq; off < seqWindow {
+		w, b := t.bit(off)
+		*w |= b
+	}
+}
+
+// has reports whether seq has been marked, counting numbers below the
+// window as marked.
+func (t *seqTracker) has(seq int64) bool {
+	if seq > t.high {
+		return false
+	}
+	off := t.high - seq
+	if off >= seqWindow {
+		return true
+	}
+	w, b := t.bit(off)
+	return *w&b != 0
+}
+
+// shift moves the window up by n, making room for newer numbers.
+func (t *seqTracker) shift(n int64) {
+	if n >= seqWindow {
+		t.seen = [seqWindow / 64]uint64{}
+		return
+	}
+	words, bits := int(n/64), uint(n%64)
+	for i := len(t.seen) - 1; i >= 0; i-- {
+		var v uint64
+		if j := i - words; j >= 0 {
+			v = t.seen[j] << bits
+			if bits > 0 && j > 0 {
+				v |= t.seen[j-1] >> (64 - bits)
+			}
+		}
+		t.seen[i] = v
+	}
+}
+
+// parseSeq reads a replicated write's ?seq=, 0 for an untagged write.
+func parseSeq(origin, seqStr string) (int64, error) {
+	if origin == "" || seqStr == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	if err != nil || seq <= 0 {
+		return 0, fmt.Errorf("invalid seq %q", seqStr)
+	}
+	return seq, nil
+}
+
+// seenWrite reports whether the write a delivery's ?origin= and ?seq= name
+// has already been applied here, without marking it. Untagged writes are
+// never seen.
+func seenWrite(origin, seqStr string) (bool, error) {
+	seq, err := parseSeq(origin, seqStr)
+	if err != nil || seq == 0 {
+		return false, err
+	}
+	dedupMu.Lock()
+	defer dedupMu.Unlock()
+	if t := seenBy[origin]; t != nil && t.has(seq) {
+		duplicateWrites.Add(1)
+		return true, nil
+	}
+	return false, nil
+}
+
+// commitWrite marks the write ?origin= and ?seq= name as applied here,
+// once it is stored and synced, so later deliveries of it are dropped.
+func commitWrite(origin, seqStr string) {
+	seq, err := parseSeq(origin, seqStr)
+	if err != nil || seq == 0 {
+		return
+	}
+	dedupMu.Lock()
+	defer dedupMu.Unlock()
+	t := seenBy[origin]
+	if t == nil {
+		t = &seqTracker{}
+		seenBy[origin] = t
+	}
+	t.mark(seq)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestSeqTrackerWindow(t *testing.T) {
+	var tr seqTracker
+	steps := []struct {
+		seq  int64
+		want bool
+	}{
+		{1, true}, {3, true}, {2, true}, // reordered, still new
+		{2, false}, {3, false}, // duplicates
+		{70, true}, {5, true}, {5, false}, // across a word boundary
+		{3 + seqWindow, true}, {3, false}, // 3 is now outside the window
+		{70, false}, {69, true},
+		{5000, true}, {69, false}, {4999, true},
+	}
+	for _, s := range steps {
+		if got := tr.admit(s.seq); got != s.want {
+			t.Fatalf("admit(%d) = %v, want %v", s.seq, got, s.want)
+		}
+	}
+}
+
+func TestSeenWriteUntaggedAndBadSeq(t *testing.T) {
+	commitWrite("", "")
+	if seen, err := seenWrite("", ""); seen || err != nil {
+		t.Fatalf("untagged write: %v %v", seen, err)
+	}
+	if _, err := seenWrite("n1@1", "x"); err == nil {
+		t.Fatalf("bad seq accepted")
+	}
+	if seen, _ := seenWrite("n1@1", "7"); seen {
+		t.Fatalf("first delivery seen")
+	}
+	// a delivery refused before it was applied is not marked, so its
+	// retry is still new
+	if seen, _ := seenWrite("n1@1", "7"); seen {
+		t.Fatalf("retry of an unapplied delivery seen")
+	}
+	commitWrite("n1@1", "7")
+	if seen, _ := seenWrite("n1@1", "7"); !seen {
+		t.Fatalf("duplicate of an applied write not seen")
+	}
+	if seen, _ := seenWrite("n2@1", "7"); seen {
+		t.Fatalf("same seq from another origin seen")
+	}
+}
+
+// a delivery refused for a bad checksum is not applied, so its clean retry
+// must be stored rather than acked as a duplicate
+func TestReplicateRetryAfterRefusedDelivery(t *testing.T) {
+	oldSleep := FollowerUpdateSleep
+	defer func() {
+		FollowerUpdateSleep = oldSleep
+		svc.Lock()
+		delete(svc.data, "retried")
+		svc.Unlock()
+	}()
+	FollowerUpdateSleep = 0
+	withCluster(t, func(c *membership) { c.N, c.W = 2, 2 })
+
+	e := Entry{Value: "v", Timestamp: 1, Node: "a"}
+	query := "key=retried&value=v&timestamp=1&node=a&origin=a@1&seq=41&crc="
+	good := strconv.FormatUint(uint64(e.sum("retried")), 10)
+	deliver := func(crc string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		replicateHandler(rec, httptest.NewRequest(http.MethodPost, "/replicate?"+query+crc, nil))
+		return rec
+	}
+	if rec := deliver("1"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("corrupt delivery = %d", rec.Code)
+	}
+	if rec := deliver(good); rec.Code != http.StatusOK || rec.Header().Get("X-Duplicate") != "" {
+		t.Fatalf("clean retry = %d, duplicate %q", rec.Code, rec.Header().Get("X-Duplicate"))
+	}
+	if got := svc.snapshot()["retried"]; got.Value != "v" {
+		t.Fatalf("retried key = %+v, want it stored", got)
+	}
+	if rec := deliver(good); rec.Header().Get("X-Duplicate") != "true" {
+		t.Fatalf("redelivery of an applied write not acked as a duplicate")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// /admin/diff?peer= spot-checks this node against one peer: it samples
+// up to ?sample= of the keys both hold (under ?prefix=, tombstones
+// included), asks the peer for each one's digest (see readstrategy.go)
+// and lists the keys whose copies differ, with which side is newer. With
+// POST ?repair=true it also merges the two copies of each divergent key
+// and brings both sides up to date, as /admin/repair does. It costs a
+// digest per sampled key, far less than a Merkle exchange of the whole
+// range, but only finds keys this node has; run it on the peer too for
+// the other direction.
+
+const (
+	defaultDiffSample = 100
+	maxDiffSample     = 10000
+	diffParallel      = 16 // digests asked for at once
+)
+
+// diffSide is one side's copy of a divergent key; nil when it has none.
+type diffSide struct {
+	Timestamp int64  `json:"timestamp"`
+	Node      string `json:"node,omitempty"`
+	Checksum  uint32 `json:"checksum"`
+}
+
+type diffKey struct {
+	Key   string    `json:"key"`
+	Local *diffSide `json:"local"`
+	Peer  *diffSide `json:"peer"`
+	Newer string    `json:"newer"` // local, peer, or "" when neither supersedes the other
+}
+
+type diffReport struct {
+	Peer      string    `json:"peer"`
+	Candidate int       `json:"candidates"` // keys both nodes should hold
+	Sampled   int       `json:"sampled"`
+	Divergent []diffKey `json:"divergent"`
+	Errors    int       `json:"errors,omitempty"` // keys the peer could not be asked about
+	Repaired  int       `json:"repaired,omitempty"`
+	Failed    []string  `json:"failed,omitempty"` // key@peer pushes that failed
+}
+
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	peer := q.Get("peer")
+	if !slices.Contains(cluster().peers, peer) {
+		httpError(w, http.StatusBadRequest, "peer", fmt.Sprintf("peer must be one of %s", strings.Join(cluster().peers, ",")))
+		return
+	}
+	sample := defaultDiffSample
+	if v := q.Get("sample"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxDiffSample {
+			httpError(w, http.StatusBadRequest, "sample", fmt.Sprintf("sample must be 1 to %d", maxDiffSample))
+			return
+		}
+		sample = n
+	}
+	repair := q.Get("repair") == "true"
+	if repair && r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, "repair", "repair=true needs POST")
+		return
+	}
+
+	var keys []string
+	for k := range svc.snapshot() {
+		if strings.HasPrefix(k, q.Get("prefix")) && ownedBy(k, self) && ownedBy(k, peer) {
+			keys = append(keys, k)
+		}
+	}
+	report := diffReport{Peer: peer, Candidate: len(keys), Divergent: []diffKey{}}
+	rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	keys = keys[:min(sample, len(keys))]
+	report.Sampled = len(keys)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, diffParallel)
+	for _, k := range keys {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			local, ok := localCopy(k)
+			d, found, err := fetchDigest(peer, k)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Errors++
+				return
+			}
+			if dk, differ := compareWithPeer(k, local, ok, d, found); differ {
+				report.Divergent = append(report.Divergent, dk)
+			}
+		}()
+	}
+	wg.Wait()
+	sort.Slice(report.Divergent, func(i, j int) bool { return report.Divergent[i].Key < report.Divergent[j].Key })
+
+	if repair {
+		for _, dk := range report.Divergent {
+			n, failed := repairPair(peer, dk.Key)
+			report.Repaired += n
+			for _, node := range failed {
+				report.Failed = append(report.Failed, dk.Key+"@"+node)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// compareWithPeer reports whether this node's copy of key and the peer's
+// digest of its own differ, and how.
+func compareWithPeer(key string, local Entry, ok bool, d digest, found bool) (diffKey, bool) {
+	dk := diffKey{Key: key}
+	var mine digest
+	if ok {
+		mine = digestOf(key, local)
+		dk.Local = &diffSide{mine.Timestamp, mine.Node, mine.Checksum}
+	}
+	if found {
+		dk.Peer = &diffSide{d.Timestamp, d.Node, d.Checksum}
+	}
+	if ok == found && (!ok || mine.Checksum == d.Checksum) {
+		return dk, false
+	}
+	theirs := Entry{Timestamp: d.Timestamp, Node: d.Node}
+	switch {
+	case !found || (ok && local.newerThan(theirs)):
+		dk.Newer = "local"
+	case !ok || theirs.newerThan(local):
+		dk.Newer = "peer"
+	}
+	return dk, true
+}
+
+// repairPair merges this node's and peer's copies of key and pushes the
+// result to whichever side it changes.
+func repairPair(peer, key string) (int, []string) {
+	local, ok := localCopy(key)
+	e, found, err := fetchReplica(peer, key)
+	if err != nil {
+		adminRepairs.failed.Add(1)
+		return 0, []string{peer}
+	}
+	copies := []replicaCopy{{"", local, ok}, {peer, e, found}}
+	var best Entry
+	have := false
+	for _, cp := range copies {
+		if cp.found {
+			best, _ = mergeEntry(key, best, have, cp.e)
+			have = true
+		}
+	}
+	if !have {
+		return 0, nil
+	}
+	return repairStale(&adminRepairs, key, best, copies)
+}
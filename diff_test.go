@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestAdminDiff(t *testing.T) {
+	mem := newMemNet()
+	oldNet, oldData := peerNet, svc.data
+	defer func() { peerNet, svc.data = oldNet, oldData }()
+	peerNet = mem.transport()
+	withCluster(t, func(c *membership) { c.peers = []string{"df:1"} })
+	svc.data = map[string]Entry{}
+
+	same := Entry{Value: "v", Timestamp: 1}
+	svc.put("same", same)
+	svc.put("ours", Entry{Value: "new", Timestamp: 2})
+	svc.put("theirs", Entry{Value: "old", Timestamp: 1})
+	svc.put("only-here", Entry{Value: "v", Timestamp: 1})
+
+	var mu sync.Mutex
+	remote := map[string]Entry{
+		"same":   same,
+		"ours":   {Value: "old", Timestamp: 1},
+		"theirs": {Value: "new", Timestamp: 2},
+	}
+	mem.attach("df:1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/catchup" {
+			var in map[string]Entry
+			json.NewDecoder(r.Body).Decode(&in)
+			for k, e := range in {
+				remote[k] = e
+			}
+			return
+		}
+		k := r.URL.Query().Get("key")
+		e, ok := remote[k]
+		switch {
+		case !ok:
+			http.NotFound(w, r)
+		case r.URL.Query().Get("digest") == "true":
+			writeDigest(w, k, e)
+		default:
+			json.NewEncoder(w).Encode(e)
+		}
+	}))
+
+	diff := func(method, query string) diffReport {
+		rec := httptest.NewRecorder()
+		diffHandler(rec, httptest.NewRequest(method, "/admin/diff?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s = %d %s", method, query, rec.Code, rec.Body)
+		}
+		var rep diffReport
+		json.NewDecoder(rec.Body).Decode(&rep)
+		return rep
+	}
+	rep := diff(http.MethodGet, "peer=df:1")
+	newer := map[string]string{}
+	for _, dk := range rep.Divergent {
+		newer[dk.Key] = dk.Newer
+	}
+	if rep.Sampled != 4 || len(newer) != 3 || newer["ours"] != "local" || newer["theirs"] != "peer" || newer["only-here"] != "local" {
+		t.Fatalf("diff = %+v", rep)
+	}
+	if rep := diff(http.MethodGet, "peer=df:1&sample=1"); rep.Sampled != 1 || rep.Candidate != 4 {
+		t.Fatalf("sampled diff = %+v", rep)
+	}
+
+	if rep := diff(http.MethodPost, "peer=df:1&repair=true"); rep.Repaired != 3 || len(rep.Failed) != 0 {
+		t.Fatalf("repair = %+v", rep)
+	}
+	mu.Lock()
+	if remote["ours"].Value != "new" || remote["only-here"].Value != "v" {
+		t.Errorf("peer not repaired: %+v", remote)
+	}
+	mu.Unlock()
+	if svc.data["theirs"].Value != "new" {
+		t.Errorf("local copy not repaired: %+v", svc.data["theirs"])
+	}
+	if rep := diff(http.MethodGet, "peer=df:1"); len(rep.Divergent) != 0 {
+		t.Fatalf("still divergent after repair: %+v", rep.Divergent)
+	}
+
+	rec := httptest.NewRecorder()
+	diffHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/diff?peer=nobody:1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown peer = %d", rec.Code)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// bufferedResponse captures a handler's reply for in-process callers.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// dispatch runs an HTTP request through this node's own routes without
+// touching the network, for protocol front-ends that map onto the HTTP API.
+func dispatch(method, path string, q url.Values) (int, []byte) {
+	req, _ := http.NewRequest(method, path+"?"+q.Encode(), nil)
+	rec := &bufferedResponse{header: make(http.Header)}
+	recoverHandler(api.mux).ServeHTTP(rec, req)
+	rec.WriteHeader(http.StatusOK)
+	// front-ends relay error bodies as text, so unwrap validation errors
+	var ae apiError
+	if rec.status >= 400 && json.Unmarshal(rec.body.Bytes(), &ae) == nil && ae.Error != "" {
+		return rec.status, []byte(ae.Error + "\n")
+	}
+	return rec.status, rec.body.Bytes()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDumpAndApplyDump(t *testing.T) {
+	src := startNode(t, 9114, nil, true, 1, 1, 1)
+	defer src.Process.Kill()
+	dst := startNode(t, 9115, nil, true, 1, 1, 1)
+	defer dst.Process.Kill()
+	waitReady(t, 9114, 9115)
+
+	for _, q := range []string{"/set?key=b&value=2", "/set?key=a&value=1", "/set?key=c&value=3", "/delete?key=c"} {
+		resp, err := http.Post("http://localhost:9114"+q, "", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		resp.Body.Close()
+	}
+	dump := func(port string) []byte {
+		resp, err := http.Get("http://localhost:" + port + "/dump")
+		if err != nil {
+			t.Fatalf("dump: %v", err)
+		}
+		defer resp.Body.Close()
+		bs, _ := io.ReadAll(resp.Body)
+		return bs
+	}
+
+	d := dump("9114")
+	lines := strings.Split(strings.TrimSpace(string(d)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"key":"a"`) || !strings.Contains(lines[2], `"deleted":true`) {
+		t.Fatalf("dump = %s", d)
+	}
+
+	apply := func(body []byte) applyDumpResult {
+		resp, err := http.Post("http://localhost:9115/applyDump", formatNDJSON, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("applyDump: %v", err)
+		}
+		defer resp.Body.Close()
+		var res applyDumpResult
+		json.NewDecoder(resp.Body).Decode(&res)
+		return res
+	}
+	if res := apply(d); res.Applied != 3 {
+		t.Errorf("first apply = %+v, want 3 applied", res)
+	}
+	if res := apply(d); res.Applied != 0 || res.Unchanged != 3 {
+		t.Errorf("second apply = %+v, want all unchanged", res)
+	}
+	if got := dump("9115"); !bytes.Equal(got, d) {
+		t.Errorf("dumps differ after apply:\n%s\n%s", d, got)
+	}
+}
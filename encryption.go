@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With -ENCRYPTION_KEYS the write-ahead log holds each entry sealed with
+// AES-GCM instead of in the clear; the store key is authenticated with it
+// but stays readable so replay can index records. Keys come from a file of
+//
+//	<id> <base64 AES-128/192/256 key>
+//
+// lines, or from exec:<command>, whose stdout is read the same way, for
+// fetching them from a KMS. The last key listed seals new records; the
+// others only open old ones. To rotate, list a new key last and POST
+// /admin/keys/rotate: records are re-sealed under it as their keys are
+// read, and all of them when the log is compacted on the next start. An
+// old key can be dropped once /admin/keys/rotate reports no stale records.
+
+var (
+	atRest      *keyring // nil without -ENCRYPTION_KEYS
+	reencrypted atomic.Int64
+)
+
+type keyring struct {
+	source string
+
+	mu     sync.RWMutex
+	aeads  map[string]cipher.AEAD
+	active string
+}
+
+func loadKeyring(source string) (*keyring, error) {
+	k := &keyring{source: source}
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// reload reads the keys again from their source, switching new records to
+// the last one.
+func (k *keyring) reload() error {
+	raw, err := readKeySource(k.source)
+	if err != nil {
+		return fmt.Errorf("encryption keys: %w", err)
+	}
+	aeads := map[string]cipher.AEAD{}
+	var active string
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, b64, ok := strings.Cut(line, " ")
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+		if !ok || err != nil {
+			return fmt.Errorf("encryption keys line %d: want <id> <base64 key>", n)
+		}
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		aeads[id], _ = cipher.NewGCM(block)
+		active = id
+	}
+	if active == "" {
+		return errors.New("encryption keys: none found")
+	}
+
+	for id, n := range wal.sealedBy() {
+		if aeads[id] == nil && n > 0 {
+			return fmt.Errorf("encryption key %q dropped but still seals %d records", id, n)
+		}
+	}
+	k.mu.Lock()
+	k.aeads, k.active = aeads, active
+	k.mu.Unlock()
+	return nil
+}
+
+func readKeySource(source string) ([]byte, error) {
+	command, ok := strings.CutPrefix(source, execPrefix)
+	if !ok {
+		return os.ReadFile(source)
+	}
+	argv := strings.Fields(command)
+	if len(argv) == 0 {
+		return nil, errors.New("empty command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return exec.CommandContext(ctx, argv[0], argv[1:]...).Output()
+}
+
+func (k *keyring) current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// seal encrypts plain under the active key, bound to the store key.
+func (k *keyring) seal(key string, plain []byte) (string, []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	aead := k.aeads[k.active]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	rand.Read(nonce)
+	return k.active, aead.Seal(nonce, nonce, plain, []byte(key))
+}
+
+// open decrypts what seal produced under key id.
+func (k *keyring) open(id, key string, sealed []byte) ([]byte, error) {
+	k.mu.RLock()
+	aead := k.aeads[id]
+	k.mu.RUnlock()
+	if aead == nil {
+		return nil, fmt.Errorf("encryption key %q not in the keyring", id)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed record too short")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, sealed[:n], sealed[n:], []byte(key))
+}
+
+type rotateReport struct {
+	Active string `json:"active"`
+	Keys   int    `json:"keys"`
+	Stale  int    `json:"stale"` // logged entries still sealed under older keys
+}
+
+// rotateKeysHandler reloads the keyring, e.g. after a new key was added.
+func rotateKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if atRest == nil {
+		httpError(w, http.StatusConflict, "", "encryption at rest is off (-ENCRYPTION_KEYS)")
+		return
+	}
+	if err := atRest.reload(); err != nil {
+		httpError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	atRest.mu.RLock()
+	report := rotateReport{Active: atRest.active, Keys: len(atRest.aeads)}
+	atRest.mu.RUnlock()
+	for id, n := range wal.sealedBy() {
+		if id != report.Active {
+			report.Stale += n
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// openRecord decrypts a sealed WAL record's entry.
+func openRecord(rec walRecord) (*Entry, error) {
+	if atRest == nil {
+		return nil, errors.New("record is encrypted; start with -ENCRYPTION_KEYS")
+	}
+	plain, err := atRest.open(rec.KeyID, rec.Key, rec.Sealed)
+	if err != nil {
+		return nil, err
+	}
+	var e Entry
+	if err := json.Unmarshal(plain, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedWALRotation(t *testing.T) {
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "keys")
+	path := filepath.Join(dir, "kv.wal")
+	os.WriteFile(keys, []byte("k1 MDEyMzQ1Njc4OWFiY2RlZg==\n"), 0o600)
+	k, err := loadKeyring(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKeys, oldWAL := atRest, wal
+	defer func() { atRest, wal = oldKeys, oldWAL }()
+	atRest = k
+
+	l, _, err := openWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal = l
+	l.append("a", Entry{Value: "secret-a", Timestamp: 1})
+	l.append("b", Entry{Value: "secret-b", Timestamp: 2})
+	l.sync()
+	if bs, _ := os.ReadFile(path); bytes.Contains(bs, []byte("secret")) {
+		t.Fatalf("value in the clear: %s", bs)
+	}
+
+	// rotate: a is re-sealed under k2 when read, b stays under k1
+	os.WriteFile(keys, []byte("k1 MDEyMzQ1Njc4OWFiY2RlZg==\nk2 ZmVkY2JhOTg3NjU0MzIxMA==\n"), 0o600)
+	if err := k.reload(); err != nil {
+		t.Fatal(err)
+	}
+	l.reseal("a", Entry{Value: "secret-a", Timestamp: 1})
+	l.reseal("a", Entry{Value: "secret-a", Timestamp: 1})
+	if got := l.sealedBy(); got["k1"] != 1 || got["k2"] != 1 {
+		t.Fatalf("sealed by %v, want one record under each key", got)
+	}
+	os.WriteFile(keys, []byte("k2 ZmVkY2JhOTg3NjU0MzIxMA==\n"), 0o600)
+	if err := k.reload(); err == nil {
+		t.Fatal("dropped k1 while it still seals b")
+	}
+	l.sync()
+	l.f.Close()
+
+	l, data, err := openWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal = l
+	defer l.f.Close()
+	if data["a"].Value != "secret-a" || data["b"].Value != "secret-b" {
+		t.Fatalf("replayed %+v", data)
+	}
+	if err := k.reload(); err != nil {
+		t.Fatalf("k1 still needed after compaction: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Reads answer with the bare entry (or entry map) by default. A client
+// that also wants to know how the read was served asks for an envelope,
+// with ?envelope=true or Accept: application/json; profile="envelope":
+//
+//	{"data": <the usual body>, "meta": {"replicas_contacted": 2, "consistency": "quorum"}}
+//
+// replicas_contacted counts this node when it holds a key, and every peer
+// asked, whether or not it answered. Other formats have no envelope.
+
+const envelopeProfile = "envelope"
+
+type responseMeta struct {
+	ReplicasContacted int    `json:"replicas_contacted"`
+	Consistency       string `json:"consistency"`
+}
+
+// wantsEnvelope reports whether r asks for its JSON answer in an envelope.
+func wantsEnvelope(r *http.Request) bool {
+	if responseFormat(r) != formatJSON {
+		return false
+	}
+	if r.URL.Query().Get("envelope") == "true" {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaTypes[mt] == formatJSON && params["profile"] == envelopeProfile {
+			return true
+		}
+	}
+	return false
+}
+
+// readMeta is the meta of a read at rq replicas.
+func readMeta(r *http.Request, rq int) responseMeta {
+	return responseMeta{ReplicasContacted: traceOf(r).contacted(), Consistency: levelName(rq)}
+}
+
+func writeEnvelope(w http.ResponseWriter, data any, meta responseMeta) {
+	w.Header().Set("Content-Type", formatJSON)
+	json.NewEncoder(w).Encode(struct {
+		Data any          `json:"data"`
+		Meta responseMeta `json:"meta"`
+	}{data, meta})
+}
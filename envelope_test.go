@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadEnvelope(t *testing.T) {
+	p1, p2 := 9172, 9173
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	a := startNode(t, p1, []string{addr(p2)}, true, 2, 2, 2)
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{addr(p1)}, false, 2, 2, 2)
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/set?key=k&value=v", addr(p1)), "", nil)
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("set: %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	get := func(path, accept string) string {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr(p1)+path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		bs, _ := io.ReadAll(resp.Body)
+		return string(bs)
+	}
+	if body := get("/get?key=k", ""); strings.Contains(body, `"data"`) {
+		t.Fatalf("plain read enveloped: %s", body)
+	}
+
+	var env struct {
+		Data Entry        `json:"data"`
+		Meta responseMeta `json:"meta"`
+	}
+	for _, c := range []struct{ path, accept, level string }{
+		{"/get?key=k&envelope=true", "", "all"},
+		{"/get?key=k&R=1", `application/json; profile="envelope"`, "one"},
+	} {
+		body := get(c.path, c.accept)
+		if err := json.Unmarshal([]byte(body), &env); err != nil || env.Data.Value != "v" {
+			t.Fatalf("%s: %s (%v)", c.path, body, err)
+		}
+		want := responseMeta{ReplicasContacted: 2, Consistency: c.level}
+		if c.level == "one" {
+			want.ReplicasContacted = 1
+		}
+		if env.Meta != want {
+			t.Errorf("%s: meta %+v, want %+v", c.path, env.Meta, want)
+		}
+	}
+
+	var many struct {
+		Data map[string]Entry `json:"data"`
+		Meta responseMeta     `json:"meta"`
+	}
+	body := get("/mget?key=k&key=missing&envelope=true", "")
+	if err := json.Unmarshal([]byte(body), &many); err != nil || len(many.Data) != 1 || many.Data["k"].Value != "v" || many.Meta.ReplicasContacted != 2 {
+		t.Fatalf("mget: %s (%v)", body, err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// currentEpoch is the newest leader epoch this node has seen. Replication
+// stamped with an older epoch comes from a deposed leader and is refused.
+var currentEpoch atomic.Int64
+
+// observeEpoch raises currentEpoch to e if it is newer and reports whether
+// e is current (not stale). A leader that sees a newer epoch steps down.
+func observeEpoch(e int64) bool {
+	for {
+		cur := currentEpoch.Load()
+		if e < cur {
+			return false
+		}
+		if e == cur {
+			return true
+		}
+		if currentEpoch.CompareAndSwap(cur, e) {
+			if isLeader.CompareAndSwap(true, false) {
+				log.Printf("saw newer epoch %d (had %d), stepping down as leader", e, cur)
+				forgetSelfAsLeader()
+			}
+			return true
+		}
+	}
+}
+
+// parseEpoch reads the epoch query parameter. Requests from peers that
+// predate epochs carry none and are treated as epoch 0.
+func parseEpoch(r *http.Request) (int64, error) {
+	v := r.URL.Query().Get("epoch")
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// rejectStaleEpoch answers a request from a stale leader, telling it which
+// epoch is current so it can step down.
+func rejectStaleEpoch(w http.ResponseWriter) {
+	w.Header().Set("X-Epoch", strconv.FormatInt(currentEpoch.Load(), 10))
+	http.Error(w, "stale epoch", http.StatusConflict)
+}
+
+// observeRejection inspects a peer's refusal for a newer epoch.
+func observeRejection(resp *http.Response) {
+	if resp.StatusCode != http.StatusConflict {
+		return
+	}
+	if e, err := strconv.ParseInt(resp.Header.Get("X-Epoch"), 10, 64); err == nil {
+		observeEpoch(e)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEpoch_FollowerRejectsStaleLeader(t *testing.T) {
+	fPort := 9031
+	f := startNode(t, fPort, nil, false, 2, 1, 2, "-EPOCH", "3")
+	defer f.Process.Kill()
+	waitReady(t, fPort)
+
+	replicate := func(epoch int) *http.Response {
+		url := fmt.Sprintf("http://localhost:%d/replicate?key=k&value=v&timestamp=%d&epoch=%d",
+			fPort, time.Now().UnixNano(), epoch)
+		resp, err := http.Post(url, "", nil)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", url, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := replicate(2); resp.StatusCode != http.StatusConflict || resp.Header.Get("X-Epoch") != "3" {
+		t.Errorf("epoch 2: expected 409 with X-Epoch 3, got %d %q", resp.StatusCode, resp.Header.Get("X-Epoch"))
+	}
+	if resp := replicate(4); resp.StatusCode != http.StatusOK {
+		t.Errorf("epoch 4: expected 200, got %d", resp.StatusCode)
+	}
+	if resp := replicate(3); resp.StatusCode != http.StatusConflict || resp.Header.Get("X-Epoch") != "4" {
+		t.Errorf("epoch 3 after 4: expected 409 with X-Epoch 4, got %d %q", resp.StatusCode, resp.Header.Get("X-Epoch"))
+	}
+}
+
+func TestEpoch_DeposedLeaderStepsDown(t *testing.T) {
+	leaderPort, fPort := 9032, 9033
+	// W<N so a non-leader never coordinates writes in leaderless mode
+	leader := startNode(t, leaderPort, []string{fmt.Sprintf("localhost:%d", fPort)}, true, 3, 1, 2)
+	f := startNode(t, fPort, []string{fmt.Sprintf("localhost:%d", leaderPort)}, false, 3, 1, 2, "-EPOCH", "2")
+	defer leader.Process.Kill()
+	defer f.Process.Kill()
+	waitReady(t, leaderPort, fPort)
+
+	setURL := fmt.Sprintf("http://localhost:%d/set?key=k&value=v", leaderPort)
+	resp, err := http.Post(setURL, "", nil)
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", setURL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected quorum failure from stale leader, got %d", resp.StatusCode)
+	}
+
+	// having learned of epoch 2, the old leader must refuse further writes
+	resp, err = http.Post(setURL, "", nil)
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", setURL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected deposed leader to reject writes, got %d", resp.StatusCode)
+	}
+}
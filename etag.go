@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// /get and /local_read tag an entry with an ETag, its timestamp followed
+// by the checksum of its contents, which also tells apart versions stamped
+// in the same nanosecond and siblings merged in. A client polling a key
+// sends the ETag back in If-None-Match and, while the entry is unchanged,
+// gets a bodyless 304 instead of the value. The experiment's replica
+// polling (experiment.go) does this too, so its reads cost the replicas
+// little until the write arrives.
+//
+// Writes honor If-Match the same way: /set, /delete and the other
+// coordinated writes apply only if the key's current entry on the node
+// coordinating them (the leader, when there is one) still carries one of
+// the ETags listed, checked under the store lock like /cas, and answer 412
+// otherwise. Read, change, write back with If-Match is thus optimistic
+// concurrency control in plain HTTP.
+
+// entryETag is the ETag of key's entry e.
+func entryETag(key string, e Entry) string {
+	return fmt.Sprintf(`"%d-%08x"`, e.Timestamp, e.sum(key))
+}
+
+// etagMatches reports whether the If-None-Match or If-Match header value
+// list names etag, or is "*". Weak tags compare by their opaque part.
+func etagMatches(list, etag string) bool {
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// withIfMatch adds r's If-Match header, if any, to cond: the write goes
+// ahead only while key's entry on the coordinator has one of the listed
+// ETags, or for "*" any live value, and is answered 412 otherwise.
+func withIfMatch(r *http.Request, key string, cond writeCond) writeCond {
+	list := r.Header.Get("If-Match")
+	if list == "" {
+		return cond
+	}
+	return func(cur Entry, ok bool, e *Entry) bool {
+		if !ok || !cur.live() || !etagMatches(list, entryETag(key, cur)) {
+			return false
+		}
+		return cond == nil || cond(cur, ok, e)
+	}
+}
+
+// notModified sets key's ETag on w and, when r's If-None-Match names it,
+// answers 304 and returns true.
+func notModified(w http.ResponseWriter, r *http.Request, key string, e Entry) bool {
+	etag := entryETag(key, e)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	for _, c := range []struct {
+		list string
+		want bool
+	}{
+		{`"1-ab"`, true},
+		{`W/"1-ab"`, true},
+		{`"0-00", "1-ab"`, true},
+		{`*`, true},
+		{`"1-ac"`, false},
+		{`1-ab`, false},
+	} {
+		if got := etagMatches(c.list, `"1-ab"`); got != c.want {
+			t.Errorf("etagMatches(%s) = %v, want %v", c.list, got, c.want)
+		}
+	}
+}
+
+func TestSet_IfMatch(t *testing.T) {
+	port := 9167
+	n := startNode(t, port, nil, true, 1, 1, 1)
+	defer n.Process.Kill()
+	waitReady(t, port)
+	base := fmt.Sprintf("http://localhost:%d", port)
+
+	post := func(path, ifMatch string) int {
+		req, _ := http.NewRequest(http.MethodPost, base+path, nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	etagOf := func() string {
+		resp, err := http.Get(base + "/get?key=doc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("ETag")
+	}
+
+	if code := post("/set?key=doc&value=v1", "*"); code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match: * on a missing key = %d, want 412", code)
+	}
+	post("/set?key=doc&value=v1", "")
+	v1 := etagOf()
+	if code := post("/set?key=doc&value=v2", v1); code != http.StatusCreated {
+		t.Fatalf("write with the current ETag = %d, want 201", code)
+	}
+	// a second writer still holding v1's ETag loses
+	if code := post("/set?key=doc&value=v3", v1); code != http.StatusPreconditionFailed {
+		t.Fatalf("write with a stale ETag = %d, want 412", code)
+	}
+	if code := post("/delete?key=doc", v1); code != http.StatusPreconditionFailed {
+		t.Fatalf("delete with a stale ETag = %d, want 412", code)
+	}
+	if e, _ := getEntry(t, base+"/get?key=doc"); e.Value != "v2" {
+		t.Fatalf("value after the lost writes = %q, want v2", e.Value)
+	}
+	if code := post("/delete?key=doc", etagOf()); code != http.StatusOK {
+		t.Fatalf("delete with the current ETag = %d, want 200", code)
+	}
+}
+
+func TestGet_IfNoneMatch(t *testing.T) {
+	port := 9166
+	n := startNode(t, port, nil, true, 1, 1, 1)
+	defer n.Process.Kill()
+	waitReady(t, port)
+	base := fmt.Sprintf("http://localhost:%d", port)
+
+	set := func(v string) {
+		resp, err := http.Post(base+"/set?key=poll&value="+v, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	get := func(path, etag string) (int, string, string) {
+		req, _ := http.NewRequest(http.MethodGet, base+path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		bs, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("ETag"), string(bs)
+	}
+
+	set("a")
+	code, etag, _ := get("/get?key=poll", "")
+	if code != http.StatusOK || etag == "" {
+		t.Fatalf("first read = %d, ETag %q", code, etag)
+	}
+	for _, path := range []string{"/get?key=poll", "/local_read?key=poll"} {
+		if code, again, body := get(path, etag); code != http.StatusNotModified || again != etag || body != "" {
+			t.Errorf("%s unchanged = %d, ETag %q, body %q; want a bodyless 304", path, code, again, body)
+		}
+	}
+	set("b")
+	if code, next, _ := get("/get?key=poll", etag); code != http.StatusOK || next == etag {
+		t.Errorf("read after a write = %d, ETag %q (was %q)", code, next, etag)
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A subset of the etcd v3 API (KV.Put, KV.Range, KV.DeleteRange and
+// Watch.Watch) served as gRPC over cleartext HTTP/2, enough for etcdctl's
+// basic put/get/del/watch. Single-key operations go through the node's
+// HTTP handlers and so honor R and W; range reads and deletes work on the
+// keys this node holds. Revisions are entry timestamps and the raft term
+// is the leader epoch.
+
+// gRPC status codes used below.
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+)
+
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+// serveGRPC serves the etcd API on addr using prior-knowledge h2c.
+func serveGRPC(addr string) {
+	var protos http.Protocols
+	protos.SetUnencryptedHTTP2(true)
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(etcdHandler), Protocols: &protos}
+	log.Printf("etcd v3 gRPC front-end listening on %s", addr)
+	log.Fatal(srv.ListenAndServe())
+}
+
+func etcdHandler(w http.ResponseWriter, r *http.Request) {
+	awaitReady()
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	switch r.URL.Path {
+	case "/etcdserverpb.KV/Put":
+		grpcUnary(w, r, etcdPut)
+	case "/etcdserverpb.KV/Range":
+		grpcUnary(w, r, etcdRange)
+	case "/etcdserverpb.KV/DeleteRange":
+		grpcUnary(w, r, etcdDeleteRange)
+	case "/etcdserverpb.Watch/Watch":
+		etcdWatch(w, r)
+	default:
+		grpcFinish(w, &grpcError{grpcUnimplemented, "unknown method " + r.URL.Path})
+	}
+}
+
+// readGRPCMessage reads one length-prefixed gRPC message.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > maxBodyBytes {
+		return nil, &grpcError{grpcResourceExhausted, fmt.Sprintf("message is %d bytes, limit is %d", n, maxBodyBytes)}
+	}
+	msg := make([]byte, n)
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+func writeGRPCMessage(w io.Writer, msg []byte) error {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+// grpcFinish sends the status trailers that end a call.
+func grpcFinish(w http.ResponseWriter, err error) {
+	code, msg := grpcOK, ""
+	if err != nil {
+		var ge *grpcError
+		if !errors.As(err, &ge) {
+			ge = &grpcError{grpcInternal, err.Error()}
+		}
+		code, msg = ge.code, ge.msg
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+func grpcUnary(w http.ResponseWriter, r *http.Request, call func([]byte) ([]byte, error)) {
+	req, err := readGRPCMessage(r.Body)
+	if err != nil {
+		grpcFinish(w, err)
+		return
+	}
+	resp, err := call(req)
+	if err == nil {
+		err = writeGRPCMessage(w, resp)
+	}
+	grpcFinish(w, err)
+}
+
+// etcdHeader encodes a ResponseHeader.
+func etcdHeader() []byte {
+	var h protoBuf
+	h.uint(1, 1)             // cluster_id
+	h.uint(2, hashKey(self)) // member_id
+	h.int(3, changes.revision.Load())
+	h.int(4, currentEpoch.Load()) // raft_term
+	return h
+}
+
+// etcdKV encodes a KeyValue.
+func etcdKV(key string, e Entry, keysOnly bool) []byte {
+	var kv protoBuf
+	kv.bytes(1, []byte(key))
+	kv.int(2, e.Timestamp) // create_revision
+	kv.int(3, e.Timestamp) // mod_revision
+	kv.int(4, 1)           // version
+	if !keysOnly {
+		kv.bytes(5, []byte(e.Value))
+	}
+	return kv
+}
+
+// grpcFromHTTP maps a failed in-process HTTP call onto a gRPC error.
+func grpcFromHTTP(status int, body []byte) error {
+	msg := strings.TrimSpace(string(body))
+	switch status {
+	case http.StatusBadRequest, http.StatusServiceUnavailable, http.StatusInternalServerError:
+		return &grpcError{grpcUnavailable, msg}
+	case http.StatusPreconditionFailed:
+		return &grpcError{grpcFailedPrecondition, msg}
+	default:
+		return &grpcError{grpcInternal, msg}
+	}
+}
+
+// getThroughHandlers reads key through /get, honoring the node's R.
+func getThroughHandlers(key string) (Entry, bool) {
+	status, body := dispatch(http.MethodGet, "/get", url.Values{"key": {key}})
+	if status != http.StatusOK {
+		return Entry{}, false
+	}
+	var e Entry
+	json.Unmarshal(body, &e)
+	return e, true
+}
+
+func etcdPut(req []byte) ([]byte, error) {
+	fields, err := parseProto(req)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	var key, value string
+	var prevKV bool
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			key = string(f.data)
+		case 2:
+			value = string(f.data)
+		case 3:
+			if f.varint != 0 {
+				return nil, &grpcError{grpcUnimplemented, "leases are not supported"}
+			}
+		case 4:
+			prevKV = f.varint != 0
+		}
+	}
+	if key == "" {
+		return nil, &grpcError{grpcInvalidArgument, "key is not provided"}
+	}
+	prev, hadPrev := Entry{}, false
+	if prevKV {
+		prev, hadPrev = getThroughHandlers(key)
+	}
+	if status, body := dispatch(http.MethodPost, "/set", url.Values{"key": {key}, "value": {value}}); status != http.StatusCreated {
+		return nil, grpcFromHTTP(status, body)
+	}
+	var resp protoBuf
+	resp.message(1, etcdHeader())
+	if hadPrev {
+		resp.message(2, etcdKV(key, prev, false))
+	}
+	return resp, nil
+}
+
+// keyRange is the etcd [key, rangeEnd) selector: an empty end selects key
+// alone and "\x00" selects everything from key on.
+type keyRange struct{ key, end string }
+
+func (kr keyRange) contains(k string) bool {
+	switch kr.end {
+	case "":
+		return k == kr.key
+	case "\x00":
+		return k >= kr.key
+	default:
+		return k >= kr.key && k < kr.end
+	}
+}
+
+// localRange lists the live local entries in kr, sorted by key.
+func localRange(kr keyRange) []KV {
+	var out []KV
+	now := time.Now().UnixNano()
+	for k, e := range svc.snapshot() {
+		if !e.Deleted && !e.expired(now) && kr.contains(k) {
+			out = append(out, KV{Key: k, Entry: e})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// selectRange resolves kr to entries: a single key via the read path,
+// anything wider from the local store.
+func selectRange(kr keyRange) []KV {
+	if kr.end != "" {
+		return localRange(kr)
+	}
+	if e, ok := getThroughHandlers(kr.key); ok {
+		return []KV{{Key: kr.key, Entry: e}}
+	}
+	return nil
+}
+
+func etcdRange(req []byte) ([]byte, error) {
+	fields, err := parseProto(req)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	var kr keyRange
+	var limit int
+	var keysOnly, countOnly bool
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			kr.key = string(f.data)
+		case 2:
+			kr.end = string(f.data)
+		case 3:
+			limit = int(f.varint)
+		case 8:
+			keysOnly = f.varint != 0
+		case 9:
+			countOnly = f.varint != 0
+		}
+	}
+	kvs := selectRange(kr)
+	var resp protoBuf
+	resp.message(1, etcdHeader())
+	count := len(kvs)
+	if limit > 0 && len(kvs) > limit {
+		kvs = kvs[:limit]
+		resp.bool(3, true) // more
+	}
+	if !countOnly {
+		for _, kv := range kvs {
+			resp.message(2, etcdKV(kv.Key, kv.Entry, keysOnly))
+		}
+	}
+	resp.int(4, int64(count))
+	return resp, nil
+}
+
+func etcdDeleteRange(req []byte) ([]byte, error) {
+	fields, err := parseProto(req)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	var kr keyRange
+	var prevKV bool
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			kr.key = string(f.data)
+		case 2:
+			kr.end = string(f.data)
+		case 3:
+			prevKV = f.varint != 0
+		}
+	}
+	var resp protoBuf
+	resp.message(1, etcdHeader())
+	kvs := selectRange(kr)
+	for _, kv := range kvs {
+		if status, body := dispatch(http.MethodPost, "/delete", url.Values{"key": {kv.Key}}); status != http.StatusOK {
+			return nil, grpcFromHTTP(status, body)
+		}
+	}
+	resp.int(2, int64(len(kvs)))
+	if prevKV {
+		for _, kv := range kvs {
+			resp.message(3, etcdKV(kv.Key, kv.Entry, false))
+		}
+	}
+	return resp, nil
+}
+
+// etcdWatch serves a Watch stream: the client creates and cancels watches
+// over key ranges and receives an event for every matching local change.
+func etcdWatch(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || r.ProtoMajor != 2 {
+		grpcFinish(w, &grpcError{grpcUnimplemented, "watch needs HTTP/2"})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var mu sync.Mutex // guards writes to w and the watch table
+	watches := make(map[int64]keyRange)
+	var nextID int64
+	send := func(msg protoBuf) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := writeGRPCMessage(w, msg); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	subID, feed := changes.subscribe(1024)
+	defer changes.unsubscribe(subID)
+
+	reqs := make(chan []byte)
+	reqErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := readGRPCMessage(r.Body)
+			if err != nil {
+				reqErr <- err
+				return
+			}
+			select {
+			case reqs <- msg:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case err := <-reqErr:
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			grpcFinish(w, err)
+			return
+		case msg := <-reqs:
+			fields, err := parseProto(msg)
+			if err != nil {
+				grpcFinish(w, &grpcError{grpcInvalidArgument, err.Error()})
+				return
+			}
+			for _, f := range fields {
+				resp, err := handleWatchRequest(f, watches, &nextID, &mu)
+				if err != nil {
+					grpcFinish(w, err)
+					return
+				}
+				if resp != nil {
+					if err := send(resp); err != nil {
+						return
+					}
+				}
+			}
+		case c := <-feed:
+			mu.Lock()
+			var hits []int64
+			for id, kr := range watches {
+				if kr.contains(c.Key) {
+					hits = append(hits, id)
+				}
+			}
+			mu.Unlock()
+			for _, id := range hits {
+				var ev protoBuf
+				if c.Entry.Deleted {
+					ev.uint(1, 1) // DELETE
+				}
+				ev.message(2, etcdKV(c.Key, c.Entry, false))
+				var resp protoBuf
+				resp.message(1, etcdHeader())
+				resp.int(2, id)
+				resp.message(11, ev)
+				if err := send(resp); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// handleWatchRequest applies one WatchRequest field (create_request or
+// cancel_request) and returns the response to send.
+func handleWatchRequest(f protoField, watches map[int64]keyRange, nextID *int64, mu *sync.Mutex) (protoBuf, error) {
+	sub, err := parseProto(f.data)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	var resp protoBuf
+	resp.message(1, etcdHeader())
+	mu.Lock()
+	defer mu.Unlock()
+	switch f.num {
+	case 1: // create_request
+		var kr keyRange
+		id := int64(-1)
+		for _, sf := range sub {
+			switch sf.num {
+			case 1:
+				kr.key = string(sf.data)
+			case 2:
+				kr.end = string(sf.data)
+			case 7:
+				id = int64(sf.varint)
+			}
+		}
+		if id < 0 {
+			id = *nextID
+			*nextID++
+		}
+		watches[id] = kr
+		resp.int(2, id)
+		resp.bool(3, true) // created
+	case 2: // cancel_request
+		var id int64
+		for _, sf := range sub {
+			if sf.num == 1 {
+				id = int64(sf.varint)
+			}
+		}
+		delete(watches, id)
+		resp.int(2, id)
+		resp.bool(4, true) // canceled
+	default:
+		return nil, &grpcError{grpcUnimplemented, fmt.Sprintf("watch request field %d", f.num)}
+	}
+	return resp, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// grpcClient speaks prior-knowledge h2c, as etcdctl does without TLS
+func grpcClient() *http.Client {
+	var protos http.Protocols
+	protos.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: &protos}}
+}
+
+func grpcFrame(msg []byte) []byte {
+	var b bytes.Buffer
+	writeGRPCMessage(&b, msg)
+	return b.Bytes()
+}
+
+// grpcCall makes a unary call and returns the decoded response fields
+func grpcCall(t *testing.T, c *http.Client, port int, method string, req protoBuf) []protoField {
+	url := fmt.Sprintf("http://localhost:%d%s", port, method)
+	resp, err := c.Post(url, "application/grpc", bytes.NewReader(grpcFrame(req)))
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	msg, err := readGRPCMessage(resp.Body)
+	if err != nil {
+		t.Fatalf("%s: reading response: %v", method, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	if s := resp.Trailer.Get("Grpc-Status"); s != "0" {
+		t.Fatalf("%s: grpc-status %q (%s)", method, s, resp.Trailer.Get("Grpc-Message"))
+	}
+	fields, err := parseProto(msg)
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	return fields
+}
+
+// rangeValues pulls key=value pairs out of a RangeResponse
+func rangeValues(t *testing.T, fields []protoField) map[string]string {
+	out := map[string]string{}
+	for _, f := range fields {
+		if f.num != 2 {
+			continue
+		}
+		kv, _ := parseProto(f.data)
+		var k, v string
+		for _, kf := range kv {
+			switch kf.num {
+			case 1:
+				k = string(kf.data)
+			case 5:
+				v = string(kf.data)
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func TestEtcd_PutRangeDeleteAndWatch(t *testing.T) {
+	node := startNode(t, 9091, nil, true, 1, 1, 1, "-GRPC_PORT", "9092")
+	defer node.Process.Kill()
+	waitReady(t, 9091)
+	c := grpcClient()
+
+	// open a watch on prefix "app/" before writing
+	pr, pw := io.Pipe()
+	watchResp := make(chan *http.Response, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:9092/etcdserverpb.Watch/Watch", pr)
+		req.Header.Set("Content-Type", "application/grpc")
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Errorf("watch: %v", err)
+			close(watchResp)
+			return
+		}
+		watchResp <- resp
+	}()
+	var create, watchReq protoBuf
+	create.bytes(1, []byte("app/"))
+	create.bytes(2, []byte("app0"))
+	watchReq.message(1, create)
+	pw.Write(grpcFrame(watchReq))
+	resp := <-watchResp
+	if resp == nil {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	if _, err := readGRPCMessage(resp.Body); err != nil { // created
+		t.Fatalf("watch created: %v", err)
+	}
+
+	for _, kv := range [][2]string{{"app/a", "1"}, {"app/b", "2"}, {"other", "3"}} {
+		var put protoBuf
+		put.bytes(1, []byte(kv[0]))
+		put.bytes(2, []byte(kv[1]))
+		grpcCall(t, c, 9092, "/etcdserverpb.KV/Put", put)
+	}
+
+	var get protoBuf
+	get.bytes(1, []byte("app/a"))
+	if got := rangeValues(t, grpcCall(t, c, 9092, "/etcdserverpb.KV/Range", get)); got["app/a"] != "1" || len(got) != 1 {
+		t.Errorf("single-key Range: got %v", got)
+	}
+	var prefix protoBuf
+	prefix.bytes(1, []byte("app/"))
+	prefix.bytes(2, []byte("app0"))
+	if got := rangeValues(t, grpcCall(t, c, 9092, "/etcdserverpb.KV/Range", prefix)); len(got) != 2 || got["app/b"] != "2" {
+		t.Errorf("prefix Range: got %v", got)
+	}
+
+	var del protoBuf
+	del.bytes(1, []byte("app/"))
+	del.bytes(2, []byte("app0"))
+	for _, f := range grpcCall(t, c, 9092, "/etcdserverpb.KV/DeleteRange", del) {
+		if f.num == 2 && f.varint != 2 {
+			t.Errorf("DeleteRange: expected 2 deleted, got %d", f.varint)
+		}
+	}
+	if got := rangeValues(t, grpcCall(t, c, 9092, "/etcdserverpb.KV/Range", prefix)); len(got) != 0 {
+		t.Errorf("Range after delete: got %v", got)
+	}
+
+	// the watch sees two puts and two deletes under app/, nothing for "other"
+	var events []string
+	for len(events) < 4 {
+		msg, err := readGRPCMessage(resp.Body)
+		if err != nil {
+			t.Fatalf("watch event: %v", err)
+		}
+		fields, _ := parseProto(msg)
+		for _, f := range fields {
+			if f.num != 11 {
+				continue
+			}
+			ev, _ := parseProto(f.data)
+			kind := "PUT"
+			var key string
+			for _, ef := range ev {
+				if ef.num == 1 && ef.varint == 1 {
+					kind = "DELETE"
+				}
+				if ef.num == 2 {
+					kv, _ := parseProto(ef.data)
+					key = string(kv[0].data)
+				}
+			}
+			events = append(events, kind+" "+key)
+		}
+	}
+	want := "[PUT app/a PUT app/b DELETE app/a DELETE app/b]"
+	if fmt.Sprint(events) != want {
+		t.Errorf("watch events: expected %s, got %v", want, events)
+	}
+	pw.Close()
+}
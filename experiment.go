@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// /admin/experiment measures the inconsistency window the way the tests do
+// by hand: each trial writes a fresh value through the client API (to the
+// leader when there is one), then polls every replica's /local_read every
+// ?interval= until the value shows up there or ?timeout= passes. The
+// report gives, over ?trials= trials, how long after the write was
+// acknowledged each replica and the whole cluster took to converge.
+
+const experimentPrefix = "__experiment/"
+
+type experimentReport struct {
+	Trials     int                       `json:"trials"`
+	Converged  int                       `json:"converged"` // trials every replica caught up in
+	WriteMS    latencySummary            `json:"write_ms"`
+	ClusterMS  latencySummary            `json:"cluster_ms"` // after the ack, until the last replica had it
+	ReplicaMS  map[string]latencySummary `json:"replica_ms"`
+	WriteError string                    `json:"write_error,omitempty"`
+}
+
+// latencySummary is a distribution of milliseconds.
+type latencySummary struct {
+	Min float64 `json:"min"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+func summarize(ms []float64) latencySummary {
+	if len(ms) == 0 {
+		return latencySummary{}
+	}
+	sort.Float64s(ms)
+	at := func(q float64) float64 { return ms[int(q*float64(len(ms)-1))] }
+	return latencySummary{Min: ms[0], P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: ms[len(ms)-1]}
+}
+
+func millis(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+func experimentHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	trials, err := strconv.Atoi(q.Get("trials"))
+	if q.Get("trials") == "" {
+		trials, err = 20, nil
+	}
+	if err != nil || trials < 1 || trials > 1000 {
+		httpError(w, http.StatusBadRequest, "trials", "trials must be 1 to 1000")
+		return
+	}
+	interval, timeout := time.Millisecond, 5*time.Second
+	for _, p := range []struct {
+		name string
+		d    *time.Duration
+	}{{"interval", &interval}, {"timeout", &timeout}} {
+		if v := q.Get(p.name); v != "" {
+			if *p.d, err = time.ParseDuration(v); err != nil || *p.d <= 0 {
+				httpError(w, http.StatusBadRequest, p.name, p.name+" must be a positive duration")
+				return
+			}
+		}
+	}
+
+	// many trials can outlast -HTTP_WRITE_TIMEOUT
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	target := clientAddr
+	if l := currentLeader(); l != "" && !isLeader.Load() {
+		target = clientAddrOf(l)
+	}
+	replicas := append([]string{self}, cluster().peers...)
+	report := experimentReport{Trials: trials, ReplicaMS: map[string]latencySummary{}}
+	var writes, cluster []float64
+	perReplica := map[string][]float64{}
+	for i := 0; i < trials; i++ {
+		key := fmt.Sprintf("%s%s", experimentPrefix, randomID()[:8])
+		value := randomID()
+		start := time.Now()
+		if err := experimentWrite(fmt.Sprintf("http://%s/set?key=%s&value=%s", target, url.QueryEscape(key), value)); err != nil {
+			report.WriteError = err.Error()
+			break
+		}
+		acked := time.Now()
+		writes = append(writes, millis(acked.Sub(start)))
+
+		seen := pollReplicas(replicas, key, value, acked, interval, timeout)
+		last, all := time.Duration(0), true
+		for _, rep := range replicas {
+			d, ok := seen[rep]
+			if !ok {
+				all = false
+				continue
+			}
+			perReplica[rep] = append(perReplica[rep], millis(d))
+			last = max(last, d)
+		}
+		if all {
+			report.Converged++
+			cluster = append(cluster, millis(last))
+		}
+		experimentWrite(fmt.Sprintf("http://%s/delete?key=%s", target, url.QueryEscape(key)))
+	}
+	report.WriteMS, report.ClusterMS = summarize(writes), summarize(cluster)
+	for rep, ms := range perReplica {
+		report.ReplicaMS[rep] = summarize(ms)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// experimentWrite POSTs a trial's write or cleanup, wanting a 2xx.
+func experimentWrite(target string) error {
+	resp, err := http.Post(target, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// pollReplicas reports, for each replica that served value for key within
+// timeout, how long after since it first did.
+func pollReplicas(replicas []string, key, value string, since time.Time, interval, timeout time.Duration) map[string]time.Duration {
+	seen := map[string]time.Duration{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	deadline := since.Add(timeout)
+	for _, rep := range replicas {
+		wg.Add(1)
+		go func(rep string) {
+			defer wg.Done()
+			target := fmt.Sprintf("http://%s/local_read?key=%s", clientAddrOf(rep), url.QueryEscape(key))
+			etag := ""
+			for time.Now().Before(deadline) {
+				if readsValue(target, value, &etag) {
+					mu.Lock()
+					seen[rep] = time.Since(since)
+					mu.Unlock()
+					return
+				}
+				time.Sleep(interval)
+			}
+		}(rep)
+	}
+	wg.Wait()
+	return seen
+}
+
+// readsValue reports whether target serves value. etag holds the ETag of
+// the last entry it served otherwise, so an unchanged one comes back as a
+// bodyless 304.
+func readsValue(target, value string, etag *string) bool {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return false
+	}
+	if *etag != "" {
+		req.Header.Set("If-None-Match", *etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return false
+	}
+	var e Entry
+	if json.NewDecoder(resp.Body).Decode(&e) != nil {
+		return false
+	}
+	*etag = resp.Header.Get("ETag")
+	return e.Value == value
+}
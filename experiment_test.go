@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestInconsistencyWindowExperiment(t *testing.T) {
+	p1, p2 := 9125, 9126
+	a := startNode(t, p1, []string{fmt.Sprintf("localhost:%d", p2)}, true, 2, 1, 1)
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{fmt.Sprintf("localhost:%d", p1)}, false, 2, 1, 1)
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/admin/experiment?trials=3", p1), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var rep experimentReport
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		t.Fatal(err)
+	}
+	follower := rep.ReplicaMS[fmt.Sprintf("localhost:%d", p2)]
+	if rep.Converged != 3 || rep.WriteError != "" {
+		t.Fatalf("report %+v", rep)
+	}
+	// W=1 acks before replicating, which takes at least the 200ms leader delay
+	if follower.Min < 150 || rep.ClusterMS.Max < follower.Max {
+		t.Fatalf("follower window %+v, cluster %+v", follower, rep.ClusterMS)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Fuzz targets for what a buggy client or peer can send: request bodies
+// and parameters, replication requests, /applyDump input, the WAL and
+// snapshot format, the RESP and gRPC framing, and Snappy batches. Plain `go test` runs only the
+// seeds; `go test -fuzz=FuzzReplicate -fuzztime=1m .` searches for more.
+// None of them may panic, and a decoder that accepts an input must hand
+// back something the store can hold.
+
+func FuzzRequestBodies(f *testing.F) {
+	var set protoBuf
+	set.bytes(1, []byte("k"))
+	set.bytes(2, []byte("v"))
+	f.Add(formatProtobuf, []byte(set))
+	f.Add(formatMsgpack, mpStr(mpStr(mpArrayHeader(nil, 2), "a"), "b"))
+	f.Add(formatMsgpack, []byte{0xdf, 0xff, 0xff, 0xff, 0xff})
+	f.Add(formatJSON, []byte(`{"key":"k","value":"v"}`))
+	f.Add(formatJSON, []byte(`{"keys":["a","b"]}`))
+	f.Fuzz(func(t *testing.T, ctype string, body []byte) {
+		for _, decode := range []func(*http.Request){
+			func(r *http.Request) { decodeSetBody(r) },
+			func(r *http.Request) { decodeKeys(r) },
+		} {
+			r := httptest.NewRequest(http.MethodPost, "/set", bytes.NewReader(body))
+			r.Header.Set("Content-Type", ctype)
+			decode(r)
+		}
+		if v, rest, err := mpDecode(body); err == nil && !bytes.HasSuffix(body, rest) {
+			t.Fatalf("mpDecode(%x) = %v, left %x, not a suffix", body, v, rest)
+		}
+		if fields, err := parseProto(body); err == nil {
+			for _, fl := range fields {
+				if len(fl.data) > len(body) {
+					t.Fatalf("field %d longer than the message", fl.num)
+				}
+			}
+		}
+	})
+}
+
+// FuzzRequestParams feeds arbitrary query strings to the parameter parsers
+// a coordinated write or read goes through.
+func FuzzRequestParams(f *testing.F) {
+	f.Add("key=k&value=v&consistency=LOCAL_QUORUM&durability=fsync")
+	f.Add("key=k&r=2&epoch=3&callback=http://h/cb")
+	f.Add("context=eyJrdjE6ODAwMCI6MX0&range=a:b&depth=4")
+	f.Fuzz(func(t *testing.T, query string) {
+		r := httptest.NewRequest(http.MethodGet, "/set", nil)
+		r.URL.RawQuery = query
+		parseConsistency(r)
+		parseDurability(r)
+		parseEpoch(r)
+		parseCallback(r)
+		parseMerkleQuery(r)
+		readQuorum(r)
+		if c, err := parseContext(r.URL.Query().Get("context")); err == nil {
+			if back, err := parseContext(c.token()); err != nil || back.String() != c.String() {
+				t.Fatalf("context %v does not round-trip: %v %v", c, back, err)
+			}
+		}
+	})
+}
+
+// FuzzReplicate sends arbitrary /replicate requests, as a buggy or
+// malicious peer might. Whatever it accepts must be stored intact.
+func FuzzReplicate(f *testing.F) {
+	e := Entry{Value: "v", Timestamp: 5, Node: "kv1:8000", Clock: vclock{"kv1:8000": 1}}
+	f.Add(entryQuery("", "fz", e))
+	f.Add("key=fz&value=v&timestamp=1&clock=" + url.QueryEscape(`{"kv1:8000":-1}`))
+	f.Add("key=fz&timestamp=1&type=gcounter&value=" + url.QueryEscape(`{"p":{"a":1e30}}`))
+	f.Add("key=fz&timestamp=x&origin=kv2:8000&seq=-1")
+	f.Add("key=%ff&timestamp=1&crc=4294967296")
+
+	oldSleep, oldEpoch := FollowerUpdateSleep, currentEpoch.Load()
+	FollowerUpdateSleep = 0
+	defer func() { FollowerUpdateSleep = oldSleep; currentEpoch.Store(oldEpoch) }()
+	h := allow(keyed(replicateHandler), http.MethodPost)
+	f.Fuzz(func(t *testing.T, query string) {
+		r := httptest.NewRequest(http.MethodPost, "/replicate", nil)
+		r.URL.RawQuery = query
+		key := r.URL.Query().Get("key")
+		defer func() {
+			svc.Lock()
+			delete(svc.data, key)
+			svc.Unlock()
+			dedupMu.Lock()
+			delete(seenBy, r.URL.Query().Get("origin"))
+			dedupMu.Unlock()
+			currentEpoch.Store(oldEpoch)
+		}()
+
+		rec := httptest.NewRecorder()
+		h(rec, r)
+		if rec.Code != http.StatusOK {
+			return
+		}
+		svc.Lock()
+		stored, ok := svc.data[key]
+		svc.Unlock()
+		if ok && !stored.intact(key) {
+			t.Fatalf("stored %+v for %q fails its checksum", stored, key)
+		}
+	})
+}
+
+func FuzzApplyDump(f *testing.F) {
+	f.Add([]byte(`{"key":"fz","value":"v","timestamp":1,"node":"kv1:8000"}` + "\n"))
+	f.Add([]byte(`{"key":"fz","value":"{\"p\":{}}","timestamp":1,"type":"orset"}` + "\n" + `{"key":"fz","timestamp":2,"deleted":true}`))
+	f.Add([]byte(`{"key":"","value":1}`))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		before := svc.snapshot()
+		defer func() {
+			svc.Lock()
+			for k := range svc.data {
+				if _, ok := before[k]; !ok {
+					delete(svc.data, k)
+				}
+			}
+			svc.Unlock()
+		}()
+		rec := httptest.NewRecorder()
+		applyDumpHandler(rec, httptest.NewRequest(http.MethodPost, "/applyDump", bytes.NewReader(body)))
+		if rec.Code != http.StatusOK && rec.Code != http.StatusBadRequest {
+			t.Fatalf("applyDump = %d: %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+// FuzzWALReplay replays arbitrary log files. A log that opens is rewritten
+// as a snapshot, which must replay to the same store.
+func FuzzWALReplay(f *testing.F) {
+	f.Add([]byte(`{"key":"a","entry":{"value":"1","timestamp":1}}` + "\n" + `{"reset":true}` + "\n"))
+	f.Add([]byte(`{"key":"a","entry":{"value":"1","timestamp":1,"checksum":7}}`))
+	f.Add([]byte(`{"key":"a","entry":{"value":"1"`))
+	f.Fuzz(func(t *testing.T, log []byte) {
+		path := filepath.Join(t.TempDir(), "wal")
+		if err := os.WriteFile(path, log, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		l, data, err := openWAL(path)
+		if err != nil {
+			return
+		}
+		l.f.Close()
+		l, again, err := openWAL(path)
+		if err != nil {
+			t.Fatalf("reopening the snapshot: %v", err)
+		}
+		l.f.Close()
+		if !reflect.DeepEqual(data, again) {
+			t.Fatalf("snapshot replayed %v, first replay had %v", again, data)
+		}
+	})
+}
+
+func FuzzRESPCommand(f *testing.F) {
+	f.Add([]byte("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"))
+	f.Add([]byte("PING\r\n"))
+	f.Add([]byte("*1\r\n$9223372036854775807\r\n"))
+	f.Add([]byte("*99999999999\r\n"))
+	f.Fuzz(func(t *testing.T, in []byte) {
+		rd := bufio.NewReader(bytes.NewReader(in))
+		for {
+			args, err := readCommand(rd)
+			if err != nil {
+				return
+			}
+			for _, a := range args {
+				if len(a) > len(in) {
+					t.Fatalf("argument %q not from the input", a)
+				}
+			}
+		}
+	})
+}
+
+// FuzzGRPCFrames reads length-prefixed messages as the etcd API does.
+func FuzzGRPCFrames(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0, 2, 0x0a, 0})
+	f.Add([]byte{0, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, in []byte) {
+		r := bytes.NewReader(in)
+		for {
+			msg, err := readGRPCMessage(r)
+			if err != nil {
+				return
+			}
+			if len(msg) > len(in) {
+				t.Fatalf("read a %d-byte message from %d bytes", len(msg), len(in))
+			}
+			parseProto(msg)
+		}
+	})
+}
+
+// FuzzSnappy round-trips arbitrary batches and decodes arbitrary blocks.
+func FuzzSnappy(f *testing.F) {
+	f.Add([]byte(`{"a":{"value":"1","timestamp":1},"b":{"value":"1","timestamp":1}}`))
+	f.Add(bytes.Repeat([]byte("abcd"), 100))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0x0f, 0xfe, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, in []byte) {
+		if back, err := snappyDecode(snappyEncode(in), maxBatchBytes); err != nil || !bytes.Equal(back, in) {
+			t.Fatalf("round trip of %x: %x, %v", in, back, err)
+		}
+		if out, err := snappyDecode(in, 1<<20); err == nil && len(out) > 1<<20 {
+			t.Fatalf("decoded %d bytes past the limit", len(out))
+		}
+	})
+}
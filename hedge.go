@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -HEDGE_READS=0.95 hedges R>1 reads. A read asks its R nearest replicas
+// as before, but if it still lacks answers once the 95th percentile of
+// recent replica fetches has passed, it asks one more replica, and one
+// more each time that delay passes again, and takes the first R answers
+// that arrive. One slow follower then costs a read about the p95 instead
+// of its whole delay, at the price of a few percent more replica reads.
+// The delay comes from this node's last hedgeWindow successful fetches,
+// so until it has made some, reads are not hedged.
+
+const hedgeWindow = 256 // replica fetch latencies kept
+
+var (
+	hedgeQuantile float64 // -HEDGE_READS, 0 = off
+
+	hedgedReads atomic.Int64 // extra replica reads sent by hedging
+
+	fetchLatencies struct {
+		sync.Mutex
+		samples [hedgeWindow]time.Duration
+		next    int
+	}
+)
+
+// noteFetchLatency records how long a successful replica fetch took.
+func noteFetchLatency(d time.Duration) {
+	fetchLatencies.Lock()
+	defer fetchLatencies.Unlock()
+	fetchLatencies.samples[fetchLatencies.next%hedgeWindow] = d
+	fetchLatencies.next++
+}
+
+// hedgeDelay is how long a read waits for answers before asking one more
+// replica, 0 when reads are not hedged.
+func hedgeDelay() time.Duration {
+	if hedgeQuantile <= 0 {
+		return 0
+	}
+	fetchLatencies.Lock()
+	defer fetchLatencies.Unlock()
+	return quantile(fetchLatencies.samples[:min(fetchLatencies.next, hedgeWindow)], hedgeQuantile)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHedgedReads(t *testing.T) {
+	mem := newMemNet()
+	oldNet, oldData, oldRepair, oldHedge := peerNet, svc.data, readRepairOn, hedgeQuantile
+	defer func() {
+		peerNet, svc.data, readRepairOn, hedgeQuantile = oldNet, oldData, oldRepair, oldHedge
+	}()
+	peerNet, readRepairOn = mem.transport(), false
+	withCluster(t, func(c *membership) { c.peers = []string{"slow:1", "fast:1"} })
+	svc.data = map[string]Entry{}
+	svc.put("k", Entry{Value: "v", Timestamp: 1})
+
+	// slow is the nearest by heartbeat but takes 500ms to answer a read
+	notePing("slow:1", time.Millisecond, true)
+	notePing("fast:1", 2*time.Millisecond, true)
+	replica := func(delay time.Duration) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			json.NewEncoder(w).Encode(Entry{Value: "v", Timestamp: 1})
+		}
+	}
+	mem.attach("slow:1", replica(500*time.Millisecond))
+	mem.attach("fast:1", replica(0))
+	for range hedgeWindow {
+		noteFetchLatency(5 * time.Millisecond)
+	}
+
+	read := func() time.Duration {
+		start := time.Now()
+		if e, ok := readKey(nil, "k", 2); !ok || e.Value != "v" {
+			t.Fatalf("read = %+v, %v", e, ok)
+		}
+		return time.Since(start)
+	}
+	hedgeQuantile = 0
+	if took := read(); took < 500*time.Millisecond {
+		t.Fatalf("unhedged read took %v, want the slow replica's 500ms", took)
+	}
+	hedgeQuantile = 0.95
+	before := hedgedReads.Load()
+	if took := read(); took > 250*time.Millisecond {
+		t.Fatalf("hedged read took %v", took)
+	}
+	if hedgedReads.Load() != before+1 {
+		t.Fatalf("%d hedges counted, want 1", hedgedReads.Load()-before)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// opLatency records how long reads and writes take by consistency level,
+// so /metrics has the R=1 vs quorum and W=1 vs quorum distributions the
+// experiments compare. Levels are named by levelName, or by the client's
+// ?consistency= (LOCAL_QUORUM, EACH_QUORUM).
+
+// latencyBuckets are the histogram upper bounds in seconds, fine enough
+// to separate one replication round (200ms delay) from the next.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []int64 // per bucket, plus +Inf last
+	sum    float64
+	count  int64
+}
+
+func (h *histogram) observe(secs float64) {
+	i := sort.SearchFloat64s(latencyBuckets, secs)
+	h.counts[i]++
+	h.sum += secs
+	h.count++
+}
+
+var opLatency = struct {
+	sync.Mutex
+	byOp map[[2]string]*histogram // {op, level}
+}{byOp: map[[2]string]*histogram{}}
+
+// levelName names a read or write quorum of n replicas out of N.
+func levelName(n int) string {
+	switch {
+	case n <= 1:
+		return "one"
+	case n >= cluster().N:
+		return "all"
+	case n > cluster().N/2:
+		return "quorum"
+	default:
+		return strconv.Itoa(n)
+	}
+}
+
+// writeLevel names the level of a write: the client's ?consistency=, or
+// the quorum wq it waits for.
+func writeLevel(consistency string, wq int) string {
+	if consistency != "" {
+		return consistency
+	}
+	return levelName(wq)
+}
+
+// observeOp records one op ("read" or "write") at level that started at
+// start.
+func observeOp(op, level string, start time.Time) {
+	secs := time.Since(start).Seconds()
+	opLatency.Lock()
+	defer opLatency.Unlock()
+	k := [2]string{op, level}
+	h := opLatency.byOp[k]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(latencyBuckets)+1)}
+		opLatency.byOp[k] = h
+	}
+	h.observe(secs)
+}
+
+// writeLatencyMetrics renders opLatency for /metrics.
+func writeLatencyMetrics(w io.Writer) {
+	opLatency.Lock()
+	defer opLatency.Unlock()
+	keys := make([][2]string, 0, len(opLatency.byOp))
+	for k := range opLatency.byOp {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	fmt.Fprintln(w, "# HELP kv_op_latency_seconds Coordinated reads and writes by consistency level (one, quorum, all, a replica count, or a datacenter level).")
+	fmt.Fprintln(w, "# TYPE kv_op_latency_seconds histogram")
+	for _, k := range keys {
+		h := opLatency.byOp[k]
+		labels := fmt.Sprintf("op=%q,level=%q", k[0], k[1])
+		var cum int64
+		for i, le := range latencyBuckets {
+			cum += h.counts[i]
+			fmt.Fprintf(w, "kv_op_latency_seconds_bucket{%s,le=\"%g\"} %d\n", labels, le, cum)
+		}
+		fmt.Fprintf(w, "kv_op_latency_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "kv_op_latency_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(w, "kv_op_latency_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
+
+// writeHistogram renders h as the series of histogram name with labels.
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	var cum int64
+	for i, le := range latencyBuckets {
+		cum += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, le, cum)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// ackCounts counts writes by how many replicas had them, at the client's
+// answer and once settled.
+var ackCounts = struct {
+	sync.Mutex
+	byStage map[[2]string][]int64 // {stage, level} -> writes by ack count
+}{byStage: map[[2]string][]int64{}}
+
+// observeAcks records a write at level that acks replicas had by stage
+// ("response" or "final").
+func observeAcks(stage, level string, acks int) {
+	ackCounts.Lock()
+	defer ackCounts.Unlock()
+	k := [2]string{stage, level}
+	c := ackCounts.byStage[k]
+	for len(c) <= acks {
+		c = append(c, 0)
+	}
+	c[acks]++
+	ackCounts.byStage[k] = c
+}
+
+// writeAckMetrics renders ackCounts for /metrics, with a bucket per
+// replica count up to N.
+func writeAckMetrics(w io.Writer) {
+	ackCounts.Lock()
+	defer ackCounts.Unlock()
+	keys := make([][2]string, 0, len(ackCounts.byStage))
+	for k := range ackCounts.byStage {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	fmt.Fprintln(w, "# HELP kv_write_acks Replicas holding each coordinated write (the coordinator included), when the client was answered (stage=\"response\") and once no replica was pending (stage=\"final\").")
+	fmt.Fprintln(w, "# TYPE kv_write_acks histogram")
+	for _, k := range keys {
+		c := ackCounts.byStage[k]
+		labels := fmt.Sprintf("stage=%q,level=%q", k[0], k[1])
+		var cum, total, sum int64
+		for n, writes := range c {
+			total += writes
+			sum += int64(n) * writes
+		}
+		n := 0
+		for le := 1; le <= cluster().N; le++ {
+			for ; n <= le && n < len(c); n++ {
+				cum += c[n]
+			}
+			fmt.Fprintf(w, "kv_write_acks_bucket{%s,le=\"%d\"} %d\n", labels, le, cum)
+		}
+		fmt.Fprintf(w, "kv_write_acks_bucket{%s,le=\"+Inf\"} %d\n", labels, total)
+		fmt.Fprintf(w, "kv_write_acks_sum{%s} %d\n", labels, sum)
+		fmt.Fprintf(w, "kv_write_acks_count{%s} %d\n", labels, total)
+	}
+}
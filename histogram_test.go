@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpLatencyByLevel(t *testing.T) {
+	withCluster(t, func(c *membership) { c.N = 3 })
+	for n, want := range map[int]string{1: "one", 2: "quorum", 3: "all"} {
+		if got := levelName(n); got != want {
+			t.Errorf("levelName(%d) = %q, want %q", n, got, want)
+		}
+	}
+
+	observeOp("read", "quorum", time.Now().Add(-30*time.Millisecond))
+	var buf bytes.Buffer
+	writeLatencyMetrics(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		`kv_op_latency_seconds_bucket{op="read",level="quorum",le="0.025"} 0`,
+		`kv_op_latency_seconds_bucket{op="read",level="quorum",le="0.05"} 1`,
+		`kv_op_latency_seconds_count{op="read",level="quorum"} 1`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %s in\n%s", line, out)
+		}
+	}
+}
+
+func TestWriteAcksAtResponseAndFinal(t *testing.T) {
+	withCluster(t, func(c *membership) { c.N = 3 })
+
+	// a W=1 write: answered with only the coordinator's copy, settled with all three
+	ws := newWrite("k", []string{"a:1", "b:1"}, "")
+	ws.level = "acktest"
+	ws.begin()
+	ws.answered()
+	ws.replicated("a:1", true)
+	ws.replicated("b:1", true)
+	// one that never began is not counted
+	never := newWrite("k", nil, "")
+	never.level = "acktest"
+	never.answered()
+
+	var buf bytes.Buffer
+	writeAckMetrics(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		`kv_write_acks_bucket{stage="response",level="acktest",le="1"} 1`,
+		`kv_write_acks_count{stage="response",level="acktest"} 1`,
+		`kv_write_acks_bucket{stage="final",level="acktest",le="2"} 0`,
+		`kv_write_acks_bucket{stage="final",level="acktest",le="3"} 1`,
+		`kv_write_acks_sum{stage="final",level="acktest"} 3`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %s in\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A merge hook is an external program that reconciles conflicting
+// versions, selected like any other resolver as exec:<command>, e.g.
+//
+//	-CONFLICT_PREFIXES="cart/=exec:/opt/kv/merge-cart"
+//
+// It is only run when two versions are concurrent by their vector
+// clocks; a version that descends from the other simply replaces it. The
+// hook gets {"current": Entry, "incoming": Entry} as JSON on stdin and
+// prints the merged {"value": ..., "deleted": ...} on stdout. The node
+// fills in the rest: the later timestamp and node of the two, and a clock
+// descending from both. A hook that fails, times out or prints garbage
+// leaves the merge to LWW. Like any resolver it must not depend on which
+// version is current, or replicas will not converge.
+
+// mergeHookTimeout bounds one hook run, set by -MERGE_HOOK_TIMEOUT. Hooks
+// run under the store lock, so keep them quick.
+var mergeHookTimeout = time.Second
+
+var hookOK, hookFailed atomic.Int64
+
+const execPrefix = "exec:"
+
+type execResolver struct {
+	argv []string
+}
+
+func newExecResolver(command string) (ConflictResolver, error) {
+	argv := strings.Fields(command)
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("merge hook: empty command")
+	}
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return nil, fmt.Errorf("merge hook: %w", err)
+	}
+	return execResolver{argv}, nil
+}
+
+func (h execResolver) Resolve(current, incoming Entry) Entry {
+	fallback := lww{}.Resolve(current, incoming)
+	newer, older := incoming.Clock.descends(current.Clock), current.Clock.descends(incoming.Clock)
+	switch {
+	case newer && older:
+		return fallback // same clock: copies of one version
+	case newer:
+		return incoming
+	case older:
+		return current
+	}
+	merged, err := h.run(current, incoming)
+	if err != nil {
+		hookFailed.Add(1)
+		log.Printf("merge hook %s: %v; falling back to LWW", h.argv[0], err)
+		return fallback
+	}
+	hookOK.Add(1)
+	out := fallback
+	out.Value, out.Deleted, out.Type = merged.Value, merged.Deleted, ""
+	out.Clock = current.Clock.merge(incoming.Clock)
+	out.Siblings = nil
+	return out
+}
+
+func (h execResolver) run(current, incoming Entry) (Entry, error) {
+	in, _ := json.Marshal(map[string]Entry{"current": current, "incoming": incoming})
+	ctx, cancel := context.WithTimeout(context.Background(), mergeHookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.argv[0], h.argv[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(in), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return Entry{}, err
+	}
+	var merged struct {
+		Value   *string `json:"value"`
+		Deleted bool    `json:"deleted"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &merged); err != nil || merged.Value == nil && !merged.Deleted {
+		return Entry{}, fmt.Errorf("want {\"value\": ...} on stdout, got %q", stdout.String())
+	}
+	e := Entry{Deleted: merged.Deleted}
+	if merged.Value != nil {
+		e.Value = *merged.Value
+	}
+	if len(e.Value) > maxValueBytes {
+		return Entry{}, fmt.Errorf("merged value too large")
+	}
+	return e, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeHook(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "merge.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecResolverMergesConcurrentVersions(t *testing.T) {
+	r, err := lookupResolver("exec:" + writeHook(t, `cat >/dev/null; echo '{"value":"merged"}'`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := Entry{Value: "a", Timestamp: 100, Node: "kv1:8000", Clock: vclock{"kv1:8000": 1}}
+	b := Entry{Value: "b", Timestamp: 200, Node: "kv2:8000", Clock: vclock{"kv2:8000": 1}}
+
+	got := r.Resolve(a, b)
+	if got.Value != "merged" || got.Timestamp != 200 || got.Node != "kv2:8000" {
+		t.Fatalf("merged = %+v", got)
+	}
+	if !got.Clock.descends(a.Clock) || !got.Clock.descends(b.Clock) {
+		t.Errorf("merged clock %v does not descend from both versions", got.Clock)
+	}
+
+	// a version that descends from the other replaces it without the hook
+	next := Entry{Value: "next", Timestamp: 50, Node: "kv1:8000", Clock: got.Clock.merge(vclock{"kv1:8000": 2})}
+	if got := r.Resolve(got, next); got.Value != "next" {
+		t.Errorf("descendant = %+v, want it to win", got)
+	}
+}
+
+func TestExecResolverFallsBackToLWW(t *testing.T) {
+	for name, body := range map[string]string{
+		"exit":    `echo broken >&2; exit 1`,
+		"garbage": `echo not json`,
+	} {
+		r, err := lookupResolver("exec:" + writeHook(t, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := Entry{Value: "a", Timestamp: 100, Clock: vclock{"kv1:8000": 1}}
+		b := Entry{Value: "b", Timestamp: 200, Clock: vclock{"kv2:8000": 1}}
+		if got := r.Resolve(a, b); got.Value != "b" {
+			t.Errorf("%s: failed hook gave %+v, want LWW's b", name, got)
+		}
+	}
+
+	if _, err := lookupResolver("exec:/no/such/hook"); err == nil {
+		t.Error("missing hook command accepted")
+	}
+}
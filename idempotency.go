@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyTTL is how long a coordinator remembers an Idempotency-Key,
+// set by -IDEMPOTENCY_TTL.
+var idempotencyTTL = 10 * time.Minute
+
+const maxIdempotencyKey = 255
+
+// idemResult is the outcome of the first request made with a key. done is
+// closed once the response has been recorded. Only decided writes (2xx and
+// 412) are kept; anything else, such as a 503 during a leader transfer or a
+// follower's redirect, is dropped so the retry runs for real.
+type idemResult struct {
+	done        chan struct{}
+	fingerprint string
+	dropped     bool
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+}
+
+var (
+	idemMu      sync.Mutex
+	idemResults = make(map[string]*idemResult)
+	idemSweep   time.Time
+)
+
+// idempotent replays the recorded response when a write is retried with
+// the same Idempotency-Key, so a retry after a timeout does not apply
+// the write a second time under a new timestamp. Concurrent duplicates
+// wait for the first to finish. Reusing a key for a different request
+// is a 422.
+func idempotent(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			h(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			httpError(w, http.StatusBadRequest, "Idempotency-Key", "Idempotency-Key longer than 255 bytes")
+			return
+		}
+		fp, err := fingerprint(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "", "reading body: "+err.Error())
+			return
+		}
+
+		for {
+			res, first := claimIdempotencyKey(key, fp)
+			if !first {
+				<-res.done
+				if res.dropped {
+					continue // the first attempt failed; this one retries it
+				}
+				if res.fingerprint != fp {
+					httpError(w, http.StatusUnprocessableEntity, "Idempotency-Key", "Idempotency-Key was used for a different request")
+					return
+				}
+				for k, v := range res.header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(res.status)
+				w.Write(res.body)
+				return
+			}
+
+			rec := &capturingWriter{ResponseWriter: w}
+			defer func() {
+				idemMu.Lock()
+				if !decided(rec.status) {
+					res.dropped = true
+					delete(idemResults, key)
+				} else {
+					res.status, res.header, res.body = rec.status, rec.Header().Clone(), rec.body.Bytes()
+					// transport headers are the outer middleware's to set again
+					for _, k := range []string{"Content-Encoding", "Content-Length", "Vary"} {
+						res.header.Del(k)
+					}
+					res.expires = time.Now().Add(idempotencyTTL)
+				}
+				idemMu.Unlock()
+				close(res.done)
+			}()
+			h(rec, r)
+			return
+		}
+	}
+}
+
+func decided(status int) bool {
+	return status >= 200 && status < 300 || status == http.StatusPreconditionFailed
+}
+
+// claimIdempotencyKey returns the result slot for key, and whether this
+// request is the first to use it.
+func claimIdempotencyKey(key, fp string) (*idemResult, bool) {
+	idemMu.Lock()
+	defer idemMu.Unlock()
+	now := time.Now()
+	if now.Sub(idemSweep) > time.Minute {
+		for k, res := range idemResults {
+			if !res.expires.IsZero() && now.After(res.expires) {
+				delete(idemResults, k)
+			}
+		}
+		idemSweep = now
+	}
+	if res, ok := idemResults[key]; ok && (res.expires.IsZero() || now.Before(res.expires)) {
+		return res, false
+	}
+	res := &idemResult{done: make(chan struct{}), fingerprint: fp}
+	idemResults[key] = res
+	return res, true
+}
+
+// fingerprint identifies a request by method, path, query and body. The
+// body is put back for the handler.
+func fingerprint(r *http.Request) (string, error) {
+	sum := sha256.New()
+	io.WriteString(sum, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+"\n")
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := readBody(r)
+		if err != nil {
+			return "", err
+		}
+		sum.Write(body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// capturingWriter passes a response through while keeping a copy.
+type capturingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *capturingWriter) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *capturingWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(p)
+	return c.ResponseWriter.Write(p)
+}
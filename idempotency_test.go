@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIdempotencyKeyReplaysWrite(t *testing.T) {
+	node := startNode(t, 9107, nil, true, 1, 1, 1)
+	defer node.Process.Kill()
+	waitReady(t, 9107)
+
+	set := func(query, idemKey string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:9107/set?"+query, nil)
+		req.Header.Set("Idempotency-Key", idemKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("set: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	first := set("key=idem&value=1", "req-1")
+	before, _ := getEntry(t, "http://localhost:9107/get?key=idem")
+	retry := set("key=idem&value=1", "req-1")
+	after, _ := getEntry(t, "http://localhost:9107/get?key=idem")
+
+	if first.StatusCode != http.StatusCreated || retry.StatusCode != http.StatusCreated {
+		t.Fatalf("statuses %d, %d", first.StatusCode, retry.StatusCode)
+	}
+	if retry.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry was not marked as replayed")
+	}
+	if after.Timestamp != before.Timestamp {
+		t.Errorf("retry rewrote the entry: %d -> %d", before.Timestamp, after.Timestamp)
+	}
+
+	if resp := set("key=idem&value=2", "req-1"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("reused key for a different write = %d, want 422", resp.StatusCode)
+	}
+	if resp := set("key=idem&value=2", "req-2"); resp.StatusCode != http.StatusCreated || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("fresh key = %d (replayed %q)", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+}
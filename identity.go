@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Each node has a random ID, and belongs to a cluster with a random UUID,
+// both kept in an identity file (-IDENTITY, by default next to the WAL)
+// so they survive restarts. Peer requests and responses carry both; a
+// node refuses requests from, and replies from, another cluster with 421,
+// and refuses a peer using its own node ID, the sign of a copied data
+// directory. A node without a cluster UUID joins the cluster of the first
+// peer it talks to, unless -CLUSTER_ID names one; if no peer has one
+// either, the node with the lowest address among its peers creates it.
+
+const (
+	nodeIDHeader  = "X-KV-Node"
+	clusterHeader = "X-KV-Cluster"
+)
+
+type nodeIdentity struct {
+	NodeID  string `json:"node_id"`
+	Cluster string `json:"cluster_id,omitempty"`
+}
+
+var ident struct {
+	sync.Mutex
+	nodeIdentity
+	path    string            // "" keeps the identity in memory only
+	peerIDs map[string]string // node ID each peer answered with
+}
+
+func randomID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// loadIdentity reads the identity file, creating it with a new node ID if
+// there is none. cluster, if set, must match the one recorded there.
+func loadIdentity(path, cluster string) error {
+	ident.path, ident.peerIDs, ident.nodeIdentity = path, map[string]string{}, nodeIdentity{}
+	if path != "" {
+		bs, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(bs, &ident.nodeIdentity)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("identity %s: %w", path, err)
+		}
+	}
+	if ident.Cluster != "" && cluster != "" && ident.Cluster != cluster {
+		return fmt.Errorf("identity %s: node belongs to cluster %s, not -CLUSTER_ID %s", path, ident.Cluster, cluster)
+	}
+	if ident.Cluster == "" {
+		ident.Cluster = cluster
+	}
+	if ident.NodeID == "" {
+		ident.NodeID = randomID()
+	}
+	return saveIdentity()
+}
+
+// saveIdentity writes the identity file. The caller holds ident's lock or
+// is loading it.
+func saveIdentity() error {
+	if ident.path == "" {
+		return nil
+	}
+	bs, _ := json.Marshal(ident.nodeIdentity)
+	tmp := ident.path + ".tmp"
+	if err := os.WriteFile(tmp, append(bs, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ident.path)
+}
+
+func identity() nodeIdentity {
+	ident.Lock()
+	defer ident.Unlock()
+	return ident.nodeIdentity
+}
+
+// joinCluster adopts cluster if this node has none yet.
+func joinCluster(cluster, from string) {
+	ident.Lock()
+	defer ident.Unlock()
+	if ident.Cluster != "" {
+		return
+	}
+	ident.Cluster = cluster
+	log.Printf("joined cluster %s (from %s)", cluster, from)
+	if err := saveIdentity(); err != nil {
+		log.Printf("saving identity: %v", err)
+	}
+}
+
+// checkIdentity vets the node and cluster a peer sent, joining its cluster
+// if this node has none. from names the peer for messages.
+func checkIdentity(node, cluster, from string) error {
+	me := identity()
+	if node != "" && node == me.NodeID {
+		return fmt.Errorf("%s uses this node's ID %s; was its data directory copied?", from, node)
+	}
+	switch {
+	case cluster == "":
+	case me.Cluster == "":
+		joinCluster(cluster, from)
+	case cluster != me.Cluster:
+		return fmt.Errorf("%s belongs to cluster %s, not %s", from, cluster, me.Cluster)
+	}
+	return nil
+}
+
+// setIdentityHeaders stamps this node's ID and cluster on h.
+func setIdentityHeaders(h http.Header) {
+	me := identity()
+	h.Set(nodeIDHeader, me.NodeID)
+	if me.Cluster != "" {
+		h.Set(clusterHeader, me.Cluster)
+	}
+}
+
+// learnIdentity vets a peer's response and notes the node ID that answered.
+func learnIdentity(peer string, resp *http.Response) error {
+	node := resp.Header.Get(nodeIDHeader)
+	if err := checkIdentity(node, resp.Header.Get(clusterHeader), "peer "+peer); err != nil {
+		return err
+	}
+	if node == "" {
+		return nil
+	}
+	ident.Lock()
+	defer ident.Unlock()
+	if ident.peerIDs == nil {
+		ident.peerIDs = map[string]string{}
+	}
+	if old, ok := ident.peerIDs[peer]; ok && old != node {
+		log.Printf("peer %s is now node %s, was %s; it has lost its identity and maybe its data", peer, node, old)
+	}
+	ident.peerIDs[peer] = node
+	return nil
+}
+
+// knownPeer reports whether h carries the node ID one of this node's peers
+// answered with, and this node's cluster.
+func knownPeer(h http.Header) bool {
+	node, me := h.Get(nodeIDHeader), identity()
+	if node == "" || h.Get(clusterHeader) != me.Cluster {
+		return false
+	}
+	ident.Lock()
+	defer ident.Unlock()
+	for _, p := range cluster().peers {
+		if ident.peerIDs[p] == node {
+			return true
+		}
+	}
+	return false
+}
+
+func peerNodeID(peer string) string {
+	ident.Lock()
+	defer ident.Unlock()
+	return ident.peerIDs[peer]
+}
+
+// checkClusterIdentity refuses requests from another cluster. Requests
+// without identity headers, i.e. from clients, are served.
+func checkClusterIdentity(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setIdentityHeaders(w.Header())
+		if err := checkIdentity(r.Header.Get(nodeIDHeader), r.Header.Get(clusterHeader), "sender "+r.RemoteAddr); err != nil {
+			httpError(w, http.StatusMisdirectedRequest, clusterHeader, err.Error())
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// bootstrapCluster creates a cluster UUID once every peer has answered
+// the handshake without one, if this node sorts first.
+func bootstrapCluster() {
+	for _, p := range cluster().peers {
+		if p < self {
+			return
+		}
+	}
+	ident.Lock()
+	defer ident.Unlock()
+	if ident.Cluster != "" {
+		return
+	}
+	ident.Cluster = randomID()
+	log.Printf("created cluster %s", ident.Cluster)
+	if err := saveIdentity(); err != nil {
+		log.Printf("saving identity: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentityPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.id")
+	if err := loadIdentity(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	first := identity()
+	joinCluster("c1", "test")
+	if err := loadIdentity(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if me := identity(); me.NodeID != first.NodeID || me.Cluster != "c1" {
+		t.Fatalf("reloaded %+v, was node %s in c1", me, first.NodeID)
+	}
+	if err := loadIdentity(path, "c2"); err == nil {
+		t.Fatal("-CLUSTER_ID c2 accepted for a node of c1")
+	}
+	loadIdentity("", "")
+}
+
+func TestClusterIdentityCheck(t *testing.T) {
+	loadIdentity("", "c1")
+	defer loadIdentity("", "")
+	me := identity()
+	h := checkClusterIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, c := range []struct {
+		node, cluster string
+		want          int
+	}{
+		{"", "", 200},        // a client
+		{"other", "c1", 200}, // a peer
+		{"other", "", 200},   // a peer that has not joined yet
+		{"other", "c2", http.StatusMisdirectedRequest},
+		{me.NodeID, "c1", http.StatusMisdirectedRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/replicate", nil)
+		if c.node != "" {
+			req.Header.Set(nodeIDHeader, c.node)
+		}
+		if c.cluster != "" {
+			req.Header.Set(clusterHeader, c.cluster)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.want || rec.Header().Get(clusterHeader) != "c1" {
+			t.Errorf("node %q cluster %q: status %d, X-KV-Cluster %q", c.node, c.cluster, rec.Code, rec.Header().Get(clusterHeader))
+		}
+	}
+}
+
+func TestPeerTransportJoinsCluster(t *testing.T) {
+	loadIdentity("", "")
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(nodeIDHeader, "peer")
+		w.Header().Set(clusterHeader, "c1")
+	}))
+	defer peer.Close()
+	c := &http.Client{Transport: peerTransport{http.DefaultTransport}}
+	resp, err := c.Get(peer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if identity().Cluster != "c1" {
+		t.Fatalf("did not join the peer's cluster: %+v", identity())
+	}
+
+	loadIdentity("", "c2")
+	defer loadIdentity("", "")
+	if _, err := c.Get(peer.URL); err == nil {
+		t.Fatal("reply from another cluster accepted")
+	}
+}
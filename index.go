@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secondary indexes, -INDEXES=email,address.city, look inside JSON object
+// values: each node keeps, for every declared field (a dotted path), the
+// keys whose live value holds it, by the field's value. Strings index as
+// themselves, numbers and booleans as their JSON text, and an array of
+// them under each element. /query?index=email&value=a@b.c lists the
+// matching keys and their entries from the node's own store, like /scan.
+// With ?R= above 1 the node also asks its peers for their matches and
+// reads each key it hears of at that quorum, keeping the ones whose
+// merged value still matches, so a replica that missed a write, or an
+// index entry a newer write replaced elsewhere, does not decide the
+// answer. Indexes are kept under the store lock by Store.put and rebuilt
+// whenever the store is replaced, so they cost nothing to recover.
+
+var (
+	indexedFields []string // -INDEXES
+
+	// indexes maps field -> value -> keys, under the store lock.
+	indexes = map[string]map[string]map[string]struct{}{}
+)
+
+// configureIndexes applies -INDEXES.
+func configureIndexes(spec string) error {
+	indexedFields = nil
+	clear(indexes)
+	for _, f := range strings.Split(spec, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		if slices.Contains(strings.Split(f, "."), "") {
+			return fmt.Errorf("index %q: want a field or dotted path", f)
+		}
+		indexedFields = append(indexedFields, f)
+		indexes[f] = map[string]map[string]struct{}{}
+	}
+	return nil
+}
+
+// fieldValues returns what the JSON value v holds at the dotted path, as
+// index values.
+func fieldValues(v, path string) []string {
+	var doc any
+	if json.Unmarshal([]byte(v), &doc) != nil {
+		return nil
+	}
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			return nil
+		}
+		doc = obj[name]
+	}
+	if arr, ok := doc.([]any); ok {
+		var out []string
+		for _, el := range arr {
+			if s, ok := indexValue(el); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	if s, ok := indexValue(doc); ok {
+		return []string{s}
+	}
+	return nil
+}
+
+func indexValue(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// matches reports whether a live version of e holds value at field.
+func matches(e Entry, field, value string) bool {
+	now := time.Now().UnixNano()
+	for _, v := range e.versions() {
+		if !v.Deleted && !v.expired(now) && v.Type == "" && slices.Contains(fieldValues(v.Value, field), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexEntry adds (sign 1) or removes (sign -1) key's index entries for e.
+// Expired values stay indexed until swept; queries check them.
+func indexEntry(key string, e Entry, sign int) {
+	for _, f := range indexedFields {
+		for _, v := range e.versions() {
+			if v.Deleted || v.Type != "" {
+				continue
+			}
+			for _, val := range fieldValues(v.Value, f) {
+				keys := indexes[f][val]
+				if sign > 0 {
+					if keys == nil {
+						keys = map[string]struct{}{}
+						indexes[f][val] = keys
+					}
+					keys[key] = struct{}{}
+					continue
+				}
+				delete(keys, key)
+				if len(keys) == 0 {
+					delete(indexes[f], val)
+				}
+			}
+		}
+	}
+}
+
+// reindex rebuilds the indexes after the store was replaced.
+func reindex(data map[string]Entry) {
+	for _, f := range indexedFields {
+		indexes[f] = map[string]map[string]struct{}{}
+	}
+	for k, e := range data {
+		indexEntry(k, e, 1)
+	}
+}
+
+// localMatches lists this node's live entries holding value at field.
+func localMatches(field, value string) []KV {
+	svc.RLock()
+	defer svc.RUnlock()
+	var out []KV
+	for k := range indexes[field][value] {
+		if e := svc.data[k]; matches(e, field, value) {
+			out = append(out, KV{Key: k, Entry: e})
+		}
+	}
+	return out
+}
+
+// queryHandler answers /query?index=&value=, from this node alone or, with
+// ?R=, merged across the cluster.
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	field, value := q.Get("index"), q.Get("value")
+	if _, ok := indexes[field]; !ok {
+		httpError(w, http.StatusBadRequest, "index", fmt.Sprintf("%q is not an indexed field (-INDEXES=%s)", field, strings.Join(indexedFields, ",")))
+		return
+	}
+	limit := -1
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			httpError(w, http.StatusBadRequest, "limit", "limit must be a non-negative integer")
+			return
+		}
+		limit = n
+	}
+
+	out := localMatches(field, value)
+	if rq := readQuorum(r); rq > 1 {
+		var err error
+		if out, err = quorumMatches(traceOf(r), field, value, rq, out); err != nil {
+			httpError(w, http.StatusServiceUnavailable, "", err.Error())
+			return
+		}
+	}
+	if out == nil {
+		out = []KV{}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	if limit >= 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	bs, _ := json.Marshal(out)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
+
+// quorumMatches collects the keys every peer's index has for value,
+// besides the local ones, then reads each at rq and keeps those whose
+// merged entry matches. It fails when fewer than rq nodes answered.
+func quorumMatches(tr *reqTrace, field, value string, rq int, local []KV) ([]KV, error) {
+	candidates := map[string]bool{}
+	for _, kv := range local {
+		candidates[kv.Key] = true
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	answered := 1
+	for _, p := range cluster().peers {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			keys, err := fetchIndexKeys(p, field, value)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				return
+			}
+			answered++
+			for _, k := range keys {
+				candidates[k] = true
+			}
+		}(p)
+	}
+	wg.Wait()
+	if answered < replicaQuorum(rq) {
+		return nil, fmt.Errorf("only %d of the %d nodes needed answered", answered, replicaQuorum(rq))
+	}
+
+	out := make([]KV, 0, len(candidates))
+	for k := range candidates {
+		wg.Add(1)
+		go func(k string) {
+			defer wg.Done()
+			if e, ok := readKey(tr, k, rq); ok && matches(e, field, value) {
+				mu.Lock()
+				out = append(out, KV{Key: k, Entry: e})
+				mu.Unlock()
+			}
+		}(k)
+	}
+	wg.Wait()
+	return out, nil
+}
+
+// indexKeysHandler is the peer side of quorumMatches: the keys this
+// node's index has for ?value= under ?index=.
+func indexKeysHandler(w http.ResponseWriter, r *http.Request) {
+	field := r.URL.Query().Get("index")
+	if _, ok := indexes[field]; !ok {
+		httpError(w, http.StatusBadRequest, "index", fmt.Sprintf("%q is not indexed here", field))
+		return
+	}
+	keys := []string{}
+	for _, kv := range localMatches(field, r.URL.Query().Get("value")) {
+		keys = append(keys, kv.Key)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func fetchIndexKeys(peer, field, value string) ([]string, error) {
+	resp, err := peerNet.Fetch(context.Background(), peer, fmt.Sprintf("/index_keys?index=%s&value=%s", url.QueryEscape(field), url.QueryEscape(value)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var keys []string
+	err = json.NewDecoder(resp.Body).Decode(&keys)
+	return keys, err
+}
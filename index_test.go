@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIndex_FieldValuesAndUpkeep(t *testing.T) {
+	for _, c := range []struct {
+		value, path string
+		want        []string
+	}{
+		{`{"email":"a@x"}`, "email", []string{"a@x"}},
+		{`{"address":{"city":"Seattle"}}`, "address.city", []string{"Seattle"}},
+		{`{"age":42,"admin":true}`, "age", []string{"42"}},
+		{`{"tags":["a",1,{"x":1}]}`, "tags", []string{"a", "1"}},
+		{`{"address":"Seattle"}`, "address.city", nil},
+		{`not json`, "email", nil},
+	} {
+		if got := fieldValues(c.value, c.path); !slices.Equal(got, c.want) {
+			t.Errorf("fieldValues(%s, %s) = %q, want %q", c.value, c.path, got, c.want)
+		}
+	}
+
+	defer configureIndexes("")
+	if err := configureIndexes("email"); err != nil {
+		t.Fatal(err)
+	}
+	svc.Lock()
+	svc.put("idx/1", Entry{Value: `{"email":"a@x"}`, Timestamp: 1})
+	svc.put("idx/1", Entry{Value: `{"email":"b@x"}`, Timestamp: 2})
+	svc.Unlock()
+	defer func() {
+		svc.Lock()
+		delete(svc.data, "idx/1")
+		svc.Unlock()
+	}()
+	if got := localMatches("email", "a@x"); len(got) != 0 {
+		t.Errorf("old value still indexed: %+v", got)
+	}
+	if got := localMatches("email", "b@x"); len(got) != 1 || got[0].Key != "idx/1" {
+		t.Errorf("new value matches = %+v", got)
+	}
+	if err := configureIndexes("address..city"); err == nil {
+		t.Error("empty path element accepted")
+	}
+}
+
+func TestIndex_QuorumQueryMergesReplicas(t *testing.T) {
+	p1, p2 := 9163, 9164
+	a := startNode(t, p1, []string{fmt.Sprintf("localhost:%d", p2)}, false, 2, 1, 2, "-LEADERLESS", "-INDEXES", "email")
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{fmt.Sprintf("localhost:%d", p1)}, false, 2, 1, 2, "-LEADERLESS", "-INDEXES", "email")
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/set?key=user/1&value=%s", p1, url.QueryEscape(`{"email":"a@x"}`)), "", nil)
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("set: %v %v", resp, err)
+	}
+	resp.Body.Close()
+	// b alone learns that user/1 changed and that user/2 exists
+	for _, kv := range [][2]string{{"user/1", `{"email":"b@x"}`}, {"user/2", `{"email":"a@x"}`}} {
+		q := url.Values{"key": {kv[0]}, "value": {kv[1]}, "timestamp": {strconv.FormatInt(time.Now().UnixNano(), 10)}, "node": {"test"}, "epoch": {"1"}}
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/replicate?%s", p2, q.Encode()), "", nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("replicate %s: %v %v", kv[0], resp, err)
+		}
+		resp.Body.Close()
+	}
+
+	query := func(r int) []string {
+		var out []KV
+		if err := getJSON(fmt.Sprintf("http://localhost:%d/query?index=email&value=a@x&R=%d", p1, r), &out); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, kv := range out {
+			keys = append(keys, kv.Key)
+		}
+		return keys
+	}
+	if got := query(1); !slices.Equal(got, []string{"user/1"}) {
+		t.Errorf("local query = %v, want [user/1]", got)
+	}
+	if got := query(2); !slices.Equal(got, []string{"user/2"}) {
+		t.Errorf("R=2 query = %v, want [user/2]", got)
+	}
+	var out []KV
+	if err := getJSON(fmt.Sprintf("http://localhost:%d/query?index=name&value=x", p1), &out); err == nil {
+		t.Error("query on a field that is not indexed succeeded")
+	}
+}
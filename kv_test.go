@@ -31,8 +31,8 @@ func TestMain(m *testing.M) {
 	}
 	binName = filepath.Join(wd, "kvserver_test_bin"+ext)
 
-	// build main.go → binName
-	build := exec.Command("go", "build", "-o", binName, "main.go")
+	// build the package → binName
+	build := exec.Command("go", "build", "-o", binName, ".")
 	build.Stdout = os.Stdout
 	build.Stderr = os.Stderr
 	if err := build.Run(); err != nil {
@@ -45,8 +45,8 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-// startNode launches one server instance
-func startNode(t *testing.T, port int, peers []string, leader bool, N, R, W int) *exec.Cmd {
+// startNode launches one server instance, passing any extra flags through
+func startNode(t *testing.T, port int, peers []string, leader bool, N, R, W int, extra ...string) *exec.Cmd {
 	args := []string{
 		"-PORT", fmt.Sprint(port),
 		"-PEERS", strings.Join(peers, ","),
@@ -57,6 +57,7 @@ func startNode(t *testing.T, port int, peers []string, leader bool, N, R, W int)
 	if leader {
 		args = append(args, "-LEADER")
 	}
+	args = append(args, extra...)
 	cmd := exec.Command(binName, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -66,6 +67,27 @@ func startNode(t *testing.T, port int, peers []string, leader bool, N, R, W int)
 	return cmd
 }
 
+// waitReady polls each node's /status until it reports ready.
+func waitReady(t *testing.T, ports ...int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for _, p := range ports {
+		for {
+			resp, err := http.Get(fmt.Sprintf("http://localhost:%d/status", p))
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					break
+				}
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("node on port %d not ready: %v", p, err)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+}
+
 // getEntry does an HTTP GET and unmarshals an Entry if 200 OK
 func getEntry(t *testing.T, url string) (Entry, int) {
 	resp, err := http.Get(url)
@@ -105,7 +127,7 @@ func TestLeader_ImmediateConsistencyAndWindow(t *testing.T) {
 	defer f1.Process.Kill()
 	defer f2.Process.Kill()
 
-	time.Sleep(200 * time.Millisecond)
+	waitReady(t, leaderPort, f1Port, f2Port)
 
 	key, val := "foo", "bar"
 	setURL := fmt.Sprintf("http://localhost:%d/set?key=%s&value=%s", leaderPort, key, val)
@@ -156,7 +178,7 @@ func TestLeaderless_InconsistencyWindowThenConsistency(t *testing.T) {
 	defer n1.Process.Kill()
 	defer n2.Process.Kill()
 	defer n3.Process.Kill()
-	time.Sleep(200 * time.Millisecond)
+	waitReady(t, p1, p2, p3)
 
 	key, val := "baz", "qux"
 	setURL := fmt.Sprintf("http://localhost:%d/set?key=%s&value=%s", p1, key, val)
@@ -191,3 +213,35 @@ func TestLeaderless_InconsistencyWindowThenConsistency(t *testing.T) {
         t.Errorf("p2 /get: expected %q got %q (code %d)", val, e2.Value, code)
     }
 }
+
+// a quorum read on a node that missed a delete must see the replica's
+// tombstone rather than take its absence for a missing key
+func TestQuorumReadSeesReplicaTombstone(t *testing.T) {
+	lPort, fPort := 9188, 9189
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	leader := startNode(t, lPort, []string{addr(fPort)}, true, 2, 2, 2)
+	defer leader.Process.Kill()
+	f := startNode(t, fPort, []string{addr(lPort)}, false, 2, 2, 2)
+	defer f.Process.Kill()
+	waitReady(t, lPort, fPort)
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/set?key=k&value=v", addr(lPort)), "", nil)
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("set: %v %v", resp, err)
+	}
+	resp.Body.Close()
+	e, _ := getEntry(t, fmt.Sprintf("http://%s/local_read?key=k", addr(lPort)))
+
+	// only the follower takes the delete
+	del := fmt.Sprintf("http://%s/replicate?key=k&deleted=true&timestamp=%d&node=%s&epoch=1&from=%s",
+		addr(fPort), e.Timestamp+1, addr(lPort), addr(lPort))
+	resp, err = http.Post(del, "", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("replicating the tombstone: %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	if e, code := getEntry(t, fmt.Sprintf("http://%s/get?key=k", addr(lPort))); code != http.StatusNotFound {
+		t.Errorf("R=2 read after the delete = %d %+v, want 404", code, e)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Heartbeats also feed a window of each peer's last rttWindow round
+// trips, from which rttPercentile reads its tail. With
+// -PEER_TIMEOUT_FACTOR set, each /replicate attempt to a peer gets that
+// peer's p99 RTT times the factor, but never less than -PEER_TIMEOUT_MIN
+// (which has to cover the time a follower takes to apply a write) and
+// never more than the write's own budget. A peer that stops answering
+// then costs a write one attempt's worth rather than its whole
+// -WRITE_TIMEOUT, so the write moves on to the next replica, while a peer
+// that is far away but answering gets the longer timeout its RTT calls
+// for instead of one global value tuned to the nearest.
+
+const (
+	rttAlpha  = 0.2 // weight of the newest sample in the smoothed round-trip time
+	rttWindow = 128 // heartbeats kept per peer for percentiles
+
+	// pingBeats is how many heartbeat intervals a ping may take before it
+	// counts as missed, so a peer that accepts connections but never
+	// answers is marked down, and at most this many pings to it are out
+	pingBeats = 3
+)
+
+var (
+	peerTimeoutFactor float64                  // -PEER_TIMEOUT_FACTOR, 0 = no per-attempt timeout
+	peerTimeoutMin    = 500 * time.Millisecond // -PEER_TIMEOUT_MIN
+
+	peerTimeouts atomic.Int64 // replication attempts abandoned at their adaptive timeout
+)
+
+// startPinger pings every peer each heartbeat interval to keep latency
+// estimates fresh for read routing.
+func startPinger() {
+	go func() {
+		for range time.Tick(heartbeatEvery) {
+			for _, p := range cluster().peers {
+				go ping(p)
+			}
+		}
+	}()
+}
+
+func ping(peer string) {
+	ctx, cancel := context.WithTimeout(context.Background(), pingBeats*heartbeatEvery)
+	defer cancel()
+	start := time.Now()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+peer+"/ping", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		notePing(peer, 0, false)
+		return
+	}
+	resp.Body.Close()
+	notePing(peer, time.Since(start), resp.StatusCode == http.StatusOK)
+	if resp.StatusCode == http.StatusOK {
+		deliverHints(peer)
+	}
+	learnLeader(resp)
+	learnClientAddr(peer, resp)
+	learnTags(peer, resp)
+}
+
+// pingHandler answers heartbeats; a leader names itself and its epoch so
+// followers can point clients at it.
+func pingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Client-Addr", clientAddr)
+	w.Header().Set(tagsHeader, encodeTags(nodeTags()))
+	if isLeader.Load() {
+		w.Header().Set("X-Leader", self)
+		w.Header().Set("X-Epoch", strconv.FormatInt(currentEpoch.Load(), 10))
+	}
+	w.Write([]byte("pong\n"))
+}
+
+// learnLeader records a leader advertised in a heartbeat response.
+func learnLeader(resp *http.Response) {
+	l := resp.Header.Get("X-Leader")
+	e, err := strconv.ParseInt(resp.Header.Get("X-Epoch"), 10, 64)
+	if l == "" || err != nil || isLeader.Load() {
+		return
+	}
+	if observeEpoch(e) {
+		setLeader(l)
+	}
+}
+
+// notePing folds one heartbeat into the peer's smoothed RTT.
+func notePing(peer string, rtt time.Duration, ok bool) {
+	ps := statusFor(peer)
+	ps.Lock()
+	defer ps.Unlock()
+	ps.reachable = ok
+	if !ok {
+		ps.missedPings++
+		return
+	}
+	ps.missedPings = 0
+	if ps.rtt == 0 {
+		ps.rtt = rtt
+	} else {
+		ps.rtt = time.Duration(rttAlpha*float64(rtt) + (1-rttAlpha)*float64(ps.rtt))
+	}
+	ps.rtts[ps.rttNext%rttWindow] = rtt
+	ps.rttNext++
+}
+
+// rttPercentile is the q-th quantile (0..1) of peer's recent heartbeat
+// round trips, 0 before its first answer. The caller does not hold ps.
+func rttPercentile(peer string, q float64) time.Duration {
+	ps := statusFor(peer)
+	ps.Lock()
+	defer ps.Unlock()
+	return ps.rttQuantile(q)
+}
+
+// rttQuantile is rttPercentile for a locked ps.
+func (ps *peerStatus) rttQuantile(q float64) time.Duration {
+	return quantile(ps.rtts[:min(ps.rttNext, rttWindow)], q)
+}
+
+// quantile is the q-th quantile (0..1) of samples, 0 for none.
+func quantile(samples []time.Duration, q float64) time.Duration {
+	n := len(samples)
+	if n == 0 {
+		return 0
+	}
+	s := slices.Clone(samples)
+	slices.Sort(s)
+	return s[min(int(q*float64(n)), n-1)]
+}
+
+// replicationTimeout is how long one replication attempt to peer may take
+// under -PEER_TIMEOUT_FACTOR, 0 when there is no limit of its own.
+func replicationTimeout(peer string) time.Duration {
+	if peerTimeoutFactor <= 0 {
+		return 0
+	}
+	return max(peerTimeoutMin, time.Duration(peerTimeoutFactor*float64(rttPercentile(peer, 0.99))))
+}
+
+// attemptContext bounds one replication attempt to peer by its adaptive
+// timeout, on top of ctx.
+func attemptContext(ctx context.Context, peer string) (context.Context, context.CancelFunc) {
+	if d := replicationTimeout(peer); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// nearestPeers orders peers by smoothed RTT. Peers that have not answered
+// a ping yet come next, and peers whose last ping failed go last.
+func nearestPeers() []string {
+	type cand struct {
+		addr string
+		rank int
+		rtt  time.Duration
+	}
+	cands := make([]cand, 0, len(cluster().peers))
+	for _, p := range cluster().peers {
+		ps := statusFor(p)
+		ps.Lock()
+		c := cand{addr: p, rtt: ps.rtt}
+		switch {
+		case ps.reachable:
+			c.rank = 0
+		case ps.rtt == 0:
+			c.rank = 1
+		default:
+			c.rank = 2
+		}
+		ps.Unlock()
+		cands = append(cands, c)
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		if cands[i].rank != cands[j].rank {
+			return cands[i].rank < cands[j].rank
+		}
+		return cands[i].rtt < cands[j].rtt
+	})
+	out := make([]string, len(cands))
+	for i, c := range cands {
+		out[i] = c.addr
+	}
+	return out
+}
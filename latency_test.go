@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveReplicationTimeout(t *testing.T) {
+	oldFactor, oldMin := peerTimeoutFactor, peerTimeoutMin
+	defer func() { peerTimeoutFactor, peerTimeoutMin = oldFactor, oldMin }()
+	peerTimeoutFactor, peerTimeoutMin = 3, 50*time.Millisecond
+
+	// near answers in 1ms, far in 30-100ms
+	for i := range 100 {
+		notePing("near:1", time.Millisecond, true)
+		notePing("far:1", time.Duration(30+i%71)*time.Millisecond, true)
+	}
+	if p := rttPercentile("far:1", 0.99); p != 100*time.Millisecond {
+		t.Fatalf("far p99 = %v, want 100ms", p)
+	}
+	if d := replicationTimeout("near:1"); d != peerTimeoutMin {
+		t.Fatalf("near timeout = %v, want the %v floor", d, peerTimeoutMin)
+	}
+	if d := replicationTimeout("far:1"); d != 300*time.Millisecond {
+		t.Fatalf("far timeout = %v, want 3 x 100ms", d)
+	}
+
+	mem := newMemNet()
+	old := peerNet
+	peerNet = mem.transport()
+	defer func() { peerNet = old }()
+	hang := make(chan struct{})
+	defer close(hang)
+	mem.attach("near:1", http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-hang }))
+	mem.attach("far:1", http.HandlerFunc(func(http.ResponseWriter, *http.Request) { time.Sleep(150 * time.Millisecond) }))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// a hung peer costs one short attempt, not the write's whole budget
+	before, start := peerTimeouts.Load(), time.Now()
+	if replicateWithin(ctx, "near:1", "k", Entry{Value: "v", Timestamp: 1}) {
+		t.Fatal("replicated to a hung peer")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("giving up on the hung peer took %v", took)
+	}
+	if peerTimeouts.Load() != before+1 {
+		t.Fatal("timeout not counted")
+	}
+
+	// a slow peer is given the time its RTT says it needs
+	if !replicateWithin(ctx, "far:1", "k", Entry{Value: "v", Timestamp: 2}) {
+		t.Fatal("slow-but-alive peer timed out")
+	}
+}
+
+func TestPingTimesOutOnHungPeer(t *testing.T) {
+	old := heartbeatEvery
+	defer func() { heartbeatEvery = old }()
+	heartbeatEvery = 20 * time.Millisecond
+
+	// accepts the connection but never answers, like a paused process
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hung.Close()
+	defer close(release)
+	peer := strings.TrimPrefix(hung.URL, "http://")
+
+	done := make(chan struct{})
+	go func() {
+		ping(peer)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ping to a hung peer never returned")
+	}
+	ps := statusFor(peer)
+	ps.Lock()
+	missed, reachable := ps.missedPings, ps.reachable
+	ps.Unlock()
+	if missed != 1 || reachable {
+		t.Fatalf("after a timed-out ping: missed %d, reachable %v", missed, reachable)
+	}
+}
+
+func TestNearestPeersOrder(t *testing.T) {
+	peers := []string{"order-slow:1", "order-new:1", "order-down-far:1", "order-fast:1", "order-down-near:1"}
+	withCluster(t, func(c *membership) { c.peers = peers })
+	notePing("order-slow:1", 20*time.Millisecond, true)
+	notePing("order-fast:1", 5*time.Millisecond, true)
+	notePing("order-down-far:1", 9*time.Millisecond, true)
+	notePing("order-down-far:1", 0, false)
+	notePing("order-down-near:1", 2*time.Millisecond, true)
+	notePing("order-down-near:1", 0, false)
+
+	// reachable by RTT, then never answered, then unreachable by RTT
+	want := []string{"order-fast:1", "order-slow:1", "order-new:1", "order-down-near:1", "order-down-far:1"}
+	if got := nearestPeers(); !slices.Equal(got, want) {
+		t.Fatalf("nearestPeers = %v, want %v", got, want)
+	}
+}
+
+func TestReadKeyFallsBackToNextReplica(t *testing.T) {
+	mem := newMemNet()
+	old := peerNet
+	peerNet = mem.transport()
+	defer func() { peerNet = old }()
+
+	broken := http.NewServeMux()
+	broken.HandleFunc("/getReplica", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "disk on fire", http.StatusInternalServerError)
+	})
+	mem.attach("fallback-near:1", broken)
+	var farAsked bool
+	far := http.NewServeMux()
+	far.HandleFunc("/getReplica", func(w http.ResponseWriter, r *http.Request) {
+		farAsked = true
+		json.NewEncoder(w).Encode(Entry{Value: "new", Timestamp: 2})
+	})
+	mem.attach("fallback-far:1", far)
+	notePing("fallback-near:1", time.Millisecond, true)
+	notePing("fallback-far:1", 50*time.Millisecond, true)
+
+	withCluster(t, func(c *membership) { c.peers, c.N, c.R = []string{"fallback-far:1", "fallback-near:1"}, 3, 2 })
+	svc.Lock()
+	svc.data["fallback"] = Entry{Value: "old", Timestamp: 1}
+	svc.Unlock()
+	defer func() {
+		svc.Lock()
+		delete(svc.data, "fallback")
+		svc.Unlock()
+	}()
+
+	// the nearest replica fails, so the second copy comes from the far one
+	e, ok := readKey(nil, "fallback", 2)
+	if !ok || e.Value != "new" || !farAsked {
+		t.Fatalf("readKey = %+v, %v (far asked: %v), want the far replica's newer copy", e, ok, farAsked)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// In a leaderless cluster any node coordinates a write Dynamo-style: it
+// applies the write locally, sends it to every peer at once and answers
+// once W replicas, itself included, have it. Peers that answer later
+// still get the write, and /write_status follows them. A cluster runs
+// leaderless with -LEADERLESS, for any W, or when W=N and no node is
+// -LEADER, as before.
+
+// leaderlessMode is -LEADERLESS.
+var leaderlessMode bool
+
+// leaderlessCluster reports whether every node may coordinate writes.
+func leaderlessCluster() bool {
+	c := cluster()
+	return leaderlessMode || c.W == c.N
+}
+
+type peerAck struct {
+	peer  string
+	start time.Time
+	ok    bool
+}
+
+// leaderlessWrite replicates e, already applied here, to targets and
+// answers once wq replicas have it or the write budget runs out.
+func leaderlessWrite(w http.ResponseWriter, tr *reqTrace, ws *writeStatus, targets []string, key string, e Entry, wq, done int) {
+	wq = replicaQuorum(wq)
+	tr.setQuorum(wq)
+	ctx, cancel := writeBudget()
+	defer cancel()
+	targets = skipDown(tr, ws, targets)
+	acks := make(chan peerAck, len(targets))
+	for _, p := range targets {
+		go func(p string) {
+			start := time.Now()
+			nodeClock.Sleep(LeaderDelayPerFollower)
+			ok := replicateOrHint(context.Background(), tr, p, key, e)
+			ws.replicated(p, ok)
+			acks <- peerAck{p, start, ok}
+		}(p)
+	}
+	got := selfAcks(key)
+	for answered := 0; got < wq && answered < len(targets); answered++ {
+		select {
+		case a := <-acks:
+			tr.peerAck(a.peer, a.start, a.ok)
+			if a.ok {
+				got++
+			}
+		case <-ctx.Done():
+			quorumFailed(w, ctx, ws, e, got, wq)
+			return
+		}
+	}
+	if got < wq {
+		quorumFailed(w, ctx, ws, e, got, wq)
+		return
+	}
+	w.WriteHeader(done)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLeaderlessQuorumWriteFromAnyNode(t *testing.T) {
+	ports := []int{9134, 9135, 9136}
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	for i, p := range ports[:2] {
+		// the third node never starts: W=2 of N=3 does not need it
+		others := []string{addr(ports[1-i]), addr(ports[2])}
+		n := startNode(t, p, others, false, 3, 2, 2, "-LEADERLESS")
+		defer n.Process.Kill()
+	}
+	waitReady(t, ports[:2]...)
+
+	start := time.Now()
+	resp, err := http.Post(fmt.Sprintf("http://%s/set?key=k&value=v", addr(ports[1])), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	took := time.Since(start)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("leaderless W=2 write through a non-leader = %d", resp.StatusCode)
+	}
+	// both peers are sent the write at once, so one delay, not two
+	if took < LeaderDelayPerFollower || took > 2*LeaderDelayPerFollower {
+		t.Fatalf("write took %s", took)
+	}
+	if e, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=k", addr(ports[0]))); code != http.StatusOK || e.Value != "v" {
+		t.Fatalf("other replica has %+v (%d)", e, code)
+	}
+
+	// with W=3 the missing node fails the write within the budget
+	resp, err = http.Post(fmt.Sprintf("http://%s/config?W=3", addr(ports[0])), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Post(fmt.Sprintf("http://%s/set?key=k&value=w", addr(ports[0])), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || resp.Header.Get(acksHeader) != "2" {
+		t.Fatalf("W=3 with a node down = %d, %s acks", resp.StatusCode, resp.Header.Get(acksHeader))
+	}
+	// the body says which replicas kept the partial write
+	var partial partialWrite
+	if err := json.NewDecoder(resp.Body).Decode(&partial); err != nil {
+		t.Fatal(err)
+	}
+	accepted := []string{addr(ports[0]), addr(ports[1])}
+	slices.Sort(accepted)
+	if !slices.Equal(partial.Accepted, accepted) || !slices.Equal(partial.Failed, []string{addr(ports[2])}) || partial.Required != 3 || partial.Timestamp == 0 {
+		t.Fatalf("partial write %+v", partial)
+	}
+	if e, _ := getEntry(t, fmt.Sprintf("http://%s/local_read?key=k", addr(ports[1]))); e.Timestamp != partial.Timestamp {
+		t.Fatalf("replica has timestamp %d, partial write reported %d", e.Timestamp, partial.Timestamp)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	self string // address peers use to reach this node
+
+	leaderMu   sync.RWMutex
+	leaderAddr string // current leader as far as this node knows
+
+	// writeGate is held shared by leader writes and exclusively by a
+	// leadership transfer, so a handover waits for in-flight writes.
+	writeGate    sync.RWMutex
+	transferring atomic.Bool
+	// asyncRepl counts W=1 replications still on their way to followers.
+	asyncRepl sync.WaitGroup
+)
+
+func currentLeader() string {
+	leaderMu.RLock()
+	defer leaderMu.RUnlock()
+	return leaderAddr
+}
+
+func setLeader(addr string) {
+	leaderMu.Lock()
+	leaderAddr = addr
+	leaderMu.Unlock()
+}
+
+// forgetSelfAsLeader clears the leader if it is this node, which has just
+// stepped down, so it stops naming itself until it learns the new one.
+func forgetSelfAsLeader() {
+	leaderMu.Lock()
+	if leaderAddr == self {
+		leaderAddr = ""
+	}
+	leaderMu.Unlock()
+}
+
+// checkReplicationSource admits a /replicate only from the leader this
+// node follows. A follower that knows no leader yet, or sees a newer
+// epoch than it had, takes the sender as its leader; the leader itself
+// takes replication from no one. Leaderless clusters (W=N) let every node
+// coordinate, and peers older than protocolSource do not say who they are:
+// a /replicate naming no one is taken while the leader, or with no leader
+// known yet any peer, spoke an older version at handshake. The request's
+// own version header cannot vouch for it, as any sender can set it.
+func checkReplicationSource(r *http.Request, newerEpoch bool) error {
+	from := r.URL.Query().Get("from")
+	if leaderlessCluster() {
+		return nil
+	}
+	if from == "" && legacySourcePeer() {
+		return nil
+	}
+	switch leader := currentLeader(); {
+	case from == "":
+		return fmt.Errorf("replication must name its coordinator (from)")
+	case isLeader.Load():
+		return fmt.Errorf("this node is the leader; %s cannot replicate to it", from)
+	case leader == "" || newerEpoch:
+		setLeader(from)
+	case from != leader:
+		return fmt.Errorf("%s is not the leader (%s is)", from, leader)
+	}
+	return nil
+}
+
+// legacySourcePeer reports whether a peer that could be replicating to
+// this node was found at handshake to predate protocolSource.
+func legacySourcePeer() bool {
+	if leader := currentLeader(); leader != "" {
+		return peerProtocolFor(leader) < protocolSource
+	}
+	for _, p := range cluster().peers {
+		if peerProtocolFor(p) < protocolSource {
+			return true
+		}
+	}
+	return false
+}
+
+// beginLeaderWrite admits a write on the leader unless a transfer is under
+// way; callers must endLeaderWrite when it returns true.
+func beginLeaderWrite() bool {
+	if transferring.Load() {
+		return false
+	}
+	writeGate.RLock()
+	if transferring.Load() || !isLeader.Load() {
+		writeGate.RUnlock()
+		return false
+	}
+	return true
+}
+
+func endLeaderWrite() { writeGate.RUnlock() }
+
+// notLeaderHeader marks a write refused because this node does not lead,
+// as opposed to one refused for what it asked, so a client knows to try
+// another node instead of giving up.
+const notLeaderHeader = "X-Not-Leader"
+
+// rejectNonLeaderWrite refuses a write, pointing the client at the leader
+// when one is known.
+func rejectNonLeaderWrite(w http.ResponseWriter) {
+	w.Header().Set(notLeaderHeader, "true")
+	if l := currentLeader(); l != "" && l != self {
+		w.Header().Set("X-Leader", clientAddrOf(l))
+	}
+	if transferring.Load() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "leadership transfer in progress", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "writes only allowed on leader", http.StatusBadRequest)
+}
+
+// transferLeadershipHandler hands the leader role to ?to=host:port: writes
+// are paused, the target is brought up to date, and it takes over under a
+// new epoch.
+func transferLeadershipHandler(w http.ResponseWriter, r *http.Request) {
+	to := r.URL.Query().Get("to")
+	if !isPeer(to) {
+		http.Error(w, "to must be one of this node's peers", http.StatusBadRequest)
+		return
+	}
+	if !isLeader.Load() || !transferring.CompareAndSwap(false, true) {
+		http.Error(w, "not the leader or transfer already in progress", http.StatusConflict)
+		return
+	}
+	writeGate.Lock()
+	defer func() {
+		transferring.Store(false)
+		writeGate.Unlock()
+	}()
+	asyncRepl.Wait()
+
+	if err := catchUp(to); err != nil {
+		http.Error(w, "catching up target: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	epoch := currentEpoch.Load() + 1
+	url := fmt.Sprintf("http://%s/admin/accept_leadership?epoch=%d", to, epoch)
+	if err := postOK(url, "", nil); err != nil {
+		http.Error(w, "handing over: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	observeEpoch(epoch) // steps this node down
+	setLeader(to)
+
+	log.Printf("transferred leadership to %s at epoch %d", to, epoch)
+	fmt.Fprintf(w, "leadership transferred to %s at epoch %d\n", to, epoch)
+}
+
+// catchUp ships this node's full store to peer.
+func catchUp(peer string) error {
+	bs, _ := json.Marshal(svc.snapshot())
+	return postBatch(peer, fmt.Sprintf("/catchup?epoch=%d", currentEpoch.Load()), bs)
+}
+
+// catchupHandler merges a bulk set of entries, the newest winning.
+func catchupHandler(w http.ResponseWriter, r *http.Request) {
+	epoch, err := parseEpoch(r)
+	if err != nil {
+		http.Error(w, "invalid epoch", http.StatusBadRequest)
+		return
+	}
+	if !observeEpoch(epoch) {
+		rejectStaleEpoch(w)
+		return
+	}
+	var entries map[string]Entry
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		http.Error(w, "invalid catchup body", http.StatusBadRequest)
+		return
+	}
+	for k, in := range entries {
+		if err := checkReceived(k, in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	svc.Lock()
+	for k, in := range entries {
+		cur, ok := svc.intactCopy(k)
+		if merged, changed := mergeEntry(k, cur, ok, in); changed {
+			svc.put(k, merged)
+		}
+	}
+	svc.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// acceptLeadershipHandler makes this node leader under ?epoch= and tells
+// the rest of the cluster.
+func acceptLeadershipHandler(w http.ResponseWriter, r *http.Request) {
+	epoch, err := parseEpoch(r)
+	if err != nil || epoch <= currentEpoch.Load() {
+		http.Error(w, "epoch must be newer than the current one", http.StatusBadRequest)
+		return
+	}
+	if !observeEpoch(epoch) {
+		rejectStaleEpoch(w)
+		return
+	}
+	isLeader.Store(true)
+	setLeader(self)
+	for _, p := range cluster().peers {
+		go announceLeader(p, epoch)
+	}
+	log.Printf("accepted leadership at epoch %d", epoch)
+	w.WriteHeader(http.StatusOK)
+}
+
+func announceLeader(peer string, epoch int64) {
+	url := fmt.Sprintf("http://%s/leader?addr=%s&epoch=%d", peer, self, epoch)
+	if err := postOK(url, "", nil); err != nil {
+		log.Printf("announcing leadership to %s: %v", peer, err)
+	}
+}
+
+// leaderHandler reports the known leader on GET and records an
+// announcement from a new leader on POST.
+func leaderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		epoch, err := parseEpoch(r)
+		addr := r.URL.Query().Get("addr")
+		if err != nil || addr == "" {
+			http.Error(w, "invalid leader announcement", http.StatusBadRequest)
+			return
+		}
+		if !observeEpoch(epoch) {
+			rejectStaleEpoch(w)
+			return
+		}
+		setLeader(addr)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	bs, _ := json.Marshal(map[string]any{
+		"leader":    currentLeader(),
+		"epoch":     currentEpoch.Load(),
+		"self":      self,
+		"is_leader": isLeader.Load(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
+
+func isPeer(addr string) bool {
+	for _, p := range cluster().peers {
+		if p == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// postOK POSTs to url and turns anything but 200 into an error.
+func postOK(url, contentType string, body io.Reader) error {
+	resp, err := http.Post(url, contentType, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		observeRejection(resp)
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return nil
+}
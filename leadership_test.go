@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLeadership_TransferHandsOverWithNewEpoch(t *testing.T) {
+	lPort, f1Port, f2Port := 9041, 9042, 9043
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+
+	// N=3 W=2 so followers never coordinate writes on their own
+	leader := startNode(t, lPort, []string{addr(f1Port), addr(f2Port)}, true, 3, 1, 2)
+	f1 := startNode(t, f1Port, []string{addr(lPort), addr(f2Port)}, false, 3, 1, 2)
+	f2 := startNode(t, f2Port, []string{addr(lPort), addr(f1Port)}, false, 3, 1, 2)
+	defer leader.Process.Kill()
+	defer f1.Process.Kill()
+	defer f2.Process.Kill()
+	waitReady(t, lPort, f1Port, f2Port)
+
+	post := func(url string) *http.Response {
+		resp, err := http.Post(url, "", nil)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", url, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := post(fmt.Sprintf("http://%s/set?key=before&value=1", addr(lPort))); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("write before transfer: expected 201, got %d", resp.StatusCode)
+	}
+	if resp := post(fmt.Sprintf("http://%s/admin/transfer_leadership?to=%s", addr(lPort), addr(f1Port))); resp.StatusCode != http.StatusOK {
+		t.Fatalf("transfer: expected 200, got %d", resp.StatusCode)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/leader", addr(f1Port)))
+	if err != nil {
+		t.Fatalf("GET /leader failed: %v", err)
+	}
+	var info struct {
+		Epoch    int64 `json:"epoch"`
+		IsLeader bool  `json:"is_leader"`
+	}
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if !info.IsLeader || info.Epoch != 2 {
+		t.Fatalf("expected f1 to lead at epoch 2, got %+v", info)
+	}
+
+	// the old leader now refuses writes and points at the new one
+	resp = post(fmt.Sprintf("http://%s/set?key=after&value=2", addr(lPort)))
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("X-Leader") != addr(f1Port) {
+		t.Errorf("old leader: expected 400 with X-Leader %s, got %d %q",
+			addr(f1Port), resp.StatusCode, resp.Header.Get("X-Leader"))
+	}
+	if resp := post(fmt.Sprintf("http://%s/set?key=after&value=2", addr(f1Port))); resp.StatusCode != http.StatusCreated {
+		t.Errorf("new leader: expected 201, got %d", resp.StatusCode)
+	}
+
+	// the new leader was caught up with the write accepted before the transfer
+	e, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=before", addr(f1Port)))
+	if code != http.StatusOK || e.Value != "1" {
+		t.Errorf("new leader missing pre-transfer write: %+v (code %d)", e, code)
+	}
+}
+
+func TestLeadership_FollowerRefusesReplicationFromNonLeader(t *testing.T) {
+	lPort, fPort, rogue := 9132, 9133, "localhost:9999"
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	leader := startNode(t, lPort, []string{addr(fPort)}, true, 2, 1, 1)
+	f := startNode(t, fPort, []string{addr(lPort)}, false, 2, 1, 1)
+	defer leader.Process.Kill()
+	defer f.Process.Kill()
+	time.Sleep(300 * time.Millisecond) // f learns the leader from heartbeats
+
+	replicate := func(port int, from, protocol string, epoch int) int {
+		url := fmt.Sprintf("http://localhost:%d/replicate?key=k&value=v&timestamp=%d&epoch=%d", port, time.Now().UnixNano(), epoch)
+		if from != "" {
+			url += "&from=" + from
+		}
+		req, _ := http.NewRequest(http.MethodPost, url, nil)
+		if protocol != "" {
+			req.Header.Set(protocolHeader, protocol)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, c := range []struct {
+		name           string
+		port           int
+		from, protocol string
+		epoch, want    int
+	}{
+		{"from the leader", fPort, addr(lPort), "", 1, http.StatusOK},
+		{"from another node", fPort, rogue, "", 1, http.StatusForbidden},
+		{"naming no one", fPort, "", "", 1, http.StatusForbidden},
+		{"claiming a protocol too old to say", fPort, "", "2", 1, http.StatusForbidden},
+		{"to the leader", lPort, addr(fPort), "", 1, http.StatusForbidden},
+		{"from a leader of a newer epoch", fPort, rogue, "", 2, http.StatusOK},
+		{"from the old leader after it", fPort, addr(lPort), "", 2, http.StatusForbidden},
+	} {
+		if got := replicate(c.port, c.from, c.protocol, c.epoch); got != c.want {
+			t.Errorf("replication %s = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReplicationSourceFromLegacyLeader(t *testing.T) {
+	old, cur := "localhost:9901", "localhost:9902"
+	withCluster(t, func(c *membership) { c.peers, c.N, c.R, c.W = []string{old, cur}, 3, 1, 2 })
+	wasLeader, leader := isLeader.Load(), currentLeader()
+	defer func() {
+		isLeader.Store(wasLeader)
+		setLeader(leader)
+		peerProtocols.Delete(old)
+	}()
+	isLeader.Store(false)
+	peerProtocols.Store(old, protocolChecksums)
+
+	req := httptest.NewRequest(http.MethodPost, "/replicate?key=k", nil)
+	for _, c := range []struct {
+		leader string
+		ok     bool
+	}{
+		{"", true},   // a peer spoke an older version at handshake
+		{old, true},  // the leader did
+		{cur, false}, // the leader names itself, so one naming no one is not it
+	} {
+		setLeader(c.leader)
+		if err := checkReplicationSource(req, false); (err == nil) != c.ok {
+			t.Errorf("leader %q: checkReplicationSource = %v", c.leader, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// A node goes through explicit lifecycle states, shown on /status:
+//
+//	starting    flags read, listeners coming up
+//	recovering  replaying the WAL
+//	syncing     one anti-entropy pass with each peer that answers, for at
+//	            most -STARTUP_SYNC, so a restarted node does not serve what
+//	            it missed while down
+//	ready       serving clients
+//	draining    after SIGINT or SIGTERM: in-flight client requests and W=1
+//	            replication get up to -DRAIN_TIMEOUT to finish, then the WAL
+//	            is fsynced and the node exits
+//
+// Client requests get 503 with Retry-After and X-KV-State in every state
+// but ready. Peer requests (see peerRequest) are served from syncing on,
+// so peers can sync with a node that is itself syncing and a draining
+// node still takes replication. /status and /metrics answer in every
+// state; /status is 200 only when ready, so it doubles as a readiness
+// probe. The RESP, memcached and etcd front-ends accept connections early
+// and serve them once ready.
+
+const (
+	stateStarting   = "starting"
+	stateRecovering = "recovering"
+	stateSyncing    = "syncing"
+	stateReady      = "ready"
+	stateDraining   = "draining"
+
+	stateHeader = "X-KV-State"
+)
+
+type stateChange struct {
+	State string    `json:"state"`
+	At    time.Time `json:"at"`
+}
+
+var (
+	startupSync  = 10 * time.Second // -STARTUP_SYNC (0 = skip syncing)
+	drainTimeout = 5 * time.Second  // -DRAIN_TIMEOUT
+
+	lifecycle = struct {
+		sync.Mutex
+		history []stateChange // oldest first; the last is the current state
+		ready   chan struct{} // closed on reaching ready
+	}{
+		history: []stateChange{{stateStarting, time.Now().UTC()}},
+		ready:   make(chan struct{}),
+	}
+
+	clientInFlight atomic.Int64 // client requests admitted and not yet answered
+)
+
+func currentState() string {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+	return lifecycle.history[len(lifecycle.history)-1].State
+}
+
+func setState(s string) {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+	lifecycle.history = append(lifecycle.history, stateChange{s, time.Now().UTC()})
+	if s == stateReady {
+		close(lifecycle.ready)
+	}
+	log.Printf("lifecycle: %s", s)
+}
+
+// awaitReady blocks until the node is ready, for the front-ends that do
+// not go through gated.
+func awaitReady() { <-lifecycle.ready }
+
+// gated holds client traffic back until the node is ready, and again once
+// it drains.
+func gated(h http.Handler) http.Handler { return gate(h, peerRequest) }
+
+// peerGated is gated for the -PEER_PORT listener, where every request is a
+// peer's.
+func peerGated(h http.Handler) http.Handler {
+	return gate(h, func(*http.Request) bool { return true })
+}
+
+// peerRequest tells a peer's request on the client port from a client's.
+// With -PEER_PORT set none is a peer's. On a shared port the request must
+// carry the node ID one of the peers answered this node with, and this
+// node's cluster UUID; the protocol header alone is no sign, as any client
+// can send it.
+func peerRequest(r *http.Request) bool {
+	// a request proxied by -ROUTING comes from a peer but is a client's
+	if peerPortSeparate() || r.Header.Get(routedHeader) != "" {
+		return false
+	}
+	return knownPeer(r.Header)
+}
+
+func gate(h http.Handler, fromPeer func(*http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/status" || r.URL.Path == "/metrics" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		st := currentState()
+		peer := fromPeer(r)
+		if st != stateReady && !(peer && (st == stateSyncing || st == stateDraining)) {
+			w.Header().Set(stateHeader, st)
+			w.Header().Set("Retry-After", "1")
+			httpError(w, http.StatusServiceUnavailable, "", "node is "+st)
+			return
+		}
+		if !peer {
+			clientInFlight.Add(1)
+			defer clientInFlight.Add(-1)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+type nodeStatus struct {
+	State   string        `json:"state"`
+	Since   time.Time     `json:"since"`
+	Ready   bool          `json:"ready"`
+	History []stateChange `json:"history"`
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	lifecycle.Lock()
+	cur := lifecycle.history[len(lifecycle.history)-1]
+	st := nodeStatus{State: cur.State, Since: cur.At, Ready: cur.State == stateReady, History: append([]stateChange(nil), lifecycle.history...)}
+	lifecycle.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(stateHeader, st.State)
+	if !st.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(st)
+}
+
+// syncWithPeers runs the syncing step: one anti-entropy pass with every
+// peer at once, giving up on those still going after -STARTUP_SYNC. Peers
+// that are down or still starting fail fast and are skipped; they sync
+// with this node when they come up.
+func syncWithPeers() {
+	if startupSync <= 0 || len(cluster().peers) == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, p := range cluster().peers {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			if n, err := merkleSync(p, merkleRange{}, defaultMerkleDepth); err != nil {
+				log.Printf("startup sync with %s: %v", p, err)
+			} else if n > 0 {
+				log.Printf("startup sync with %s: exchanged %d keys", p, n)
+			}
+		}(p)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(startupSync):
+		log.Printf("startup sync still running after %v; serving anyway", startupSync)
+	}
+}
+
+// drainOnSignal drains and exits on SIGINT or SIGTERM; a second signal
+// exits at once.
+func drainOnSignal() {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	setState(stateDraining)
+	go func() {
+		<-sig
+		os.Exit(1)
+	}()
+
+	deadline := time.Now().Add(drainTimeout)
+	for clientInFlight.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	replicated := make(chan struct{})
+	go func() {
+		asyncRepl.Wait()
+		close(replicated)
+	}()
+	select {
+	case <-replicated:
+	case <-time.After(time.Until(deadline)):
+		log.Printf("draining: gave up after %v with requests or replication still in flight", drainTimeout)
+	}
+	if wal != nil {
+		if err := wal.sync(); err != nil {
+			log.Printf("draining: WAL fsync: %v", err)
+		}
+	}
+	log.Printf("drained; exiting")
+	os.Exit(0)
+}
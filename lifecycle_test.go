@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLifecycleStatesAndDrain(t *testing.T) {
+	port := 9153
+	node := startNode(t, port, nil, true, 1, 1, 1, "-WAL", filepath.Join(t.TempDir(), "kv.wal"))
+	defer node.Process.Kill()
+	waitReady(t, port)
+
+	var st nodeStatus
+	if err := getJSON(fmt.Sprintf("http://localhost:%d/status", port), &st); err != nil {
+		t.Fatal(err)
+	}
+	var states []string
+	for _, c := range st.History {
+		states = append(states, c.State)
+	}
+	if want := fmt.Sprint([]string{stateStarting, stateRecovering, stateSyncing, stateReady}); fmt.Sprint(states) != want || !st.Ready {
+		t.Fatalf("states %v (ready %v), want %s", states, st.Ready, want)
+	}
+
+	node.Process.Signal(syscall.SIGTERM)
+	exited := make(chan error, 1)
+	go func() { exited <- node.Wait() }()
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatalf("drained node exited with %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("node did not exit after SIGTERM")
+	}
+	if _, err := http.Get(fmt.Sprintf("http://localhost:%d/status", port)); err == nil {
+		t.Fatal("still serving after draining")
+	}
+}
+
+// while syncing, only a request from a known peer gets through
+func TestGatedTellsPeersByIdentity(t *testing.T) {
+	lifecycle.Lock()
+	oldHistory := lifecycle.history
+	lifecycle.history = []stateChange{{stateSyncing, time.Now()}}
+	lifecycle.Unlock()
+	ident.Lock()
+	oldIdent, oldIDs := ident.nodeIdentity, ident.peerIDs
+	ident.nodeIdentity, ident.peerIDs = nodeIdentity{NodeID: "me", Cluster: "c1"}, map[string]string{"kv2:8000": "peer2"}
+	ident.Unlock()
+	defer func() {
+		lifecycle.Lock()
+		lifecycle.history = oldHistory
+		lifecycle.Unlock()
+		ident.Lock()
+		ident.nodeIdentity, ident.peerIDs = oldIdent, oldIDs
+		ident.Unlock()
+	}()
+	withCluster(t, func(c *membership) { c.peers = []string{"kv2:8000"} })
+
+	h := gated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	cases := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"client", nil, http.StatusServiceUnavailable},
+		{"protocol header only", map[string]string{protocolHeader: "3"}, http.StatusServiceUnavailable},
+		{"unknown node", map[string]string{protocolHeader: "3", nodeIDHeader: "stranger", clusterHeader: "c1"}, http.StatusServiceUnavailable},
+		{"other cluster", map[string]string{protocolHeader: "3", nodeIDHeader: "peer2", clusterHeader: "c2"}, http.StatusServiceUnavailable},
+		{"known peer", map[string]string{protocolHeader: "3", nodeIDHeader: "peer2", clusterHeader: "c1"}, http.StatusOK},
+		{"routed by a peer", map[string]string{protocolHeader: "3", nodeIDHeader: "peer2", clusterHeader: "c1", routedHeader: "1"}, http.StatusServiceUnavailable},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/get?key=k", nil)
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, rec.Code, c.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// listenFlag collects repeated -LISTEN flags.
+type listenFlag []string
+
+func (l *listenFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listenFlag) Set(v string) error {
+	if _, _, err := parseListenAddr(v); err != nil {
+		return err
+	}
+	*l = append(*l, v)
+	return nil
+}
+
+// parseListenAddr splits a -LISTEN value into a network and address:
+// unix:///path/to.sock, tcp://host:port, or a bare host:port.
+func parseListenAddr(spec string) (network, addr string, err error) {
+	switch {
+	case strings.HasPrefix(spec, "unix://"):
+		network, addr = "unix", strings.TrimPrefix(spec, "unix://")
+	case strings.HasPrefix(spec, "tcp://"):
+		network, addr = "tcp", strings.TrimPrefix(spec, "tcp://")
+	case strings.Contains(spec, "://"):
+		return "", "", fmt.Errorf("unsupported listener %q (want unix:// or tcp://)", spec)
+	default:
+		network, addr = "tcp", spec
+	}
+	if addr == "" {
+		return "", "", fmt.Errorf("listener %q has no address", spec)
+	}
+	return network, addr, nil
+}
+
+// listen opens a -LISTEN address. A socket file left behind by a node
+// that did not shut down cleanly is removed first.
+func listen(spec string) (net.Listener, error) {
+	network, addr, err := parseListenAddr(spec)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(addr)
+		}
+	}
+	return net.Listen(network, addr)
+}
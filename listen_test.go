@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtraListeners(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "kv.sock")
+	node := startNode(t, 9096, nil, true, 1, 1, 1,
+		"-LISTEN", "unix://"+sock, "-LISTEN", "127.0.0.1:9097")
+	defer node.Process.Kill()
+	waitReady(t, 9096)
+
+	unix := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := unix.Post("http://kv/set?key=sock&value=1", "", nil)
+	if err != nil {
+		t.Fatalf("set over unix socket: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("set over unix socket = %d", resp.StatusCode)
+	}
+
+	// the write is visible on the main port and the extra TCP listener
+	for _, url := range []string{"http://localhost:9096/get?key=sock", "http://127.0.0.1:9097/get?key=sock"} {
+		if e, code := getEntry(t, url); code != http.StatusOK || e.Value != "1" {
+			t.Fatalf("%s = %+v (%d)", url, e, code)
+		}
+	}
+}
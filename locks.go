@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// /lock?name=x&ttl=10s takes a lease on x: the lock is the key lock/x,
+// holding its owner's token with a ?ttl= deadline, written through the
+// leader like any other write. The leader checks the key's current value,
+// so two clients racing for a free lock cannot both get it. Where every
+// node coordinates (-LEADERLESS, or W=N) no single node could, and /lock
+// is refused unless there is only one node; -MODE=paxos, where the chosen
+// value decides, works without a leader. A free lock is one that
+// is missing, deleted or past its deadline, so a holder that dies loses
+// the lock once its lease runs out, with no /unlock needed. The holder
+// renews by locking again with its ?owner=, and /unlock?name=x&owner=
+// gives the lock up early. The key holds a hash of the owner's token, not
+// the token, so reading it does not let anyone else renew or release the
+// lease. Locks are plain keys: /get?key=lock/x shows whether and until
+// when the lock is held, and they replicate, expire and fail over as keys do, so a
+// lease written with W=1 can be lost if the leader dies before a follower
+// has it. Only /lock and /unlock write under lock/; the other writes
+// refuse those keys, so a lease cannot be taken or broken around them.
+
+const (
+	lockPrefix = "lock/"
+	lockTTL    = 10 * time.Second // lease without ?ttl=
+
+	lockOwnerHeader   = "X-Lock-Owner"
+	lockExpiresHeader = "X-Lock-Expires"
+)
+
+// lockNamed turns ?name= into the ?key= of the lock's entry, so the key
+// middleware validates and routes it.
+func lockNamed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("name") == "" {
+			httpError(w, http.StatusBadRequest, "name", "name required")
+			return
+		}
+		if leaderlessCluster() && !paxosMode() && cluster().N > 1 {
+			httpError(w, http.StatusBadRequest, "", "locks need a leader to coordinate them, with W below N (or -MODE=paxos)")
+			return
+		}
+		q.Set("key", lockPrefix+q.Get("name"))
+		r.URL.RawQuery = q.Encode()
+		h(w, r)
+	}
+}
+
+// unreserved refuses a write to a key under lockPrefix, which only /lock
+// and /unlock may change.
+func unreserved(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, k := range r.URL.Query()["key"] {
+			if err := checkUnreserved(k); err != nil {
+				httpError(w, http.StatusBadRequest, "key", err.Error())
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+func checkUnreserved(key string) error {
+	if strings.HasPrefix(key, lockPrefix) {
+		return fmt.Errorf("keys under %q are locks; use /lock and /unlock", lockPrefix)
+	}
+	return nil
+}
+
+// leaseWriter names the lease in the response once the write has taken
+// it, and not when the write fails after its condition passed.
+type leaseWriter struct {
+	http.ResponseWriter
+	owner, expires string
+	started        bool
+}
+
+func (l *leaseWriter) WriteHeader(code int) {
+	if !l.started && code == http.StatusCreated {
+		l.Header().Set(lockOwnerHeader, l.owner)
+		l.Header().Set(lockExpiresHeader, l.expires)
+	}
+	l.started = true
+	l.ResponseWriter.WriteHeader(code)
+}
+
+func (l *leaseWriter) Write(p []byte) (int, error) {
+	l.started = true
+	return l.ResponseWriter.Write(p)
+}
+
+func (l *leaseWriter) Unwrap() http.ResponseWriter { return l.ResponseWriter }
+
+// ownerToken is what the lock's entry holds for owner.
+func ownerToken(owner string) string {
+	sum := sha256.Sum256([]byte(owner))
+	return hex.EncodeToString(sum[:])
+}
+
+// heldBy reports whether cur, the lock's entry, is a live lease of owner.
+func heldBy(cur Entry, ok bool, owner string) bool {
+	return ok && cur.live() && cur.Value == ownerToken(owner)
+}
+
+// lockHandler takes or renews the lease on ?name= for ?owner= (a new token
+// without one), answering 201 with the owner and the lease's deadline in
+// X-Lock-Owner and X-Lock-Expires, or 412 while someone else holds it.
+func lockHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	owner := q.Get("owner")
+	if owner == "" {
+		owner = randomID()[:16]
+	}
+	expires, err := parseTTL(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "ttl", err.Error())
+		return
+	}
+	if expires == 0 {
+		expires = time.Now().Add(lockTTL).UnixNano()
+	}
+	cond := func(cur Entry, ok bool, _ *Entry) bool {
+		return !ok || !cur.live() || heldBy(cur, ok, owner)
+	}
+	lw := &leaseWriter{ResponseWriter: w, owner: owner, expires: time.Unix(0, expires).UTC().Format(time.RFC3339Nano)}
+	coordinateWrite(lw, r, q.Get("key"), Entry{Value: ownerToken(owner), Expires: expires}, cond)
+}
+
+// unlockHandler releases ?owner='s lease on ?name=, answering 200, or 412
+// if owner does not hold it (it was never taken, has expired, or is held
+// by someone else).
+func unlockHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	owner := q.Get("owner")
+	if owner == "" {
+		httpError(w, http.StatusBadRequest, "owner", "owner required")
+		return
+	}
+	cond := func(cur Entry, ok bool, _ *Entry) bool { return heldBy(cur, ok, owner) }
+	coordinateWrite(w, r, q.Get("key"), Entry{Deleted: true}, cond)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockLease(t *testing.T) {
+	wasLeader := isLeader.Load()
+	defer func() {
+		isLeader.Store(wasLeader)
+		svc.Lock()
+		delete(svc.data, lockPrefix+"job")
+		svc.Unlock()
+	}()
+	withCluster(t, func(c *membership) { c.peers, c.N, c.W = nil, 1, 1 })
+	isLeader.Store(true)
+
+	call := func(h http.HandlerFunc, query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		lockNamed(h)(rec, httptest.NewRequest(http.MethodPost, "/lock?"+query, nil))
+		return rec
+	}
+	got := call(lockHandler, "name=job&ttl=100ms")
+	owner := got.Header().Get(lockOwnerHeader)
+	if got.Code != http.StatusCreated || owner == "" || got.Header().Get(lockExpiresHeader) == "" {
+		t.Fatalf("lock = %d, %v", got.Code, got.Header())
+	}
+	if rec := call(lockHandler, "name=job&owner=other"); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("lock held by another owner = %d", rec.Code)
+	}
+	// what a reader of the key sees is not a token that releases the lease
+	e, _ := localCopy(lockPrefix + "job")
+	if e.Value == owner {
+		t.Fatal("the lock's entry holds the owner's token")
+	}
+	if rec := call(unlockHandler, "name=job&owner="+e.Value); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("unlock with the stored value = %d", rec.Code)
+	}
+	if rec := call(unlockHandler, "name=job&owner=other"); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("unlock by a non-holder = %d", rec.Code)
+	}
+	if rec := call(lockHandler, "name=job&ttl=100ms&owner="+owner); rec.Code != http.StatusCreated {
+		t.Fatalf("renew by the holder = %d", rec.Code)
+	}
+
+	// the lease runs out without an unlock
+	time.Sleep(150 * time.Millisecond)
+	if rec := call(lockHandler, "name=job&owner=other"); rec.Code != http.StatusCreated || rec.Header().Get(lockOwnerHeader) != "other" {
+		t.Fatalf("lock after the lease expired = %d", rec.Code)
+	}
+	if rec := call(unlockHandler, "name=job&owner=other"); rec.Code != http.StatusOK {
+		t.Fatalf("unlock by the holder = %d", rec.Code)
+	}
+	if rec := call(lockHandler, "name=job&owner="+owner); rec.Code != http.StatusCreated {
+		t.Fatalf("lock once released = %d", rec.Code)
+	}
+}
+
+func TestLockKeysAreReserved(t *testing.T) {
+	withCluster(t, func(c *membership) { c.peers, c.N, c.W = nil, 1, 1 })
+	for _, q := range []string{"/set?key=lock/job&value=mine", "/delete?key=lock/job", "/cas?key=lock/job&value=mine"} {
+		rec := httptest.NewRecorder()
+		unreserved(setHandler)(rec, httptest.NewRequest(http.MethodPost, q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", q, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(`{"key":"lock/job","value":"mine"}`))
+	req.Header.Set("Content-Type", "application/json")
+	setHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("set of a lock key in the body = %d, want 400", rec.Code)
+	}
+}
+
+func TestLockHeadersOnlyOnceTaken(t *testing.T) {
+	wasLeader := isLeader.Load()
+	defer func() {
+		isLeader.Store(wasLeader)
+		svc.Lock()
+		delete(svc.data, lockPrefix+"unreachable")
+		svc.Unlock()
+	}()
+	// the lock is free, but W=2 cannot be met with the only peer down
+	withCluster(t, func(c *membership) { c.peers, c.N, c.R, c.W = []string{"localhost:1"}, 2, 1, 2 })
+	isLeader.Store(true)
+
+	rec := httptest.NewRecorder()
+	lockNamed(lockHandler)(rec, httptest.NewRequest(http.MethodPost, "/lock?name=unreachable", nil))
+	if rec.Code == http.StatusCreated || rec.Header().Get(lockOwnerHeader) != "" || rec.Header().Get(lockExpiresHeader) != "" {
+		t.Fatalf("failed lock = %d, %v", rec.Code, rec.Header())
+	}
+}
+
+func TestLockNeedsASingleCoordinator(t *testing.T) {
+	wasMode := mode
+	defer func() { mode = wasMode }()
+	taken := func() bool {
+		rec := httptest.NewRecorder()
+		lockNamed(func(w http.ResponseWriter, r *http.Request) {})(rec, httptest.NewRequest(http.MethodPost, "/lock?name=job", nil))
+		return rec.Code == http.StatusOK
+	}
+
+	// W=N: every node coordinates and checks only its own copy
+	withCluster(t, func(c *membership) { c.peers, c.N, c.W = []string{"localhost:1"}, 2, 2 })
+	if taken() {
+		t.Error("lock with W=N was let through")
+	}
+	mode = modePaxos
+	if !taken() {
+		t.Error("lock under -MODE=paxos was refused")
+	}
+	mode = wasMode
+	withCluster(t, func(c *membership) { c.W = 1 })
+	if !taken() {
+		t.Error("lock with a leader and W<N was refused")
+	}
+}
@@ -1,20 +1,68 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Entry struct {
 	Value     string `json:"value"`
 	Timestamp int64  `json:"timestamp"`
+	Deleted   bool   `json:"deleted,omitempty"`  // tombstone left by /delete
+	Node      string `json:"node,omitempty"`     // node that coordinated the write
+	Type      string `json:"type,omitempty"`     // CRDT type of Value, see crdt.go
+	Seq       int64  `json:"-"`                  // coordinator's sequence number, see dedup.go
+	Sync      bool   `json:"-"`                  // fsync before acking, see wal.go
+	Clock     vclock `json:"clock,omitempty"`    // versions this one descends from, see vclock.go
+	Checksum  uint32 `json:"checksum,omitempty"` // CRC-32C of key and contents, see checksum.go
+	Owner     string `json:"owner,omitempty"`    // fingerprint of the writer's API token, see quota.go
+	// Restorable is when a soft delete's kept value expires, see softdelete.go.
+	Restorable int64 `json:"restorable,omitempty"`
+	// Expires is when a write made with ?ttl= stops being readable, see ttl.go.
+	Expires int64 `json:"expires,omitempty"`
+	// Siblings are conflicting versions kept by the "siblings" resolver.
+	Siblings []Entry `json:"siblings,omitempty"`
+	// Schema is the format version the entry was written in, see schema.go.
+	Schema int `json:"schema,omitempty"`
+	// Extra holds the fields of a newer schema this node does not know.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// newerThan orders entries by timestamp, then by coordinating node, so
+// every replica picks the same winner when two nodes stamp a write with
+// the same nanosecond. Between copies of one version, a tombstone wins:
+// that is how an expired value's tombstone replaces it.
+func (e Entry) newerThan(o Entry) bool {
+	if e.Timestamp != o.Timestamp {
+		return e.Timestamp > o.Timestamp
+	}
+	if e.Node != o.Node {
+		return e.Node > o.Node
+	}
+	return e.Deleted && !o.Deleted
+}
+
+// live reports whether e, or one of its siblings, is more than a tombstone
+// or an expired value.
+func (e Entry) live() bool {
+	now := time.Now().UnixNano()
+	for _, v := range e.versions() {
+		if !v.Deleted && !v.expired(now) {
+			return true
+		}
+	}
+	return false
 }
 
 type Store struct {
@@ -22,133 +70,725 @@ type Store struct {
 	data map[string]Entry
 }
 
+// put checksums e and stores it under key, publishing the change and
+// logging it to the WAL. The caller holds the write lock.
+func (s *Store) put(key string, e Entry) {
+	e.Checksum = e.sum(key)
+	if old, ok := s.data[key]; ok {
+		account(key, old, -1)
+		indexEntry(key, old, -1)
+	}
+	account(key, e, 1)
+	indexEntry(key, e, 1)
+	trackExpiry(key, e)
+	s.data[key] = e
+	changes.publish(key, e)
+	wal.append(key, e)
+}
+
+// snapshot copies every entry under the read lock.
+func (s *Store) snapshot() map[string]Entry {
+	s.RLock()
+	defer s.RUnlock()
+	out := make(map[string]Entry, len(s.data))
+	for k, e := range s.data {
+		out[k] = e
+	}
+	return out
+}
+
 var (
-	svc                      = Store{data: make(map[string]Entry)}
-	peers                    []string
-	isLeader                 bool
-	N, R, W                  int
-	LeaderDelayPerFollower   = 200 * time.Millisecond
-	FollowerUpdateSleep      = 100 * time.Millisecond
+	svc                       = Store{data: make(map[string]Entry)}
+	isLeader                  atomic.Bool
+	LeaderDelayPerFollower    = 200 * time.Millisecond
+	FollowerUpdateSleep       = 100 * time.Millisecond
 	FollowerSleepOnLeaderRead = 50 * time.Millisecond
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "cluster" {
+		runCluster(os.Args[2:])
+		return
+	}
+
 	port := flag.Int("PORT", 8000, "HTTP port to listen on")
 	peerStr := flag.String("PEERS", "", "comma-separated list of peer host:port")
 	leader := flag.Bool("LEADER", false, "set if this node is the leader")
-	nFlag := flag.Int("N", 1, "cluster size")
+	leaderlessFlag := flag.Bool("LEADERLESS", false, "let every node coordinate writes for any W (no -LEADER)")
+	nFlag := flag.Int("N", 0, "cluster size (0 = this node plus -PEERS)")
+	replicasFlag := flag.Int("REPLICAS", 0, "nodes holding each key, picked by the hash ring (0 = every node)")
+	routingFlag := flag.String("ROUTING", "off", "requests for keys this node does not hold (-REPLICAS): off (coordinate here), proxy or redirect")
+	keyCoordFlag := flag.Bool("KEY_COORDINATOR", false, "with -LEADERLESS, forward each write to its key's first replica so one node orders a key's writes")
+	readReplicasFlag := flag.String("READ_REPLICAS", "", "comma-separated read-only replicas (-READ_ONLY nodes) every write is also sent to, outside N and the quorums")
+	readOnlyFlag := flag.Bool("READ_ONLY", false, "serve reads and take replication, but refuse client writes")
+	rebalanceFlag := flag.Int("REBALANCE_RATE", rebalanceRate, "keys a second sent to new replicas after a membership change (0 = no cap)")
 	rFlag := flag.Int("R", 1, "read quorum")
 	wFlag := flag.Int("W", 1, "write quorum")
+	strictFlag := flag.Bool("STRICT", false, "refuse to start, and refuse /config, unless R+W>N and N counts this node and its peers")
+	epochFlag := flag.Int64("EPOCH", 1, "initial leader epoch")
+	selfFlag := flag.String("SELF", "", "host:port peers use to reach this node (default localhost:PORT)")
+	conflictFlag := flag.String("CONFLICT", "lww", "conflict resolver: lww, highest-node, siblings, max or exec:<merge hook>")
+	indexesFlag := flag.String("INDEXES", "", "JSON value fields to index for /query, as field or dotted.path,... (e.g. email,address.city)")
+	policiesFlag := flag.String("WRITE_POLICIES", "", "per-prefix write quorum and durability as prefix=W/durability,... (W a number, quorum or all; e.g. cache/=1/async,billing/=quorum/fsync)")
+	conflictPrefixFlag := flag.String("CONFLICT_PREFIXES", "", "per-namespace resolvers as prefix=resolver,... (longest prefix wins)")
+	modeFlag := flag.String("MODE", "quorum", "replication model: quorum, primary-backup, 2pc or paxos")
+	hbFlag := flag.Duration("HEARTBEAT", 100*time.Millisecond, "heartbeat/ping interval to peers")
+	foFlag := flag.Duration("FAILOVER_TIMEOUT", time.Second, "primary silence before a backup promotes itself")
+	dcFlag := flag.String("DC", "default", "datacenter this node lives in; tag peers as host:port@dc")
+	zoneFlag := flag.String("ZONE", "", "zone/rack within the datacenter; tag peers as host:port@dc/zone")
+	vnodesFlag := flag.Int("VNODES", 64, "tokens per node on the hash ring")
+	respFlag := flag.Int("RESP_PORT", 0, "serve the Redis protocol on this port (0 disables)")
+	mcFlag := flag.Int("MEMCACHED_PORT", 0, "serve the memcached text protocol on this port (0 disables)")
+	grpcFlag := flag.Int("GRPC_PORT", 0, "serve the etcd v3 gRPC API subset on this port (0 disables)")
+	certFlag := flag.String("TLS_CERT", "", "serve HTTPS (with HTTP/2) using this certificate file")
+	keyFlag := flag.String("TLS_KEY", "", "private key for -TLS_CERT")
+	peerPortFlag := flag.Int("PEER_PORT", 0, "serve replication and admin endpoints on this port instead of PORT (0 shares PORT)")
+	clientAddrFlag := flag.String("CLIENT_ADDR", "", "host:port clients use to reach this node when -PEER_PORT is set (default SELF's host with PORT)")
+	accessLogFlag := flag.Bool("ACCESS_LOG", false, "log every request with its latency and per-peer ack times")
+	idemFlag := flag.Duration("IDEMPOTENCY_TTL", idempotencyTTL, "how long a write's Idempotency-Key is remembered")
+	antiEntropyFlag := flag.Duration("ANTI_ENTROPY", 0, "how often to reconcile with every peer by Merkle tree (0 = only on /admin/anti_entropy)")
+	walFlag := flag.String("WAL", "", "append every change to this write-ahead log and replay it on startup")
+	durabilityFlag := flag.String("DURABILITY", durabilityAsync, "default write durability: async, or fsync (needs -WAL)")
+	walSyncFlag := flag.Duration("WAL_SYNC", walSyncEvery, "how often async writes are fsynced")
+	nsQuotaFlag := flag.String("NAMESPACE_QUOTAS", "", "per-prefix limits as prefix=keys/bytes,... (e.g. user/=1000/10MB)")
+	tokenQuotaFlag := flag.String("TOKEN_QUOTAS", "", "per-API-token limits as fingerprint=keys/bytes,...")
+	softFlag := flag.Duration("SOFT_DELETE_RETENTION", softDeleteRetention, "how long /delete?soft=true keeps a value for /restore")
+	identityFlag := flag.String("IDENTITY", "", "file keeping this node's ID and cluster UUID (default <WAL>.id with -WAL)")
+	clusterFlag := flag.String("CLUSTER_ID", "", "cluster UUID this node must belong to (default: join the peers' cluster)")
+	auditFlag := flag.String("AUDIT_LOG", "", "also append audit records to this file")
+	keysFlag := flag.String("ENCRYPTION_KEYS", "", "encrypt the WAL with the AES keys in this file, or printed by exec:<command>")
+	readRepairFlag := flag.Bool("READ_REPAIR", true, "push the merged result to stale replicas found by R>1 reads")
+	hookTimeoutFlag := flag.Duration("MERGE_HOOK_TIMEOUT", mergeHookTimeout, "how long an exec: merge hook may run before LWW decides")
+	writeTimeoutFlag := flag.Duration("WRITE_TIMEOUT", writeTimeout, "how long a synchronous write may wait for its acks before answering 504 (0 = unbounded)")
+	readHeaderFlag := flag.Duration("HTTP_READ_HEADER_TIMEOUT", readHeaderTimeout, "how long a client may take to send request headers")
+	httpWriteFlag := flag.Duration("HTTP_WRITE_TIMEOUT", httpWriteTimeout, "how long reading a request body and writing its response may take (0 = unbounded)")
+	idleFlag := flag.Duration("HTTP_IDLE_TIMEOUT", idleTimeout, "how long an idle keep-alive connection is kept open")
+	headerBytesFlag := flag.Int("HTTP_MAX_HEADER_BYTES", maxHeaderBytes, "largest request line plus headers accepted")
+	compressFlag := flag.Bool("PEER_COMPRESSION", true, "Snappy-compress bulk transfers to peers that support it")
+	peerTimeoutFlag := flag.Float64("PEER_TIMEOUT_FACTOR", 0, "give each replication attempt the peer's p99 heartbeat RTT times this (0 = only the write budget applies)")
+	peerTimeoutMinFlag := flag.Duration("PEER_TIMEOUT_MIN", peerTimeoutMin, "shortest adaptive replication timeout; must cover a follower's apply time")
+	rollbackFlag := flag.Bool("ROLLBACK_FAILED_WRITES", false, "tombstone a W>1 write that misses its quorum on the replicas that accepted it")
+	unreachableFlag := flag.String("UNREACHABLE_POLICY", unreachablePolicy, "what W>1 writes do about replicas that do not answer: wait, skip-fast, retry-then-hint or fail-fast")
+	readStrategyFlag := flag.String("READ_STRATEGY", readStrategy, "how R>1 reads fan out: first-r, all-wait-r or digest")
+	hedgeFlag := flag.Float64("HEDGE_READS", 0, "ask one more replica when an R>1 read has waited this quantile of recent replica fetch times, e.g. 0.95 (0 = off)")
+	batchFlag := flag.Duration("BATCH_WINDOW", 0, "how long the leader gathers writes into one replication round per follower (0 = replicate each write on its own)")
+	statsFlag := flag.Duration("STATS_INTERVAL", statsInterval, "how often the store is sampled for /stats (0 = off)")
+	ttlSweepFlag := flag.Duration("TTL_SWEEP", ttlSweep, "how often expired ?ttl= keys are turned into tombstones (0 = only hide them on read)")
+	orderFlag := flag.Duration("APPLY_ORDER_WAIT", applyOrderWait, "how long a follower holds back a replication for the one its coordinator sent before it (0 = apply on arrival)")
+	startupSyncFlag := flag.Duration("STARTUP_SYNC", startupSync, "how long a starting node may spend syncing with its peers before it serves clients (0 = skip)")
+	drainFlag := flag.Duration("DRAIN_TIMEOUT", drainTimeout, "how long SIGINT/SIGTERM waits for in-flight requests and replication before exiting")
+	tagsFlag := flag.String("TAGS", "", "free-form tags for this node, as name=value,... (e.g. rack=r7,tier=ssd), shown to peers")
+	busyFlag := flag.Int64("REPLICATE_BUSY", 0, "replications in flight past which /replicate answers busy so coordinators back off (0 = never)")
+	limitsFlag := flag.String("CONCURRENCY_LIMITS", "", "expensive requests at once as class=slots[/queue],... for quorum_read, scan and dump (e.g. scan=4/8)")
+	maxConnsFlag := flag.Int("MAX_CONNS", 0, "connections open at once across the HTTP listeners (0 = unlimited)")
+	skewFlag := flag.Duration("CLOCK_SKEW", 0, "offset the timestamps of writes this node coordinates, e.g. -2s (for LWW experiments)")
+	cdcFlag := flag.String("CDC", "", "publish the writes this node coordinates to nats://host:port/subject")
+	slowFlag := flag.Duration("SLOW_REQUEST", 0, "log requests slower than this with peer timings, quorum progress and retries (0 disables)")
+	var listens listenFlag
+	flag.Var(&listens, "LISTEN", "extra listener for the HTTP API, e.g. unix:///var/run/kv.sock or tcp://127.0.0.1:9000 (repeatable)")
+	peerH2CFlag := flag.Bool("PEER_H2C", false, "talk to peers over cleartext HTTP/2 (all peers must be h2c-capable)")
 	flag.Parse()
 
+	localDC, localZone = *dcFlag, *zoneFlag
+	if err := configureQuotas(*nsQuotaFlag, *tokenQuotaFlag); err != nil {
+		log.Fatal(err)
+	}
+	if err := configureIndexes(*indexesFlag); err != nil {
+		log.Fatal(err)
+	}
+	if err := configurePolicies(*policiesFlag); err != nil {
+		log.Fatal(err)
+	}
+	if err := configureReadStrategy(*readStrategyFlag); err != nil {
+		log.Fatal(err)
+	}
+	if err := configureUnreachablePolicy(*unreachableFlag); err != nil {
+		log.Fatal(err)
+	}
+	if err := configureResolvers(*conflictFlag, *conflictPrefixFlag); err != nil {
+		log.Fatal(err)
+	}
+	if err := configureLimits(*limitsFlag); err != nil {
+		log.Fatal(err)
+	}
+	tags, err := parseTags(*tagsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	localTags = tags
+	replicateBusyAt, compressBatches, applyOrderWait = *busyFlag, *compressFlag, *orderFlag
+	routing, err := parseRouting(*routingFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	routingMode, keyCoordinator = routing, *keyCoordFlag
+	readReplicas, readOnly = parseReadReplicas(*readReplicasFlag), *readOnlyFlag
+	accessLogOn, slowRequest = *accessLogFlag, *slowFlag
+	idempotencyTTL = *idemFlag
+	mergeHookTimeout = *hookTimeoutFlag
+	writeTimeout = *writeTimeoutFlag
+	clockSkew.Store(int64(*skewFlag))
+	readHeaderTimeout, httpWriteTimeout, idleTimeout = *readHeaderFlag, *httpWriteFlag, *idleFlag
+	maxHeaderBytes, maxConns = *headerBytesFlag, *maxConnsFlag
+	readRepairOn = *readRepairFlag
+	rollbackFailed = *rollbackFlag
+	antiEntropyEvery = *antiEntropyFlag
+	identityPath := *identityFlag
+	if identityPath == "" && *walFlag != "" {
+		identityPath = *walFlag + ".id"
+	}
+	if err := loadIdentity(identityPath, *clusterFlag); err != nil {
+		log.Fatal(err)
+	}
+	if *auditFlag != "" {
+		if err := openAuditLog(*auditFlag); err != nil {
+			log.Fatalf("opening audit log: %v", err)
+		}
+	}
+	if *keysFlag != "" {
+		if *walFlag == "" {
+			log.Fatal("-ENCRYPTION_KEYS needs -WAL")
+		}
+		k, err := loadKeyring(*keysFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		atRest = k
+	}
+	defaultDurability = *durabilityFlag
+	softDeleteRetention = *softFlag
+	ttlSweep = *ttlSweepFlag
+	statsInterval = *statsFlag
+	batchWindow = *batchFlag
+	hedgeQuantile = *hedgeFlag
+	peerTimeoutFactor, peerTimeoutMin = *peerTimeoutFlag, *peerTimeoutMinFlag
+	startupSync, drainTimeout = *startupSyncFlag, *drainFlag
 	if *peerStr != "" {
-		peers = strings.Split(*peerStr, ",")
-	}
-	isLeader = *leader
-	N, R, W = *nFlag, *rFlag, *wFlag
-
-	http.HandleFunc("/set", setHandler)
-	http.HandleFunc("/get", getHandler)
-	http.HandleFunc("/replicate", replicateHandler)
-	http.HandleFunc("/getReplica", getReplicaHandler)
-	http.HandleFunc("/config", configHandler)
-	http.HandleFunc("/local_read", localReadHandler)
-
-	addr := fmt.Sprintf(":%d", *port)
-	log.Printf("starting KV service on %s (leader=%v N=%d W=%d R=%d peers=%v)",
-		addr, isLeader, N, W, R, peers)
-	log.Fatal(http.ListenAndServe(addr, nil))
-}
+		ps, dcs, zones := parsePeers(*peerStr)
+		updateCluster(func(c *membership) { c.peers, c.peerDC, c.peerZone = ps, dcs, zones })
+	}
+	if *leaderlessFlag && (*leader || *modeFlag == modePrimaryBackup || *modeFlag == mode2PC || *modeFlag == modePaxos) {
+		log.Fatal("-LEADERLESS cannot be combined with -LEADER, -MODE=primary-backup, -MODE=2pc or -MODE=paxos")
+	}
+	leaderlessMode = *leaderlessFlag
+	isLeader.Store(*leader)
+	currentEpoch.Store(*epochFlag)
+	self = *selfFlag
+	if self == "" {
+		self = fmt.Sprintf("localhost:%d", *port)
+		if *peerPortFlag != 0 {
+			self = fmt.Sprintf("localhost:%d", *peerPortFlag)
+		}
+	}
+	clientAddr = self
+	if *peerPortFlag != 0 {
+		separatePeerPort()
+		clientAddr = *clientAddrFlag
+		if clientAddr == "" {
+			clientAddr = defaultClientAddr(*port)
+		}
+	}
+	if *leader {
+		setLeader(self)
+	}
+	mode, heartbeatEvery, failoverTimeout = *modeFlag, *hbFlag, *foFlag
+	vnodes = *vnodesFlag
+	updateCluster(func(c *membership) { c.ring = NewRing(members(), vnodes) })
+	if *peerH2CFlag {
+		usePeerH2C()
+	}
+	usePeerProtocol()
+	updateCluster(func(c *membership) {
+		c.N, c.R, c.W = *nFlag, *rFlag, *wFlag
+		if c.N == 0 {
+			c.N = len(c.peers) + 1
+		}
+	})
+	replicationFactor, rebalanceRate = *replicasFlag, *rebalanceFlag
+	strictQuorum = *strictFlag
+	checkStartupQuorum()
 
-func configHandler(w http.ResponseWriter, r *http.Request) {
-	if v := r.URL.Query().Get("N"); v != "" {
-		if i, err := strconv.Atoi(v); err == nil {
-			N = i
+	const get, post = http.MethodGet, http.MethodPost
+	api.Use(gated)
+	api.HandleFunc("/status", allow(statusHandler, get))
+	api.HandleFunc("/set", allow(audited(keyed(unreserved(routed(idempotent(annotated(setHandler)))))), post))
+	api.HandleFunc("/get", allow(keyed(routed(annotated(getHandler))), get))
+	api.HandleFunc("/mget", allow(keyed(annotated(mgetHandler)), get, post))
+	api.HandleFunc("/delete", allow(audited(keyed(unreserved(routed(idempotent(annotated(deleteHandler)))))), post))
+	api.HandleFunc("/cas", allow(audited(keyed(unreserved(routed(idempotent(annotated(casHandler)))))), post))
+	api.HandleFunc("/append", allow(audited(keyed(unreserved(routed(idempotent(annotated(appendHandler)))))), post))
+	api.HandleFunc("/getset", allow(audited(keyed(unreserved(routed(idempotent(annotated(getsetHandler)))))), post))
+	api.HandleFunc("/lock", allow(lockNamed(audited(keyed(routed(annotated(lockHandler))))), post))
+	api.HandleFunc("/unlock", allow(lockNamed(audited(keyed(routed(annotated(unlockHandler))))), post))
+	api.HandleFunc("/restore", allow(audited(keyed(unreserved(routed(idempotent(annotated(restoreHandler)))))), post))
+	api.HandleFunc("/crdt/incr", allow(audited(keyed(unreserved(routed(idempotent(annotated(crdtIncrHandler)))))), post))
+	api.HandleFunc("/crdt/add", allow(audited(keyed(unreserved(routed(idempotent(annotated(crdtAddHandler)))))), post))
+	api.HandleFunc("/crdt/remove", allow(audited(keyed(unreserved(routed(idempotent(annotated(crdtRemoveHandler)))))), post))
+	api.HandleFunc("/crdt/value", allow(keyed(routed(crdtValueHandler)), get))
+	api.HandleFunc("/scan", allow(limited(classScan, scanHandler), get))
+	api.HandleFunc("/keys", allow(limited(classScan, keysHandler), get))
+	api.HandleFunc("/query", allow(limited(classScan, queryHandler), get))
+	api.HandleFunc("/config", allow(audited(configHandler), post))
+	api.HandleFunc("/local_read", allow(keyed(localReadHandler), get))
+	api.HandleFunc("/peers", allow(peersHandler, get))
+	api.HandleFunc("/ring", allow(ringHandler, get))
+	api.HandleFunc("/cluster/status", allow(clusterStatusHandler, get))
+	api.HandleFunc("/owner", allow(keyed(ownerHandler), get))
+	api.HandleFunc("/metrics", allow(metricsHandler, get))
+	api.HandleFunc("/stats", allow(statsHandler, get))
+	api.HandleFunc("/write_status", allow(writeStatusHandler, get))
+	api.HandleFunc("/openapi.json", allow(openAPIHandler, get))
+
+	// internal endpoints, on the peer port when there is one
+	peerAPI.HandleFunc("/replicate", allow(backpressured(keyed(replicateHandler)), post))
+	peerAPI.HandleFunc("/replicate_batch", allow(backpressured(decompressed(replicateBatchHandler)), post))
+	peerAPI.HandleFunc("/getReplica", allow(keyed(getReplicaHandler), get))
+	peerAPI.HandleFunc("/index_keys", allow(indexKeysHandler, get))
+	peerAPI.HandleFunc("/ping", allow(pingHandler, get))
+	peerAPI.HandleFunc("/handshake", allow(handshakeHandler, get))
+	peerAPI.HandleFunc("/leader", allow(leaderHandler, get, post))
+	peerAPI.HandleFunc("/catchup", allow(decompressed(catchupHandler), post))
+	peerAPI.HandleFunc("/admin/transfer_leadership", allow(audited(transferLeadershipHandler), post))
+	peerAPI.HandleFunc("/admin/accept_leadership", allow(audited(acceptLeadershipHandler), post))
+	peerAPI.HandleFunc("/pb/apply", allow(keyed(pbApplyHandler), post))
+	peerAPI.HandleFunc("/2pc/prepare", allow(keyed(prepareHandler), post))
+	peerAPI.HandleFunc("/2pc/commit", allow(decisionHandler(true), post))
+	peerAPI.HandleFunc("/2pc/abort", allow(decisionHandler(false), post))
+	peerAPI.HandleFunc("/paxos/prepare", allow(keyed(paxosPrepareHandler), post))
+	peerAPI.HandleFunc("/paxos/accept", allow(keyed(paxosAcceptHandler), post))
+	peerAPI.HandleFunc("/pb/resync", allow(decompressed(pbResyncHandler), post))
+	peerAPI.HandleFunc("/pb/heartbeat", allow(pbHeartbeatHandler, post))
+	peerAPI.HandleFunc("/node", allow(nodeHandler, get))
+	peerAPI.HandleFunc("/admin/anti_entropy", allow(audited(antiEntropyHandler), post))
+	peerAPI.HandleFunc("/admin/repair", allow(audited(repairHandler), post))
+	peerAPI.HandleFunc("/admin/diff", allow(audited(diffHandler), get, post))
+	peerAPI.HandleFunc("/dump", allow(limited(classDump, dumpHandler), get))
+	peerAPI.HandleFunc("/applyDump", allow(audited(decompressed(applyDumpHandler)), post))
+	peerAPI.HandleFunc("/admin/merkle", allow(merkleHandler, get))
+	peerAPI.HandleFunc("/admin/keys/rotate", allow(audited(rotateKeysHandler), post))
+	peerAPI.HandleFunc("/admin/merkle/leaves", allow(merkleLeavesHandler, get))
+	peerAPI.HandleFunc("/admin/audit", allow(auditHandler, get))
+	peerAPI.HandleFunc("/admin/purge", allow(audited(adminPurgeHandler), post))
+	peerAPI.HandleFunc("/purge", allow(purgeHandler, post))
+	peerAPI.HandleFunc("/admin/clock_skew", allow(audited(clockSkewHandler), get, post))
+	peerAPI.HandleFunc("/admin/experiment", allow(audited(experimentHandler), post))
+	peerAPI.HandleFunc("/admin/webhooks", allow(audited(adminWebhooksHandler), get, post))
+	peerAPI.HandleFunc("/webhooks", allow(webhookHandler, post))
+	peerAPI.HandleFunc("/admin/members", allow(audited(adminMembersHandler), get, post))
+	peerAPI.HandleFunc("/members", allow(membersHandler, post))
+	peerAPI.HandleFunc("/admin/rebalance", allow(rebalanceHandler, get))
+	peerAPI.HandleFunc("/ui", allow(dashboardHandler, get))
+	peerAPI.HandleFunc("/ui/status", allow(uiStatusHandler, get))
+	if peerPortSeparate() {
+		api.HandleFunc("/leader", allow(leaderHandler, get))
+		peerAPI.HandleFunc("/config", allow(audited(configHandler), post)) // for the dashboard
+		peerAPI.Use(peerGated)
+		peerAPI.HandleFunc("/status", allow(statusHandler, get))
+		peerAddr := fmt.Sprintf(":%d", *peerPortFlag)
+		log.Printf("serving peer endpoints on %s", peerAddr)
+		ln, err := net.Listen("tcp", peerAddr)
+		if err != nil {
+			log.Fatal(err)
 		}
+		go func() { log.Fatal(serve(peerAPI.HTTPServer(peerAddr), ln, "", "")) }()
+	}
+
+	if *respFlag != 0 {
+		go serveRESP(fmt.Sprintf(":%d", *respFlag))
+	}
+	if *mcFlag != 0 {
+		go serveMemcached(fmt.Sprintf(":%d", *mcFlag))
+	}
+	if *grpcFlag != 0 {
+		go serveGRPC(fmt.Sprintf(":%d", *grpcFlag))
 	}
-	if v := r.URL.Query().Get("W"); v != "" {
-		if i, err := strconv.Atoi(v); err == nil {
-			W = i
+
+	addr, c := fmt.Sprintf(":%d", *port), cluster()
+	log.Printf("starting KV service on %s (mode=%s leader=%v epoch=%d N=%d W=%d R=%d peers=%v)",
+		addr, mode, isLeader.Load(), currentEpoch.Load(), c.N, c.W, c.R, c.peers)
+	srv := api.HTTPServer(addr)
+	for _, spec := range listens {
+		ln, err := listen(spec)
+		if err != nil {
+			log.Fatalf("listen %s: %v", spec, err)
 		}
+		log.Printf("also serving HTTP on %s", spec)
+		go func() { log.Fatal(serve(srv, ln, *certFlag, *keyFlag)) }()
 	}
-	if v := r.URL.Query().Get("R"); v != "" {
-		if i, err := strconv.Atoi(v); err == nil {
-			R = i
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() { log.Fatal(serve(srv, ln, *certFlag, *keyFlag)) }()
+
+	setState(stateRecovering)
+	if *walFlag != "" {
+		l, data, err := openWAL(*walFlag)
+		if err != nil {
+			log.Fatalf("opening WAL: %v", err)
+		}
+		wal, svc.data, walSyncEvery = l, data, *walSyncFlag
+		recountUsage(data)
+		reindex(data)
+		retrackExpiry(data)
+		go wal.syncLoop()
+		log.Printf("replayed %d keys from %s", len(data), *walFlag)
+	}
+	if _, err := checkDurability(*durabilityFlag); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkPolicies(); err != nil {
+		log.Fatal(err)
+	}
+	if primaryBackup() {
+		startPrimaryBackup()
+	}
+	handshakePeers()
+	startPinger()
+	startAntiEntropy()
+	startSoftDeleteSweeper()
+	startTTLSweeper()
+	startStatsCollector()
+	if *cdcFlag != "" {
+		if err := startCDC(*cdcFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	setState(stateSyncing)
+	syncWithPeers()
+	setState(stateReady)
+	drainOnSignal()
+}
+
+// configHandler sets the quorums given in ?N=, ?W= and ?R=. It holds
+// membersMu so a membership change cannot land between checking the new
+// quorums against the cluster and publishing them.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	membersMu.Lock()
+	defer membersMu.Unlock()
+	var n, wq, rq int
+	var err error
+	updateCluster(func(c *membership) {
+		n, wq, rq = c.N, c.W, c.R
+		for name, v := range map[string]*int{"N": &n, "W": &wq, "R": &rq} {
+			if s := r.URL.Query().Get(name); s != "" {
+				if i, err := strconv.Atoi(s); err == nil {
+					*v = i
+				}
+			}
 		}
+		if err = checkQuorumConfig(n, rq, wq, len(c.peers)+1); err != nil && strictQuorum {
+			return // publish the view unchanged
+		}
+		c.N, c.W, c.R = n, wq, rq
+	})
+	if err != nil {
+		if strictQuorum {
+			httpError(w, http.StatusBadRequest, "", "-STRICT: "+err.Error())
+			return
+		}
+		log.Printf("warning: /config: %v", err)
 	}
-	fmt.Fprintf(w, "reconfigured to N=%d W=%d R=%d\n", N, W, R)
+	fmt.Fprintf(w, "reconfigured to N=%d W=%d R=%d\n", n, wq, rq)
 }
 
 func setHandler(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 	val := r.URL.Query().Get("value")
+	if requestFormat(r) != "" {
+		var err error
+		if key, val, err = decodeSetBody(r); err != nil {
+			http.Error(w, "invalid set body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = checkKey(key)
+		if err == nil {
+			err = checkUnreserved(key)
+		}
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "key", err.Error())
+			return
+		}
+		if len(val) > maxValueBytes {
+			httpError(w, http.StatusBadRequest, "value", "value too large")
+			return
+		}
+	}
+	if key == "" {
+		http.Error(w, "key required", http.StatusBadRequest)
+		return
+	}
+	expires, err := parseTTL(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "ttl", err.Error())
+		return
+	}
+	coordinateWrite(w, r, key, Entry{Value: val, Expires: expires}, nil)
+}
+
+// deleteHandler replicates a tombstone so the delete wins over older
+// copies of the key under last-writer-wins. With ?soft=true the tombstone
+// keeps the value for /restore.
+func deleteHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key required", http.StatusBadRequest)
+		return
+	}
+	var cond writeCond
+	if r.URL.Query().Get("soft") == "true" {
+		cond = softDelete
+	}
+	coordinateWrite(w, r, key, Entry{Deleted: true}, cond)
+}
+
+// casHandler sets key to value only if its current value on the
+// coordinator is ?expected=. Without ?expected= the key must not exist.
+func casHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := q.Get("key")
 	if key == "" {
 		http.Error(w, "key required", http.StatusBadRequest)
 		return
 	}
-	ts := time.Now().UnixNano()
+	expected, hasExpected := q["expected"]
+	cond := func(cur Entry, ok bool, _ *Entry) bool {
+		live := ok && !cur.Deleted && !cur.expired(time.Now().UnixNano())
+		if !hasExpected {
+			return !live
+		}
+		return live && cur.Value == expected[0]
+	}
+	coordinateWrite(w, r, key, Entry{Value: q.Get("value")}, cond)
+}
+
+// writeCond is run against the current entry under the store lock before
+// a coordinated write is applied. It may reject the write, or fill e in
+// from the current entry, as CRDT updates do.
+type writeCond func(cur Entry, ok bool, e *Entry) bool
+
+// applyLocal stores e under key unless cond rejects it.
+func applyLocal(key string, e *Entry, cond writeCond) bool {
+	svc.Lock()
+	defer svc.Unlock()
+	cur, ok := svc.intactCopy(key)
+	if cond != nil && !cond(cur, ok, e) {
+		return false
+	}
+	stampClock(cur, ok, e)
+	merged, _ := mergeEntry(key, cur, ok, *e)
+	svc.put(key, merged)
+	noteWrite(e.Timestamp)
+	return true
+}
+
+// coordinateWrite stamps e, applies it locally and replicates it the way
+// this node's replication model dictates.
+func coordinateWrite(w http.ResponseWriter, r *http.Request, key string, e Entry, cond writeCond) {
+	start := time.Now()
+	level, err := parseConsistency(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if e.Clock, err = parseContext(r.URL.Query().Get("context")); err != nil {
+		httpError(w, http.StatusBadRequest, "context", err.Error())
+		return
+	}
+	if e.Sync, err = writeDurability(r, key); err != nil {
+		httpError(w, http.StatusBadRequest, "durability", err.Error())
+		return
+	}
+	callback, err := parseCallback(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "callback", err.Error())
+		return
+	}
+	cond = withIfMatch(r, key, cond)
+	if readOnly {
+		rejectReadOnlyWrite(w)
+		return
+	}
+	e.Owner = tokenOf(r)
+	if status, err := checkQuota(key, e); err != nil {
+		httpError(w, status, "", err.Error())
+		return
+	}
+	e.Timestamp = writeClock()
+	e.Node, e.Seq = self, nextSeq()
+	tr := traceOf(r)
+	tr.setKey(key)
+	targets := replicaPeers(key)
+	ws := newWrite(key, targets, callback)
+	wq := writeQuorum(key)
+	ws.level = writeLevel(level, wq)
+	defer ws.answered()
+	done := http.StatusCreated
+	if e.Deleted {
+		done = http.StatusOK
+	}
+
+	// --- Primary-backup writes: ordered stream to every backup ---
+	if primaryBackup() {
+		if !beginLeaderWrite() {
+			rejectNonLeaderWrite(w)
+			return
+		}
+		defer endLeaderWrite()
+		defer observeOp("write", "all", start)
+		ws.level = "all"
+		if !pbWrite(tr, ws, key, e, cond) {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		w.Header().Set(writeIDHeader, ws.ID)
+		if !syncLocal(w, e) {
+			return
+		}
+		w.WriteHeader(done)
+		return
+	}
+
+	// --- Two-phase commit writes: prepare on every replica, commit on W votes ---
+	if twoPhaseCommit() {
+		if !beginLeaderWrite() {
+			rejectNonLeaderWrite(w)
+			return
+		}
+		defer endLeaderWrite()
+		defer observeOp("write", mode2PC, start)
+		ws.level = mode2PC
+		twoPhaseWrite(w, tr, ws, targets, key, e, cond, wq, done)
+		return
+	}
+
+	// --- Paxos writes: any node proposes, a majority of replicas chooses ---
+	if paxosMode() {
+		defer observeOp("write", modePaxos, start)
+		ws.level = modePaxos
+		paxosWrite(w, tr, ws, targets, key, e, cond, done)
+		return
+	}
 
 	// --- Leader writes ---
-	if isLeader {
+	if isLeader.Load() {
+		if !beginLeaderWrite() {
+			rejectNonLeaderWrite(w)
+			return
+		}
+		defer endLeaderWrite()
+		defer observeOp("write", writeLevel(level, wq), start)
+		if level == "" && refuseUnreachable(w, tr, key, targets, wq) {
+			return
+		}
+
 		// local write
-		svc.Lock()
-		svc.data[key] = Entry{Value: val, Timestamp: ts}
-		svc.Unlock()
+		if !applyLocal(key, &e, cond) {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		tr.servedLocally()
+		tr.setEntry(e.Timestamp)
+		if !syncLocal(w, e) {
+			return
+		}
+		ws.begin()
+		w.Header().Set(writeIDHeader, ws.ID)
+		feedReadReplicas(key, e)
+
+		// per-datacenter consistency level requested by the client
+		if level != "" {
+			writeDC(w, tr, ws, level, key, e, done)
+			return
+		}
 
-		// W=1: fire‐and‐forget, simulate 200ms hardware delay in each goroutine
-		if W == 1 {
-			for _, peer := range peers {
+		// W=1: fire‐and‐forget, simulate 200ms hardware delay in each goroutine;
+		// /write_status tracks when the write reaches the rest
+		if wq == 1 {
+			for _, peer := range targets {
+				asyncRepl.Add(1)
+				if batchWindow > 0 {
+					replicateBatched(peer, key, e, func(ok bool) {
+						defer asyncRepl.Done()
+						ws.replicated(peer, ok)
+					})
+					continue
+				}
 				go func(p string) {
-					time.Sleep(LeaderDelayPerFollower)
-					replicateTo(p, key, val, ts)
+					defer asyncRepl.Done()
+					nodeClock.Sleep(LeaderDelayPerFollower)
+					ws.replicated(p, replicateTo(p, key, e))
 				}(peer)
 			}
-			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(done)
 			return
 		}
 
-		// W>1: synchronous, sequential with delay, stop once W acks
-		acks := 1
-		for _, peer := range peers {
-			time.Sleep(LeaderDelayPerFollower)
-			if replicateTo(peer, key, val, ts) {
-				acks++
-			}
-			if acks >= W {
-				break
+		// W>1: synchronous, sequential with delay, stop once W acks or
+		// the write budget runs out
+		wq = replicaQuorum(wq)
+		tr.setQuorum(wq)
+		ctx, cancel := writeBudget()
+		defer cancel()
+		acks := selfAcks(key)
+		targets = skipDown(tr, ws, targets)
+		if batchWindow > 0 {
+			acks = batchedAcks(ctx, tr, ws, targets, key, e, acks, wq)
+		} else {
+			for _, peer := range targets {
+				start := time.Now()
+				if !pause(ctx, LeaderDelayPerFollower) {
+					break
+				}
+				ok := replicateOrHint(ctx, tr, peer, key, e)
+				tr.peerAck(peer, start, ok)
+				ws.replicated(peer, ok)
+				if ok {
+					acks++
+				}
+				if acks >= wq {
+					break
+				}
 			}
+			ws.skipRest()
 		}
-		if acks < W {
-			http.Error(w, "write quorum not met", http.StatusInternalServerError)
+		if acks < wq {
+			quorumFailed(w, ctx, ws, e, acks, wq)
 			return
 		}
-		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(done)
 		return
 	}
 
-	// --- Leaderless mode: any node can coordinate if W==N ---
-	if !isLeader && W == N {
+	// --- Leaderless mode: any node can coordinate ---
+	if !isLeader.Load() && leaderlessCluster() {
+		defer observeOp("write", writeLevel(level, wq), start)
+		if level == "" && refuseUnreachable(w, tr, key, targets, wq) {
+			return
+		}
 		// local write
-		svc.Lock()
-		svc.data[key] = Entry{Value: val, Timestamp: ts}
-		svc.Unlock()
-
-		acks := 1
-		for _, peer := range peers {
-			time.Sleep(LeaderDelayPerFollower)
-			if replicateTo(peer, key, val, ts) {
-				acks++
-			}
+		if !applyLocal(key, &e, cond) {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
 		}
-		if acks < W {
-			http.Error(w, "write quorum not met", http.StatusInternalServerError)
+		tr.servedLocally()
+		tr.setEntry(e.Timestamp)
+		if !syncLocal(w, e) {
 			return
 		}
-		w.WriteHeader(http.StatusCreated)
+		ws.begin()
+		w.Header().Set(writeIDHeader, ws.ID)
+		feedReadReplicas(key, e)
+
+		if level != "" {
+			writeDC(w, tr, ws, level, key, e, done)
+			return
+		}
+		leaderlessWrite(w, tr, ws, targets, key, e, wq, done)
 		return
 	}
 
-	http.Error(w, "writes only allowed on leader", http.StatusBadRequest)
+	rejectNonLeaderWrite(w)
 }
 
 func replicateHandler(w http.ResponseWriter, r *http.Request) {
@@ -156,17 +796,68 @@ func replicateHandler(w http.ResponseWriter, r *http.Request) {
 	val := r.URL.Query().Get("value")
 	tsStr := r.URL.Query().Get("timestamp")
 	ts, err := strconv.ParseInt(tsStr, 10, 64)
-	if key == "" || err != nil {
+	epoch, epochErr := parseEpoch(r)
+	if key == "" || err != nil || epochErr != nil {
 		http.Error(w, "invalid replicate args", http.StatusBadRequest)
 		return
 	}
+	newer := epoch > currentEpoch.Load()
+	if !observeEpoch(epoch) {
+		rejectStaleEpoch(w)
+		return
+	}
+	if err := checkReplicationSource(r, newer); err != nil {
+		httpError(w, http.StatusForbidden, "from", err.Error())
+		return
+	}
+	origin, seq := r.URL.Query().Get("origin"), r.URL.Query().Get("seq")
+	awaitTurn(r.Context(), origin, r.URL.Query().Get("prev"))
+	defer markApplied(origin, seq)
+
+	// validate everything first: a delivery refused here is not applied,
+	// and must not make its retry look like a duplicate
+	deleted := r.URL.Query().Get("deleted") == "true"
+	clock, err := parseClock(r.URL.Query().Get("clock"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	restorable, _ := strconv.ParseInt(r.URL.Query().Get("restorable"), 10, 64)
+	expires, _ := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	in := Entry{Value: val, Timestamp: ts, Deleted: deleted, Node: r.URL.Query().Get("node"), Type: r.URL.Query().Get("type"), Clock: clock, Restorable: restorable, Expires: expires, Owner: r.URL.Query().Get("owner")}
+	if err := parseForwarded(r.URL.Query(), &in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkQueryChecksum(r, key, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	seen, err := seenWrite(origin, seq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if seen {
+		// already applied (or too old to matter): ack without reapplying
+		w.Header().Set("X-Duplicate", "true")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-	time.Sleep(FollowerUpdateSleep)
+	nodeClock.Sleep(FollowerUpdateSleep)
 	svc.Lock()
-	if e, ok := svc.data[key]; !ok || ts > e.Timestamp {
-		svc.data[key] = Entry{Value: val, Timestamp: ts}
+	cur, ok := svc.intactCopy(key)
+	if merged, changed := mergeEntry(key, cur, ok, in); changed {
+		svc.put(key, merged)
 	}
 	svc.Unlock()
+	if err := syncReplica(r); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	commitWrite(origin, seq)
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -177,130 +868,368 @@ func getHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "key required", http.StatusBadRequest)
 		return
 	}
-
-	// R=1: local-only read
-	if R == 1 {
-		svc.RLock()
-		e, ok := svc.data[key]
-		svc.RUnlock()
+	start, rq := time.Now(), readQuorum(r)
+	if rq > 1 {
+		release, ok := acquire(w, r, classQuorumRead)
 		if !ok {
-			http.NotFound(w, r)
 			return
 		}
-		bs, _ := json.Marshal(e)
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(bs)
+		defer release()
+	}
+	tr := traceOf(r)
+	e, ok := readKey(tr, key, rq)
+	observeOp("read", levelName(rq), start)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	tr.setEntry(e.Timestamp)
+	if t := clockOf(e).token(); t != "" {
+		w.Header().Set("X-Context", t)
+	}
+	if notModified(w, r, key, e) {
+		return
+	}
+	if wantsEnvelope(r) {
+		writeEnvelope(w, e, readMeta(r, rq))
+		return
+	}
+	writeEntry(w, r, e)
+}
+
+// mgetHandler reads several keys, given as repeated ?key= or as a request
+// body, returning the ones that exist.
+func mgetHandler(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()["key"]
+	if r.Method == http.MethodPost && requestFormat(r) != "" {
+		var err error
+		if keys, err = decodeKeys(r); err != nil {
+			http.Error(w, "invalid mget body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, k := range keys {
+			if err := checkKey(k); err != nil {
+				httpError(w, http.StatusBadRequest, "key", err.Error())
+				return
+			}
+		}
+	}
+	if len(keys) == 0 {
+		http.Error(w, "key required", http.StatusBadRequest)
 		return
 	}
 
-	// R>1: read‐coordinator fetches from up to R replicas
-	type result struct {
-		e  Entry
-		ok bool
+	rq := readQuorum(r)
+	if rq > 1 {
+		release, ok := acquire(w, r, classQuorumRead)
+		if !ok {
+			return
+		}
+		defer release()
 	}
-	resCh := make(chan result, len(peers)+1)
+	tr := traceOf(r)
+	found := make([]KV, len(keys))
 	var wg sync.WaitGroup
-
-	// local read
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		svc.RLock()
-		e, ok := svc.data[key]
-		svc.RUnlock()
-		resCh <- result{e, ok}
-	}()
-
-	// peer reads via /getReplica
-	for _, peer := range peers {
+	for i, k := range keys {
 		wg.Add(1)
-		go func(p string) {
+		go func() {
 			defer wg.Done()
-			url := fmt.Sprintf("http://%s/getReplica?key=%s", p, key)
-			resp, err := http.Get(url)
-			if err != nil {
-				resCh <- result{Entry{}, false}
-				return
-			}
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				resCh <- result{Entry{}, false}
-				return
+			if e, ok := readKey(tr, k, rq); ok {
+				found[i] = KV{Key: k, Entry: e}
 			}
-			var e Entry
-			json.NewDecoder(resp.Body).Decode(&e)
-			resCh <- result{e, true}
-		}(peer)
+		}()
+	}
+	wg.Wait()
+
+	out := found[:0]
+	for _, kv := range found {
+		if kv.Key != "" {
+			out = append(out, kv)
+		}
+	}
+	if wantsEnvelope(r) {
+		byKey := make(map[string]Entry, len(out))
+		for _, kv := range out {
+			byKey[kv.Key] = kv.Entry
+		}
+		writeEnvelope(w, byKey, readMeta(r, rq))
+		return
+	}
+	writeEntries(w, r, out)
+}
+
+// readQuorum is the node's R unless the request overrides it with ?R=.
+func readQuorum(r *http.Request) int {
+	if v := r.URL.Query().Get("R"); v != "" {
+		if i, err := strconv.Atoi(v); err == nil && i > 0 {
+			return i
+		}
+	}
+	return cluster().R
+}
+
+// readKey returns the newest live entry for key among rq replicas,
+// counting this node's copy.
+func readKey(tr *reqTrace, key string, rq int) (Entry, bool) {
+	rq = replicaQuorum(rq)
+	local := ownedBy(key, self)
+	// R=1: local-only read
+	if rq == 1 && local {
+		tr.servedLocally()
+		e, ok := localCopy(key)
+		return e, ok && e.live()
+	}
+
+	// R>1: read‐coordinator fetches from up to R replicas, asking the
+	// nearest peers first and falling back to farther ones on failure, or
+	// under -HEDGE_READS when answers are slow to come
+	type result struct {
+		copy    replicaCopy
+		reached bool
+	}
+	var candidates []string
+	for _, p := range nearestPeers() {
+		if ownedBy(key, p) {
+			candidates = append(candidates, p)
+		}
+	}
+	if readStrategy == readDigest {
+		return digestRead(tr, key, rq, local, candidates)
 	}
+	resCh := make(chan result, len(candidates)+1)
 
-	go func() {
-		wg.Wait()
-		close(resCh)
-	}()
+	// local read, unless another node's replica has to stand in for it
+	launched, next := 0, 0
+	if local {
+		tr.servedLocally()
+		go func() {
+			e, ok := localCopy(key)
+			resCh <- result{replicaCopy{"", e, ok}, true}
+		}()
+		launched++
+	}
+	launch := func(n int) {
+		for ; n > 0 && next < len(candidates); n-- {
+			go func(p string) {
+				start := time.Now()
+				e, found, err := fetchReplica(p, key)
+				tr.peerAck(p, start, found)
+				if err == nil {
+					noteFetchLatency(time.Since(start))
+				}
+				resCh <- result{replicaCopy{p, e, found}, err == nil}
+			}(candidates[next])
+			next++
+			launched++
+		}
+	}
+	tr.setQuorum(rq)
+	if readStrategy == readAllWaitR {
+		launch(len(candidates))
+	} else {
+		launch(rq - launched)
+	}
+
+	var hedged <-chan time.Time
+	delay := hedgeDelay()
+	if delay > 0 {
+		hedged = time.After(delay)
+	}
 
 	got := 0
 	var best Entry
-	for r2 := range resCh {
-		if !r2.ok {
+	var copies []replicaCopy
+	for answered := 0; answered < launched; {
+		var r2 result
+		select {
+		case r2 = <-resCh:
+			answered++
+		case <-hedged:
+			hedged = nil
+			if next < len(candidates) {
+				hedgedReads.Add(1)
+				launch(1)
+				hedged = time.After(delay)
+			}
 			continue
 		}
-		got++
-		if r2.e.Timestamp > best.Timestamp {
-			best = r2.e
+		if r2.reached {
+			copies = append(copies, r2.copy)
+		}
+		if !r2.copy.found {
+			tr.retried()
+			launch(1)
+			continue
 		}
-		if got >= R {
+		best, _ = mergeEntry(key, best, got > 0, r2.copy.e)
+		got++
+		if got >= rq {
 			break
 		}
 	}
-	if got < 1 {
-		http.NotFound(w, r)
-		return
+	if got >= 1 && readRepairOn {
+		go repairStale(&readRepairs, key, best, copies)
 	}
-
-	bs, _ := json.Marshal(best)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(bs)
+	return best, got >= 1 && best.live()
 }
 
 func getReplicaHandler(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 	// simulate follower‐read delay from leader
-	time.Sleep(FollowerSleepOnLeaderRead)
+	nodeClock.Sleep(FollowerSleepOnLeaderRead)
 
+	// tombstones are returned too so the coordinator can see the delete;
+	// a corrupt copy is not, and read repair replaces it
 	svc.RLock()
-	e, ok := svc.data[key]
+	e, ok := svc.intactCopy(key)
 	svc.RUnlock()
 	if !ok {
 		http.NotFound(w, r)
 		return
 	}
+	if r.URL.Query().Get("digest") == "true" {
+		writeDigest(w, key, e)
+		return
+	}
 	bs, _ := json.Marshal(e)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(bs)
 }
 
-func replicateTo(peer, key, val string, ts int64) bool {
-	url := fmt.Sprintf("http://%s/replicate?key=%s&value=%s&timestamp=%d",
-		peer, key, val, ts)
-	resp, err := http.Post(url, "", nil)
+// fetchReplica reads key from peer's /getReplica. found is false, with a
+// nil error, when the peer does not have the key.
+func fetchReplica(peer, key string) (e Entry, found bool, err error) {
+	resp, err := peerNet.Fetch(context.Background(), peer, "/getReplica?key="+url.QueryEscape(key))
 	if err != nil {
-		return false
+		return Entry{}, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Entry{}, false, nil
+	default:
+		return Entry{}, false, responseError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		return Entry{}, false, err
+	}
+	if err := checkReceived(key, e); err != nil {
+		return Entry{}, false, err
+	}
+	return e, true, nil
+}
+
+func replicateTo(peer, key string, e Entry) bool {
+	return replicateWithin(context.Background(), peer, key, e)
+}
+
+// replicateWithin is replicateTo, abandoned when ctx ends.
+func replicateWithin(ctx context.Context, peer, key string, e Entry) bool {
+	path := fmt.Sprintf("/replicate?%s&epoch=%d&from=%s", entryQuery(peer, key, e), currentEpoch.Load(), url.QueryEscape(self))
+	if prev := nextSend(peer, e); prev > 0 {
+		path += "&prev=" + strconv.FormatInt(prev, 10)
+	}
+	for attempt := 0; ; attempt++ {
+		if !awaitPeer(ctx, peer) {
+			noteReplicationFailed(peer, ctx.Err())
+			return false
+		}
+		actx, cancel := attemptContext(ctx, peer)
+		ok, busy := replicateOnce(actx, peer, path, e)
+		if !ok && !busy && actx.Err() != nil && ctx.Err() == nil {
+			peerTimeouts.Add(1)
+		}
+		cancel()
+		switch {
+		case !busy:
+			return ok
+		case attempt == maxBusyRetries:
+			noteReplicationFailed(peer, errPeerBusy)
+			return false
+		}
+	}
+}
+
+// replicateOnce sends one /replicate to peer, reporting whether it was
+// applied or, without counting it as failed, answered busy.
+func replicateOnce(ctx context.Context, peer, path string, e Entry) (ok, busy bool) {
+	resp, err := peerNet.Send(ctx, peer, path, nil, nil)
+	if err != nil {
+		noteReplicationFailed(peer, err)
+		return false, false
+	}
+	defer resp.Body.Close()
+	if d, isBusy := busyHint(resp); isBusy {
+		notePeerBusy(peer, d)
+		return false, true
+	}
+	if resp.StatusCode != http.StatusOK {
+		observeRejection(resp)
+		noteReplicationFailed(peer, responseError(resp))
+		return false, false
+	}
+	noteReplicated(peer, e.Timestamp)
+	return true, false
+}
+
+// entryQuery encodes key and e as replication query parameters for peer.
+func entryQuery(peer, key string, e Entry) string {
+	q := url.Values{}
+	q.Set("key", key)
+	q.Set("value", e.Value)
+	q.Set("timestamp", strconv.FormatInt(e.Timestamp, 10))
+	if e.Deleted {
+		q.Set("deleted", "true")
+	}
+	if e.Node != "" {
+		q.Set("node", e.Node)
+	}
+	if e.Type != "" {
+		q.Set("type", e.Type)
+	}
+	if e.Owner != "" {
+		q.Set("owner", e.Owner)
 	}
-	resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	if e.Restorable != 0 {
+		q.Set("restorable", strconv.FormatInt(e.Restorable, 10))
+	}
+	if e.Expires != 0 {
+		q.Set("expires", strconv.FormatInt(e.Expires, 10))
+	}
+	if e.Sync {
+		q.Set("durability", durabilityFsync)
+	}
+	if len(e.Clock) > 0 {
+		q.Set("clock", e.Clock.String())
+	}
+	e = forwardQuery(q, peer, e)
+	if peerProtocolFor(peer) >= protocolChecksums {
+		bare := e
+		bare.Siblings = nil // not sent
+		q.Set("crc", strconv.FormatUint(uint64(bare.sum(key)), 10))
+	}
+	if e.Seq > 0 {
+		q.Set("origin", originID())
+		q.Set("seq", strconv.FormatInt(e.Seq, 10))
+	}
+	return q.Encode()
 }
 
 // localReadHandler returns this node’s in‐memory value without any delay
 func localReadHandler(w http.ResponseWriter, r *http.Request) {
-   key := r.URL.Query().Get("key")
-   svc.RLock()
-   e, ok := svc.data[key]
-   svc.RUnlock()
-   if !ok {
-       http.NotFound(w, r)
-       return
-   }
-   bs, _ := json.Marshal(e)
-   w.Header().Set("Content-Type", "application/json")
-   w.Write(bs)
-}
\ No newline at end of file
+	key := r.URL.Query().Get("key")
+	svc.RLock()
+	e, ok := svc.intactCopy(key)
+	svc.RUnlock()
+	if !ok || e.Deleted || e.expired(time.Now().UnixNano()) {
+		http.NotFound(w, r)
+		return
+	}
+	if notModified(w, r, key, e) {
+		return
+	}
+	bs, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
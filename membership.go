@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// N is the size of the member list, this node and its peers, rather than
+// a number of its own: -N=0 (the default) derives it, and an explicit -N
+// is checked against it (see strict.go). POST /admin/members?add= or
+// ?remove= changes the list on every node. Each node then rebuilds its
+// peer list and hash ring, sets N to the new size and recomputes R and W
+// at the same level: one stays one, a majority stays a majority, all
+// stays all, and any other count is kept but capped at N. A node removed
+// from the list is left on its own. The keys whose replicas changed are
+// then copied over in the background (see rebalance.go).
+
+var (
+	membersMu sync.Mutex // serializes membership changes
+	vnodes    = 64       // -VNODES, for rebuilding the ring
+
+	clusterMu   sync.Mutex // serializes publishing views
+	clusterView atomic.Pointer[membership]
+)
+
+// membership is one view of the cluster: the peers and their locations,
+// the hash ring over them, and the quorums. Requests read it while
+// membership changes and /config replace it, so a published view is never
+// changed: updateCluster publishes a new one whole, and a reader sees the
+// cluster from before a change or after it, never half of each.
+type membership struct {
+	peers            []string
+	peerDC, peerZone map[string]string // peer address -> datacenter, zone/rack
+	ring             *Ring             // nil until startup places the members
+	N, R, W          int
+}
+
+func init() {
+	clusterView.Store(&membership{peerDC: map[string]string{}, peerZone: map[string]string{}})
+}
+
+// cluster is the current view of the cluster.
+func cluster() *membership { return clusterView.Load() }
+
+// updateCluster publishes a copy of the current view as fn changes it. fn
+// must give the copy new slices and maps rather than write to the ones it
+// shares with the old view.
+func updateCluster(fn func(c *membership)) {
+	clusterMu.Lock()
+	defer clusterMu.Unlock()
+	next := *cluster()
+	fn(&next)
+	clusterView.Store(&next)
+}
+
+// members lists the cluster, this node first.
+func members() []string { return append([]string{self}, cluster().peers...) }
+
+// memberSpec writes member the way -PEERS takes it, with its location.
+func memberSpec(m string) string {
+	zone := cluster().peerZone[m]
+	if m == self {
+		zone = localZone
+	}
+	if zone == "" {
+		return m + "@" + dcOf(m)
+	}
+	return m + "@" + dcOf(m) + "/" + zone
+}
+
+// rescaleQuorum resizes a quorum of q out of oldN nodes to n nodes.
+func rescaleQuorum(q, oldN, n int) int {
+	switch {
+	case q <= 1:
+		return 1
+	case q >= oldN:
+		return n
+	case q == oldN/2+1:
+		return n/2 + 1
+	default:
+		return min(q, n)
+	}
+}
+
+// setMembers makes specs, written as for -PEERS, the cluster.
+func setMembers(specs []string) {
+	membersMu.Lock()
+	defer membersMu.Unlock()
+	cur := cluster()
+	dcs, zones := maps.Clone(cur.peerDC), maps.Clone(cur.peerZone)
+	var all, next []string
+	in := false
+	for _, s := range specs {
+		addr, dc, zone := splitPeer(s)
+		if addr == "" || slices.Contains(all, addr) {
+			continue
+		}
+		all = append(all, addr)
+		if addr == self {
+			in = true
+			continue
+		}
+		dcs[addr], zones[addr] = dc, zone
+		next = append(next, addr)
+	}
+	from, to := currentLayout(), layout{NewRing(all, vnodes), all, replicasOf(len(all))}
+	if !in {
+		next = nil
+	}
+	var c *membership
+	updateCluster(func(v *membership) {
+		oldN, n := v.N, len(next)+1
+		v.peerDC, v.peerZone, v.peers = dcs, zones, next
+		v.ring = NewRing(append([]string{self}, next...), vnodes)
+		v.N, v.R, v.W = n, rescaleQuorum(v.R, oldN, n), rescaleQuorum(v.W, oldN, n)
+		c = v
+	})
+	startRebalance(from, to)
+	log.Printf("membership changed: N=%d W=%d R=%d peers=%v", c.N, c.W, c.R, c.peers)
+	if err := checkQuorumConfig(c.N, c.R, c.W, c.N); err != nil {
+		log.Printf("warning: %v", err)
+	}
+}
+
+type membersReport struct {
+	Members  []string          `json:"members"`
+	N        int               `json:"n"`
+	R        int               `json:"r"`
+	W        int               `json:"w"`
+	Nodes    []string          `json:"nodes,omitempty"`  // nodes that applied a change
+	Failed   map[string]string `json:"failed,omitempty"` // node -> error
+	Complete bool              `json:"complete,omitempty"`
+}
+
+func currentMembers() membersReport {
+	var specs []string
+	for _, m := range members() {
+		specs = append(specs, memberSpec(m))
+	}
+	c := cluster()
+	return membersReport{Members: specs, N: c.N, R: c.R, W: c.W}
+}
+
+// membersHandler takes the member list (?members=, comma-separated) from a
+// peer running /admin/members.
+func membersHandler(w http.ResponseWriter, r *http.Request) {
+	specs := strings.Split(r.URL.Query().Get("members"), ",")
+	if len(specs) == 0 || specs[0] == "" {
+		httpError(w, http.StatusBadRequest, "members", "members required")
+		return
+	}
+	setMembers(specs)
+	w.WriteHeader(http.StatusOK)
+}
+
+// adminMembersHandler reports the member list (GET), or adds (?add=) or
+// removes (?remove=) a node on every member (POST), the removed one
+// included.
+func adminMembersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		json.NewEncoder(w).Encode(currentMembers())
+		return
+	}
+	q := r.URL.Query()
+	add, remove := q.Get("add"), q.Get("remove")
+	if (add == "") == (remove == "") {
+		httpError(w, http.StatusBadRequest, "", "one of add or remove required")
+		return
+	}
+	old := members()
+	specs := currentMembers().Members
+	targets := slices.Clone(old)
+	if add != "" {
+		addr, _, _ := splitPeer(add)
+		if slices.Contains(old, addr) {
+			httpError(w, http.StatusConflict, "add", addr+" is already a member")
+			return
+		}
+		specs, targets = append(specs, add), append(targets, addr)
+	} else {
+		remove, _, _ = splitPeer(remove)
+		i := slices.Index(old, remove)
+		if i < 0 {
+			httpError(w, http.StatusNotFound, "remove", remove+" is not a member")
+			return
+		}
+		if remove == self {
+			httpError(w, http.StatusBadRequest, "remove", "ask another member to remove this node")
+			return
+		}
+		specs = slices.Delete(specs, i, i+1)
+	}
+
+	report := membersReport{Nodes: []string{self}, Failed: map[string]string{}}
+	peerQ := url.Values{"members": {strings.Join(specs, ",")}}.Encode()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range targets[1:] {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			err := postOK(fmt.Sprintf("http://%s/members?%s", p, peerQ), "", nil)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Failed[p] = err.Error()
+			} else {
+				report.Nodes = append(report.Nodes, p)
+			}
+		}(p)
+	}
+	setMembers(specs)
+	wg.Wait()
+	cur := currentMembers()
+	report.Members, report.N, report.R, report.W = cur.Members, cur.N, cur.R, cur.W
+	sort.Strings(report.Nodes)
+	report.Complete = len(report.Failed) == 0
+	json.NewEncoder(w).Encode(report)
+}
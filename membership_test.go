@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// withCluster publishes a view changed by fn for the rest of the test.
+func withCluster(t *testing.T, fn func(c *membership)) {
+	t.Helper()
+	old := cluster()
+	updateCluster(fn)
+	t.Cleanup(func() { clusterView.Store(old) })
+}
+
+// requests read the membership while it changes; run with -race
+func TestMembershipChangeDuringTraffic(t *testing.T) {
+	mem := newMemNet()
+	oldSelf, oldNet, oldFactor := self, peerNet, replicationFactor
+	defer func() { self, peerNet, replicationFactor = oldSelf, oldNet, oldFactor }()
+	self, peerNet, replicationFactor = "kv1:8000", mem.transport(), 2
+	withCluster(t, func(c *membership) {
+		c.peers, c.N, c.R, c.W = []string{"kv2:8000"}, 2, 1, 2
+		c.ring = NewRing([]string{"kv1:8000", "kv2:8000"}, 8)
+	})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := fmt.Sprintf("k%d-%d", i, n)
+				for _, p := range replicaPeers(key) {
+					_ = zoneOf(p)
+				}
+				if q := writeQuorum(key); q < 1 {
+					t.Errorf("W=%d", q)
+				}
+				if c := cluster(); c.W > c.N || len(c.peers)+1 != c.N {
+					t.Errorf("view mixes changes: N=%d W=%d peers=%v", c.N, c.W, c.peers)
+				}
+				_ = currentMembers()
+			}
+		}(i)
+	}
+	for i := range 50 {
+		specs := []string{"kv1:8000", "kv2:8000", "kv3:8000@east"}
+		if i%2 == 1 {
+			specs = specs[:2]
+		}
+		setMembers(specs)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// /config during membership changes must not publish an old N; run with -race
+func TestConfigDuringMembershipChange(t *testing.T) {
+	mem := newMemNet()
+	oldSelf, oldNet := self, peerNet
+	defer func() { self, peerNet = oldSelf, oldNet }()
+	self, peerNet = "kv1:8000", mem.transport()
+	withCluster(t, func(c *membership) { c.peers, c.N, c.R, c.W = []string{"kv2:8000"}, 2, 1, 1 })
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			configHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/config?W=1", nil))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if c := cluster(); c.N != len(c.peers)+1 {
+				t.Errorf("N=%d with peers %v", c.N, c.peers)
+				return
+			}
+		}
+	}()
+	for i := range 200 {
+		specs := []string{"kv1:8000", "kv2:8000", "kv3:8000"}
+		if i%2 == 1 {
+			specs = specs[:2]
+		}
+		setMembers(specs)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestMembershipChangeRescalesQuorums(t *testing.T) {
+	oldSelf := self
+	defer func() { self = oldSelf }()
+	self = "kv1:8000"
+	withCluster(t, func(c *membership) { c.peers, c.N, c.R, c.W = []string{"kv2:8000", "kv3:8000"}, 3, 2, 3 })
+
+	setMembers([]string{"kv1:8000", "kv2:8000", "kv3:8000", "kv4:8000@east", "kv5:8000@east/b"})
+	if cluster().N != 5 || cluster().R != 3 || cluster().W != 5 {
+		t.Fatalf("after growing to 5: N=%d R=%d W=%d, want a majority read and an all write", cluster().N, cluster().R, cluster().W)
+	}
+	if !slices.Equal(cluster().peers, []string{"kv2:8000", "kv3:8000", "kv4:8000", "kv5:8000"}) || dcOf("kv5:8000") != "east" || zoneOf("kv5:8000") != "east/b" {
+		t.Fatalf("peers %v, kv5 in %s", cluster().peers, zoneOf("kv5:8000"))
+	}
+	if got := cluster().ring.PreferenceList("k", 5, zoneOf); len(got) != 5 {
+		t.Fatalf("ring has %v", got)
+	}
+
+	setMembers([]string{"kv1:8000", "kv2:8000"})
+	if cluster().N != 2 || cluster().R != 2 || cluster().W != 2 {
+		t.Fatalf("after shrinking to 2: N=%d R=%d W=%d", cluster().N, cluster().R, cluster().W)
+	}
+	setMembers([]string{"kv2:8000", "kv3:8000"})
+	if cluster().N != 1 || len(cluster().peers) != 0 {
+		t.Fatalf("removed node kept N=%d peers=%v", cluster().N, cluster().peers)
+	}
+}
+
+func TestAdminMembersAddAndRemove(t *testing.T) {
+	p1, p2 := 9130, 9131
+	a := startNode(t, p1, nil, false, 0, 1, 1)
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{fmt.Sprintf("localhost:%d", p1)}, false, 0, 1, 2)
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+
+	change := func(node int, q string) membersReport {
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/admin/members?%s", node, q), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var rep membersReport
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&rep) != nil {
+			t.Fatalf("%s: %d", q, resp.StatusCode)
+		}
+		return rep
+	}
+	members := func(node int) membersReport {
+		var rep membersReport
+		if err := getJSON(fmt.Sprintf("http://localhost:%d/admin/members", node), &rep); err != nil {
+			t.Fatal(err)
+		}
+		return rep
+	}
+
+	if rep := members(p2); rep.N != 2 || rep.W != 2 {
+		t.Fatalf("-N=0 with one peer: %+v", rep)
+	}
+	rep := change(p1, fmt.Sprintf("add=localhost:%d", p2))
+	if !rep.Complete || rep.N != 2 || len(rep.Members) != 2 {
+		t.Fatalf("add: %+v", rep)
+	}
+	rep = change(p1, fmt.Sprintf("remove=localhost:%d", p2))
+	if !rep.Complete || rep.N != 1 {
+		t.Fatalf("remove: %+v", rep)
+	}
+	if rep := members(p2); rep.N != 1 || rep.W != 1 || len(rep.Members) != 1 {
+		t.Fatalf("removed node: %+v", rep)
+	}
+}
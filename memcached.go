@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The memcached front-end speaks the text protocol's get/gets/set/delete
+// so memcached load tools can drive the cluster. Like the RESP front-end
+// it goes through the node's own HTTP handlers.
+
+// memcachedMaxRelative is the largest exptime memcached reads as seconds
+// from now rather than as a Unix time.
+const memcachedMaxRelative = 30 * 24 * 60 * 60
+
+// serveMemcached accepts memcached connections on addr.
+func serveMemcached(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("memcached listener: %v", err)
+	}
+	log.Printf("memcached front-end listening on %s", addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("memcached accept: %v", err)
+			return
+		}
+		go handleMemcached(conn)
+	}
+}
+
+func handleMemcached(conn net.Conn) {
+	defer conn.Close()
+	awaitReady()
+	rd := bufio.NewReader(conn)
+	wr := bufio.NewWriter(conn)
+	for {
+		line, err := readLine(rd)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			wr.WriteString("ERROR\r\n")
+		} else if quit := execMemcached(rd, wr, fields); quit {
+			wr.Flush()
+			return
+		}
+		if rd.Buffered() == 0 {
+			if err := wr.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// memcachedTTL reads a set's exptime: 0 never expires, up to 30 days is
+// seconds from now, and anything larger is a Unix time. A negative exptime
+// or a Unix time already past comes back as expired.
+func memcachedTTL(exptime string) (ttl time.Duration, expired bool, err error) {
+	n, err := strconv.ParseInt(exptime, 10, 64)
+	switch {
+	case err != nil || n == 0:
+		return 0, false, err
+	case n < 0:
+		return 0, true, nil
+	case n <= memcachedMaxRelative:
+		return time.Duration(n) * time.Second, false, nil
+	}
+	ttl = time.Until(time.Unix(n, 0))
+	return ttl, ttl <= 0, nil
+}
+
+// execMemcached runs one command and reports whether the client quit.
+func execMemcached(rd *bufio.Reader, w *bufio.Writer, f []string) bool {
+	switch f[0] {
+	case "get", "gets":
+		if len(f) < 2 {
+			w.WriteString("ERROR\r\n")
+			return false
+		}
+		for _, k := range f[1:] {
+			status, body := dispatch(http.MethodGet, "/get", url.Values{"key": {k}})
+			if status != http.StatusOK {
+				continue
+			}
+			var e Entry
+			json.Unmarshal(body, &e)
+			if f[0] == "gets" {
+				// the write timestamp doubles as the cas unique
+				fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n%s\r\n", k, len(e.Value), e.Timestamp, e.Value)
+			} else {
+				fmt.Fprintf(w, "VALUE %s 0 %d\r\n%s\r\n", k, len(e.Value), e.Value)
+			}
+		}
+		w.WriteString("END\r\n")
+	case "set":
+		// set <key> <flags> <exptime> <bytes> [noreply]
+		if len(f) < 5 {
+			w.WriteString("ERROR\r\n")
+			return false
+		}
+		size, err := strconv.Atoi(f[4])
+		if err != nil || size < 0 || size > maxBodyBytes {
+			w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+			return false
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return true
+		}
+		if string(data[size:]) != "\r\n" {
+			// <bytes> was wrong: skip the rest of the data line
+			if data[size+1] != '\n' {
+				readLine(rd)
+			}
+			w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+			return false
+		}
+		noreply := len(f) > 5 && f[5] == "noreply"
+		reply := "STORED"
+		ttl, expired, err := memcachedTTL(f[3])
+		q := url.Values{"key": {f[1]}, "value": {string(data[:size])}}
+		if ttl > 0 {
+			q.Set("ttl", ttl.String())
+		}
+		if err != nil {
+			reply = "CLIENT_ERROR bad command line format"
+		} else if expired {
+			// stored and gone at once, as memcached does with a past exptime
+			if status, body := dispatch(http.MethodPost, "/delete", url.Values{"key": {f[1]}}); status != http.StatusOK {
+				reply = "SERVER_ERROR " + strings.TrimSpace(string(body))
+			}
+		} else if status, body := dispatch(http.MethodPost, "/set", q); status != http.StatusCreated {
+			reply = "SERVER_ERROR " + strings.TrimSpace(string(body))
+		}
+		if !noreply {
+			w.WriteString(reply + "\r\n")
+		}
+	case "delete":
+		if len(f) < 2 {
+			w.WriteString("ERROR\r\n")
+			return false
+		}
+		reply := "DELETED"
+		if status, _ := dispatch(http.MethodGet, "/get", url.Values{"key": {f[1]}}); status != http.StatusOK {
+			reply = "NOT_FOUND"
+		} else if status, body := dispatch(http.MethodPost, "/delete", url.Values{"key": {f[1]}}); status != http.StatusOK {
+			reply = "SERVER_ERROR " + strings.TrimSpace(string(body))
+		}
+		if !(len(f) > 2 && f[2] == "noreply") {
+			w.WriteString(reply + "\r\n")
+		}
+	case "version":
+		w.WriteString("VERSION kvstore\r\n")
+	case "quit":
+		return true
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return false
+}
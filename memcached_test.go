@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemcached_BasicCommands(t *testing.T) {
+	node := startNode(t, 9184, nil, true, 1, 1, 1, "-MEMCACHED_PORT", "9185")
+	defer node.Process.Kill()
+	waitReady(t, 9184)
+
+	conn, err := net.Dial("tcp", "localhost:9185")
+	if err != nil {
+		t.Fatalf("dial memcached port: %v", err)
+	}
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+
+	// a reply runs to END for get/gets and is one line otherwise
+	send := func(req string) string {
+		conn.Write([]byte(req))
+		var b strings.Builder
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%q: reading reply: %v", req, err)
+			}
+			b.WriteString(line)
+			if !strings.HasPrefix(req, "get") || line == "END\r\n" {
+				return b.String()
+			}
+		}
+	}
+
+	cases := []struct {
+		req, want string
+	}{
+		{"get user\r\n", "END\r\n"},
+		{"set user 0 0 11\r\nAlice Smith\r\n", "STORED\r\n"},
+		{"get user nobody\r\n", "VALUE user 0 11\r\nAlice Smith\r\nEND\r\n"},
+		{"delete user\r\n", "DELETED\r\n"},
+		{"delete user\r\n", "NOT_FOUND\r\n"},
+		{"get user\r\n", "END\r\n"},
+		{"set user 0 0 -1\r\n", "CLIENT_ERROR bad data chunk\r\n"},
+		{"set user 0 0 two\r\n", "CLIENT_ERROR bad data chunk\r\n"},
+		{"set user 0 0 2\r\nabc\r\n", "CLIENT_ERROR bad data chunk\r\n"},
+		{"set user 0 0 3\r\nabcXY\r\n", "CLIENT_ERROR bad data chunk\r\n"},
+		{"get user\r\n", "END\r\n"},
+		{"set user 0 soon 1\r\na\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"set user 0 -1 1\r\na\r\n", "STORED\r\n"},
+		{"get user\r\n", "END\r\n"},
+		{"set user 0 0 1 noreply\r\na\r\nversion\r\n", "VERSION kvstore\r\n"},
+		{"flush_all\r\n", "ERROR\r\n"},
+	}
+	for _, c := range cases {
+		if got := send(c.req); got != c.want {
+			t.Errorf("%q: expected %q, got %q", c.req, c.want, got)
+		}
+	}
+
+	// gets reports the write timestamp as the cas unique
+	if got := send("gets user\r\n"); !strings.HasPrefix(got, "VALUE user 0 1 ") || !strings.HasSuffix(got, "\r\na\r\nEND\r\n") {
+		t.Errorf("gets: got %q", got)
+	}
+
+	// a non-zero exptime becomes the key's ttl
+	if got := send("set session 0 1 2\r\ns1\r\n"); got != "STORED\r\n" {
+		t.Fatalf("set with exptime: got %q", got)
+	}
+	if got := send("get session\r\n"); got != "VALUE session 0 2\r\ns1\r\nEND\r\n" {
+		t.Errorf("get before expiry: got %q", got)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if got := send("get session\r\n"); got != "END\r\n" {
+		t.Errorf("get after expiry: got %q", got)
+	}
+}
+
+func TestMemcachedTTL(t *testing.T) {
+	cases := map[string]struct {
+		ttl     time.Duration
+		expired bool
+	}{
+		"0":       {0, false},
+		"60":      {time.Minute, false},
+		"2592000": {30 * 24 * time.Hour, false},
+		"-1":      {0, true},
+		"2592001": {0, true}, // a Unix time in 1970
+	}
+	for in, want := range cases {
+		ttl, expired, err := memcachedTTL(in)
+		if err != nil || ttl > 0 && ttl != want.ttl || expired != want.expired {
+			t.Errorf("memcachedTTL(%s) = %v, %v, %v; want %v, %v", in, ttl, expired, err, want.ttl, want.expired)
+		}
+	}
+	if _, _, err := memcachedTTL("soon"); err == nil {
+		t.Error("memcachedTTL(soon) took a non-number")
+	}
+	ttl, expired, _ := memcachedTTL(strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	if expired || ttl < 59*time.Minute || ttl > time.Hour {
+		t.Errorf("memcachedTTL of a Unix time an hour out = %v, %v", ttl, expired)
+	}
+}
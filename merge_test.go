@@ -0,0 +1,162 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/quick"
+)
+
+func TestEntryNewerThanBreaksTiesByNode(t *testing.T) {
+	a := Entry{Value: "a", Timestamp: 100, Node: "kv1:8000"}
+	b := Entry{Value: "b", Timestamp: 100, Node: "kv2:8000"}
+	older := Entry{Value: "old", Timestamp: 99, Node: "kv9:8000"}
+
+	if !b.newerThan(a) || a.newerThan(b) {
+		t.Fatalf("tie not broken by node: a>b=%v b>a=%v", a.newerThan(b), b.newerThan(a))
+	}
+	if !a.newerThan(older) || older.newerThan(a) {
+		t.Fatalf("timestamp does not dominate node")
+	}
+	if a.newerThan(a) {
+		t.Fatalf("an entry is newer than itself")
+	}
+
+	// both arrival orders converge on the same winner
+	merge := func(cur Entry, in ...Entry) Entry {
+		for _, e := range in {
+			if e.newerThan(cur) {
+				cur = e
+			}
+		}
+		return cur
+	}
+	if x, y := merge(Entry{}, a, b), merge(Entry{}, b, a); x.Value != y.Value {
+		t.Fatalf("arrival order changed the winner: %v vs %v", x, y)
+	}
+}
+
+// mergeWrites is a random set of distinct writes to one key, each a single
+// version: there is only ever one write per (node, timestamp), as in a
+// real cluster.
+type mergeWrites []Entry
+
+var mergeNodes = []string{"kv1:8000", "kv2:8000", "kv3:8000"}
+
+func (mergeWrites) Generate(rnd *rand.Rand, size int) reflect.Value {
+	n := 2 + rnd.Intn(3)
+	out := make(mergeWrites, 0, n)
+	used := map[[2]int64]bool{}
+	for len(out) < n {
+		node := rnd.Intn(len(mergeNodes))
+		ts := int64(1 + rnd.Intn(6))
+		if used[[2]int64{int64(node), ts}] {
+			continue
+		}
+		used[[2]int64{int64(node), ts}] = true
+		e := Entry{Timestamp: ts, Node: mergeNodes[node], Value: strconv.Itoa(rnd.Intn(4))}
+		if rnd.Intn(4) == 0 {
+			e.Value = "x" // not an integer, for max
+		}
+		if rnd.Intn(5) == 0 {
+			e.Value, e.Deleted = "", true
+		}
+		for _, c := range mergeNodes {
+			if k := rnd.Intn(3); k > 0 {
+				if e.Clock == nil {
+					e.Clock = vclock{}
+				}
+				e.Clock[c] = int64(k)
+			}
+		}
+		out = append(out, e)
+	}
+	return reflect.ValueOf(out)
+}
+
+// crdtWrites is a random set of gcounter versions, each raising the slots
+// of the nodes it has heard from.
+type crdtWrites []Entry
+
+func (crdtWrites) Generate(rnd *rand.Rand, size int) reflect.Value {
+	var ws mergeWrites
+	ws = ws.Generate(rnd, size).Interface().(mergeWrites)
+	out := make(crdtWrites, len(ws))
+	for i, e := range ws {
+		s := crdtState{P: map[string]int64{}}
+		for _, c := range mergeNodes {
+			s.P[c] = int64(rnd.Intn(4))
+		}
+		out[i] = Entry{Timestamp: e.Timestamp, Node: e.Node, Type: typeGCounter, Value: s.encode(), Clock: e.Clock}
+	}
+	return reflect.ValueOf(out)
+}
+
+// checkMergeLaws checks that merge under r is idempotent, commutative and
+// associative, and that every delivery order of ws ends in the same state.
+func checkMergeLaws(t *testing.T, name string, r ConflictResolver, ws []Entry) bool {
+	m := func(a, b Entry) Entry { return merge(r, a, b) }
+	for _, a := range ws {
+		if got := m(a, a); !reflect.DeepEqual(got, a) {
+			t.Errorf("%s: not idempotent on %+v: %+v", name, a, got)
+			return false
+		}
+	}
+	a, b, c := ws[0], ws[1], ws[len(ws)-1]
+	if x, y := m(a, b), m(b, a); !reflect.DeepEqual(x, y) {
+		t.Errorf("%s: not commutative on %+v, %+v: %+v vs %+v", name, a, b, x, y)
+		return false
+	}
+	if x, y := m(m(a, b), c), m(a, m(b, c)); !reflect.DeepEqual(x, y) {
+		t.Errorf("%s: not associative on %+v, %+v, %+v: %+v vs %+v", name, a, b, c, x, y)
+		return false
+	}
+	var want *Entry
+	ok := true
+	permute(ws, func(order []Entry) {
+		got := order[0]
+		for _, e := range order[1:] {
+			got = m(got, e)
+		}
+		if want == nil {
+			want = &got
+		} else if ok && !reflect.DeepEqual(got, *want) {
+			t.Errorf("%s: delivery order %+v ends in %+v, another in %+v", name, order, got, *want)
+			ok = false
+		}
+	})
+	return ok
+}
+
+// permute calls f with every ordering of es.
+func permute(es []Entry, f func([]Entry)) {
+	var rec func(k int)
+	rec = func(k int) {
+		if k == len(es) {
+			f(es)
+			return
+		}
+		for i := k; i < len(es); i++ {
+			es[k], es[i] = es[i], es[k]
+			rec(k + 1)
+			es[k], es[i] = es[i], es[k]
+		}
+	}
+	rec(0)
+}
+
+func TestMergeLaws(t *testing.T) {
+	cfg := &quick.Config{MaxCountScale: 5}
+	for _, name := range []string{"lww", "highest-node", "max", "siblings"} {
+		r := resolvers[name]
+		law := func(ws mergeWrites) bool { return checkMergeLaws(t, name, r, append([]Entry(nil), ws...)) }
+		if err := quick.Check(law, cfg); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	law := func(ws crdtWrites) bool { return checkMergeLaws(t, "gcounter", lww{}, append([]Entry(nil), ws...)) }
+	if err := quick.Check(law, cfg); err != nil {
+		t.Errorf("gcounter: %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Anti-entropy compares Merkle trees instead of shipping whole stores.
+// Keys in a range are spread over 2^depth leaves by hash; a leaf's hash is
+// the XOR of its entries' hashes and an inner node hashes its two
+// children, so replicas holding the same entries build the same tree.
+// Syncing with a peer fetches its tree, walks down only where the hashes
+// differ, and exchanges the entries of the differing leaves, so the cost
+// beyond the tree itself is proportional to the difference.
+
+const (
+	defaultMerkleDepth = 8
+	maxMerkleDepth     = 16
+)
+
+// antiEntropyEvery runs merkleSync with every peer in the background, set by
+// -ANTI_ENTROPY; zero disables it.
+var antiEntropyEvery time.Duration
+
+// merkleRange is lo <= key < hi, written lo..hi; an empty bound is open.
+type merkleRange struct{ lo, hi string }
+
+func parseRange(s string) (merkleRange, error) {
+	if s == "" {
+		return merkleRange{}, nil
+	}
+	lo, hi, ok := strings.Cut(s, "..")
+	if !ok || hi != "" && hi <= lo {
+		return merkleRange{}, fmt.Errorf("range %q: want lo..hi with lo < hi", s)
+	}
+	return merkleRange{lo, hi}, nil
+}
+
+func (kr merkleRange) String() string { return kr.lo + ".." + kr.hi }
+
+func (kr merkleRange) has(key string) bool {
+	return key >= kr.lo && (kr.hi == "" || key < kr.hi)
+}
+
+// merkleTree holds the hashes level by level: levels[0] is the root,
+// levels[d] the 2^d nodes of depth d, down to the leaves.
+type merkleTree struct {
+	Range  string     `json:"range"`
+	Depth  int        `json:"depth"`
+	Levels [][]uint64 `json:"levels"`
+}
+
+func leafOf(key string, depth int) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64() >> (64 - depth))
+}
+
+func entryHash(key string, e Entry) uint64 {
+	bs, _ := json.Marshal(e)
+	sum := sha256.Sum256(append(append([]byte(key), 0), bs...))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func buildMerkle(data map[string]Entry, kr merkleRange, depth int) merkleTree {
+	leaves := make([]uint64, 1<<depth)
+	for k, e := range data {
+		if kr.has(k) {
+			leaves[leafOf(k, depth)] ^= entryHash(k, e)
+		}
+	}
+	levels := make([][]uint64, depth+1)
+	levels[depth] = leaves
+	for d := depth - 1; d >= 0; d-- {
+		below := levels[d+1]
+		level := make([]uint64, 1<<d)
+		for i := range level {
+			l, r := below[2*i], below[2*i+1]
+			if l == 0 && r == 0 {
+				continue // empty subtrees stay zero
+			}
+			var buf [16]byte
+			binary.BigEndian.PutUint64(buf[:8], l)
+			binary.BigEndian.PutUint64(buf[8:], r)
+			sum := sha256.Sum256(buf[:])
+			level[i] = binary.BigEndian.Uint64(sum[:8])
+		}
+		levels[d] = level
+	}
+	return merkleTree{kr.String(), depth, levels}
+}
+
+// diffLeaves returns the leaves whose hashes differ, descending only into
+// differing nodes.
+func diffLeaves(a, b merkleTree) []int {
+	var out []int
+	var walk func(d, i int)
+	walk = func(d, i int) {
+		if a.Levels[d][i] == b.Levels[d][i] {
+			return
+		}
+		if d == a.Depth {
+			out = append(out, i)
+			return
+		}
+		walk(d+1, 2*i)
+		walk(d+1, 2*i+1)
+	}
+	walk(0, 0)
+	return out
+}
+
+// leafEntries returns the entries in range that fall in the given leaves.
+func leafEntries(data map[string]Entry, kr merkleRange, depth int, leaves []int) map[string]Entry {
+	want := make(map[int]bool, len(leaves))
+	for _, l := range leaves {
+		want[l] = true
+	}
+	out := map[string]Entry{}
+	for k, e := range data {
+		if kr.has(k) && want[leafOf(k, depth)] {
+			out[k] = e
+		}
+	}
+	return out
+}
+
+func parseMerkleQuery(r *http.Request) (merkleRange, int, error) {
+	kr, err := parseRange(r.URL.Query().Get("range"))
+	if err != nil {
+		return kr, 0, err
+	}
+	depth := defaultMerkleDepth
+	if v := r.URL.Query().Get("depth"); v != "" {
+		if depth, err = strconv.Atoi(v); err != nil || depth < 1 || depth > maxMerkleDepth {
+			return kr, 0, fmt.Errorf("depth must be 1..%d", maxMerkleDepth)
+		}
+	}
+	return kr, depth, nil
+}
+
+// merkleHandler serves this node's tree for ?range=lo..hi at ?depth=.
+func merkleHandler(w http.ResponseWriter, r *http.Request) {
+	kr, depth, err := parseMerkleQuery(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "range", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildMerkle(svc.snapshot(), kr, depth))
+}
+
+// merkleLeavesHandler serves the entries, tombstones included, of the
+// comma-separated ?leaves= of a tree.
+func merkleLeavesHandler(w http.ResponseWriter, r *http.Request) {
+	kr, depth, err := parseMerkleQuery(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "range", err.Error())
+		return
+	}
+	var leaves []int
+	for _, s := range strings.Split(r.URL.Query().Get("leaves"), ",") {
+		l, err := strconv.Atoi(s)
+		if err != nil || l < 0 || l >= 1<<depth {
+			httpError(w, http.StatusBadRequest, "leaves", "leaves must be leaf indexes of the tree")
+			return
+		}
+		leaves = append(leaves, l)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(leafEntries(svc.snapshot(), kr, depth, leaves))
+}
+
+// merkleSync reconciles range kr with peer in both directions and reports
+// how many keys were exchanged.
+func merkleSync(peer string, kr merkleRange, depth int) (int, error) {
+	q := url.Values{"range": {kr.String()}, "depth": {strconv.Itoa(depth)}}
+	var theirs merkleTree
+	if err := peerJSON(peer, "/admin/merkle?"+q.Encode(), &theirs); err != nil {
+		return 0, err
+	}
+	if theirs.Depth != depth || len(theirs.Levels) != depth+1 {
+		return 0, fmt.Errorf("peer sent a tree of depth %d, want %d", theirs.Depth, depth)
+	}
+	snap := svc.snapshot()
+	diff := diffLeaves(buildMerkle(snap, kr, depth), theirs)
+	if len(diff) == 0 {
+		return 0, nil
+	}
+
+	leaves := make([]string, len(diff))
+	for i, l := range diff {
+		leaves[i] = strconv.Itoa(l)
+	}
+	q.Set("leaves", strings.Join(leaves, ","))
+	var remote map[string]Entry
+	if err := peerJSON(peer, "/admin/merkle/leaves?"+q.Encode(), &remote); err != nil {
+		return 0, err
+	}
+	exchanged := 0
+	for k, e := range remote {
+		if !ownedBy(k, self) {
+			continue
+		}
+		if err := checkReceived(k, e); err != nil {
+			log.Printf("anti-entropy with %s: %v", peer, err)
+			continue
+		}
+		repairLocal(k, e)
+		exchanged++
+	}
+	mine := leafEntries(snap, kr, depth, diff)
+	for k := range mine {
+		if readOnly || !ownedBy(k, peer) {
+			delete(mine, k)
+		}
+	}
+	if len(mine) > 0 {
+		bs, _ := json.Marshal(mine)
+		if err := postBatch(peer, fmt.Sprintf("/catchup?epoch=%d", currentEpoch.Load()), bs); err != nil {
+			return exchanged, err
+		}
+	}
+	return exchanged + len(mine), nil
+}
+
+// peerJSON decodes the JSON answer to a GET of path on peer as it arrives.
+func peerJSON(peer, path string, v any) error {
+	resp, err := peerNet.Stream(context.Background(), peer, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func getJSON(target string, v any) error {
+	resp, err := http.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func startAntiEntropy() {
+	if antiEntropyEvery <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(antiEntropyEvery) {
+			for _, p := range cluster().peers {
+				if n, err := merkleSync(p, merkleRange{}, defaultMerkleDepth); err != nil {
+					log.Printf("anti-entropy with %s: %v", p, err)
+				} else if n > 0 {
+					log.Printf("anti-entropy with %s: exchanged %d keys", p, n)
+				}
+			}
+		}
+	}()
+}
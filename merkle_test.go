@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMerkleDiffFindsDivergentLeaves(t *testing.T) {
+	a := map[string]Entry{}
+	for i := 0; i < 500; i++ {
+		a[fmt.Sprintf("k%03d", i)] = Entry{Value: "v", Timestamp: int64(i)}
+	}
+	b := map[string]Entry{}
+	for k, e := range a {
+		b[k] = e
+	}
+	all := merkleRange{}
+	if d := diffLeaves(buildMerkle(a, all, 8), buildMerkle(b, all, 8)); len(d) != 0 {
+		t.Fatalf("identical stores differ in leaves %v", d)
+	}
+
+	b["k042"] = Entry{Value: "newer", Timestamp: 1000}
+	delete(b, "k300")
+	d := diffLeaves(buildMerkle(a, all, 8), buildMerkle(b, all, 8))
+	got := leafEntries(a, all, 8, d)
+	if _, ok := got["k042"]; !ok || len(d) > 2 {
+		t.Fatalf("diff %v yields %d entries, want the leaves of k042 and k300", d, len(got))
+	}
+	if _, ok := got["k300"]; !ok {
+		t.Errorf("k300, missing on one side, not in the diff")
+	}
+
+	// changes outside the range leave its tree alone
+	kr, _ := parseRange("k100..k200")
+	if d := diffLeaves(buildMerkle(a, kr, 8), buildMerkle(b, kr, 8)); len(d) != 0 {
+		t.Errorf("range k100..k200 differs in %v", d)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for in, want := range map[string]merkleRange{"": {}, "a..": {"a", ""}, "..m": {"", "m"}, "a..m": {"a", "m"}} {
+		if got, err := parseRange(in); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("parseRange(%q) = %v, %v", in, got, err)
+		}
+	}
+	for _, bad := range []string{"a", "m..a", "a..a"} {
+		if _, err := parseRange(bad); err == nil {
+			t.Errorf("parseRange(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// panicCount counts handler panics turned into 500s, for /metrics.
+var panicCount atomic.Int64
+
+// statusRecorder remembers the status a handler sent.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *statusRecorder) Flush() {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// recoverHandler turns a panicking handler into a logged stack trace and a
+// 500, so one bad request cannot take the replica down. Aborted handlers
+// (http.ErrAbortHandler) are passed on for the server to drop quietly.
+func recoverHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			panicCount.Add(1)
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL, v, debug.Stack())
+			if rec.status == 0 {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(rec, r)
+	})
+}
+
+// routeStats accumulates requestMetrics per route and status code.
+type routeStats struct {
+	count   int64
+	seconds float64
+}
+
+var (
+	httpStatsMu sync.Mutex
+	httpStats   = map[[2]string]*routeStats{} // {route, code}
+)
+
+// requestMetrics counts requests and their latency by route pattern and
+// status. Unmatched paths are lumped together so they cannot blow up the
+// label set.
+func requestMetrics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		route := r.Pattern // filled in by the mux
+		if route == "" {
+			route = "unmatched"
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		key := [2]string{route, strconv.Itoa(rec.status)}
+		httpStatsMu.Lock()
+		st := httpStats[key]
+		if st == nil {
+			st = &routeStats{}
+			httpStats[key] = st
+		}
+		st.count++
+		st.seconds += time.Since(start).Seconds()
+		httpStatsMu.Unlock()
+	})
+}
+
+// writeHTTPMetrics renders requestMetrics for /metrics.
+func writeHTTPMetrics(w io.Writer) {
+	httpStatsMu.Lock()
+	keys := make([][2]string, 0, len(httpStats))
+	stats := make(map[[2]string]routeStats, len(httpStats))
+	for k, st := range httpStats {
+		keys = append(keys, k)
+		stats[k] = *st
+	}
+	httpStatsMu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	fmt.Fprintln(w, "# HELP kv_http_requests_total HTTP requests served, by route and status code.")
+	fmt.Fprintln(w, "# TYPE kv_http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "kv_http_requests_total{route=%q,code=%q} %d\n", k[0], k[1], stats[k].count)
+	}
+	fmt.Fprintln(w, "# HELP kv_http_request_seconds_total Time spent serving HTTP requests, by route and status code.")
+	fmt.Fprintln(w, "# TYPE kv_http_request_seconds_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "kv_http_request_seconds_total{route=%q,code=%q} %g\n", k[0], k[1], stats[k].seconds)
+	}
+}
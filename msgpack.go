@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Minimal MessagePack encoding for the value types the API exchanges:
+// strings, integers, booleans, nil, arrays and string-keyed maps.
+
+var errBadMsgpack = errors.New("malformed msgpack")
+
+func mpStr(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func mpInt(b []byte, v int64) []byte {
+	if v >= 0 && v < 128 {
+		return append(b, byte(v))
+	}
+	if v < 0 && v >= -32 {
+		return append(b, byte(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+func mpBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+func mpMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+	}
+}
+
+func mpArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+	}
+}
+
+// mpDecode decodes one value into string, int64, uint64, float64, bool,
+// nil, []any or map[string]any, returning the remaining input.
+func mpDecode(b []byte) (any, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errBadMsgpack
+	}
+	c, b := b[0], b[1:]
+	switch {
+	case c <= 0x7f:
+		return int64(c), b, nil
+	case c >= 0xe0:
+		return int64(int8(c)), b, nil
+	case c&0xe0 == 0xa0:
+		return mpTake(b, int(c&0x1f))
+	case c&0xf0 == 0x80:
+		return mpMap(b, int(c&0x0f))
+	case c&0xf0 == 0x90:
+		return mpArray(b, int(c&0x0f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, b, nil
+	case 0xc2:
+		return false, b, nil
+	case 0xc3:
+		return true, b, nil
+	case 0xc4, 0xd9: // bin8, str8
+		n, b, err := mpLen(b, 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mpTake(b, n)
+	case 0xc5, 0xda:
+		n, b, err := mpLen(b, 2)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mpTake(b, n)
+	case 0xc6, 0xdb:
+		n, b, err := mpLen(b, 4)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mpTake(b, n)
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		size := 1 << (c - 0xcc)
+		n, b, err := mpUint(b, size)
+		return n, b, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, b, err := mpUint(b, size)
+		if err != nil {
+			return nil, nil, err
+		}
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, b, nil
+	case 0xca:
+		n, b, err := mpUint(b, 4)
+		return float64(math.Float32frombits(uint32(n))), b, err
+	case 0xcb:
+		n, b, err := mpUint(b, 8)
+		return math.Float64frombits(n), b, err
+	case 0xdc:
+		n, b, err := mpLen(b, 2)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mpArray(b, n)
+	case 0xdd:
+		n, b, err := mpLen(b, 4)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mpArray(b, n)
+	case 0xde:
+		n, b, err := mpLen(b, 2)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mpMap(b, n)
+	case 0xdf:
+		n, b, err := mpLen(b, 4)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mpMap(b, n)
+	}
+	return nil, nil, errBadMsgpack
+}
+
+func mpUint(b []byte, size int) (uint64, []byte, error) {
+	if len(b) < size {
+		return 0, nil, errBadMsgpack
+	}
+	var v uint64
+	for _, c := range b[:size] {
+		v = v<<8 | uint64(c)
+	}
+	return v, b[size:], nil
+}
+
+func mpLen(b []byte, size int) (int, []byte, error) {
+	n, b, err := mpUint(b, size)
+	return int(n), b, err
+}
+
+func mpTake(b []byte, n int) (any, []byte, error) {
+	if n < 0 || len(b) < n {
+		return nil, nil, errBadMsgpack
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+func mpArray(b []byte, n int) (any, []byte, error) {
+	out := make([]any, 0, min(n, len(b)))
+	for i := 0; i < n; i++ {
+		v, rest, err := mpDecode(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		out, b = append(out, v), rest
+	}
+	return out, b, nil
+}
+
+func mpMap(b []byte, n int) (any, []byte, error) {
+	out := make(map[string]any, min(n, len(b)))
+	for i := 0; i < n; i++ {
+		k, rest, err := mpDecode(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		ks, ok := k.(string)
+		if !ok {
+			return nil, nil, errBadMsgpack
+		}
+		v, rest, err := mpDecode(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		out[ks], b = v, rest
+	}
+	return out, b, nil
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// The OpenAPI document for the client API is assembled from the table
+// below, a few helpers keeping each operation to a line or two. Internal
+// peer and admin endpoints are deliberately left out.
+
+type obj = map[string]any
+
+func ref(schema string) obj { return obj{"$ref": "#/components/schemas/" + schema} }
+
+func queryParam(name, desc string, required bool, schema obj) obj {
+	return obj{"name": name, "in": "query", "description": desc, "required": required, "schema": schema}
+}
+
+var (
+	strSchema = obj{"type": "string"}
+	intSchema = obj{"type": "integer", "minimum": 0}
+	keySchema = obj{"type": "string", "minLength": 1, "maxLength": maxKeyBytes}
+
+	keyParam         = queryParam("key", "Key to operate on: UTF-8 without control characters.", true, keySchema)
+	readQuorumParam  = queryParam("R", "Replicas to read, overriding the node's R.", false, obj{"type": "integer", "minimum": 1})
+	consistencyParam = queryParam("consistency", "Per-datacenter write level.", false, obj{"type": "string", "enum": []string{LocalQuorum, EachQuorum}})
+	durabilityParam  = queryParam("durability", "Ack after fsync to the write-ahead log, or once in memory; defaults to the node's -DURABILITY.", false, obj{"type": "string", "enum": []string{durabilityFsync, durabilityAsync}})
+	contextParam     = queryParam("context", "X-Context token from /get; the write replaces the versions it read.", false, strSchema)
+	envelopeParam    = queryParam("envelope", "Wrap the JSON answer as {\"data\": ..., \"meta\": {\"replicas_contacted\", \"consistency\"}}; as does Accept: application/json; profile=\"envelope\".", false, obj{"type": "boolean"})
+	callbackParam    = queryParam("callback", "http(s) URL the coordinator POSTs the write's /write_status to once no replica is pending.", false, strSchema)
+
+	ifNoneMatchParam = obj{"name": "If-None-Match", "in": "header", "description": "ETag of the entry the client holds; answered 304 while it is current.", "required": false, "schema": strSchema}
+	ifMatchParam     = obj{"name": "If-Match", "in": "header", "description": "ETags from /get; the write applies only while the key's entry on the coordinator has one of them (* for any live value).", "required": false, "schema": strSchema}
+	etagHeader       = obj{"description": "Version of the entry: its timestamp and contents checksum.", "schema": strSchema}
+
+	pageParams = []obj{
+		queryParam("prefix", "Key prefix.", false, strSchema),
+		queryParam("limit", "Maximum entries in the page.", false, intSchema),
+		queryParam("cursor", "X-Next-Cursor of the previous page.", false, strSchema),
+	}
+)
+
+// paged adds the X-Next-Cursor header of a listing page to ok.
+func paged(ok obj) obj {
+	ok["headers"] = obj{cursorHeader: obj{"description": "Pass as ?cursor= for the next page; absent on the last.", "schema": strSchema}}
+	return ok
+}
+
+func response(desc string, schema obj, types ...string) obj {
+	r := obj{"description": desc}
+	if schema != nil {
+		content := obj{}
+		for _, t := range types {
+			content[t] = obj{"schema": schema}
+		}
+		r["content"] = content
+	}
+	return r
+}
+
+func jsonResponse(desc string, schema obj) obj { return response(desc, schema, formatJSON) }
+
+// negotiated is a response available in every encoding codec.go speaks.
+func negotiated(desc string, schema obj) obj {
+	return response(desc, schema, formatJSON, formatMsgpack, formatProtobuf)
+}
+
+var (
+	errBadRequest  = jsonResponse("Invalid method, key or parameters.", ref("Error"))
+	errLeader      = response("Not the leader; X-Leader names it when known.", nil)
+	errQuorum      = jsonResponse("Write quorum not met; the body lists the replicas that accepted the write.", ref("PartialWrite"))
+	errNotModified = response("The entry still has the If-None-Match ETag.", nil)
+	errBudget      = jsonResponse("Write quorum not met within -WRITE_TIMEOUT; X-Acks counts the acks collected.", ref("PartialWrite"))
+)
+
+// writeOp describes a coordinated write answering okCode on success.
+func writeOp(summary, okCode string, params []obj, ok obj) obj {
+	return obj{
+		"summary":    summary,
+		"parameters": append(params, consistencyParam, durabilityParam, contextParam, callbackParam, ifMatchParam),
+		"responses": obj{
+			okCode: ok, "400": errBadRequest, "412": response("Precondition failed, If-Match included.", nil),
+			"413": jsonResponse("The write would exceed a byte quota.", ref("Error")),
+			"429": jsonResponse("The write would exceed a key-count quota.", ref("Error")),
+			"500": errQuorum, "503": errLeader, "504": errBudget,
+		},
+	}
+}
+
+func buildOpenAPI() obj {
+	paths := obj{
+		"/set": obj{"post": func() obj {
+			op := writeOp("Store a value under key.", "201", []obj{
+				queryParam("key", "Key to set; may come from the body instead.", false, keySchema),
+				queryParam("value", "Value to store.", false, obj{"type": "string", "maxLength": maxValueBytes}),
+				queryParam("ttl", "Time to live, as a Go duration (30s, 5m); the key then reads as missing and is swept.", false, strSchema),
+			}, response("Stored.", nil))
+			op["requestBody"] = obj{
+				"required": false,
+				"content": obj{
+					formatJSON:    obj{"schema": ref("SetRequest")},
+					formatMsgpack: obj{"schema": ref("SetRequest")},
+				},
+			}
+			return op
+		}()},
+		"/get": obj{"get": obj{
+			"summary":    "Read the newest value of key among R replicas.",
+			"parameters": []obj{keyParam, readQuorumParam, envelopeParam, ifNoneMatchParam},
+			"responses": obj{
+				"200": func() obj {
+					ok := negotiated("The entry, with any siblings.", ref("Entry"))
+					ok["headers"] = obj{
+						"X-Context": obj{"description": "Causal context token to pass as ?context= on the next write.", "schema": strSchema},
+						"ETag":      etagHeader,
+					}
+					return ok
+				}(),
+				"304": errNotModified,
+				"400": errBadRequest,
+				"404": response("No live value.", nil),
+			},
+		}},
+		"/mget": obj{
+			"get": obj{
+				"summary":    "Read several keys; missing keys are left out.",
+				"parameters": []obj{{"name": "key", "in": "query", "required": true, "schema": obj{"type": "array", "items": keySchema}, "explode": true}, readQuorumParam, envelopeParam},
+				"responses":  obj{"200": negotiated("Entries by key.", ref("EntryMap")), "400": errBadRequest},
+			},
+			"post": obj{
+				"summary":     "Read the keys listed in the body.",
+				"parameters":  []obj{readQuorumParam, envelopeParam},
+				"requestBody": obj{"required": true, "content": obj{formatJSON: obj{"schema": obj{"type": "array", "items": keySchema}}}},
+				"responses":   obj{"200": negotiated("Entries by key.", ref("EntryMap")), "400": errBadRequest},
+			},
+		},
+		"/delete": obj{"post": writeOp("Delete key, leaving a tombstone that replicates.", "200", []obj{
+			keyParam,
+			queryParam("soft", "Keep the value in the tombstone so /restore can bring it back.", false, obj{"type": "boolean"}),
+		}, response("Deleted.", nil))},
+		"/restore": obj{"post": writeOp("Undo a soft delete still within its retention window.", "201",
+			[]obj{keyParam}, response("Restored.", nil))},
+		"/cas": obj{"post": writeOp("Set key only if its current value is expected (or, without expected, only if it is absent).", "201", []obj{
+			keyParam,
+			queryParam("expected", "Value the key must currently hold.", false, strSchema),
+			queryParam("value", "New value.", false, strSchema),
+		}, response("Swapped.", nil))},
+		"/lock": obj{"post": func() obj {
+			op := writeOp("Take or renew a lease on a named lock, kept as the key lock/<name> and coordinated by the leader.", "201", []obj{
+				queryParam("name", "Lock name.", true, strSchema),
+				queryParam("owner", "Holder token; a new one is made without it. Pass it again to renew.", false, strSchema),
+				queryParam("ttl", "Lease length, as a Go duration, default 10s; the lock frees itself once it runs out.", false, strSchema),
+			}, response("Held; X-Lock-Owner carries the owner token and X-Lock-Expires the lease's deadline.", nil))
+			op["responses"].(obj)["412"] = response("Another owner holds the lock.", nil)
+			return op
+		}()},
+		"/unlock": obj{"post": func() obj {
+			op := writeOp("Release a lease taken with /lock.", "200", []obj{
+				queryParam("name", "Lock name.", true, strSchema),
+				queryParam("owner", "The holder token /lock returned.", true, strSchema),
+			}, response("Released.", nil))
+			op["responses"].(obj)["412"] = response("The owner does not hold the lock: never taken, expired, or held by another.", nil)
+			return op
+		}()},
+		"/append": obj{"post": writeOp("Append value to the key's current value, applied by the coordinator under its store lock.", "201", []obj{
+			keyParam,
+			queryParam("value", "Text to append.", false, strSchema),
+		}, response("Appended.", nil))},
+		"/getset": obj{"post": writeOp("Set key and return the entry it replaced.", "201", []obj{
+			keyParam,
+			queryParam("value", "New value.", false, strSchema),
+		}, jsonResponse("The replaced entry, or null if the key had no live value.", ref("Entry")))},
+		"/crdt/incr": obj{"post": writeOp("Add to a counter; only a pncounter may go down.", "201", []obj{
+			keyParam,
+			queryParam("by", "Amount to add, default 1.", false, obj{"type": "integer"}),
+			queryParam("type", "Counter type, default gcounter.", false, obj{"type": "string", "enum": []string{typeGCounter, typePNCounter}}),
+		}, response("Updated.", nil))},
+		"/crdt/add": obj{"post": writeOp("Add an element to an OR-set.", "201",
+			[]obj{keyParam, queryParam("elem", "Element to add.", true, strSchema)}, response("Updated.", nil))},
+		"/crdt/remove": obj{"post": writeOp("Remove the element's observed additions from an OR-set.", "201",
+			[]obj{keyParam, queryParam("elem", "Element to remove.", true, strSchema)}, response("Updated.", nil))},
+		"/crdt/value": obj{"get": obj{
+			"summary":    "Read a CRDT key and report its computed value.",
+			"parameters": []obj{keyParam, readQuorumParam},
+			"responses": obj{
+				"200": jsonResponse("Type and value: an integer for counters, a sorted array for sets.", ref("CRDTValue")),
+				"404": response("No live value.", nil),
+				"409": jsonResponse("The key does not hold a CRDT.", ref("Error")),
+			},
+		}},
+		"/scan": obj{"get": obj{
+			"summary":    "List this node's live entries by prefix, sorted by key, a page at a time.",
+			"parameters": pageParams,
+			"responses": obj{
+				"200": paged(jsonResponse("Matching entries, streamed.", obj{"type": "array", "items": ref("KV")})),
+				"400": errBadRequest,
+			},
+		}},
+		"/keys": obj{"get": obj{
+			"summary":    "List this node's live keys by prefix, sorted, a page at a time.",
+			"parameters": pageParams,
+			"responses": obj{
+				"200": paged(jsonResponse("Matching keys, streamed.", obj{"type": "array", "items": strSchema})),
+				"400": errBadRequest,
+			},
+		}},
+		"/query": obj{"get": obj{
+			"summary": "List the entries whose JSON value holds value at an -INDEXES field, sorted by key.",
+			"parameters": []obj{
+				queryParam("index", "Indexed field, as declared in -INDEXES.", true, strSchema),
+				queryParam("value", "Value to match; numbers and booleans as their JSON text.", true, strSchema),
+				queryParam("R", "Above 1, ask every peer too and confirm each match with a read at this quorum.", false, intSchema),
+				queryParam("limit", "Maximum entries to return.", false, intSchema),
+			},
+			"responses": obj{
+				"200": jsonResponse("Matching entries.", obj{"type": "array", "items": ref("KV")}),
+				"400": errBadRequest,
+				"503": jsonResponse("Fewer nodes than R answered.", ref("Error")),
+			},
+		}},
+		"/local_read": obj{"get": obj{
+			"summary":    "Read key from this node only.",
+			"parameters": []obj{keyParam, ifNoneMatchParam},
+			"responses": obj{
+				"200": func() obj {
+					ok := jsonResponse("The entry.", ref("Entry"))
+					ok["headers"] = obj{"ETag": etagHeader}
+					return ok
+				}(),
+				"304": errNotModified,
+				"404": response("No live value.", nil),
+			},
+		}},
+		"/config": obj{"post": obj{
+			"summary": "Change this node's N, W or R.",
+			"parameters": []obj{
+				queryParam("N", "Cluster size.", false, intSchema),
+				queryParam("W", "Write quorum.", false, intSchema),
+				queryParam("R", "Read quorum.", false, intSchema),
+			},
+			"responses": obj{"200": response("New settings, as text.", strSchema, "text/plain"), "400": jsonResponse("Refused under -STRICT: R+W<=N, or N is not the cluster size.", ref("Error"))},
+		}},
+		"/leader": obj{"get": obj{
+			"summary":   "Leader this node knows of and the current epoch.",
+			"responses": obj{"200": jsonResponse("Leader info.", ref("LeaderInfo"))},
+		}},
+		"/peers": obj{"get": obj{
+			"summary":   "Replication progress towards each peer.",
+			"responses": obj{"200": jsonResponse("One entry per peer.", obj{"type": "array", "items": ref("PeerInfo")})},
+		}},
+		"/ring": obj{"get": obj{
+			"summary":   "The hash ring: token ranges with their owners, and each member's vnodes and share of the key space.",
+			"responses": obj{"200": jsonResponse("Ring layout.", obj{"type": "object"})},
+		}},
+		"/owner": obj{"get": obj{
+			"summary":    "The nodes holding a key, in preference-list order.",
+			"parameters": []obj{keyParam},
+			"responses":  obj{"200": jsonResponse("Key hash, owners and whether this node is one.", obj{"type": "object"})},
+		}},
+		"/stats": obj{"get": obj{
+			"summary":   "This node's usage and quotas per namespace and API token, and recent store samples (-STATS_INTERVAL).",
+			"responses": obj{"200": jsonResponse("Usage by namespace and token; keys, bytes, read and write rates and store lock contention over time.", obj{"type": "object"})},
+		}},
+		"/status": obj{"get": obj{
+			"summary": "This node's lifecycle state (starting, recovering, syncing, ready or draining) and when it entered each.",
+			"responses": obj{
+				"200": jsonResponse("The node is ready.", obj{"type": "object"}),
+				"503": jsonResponse("The node is not ready; client requests get 503 too.", obj{"type": "object"}),
+			},
+		}},
+		"/cluster/status": obj{"get": obj{
+			"summary":    "Every member's health, lifecycle state, leader, epoch, key count, replication lag and config, gathered by this node.",
+			"parameters": []obj{queryParam("timeout", "How long to wait for each member (Go duration, default 1s).", false, strSchema)},
+			"responses": obj{
+				"200": jsonResponse("Per-node summaries and whether the healthy ones agree.", obj{"type": "object"}),
+				"400": jsonResponse("Bad timeout.", ref("Error")),
+			},
+		}},
+		"/write_status": obj{"get": obj{
+			"summary":    "Which replicas a write has reached, by its X-Write-ID.",
+			"parameters": []obj{queryParam("id", "X-Write-ID of the write.", true, strSchema)},
+			"responses": obj{
+				"200": jsonResponse("Per-replica state: pending, acked, failed or skipped.", obj{"type": "object"}),
+				"404": jsonResponse("Unknown or expired write ID.", ref("Error")),
+			},
+		}},
+		"/metrics": obj{"get": obj{
+			"summary":   "Prometheus metrics.",
+			"responses": obj{"200": response("Text exposition format.", strSchema, "text/plain")},
+		}},
+	}
+
+	entry := obj{"type": "object", "required": []string{"value", "timestamp"}, "properties": obj{
+		"value":      strSchema,
+		"timestamp":  obj{"type": "integer", "format": "int64", "description": "Write time in Unix nanoseconds."},
+		"deleted":    obj{"type": "boolean"},
+		"node":       obj{"type": "string", "description": "Node that coordinated the write; breaks timestamp ties."},
+		"type":       obj{"type": "string", "enum": []string{typeGCounter, typePNCounter, typeORSet}, "description": "CRDT type; value then holds its JSON state."},
+		"clock":      obj{"type": "object", "additionalProperties": obj{"type": "integer"}, "description": "Vector clock: writes per coordinating node this version descends from."},
+		"siblings":   obj{"type": "array", "items": ref("Entry"), "description": "Conflicting versions kept under the siblings resolver."},
+		"restorable": obj{"type": "integer", "format": "int64", "description": "Soft deletes: Unix nanoseconds until which /restore can bring the value back."},
+		"expires":    obj{"type": "integer", "format": "int64", "description": "Writes with ?ttl=: Unix nanoseconds after which the key reads as missing; on a tombstone, that it expired."},
+		"owner":      obj{"type": "string", "description": "Fingerprint of the API token that wrote this version."},
+		"checksum":   obj{"type": "integer", "format": "int64", "description": "CRC-32C of the key and the entry's contents."},
+	}}
+	schemas := obj{
+		"Entry":      entry,
+		"EntryMap":   obj{"type": "object", "additionalProperties": ref("Entry")},
+		"KV":         obj{"allOf": []obj{{"type": "object", "properties": obj{"key": strSchema}}, ref("Entry")}},
+		"CRDTValue":  obj{"type": "object", "properties": obj{"type": strSchema, "value": obj{}}},
+		"SetRequest": obj{"type": "object", "properties": obj{"key": keySchema, "value": strSchema}},
+		"Error": obj{"type": "object", "properties": obj{
+			"status": obj{"type": "integer"}, "error": strSchema, "field": strSchema,
+		}},
+		"PartialWrite": obj{"type": "object", "properties": obj{
+			"status": obj{"type": "integer"}, "error": strSchema,
+			"write_id": strSchema, "key": strSchema, "timestamp": obj{"type": "integer", "format": "int64"},
+			"acks": obj{"type": "integer"}, "required": obj{"type": "integer"},
+			"accepted": obj{"type": "array", "items": strSchema}, "failed": obj{"type": "array", "items": strSchema},
+			"skipped": obj{"type": "array", "items": strSchema}, "pending": obj{"type": "array", "items": strSchema},
+			"rolled_back": obj{"type": "array", "items": strSchema},
+		}},
+		"LeaderInfo": obj{"type": "object", "properties": obj{
+			"leader": strSchema, "epoch": obj{"type": "integer"}, "self": strSchema, "is_leader": obj{"type": "boolean"},
+		}},
+		"PeerInfo": obj{"type": "object", "properties": obj{
+			"addr":                      strSchema,
+			"last_replicated_timestamp": obj{"type": "integer", "format": "int64"},
+			"last_ack_at":               obj{"type": "string", "format": "date-time"},
+			"lag_seconds":               obj{"type": "number"},
+			"rtt_ms":                    obj{"type": "number"},
+			"reachable":                 obj{"type": "boolean"},
+			"replications_ok":           obj{"type": "integer"},
+			"replications_failed":       obj{"type": "integer"},
+			"last_error":                strSchema,
+			"last_error_at":             obj{"type": "string", "format": "date-time"},
+		}},
+	}
+
+	return obj{
+		"openapi": "3.0.3",
+		"info": obj{
+			"title":       "kv-service",
+			"version":     "1.0.0",
+			"description": "Replicated key-value store. Writes go to the leader unless the cluster runs leaderless (-LEADERLESS, or W=N).",
+		},
+		"servers":    []obj{{"url": "http://" + clientAddr}},
+		"paths":      paths,
+		"components": obj{"schemas": schemas},
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() { openAPIJSON, _ = json.MarshalIndent(buildOpenAPI(), "", "  ") })
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
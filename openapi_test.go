@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// the document must cover the key-value endpoints and use only the
+// methods the validation middleware lets through
+func TestOpenAPIDocument(t *testing.T) {
+	bs, err := json.Marshal(buildOpenAPI())
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if doc.OpenAPI[:2] != "3." {
+		t.Fatalf("openapi = %q", doc.OpenAPI)
+	}
+	for _, p := range []string{"/set", "/get", "/mget", "/delete", "/cas", "/scan"} {
+		if doc.Paths[p] == nil {
+			t.Errorf("missing path %s", p)
+		}
+	}
+	for p, ops := range doc.Paths {
+		for method := range ops {
+			switch method {
+			case "get", "post":
+			default:
+				t.Errorf("%s: unexpected operation %q", p, method)
+			}
+		}
+	}
+}
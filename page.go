@@ -0,0 +1,173 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Listings (/keys, /scan and /dump) page by key. ?limit= sets the page
+// size, defaultPageSize without it and never more than maxPageSize; when
+// more keys follow, the answer carries X-Next-Cursor, an opaque token
+// to pass back as ?cursor= for the next one, and its absence marks the
+// last page. Cursors name the last key returned, so pages stay in key
+// order however the store changes between them: later pages see keys
+// written since, and none is returned twice. A page is streamed as it is
+// read, a chunk of entries at a time with a flush after each, so the node
+// holds only the page's keys and a chunk of entries, and a client can
+// decode entries as they arrive. Finding a page's keys is one pass over
+// the store that keeps the limit+1 smallest in a bounded heap, so the
+// keys are only sorted a page at a time.
+
+const (
+	cursorHeader = "X-Next-Cursor"
+
+	listChunk = 256 // entries read from the store, and flushed, at a time
+
+	defaultPageSize = 1000  // keys per page without ?limit=
+	maxPageSize     = 10000 // largest ?limit= honoured
+)
+
+// listing is one page request.
+type listing struct {
+	prefix string
+	after  string // last key of the previous page, from ?cursor=
+	limit  int
+}
+
+// parseListing reads ?prefix=, ?cursor= and ?limit=, answering 400 for
+// bad ones.
+func parseListing(w http.ResponseWriter, r *http.Request) (listing, bool) {
+	q := r.URL.Query()
+	l := listing{prefix: q.Get("prefix"), limit: defaultPageSize}
+	if len(l.prefix) > maxKeyBytes {
+		httpError(w, http.StatusBadRequest, "prefix", "prefix longer than the key limit")
+		return l, false
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			httpError(w, http.StatusBadRequest, "limit", "limit must be a non-negative integer")
+			return l, false
+		}
+		l.limit = min(n, maxPageSize)
+	}
+	if c := q.Get("cursor"); c != "" {
+		bs, err := base64.RawURLEncoding.DecodeString(c)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "cursor", "cursor must be one a previous page returned")
+			return l, false
+		}
+		l.after = string(bs)
+	}
+	return l, true
+}
+
+// keys lists, in order, the page's keys whose entries keep accepts (all
+// of them for a nil keep), and the cursor of the page after it, if any.
+func (l listing) keys(keep func(Entry) bool) ([]string, string) {
+	if l.limit == 0 {
+		return nil, ""
+	}
+	// the limit+1 smallest keys, the largest on top: one past the page
+	// says whether another follows
+	h := make(keyHeap, 0, l.limit+1)
+	svc.RLock()
+	for k, e := range svc.data {
+		if !strings.HasPrefix(k, l.prefix) || k <= l.after || len(h) > l.limit && k >= h[0] {
+			continue
+		}
+		if keep != nil && !keep(e) {
+			continue
+		}
+		if len(h) <= l.limit {
+			heap.Push(&h, k)
+		} else {
+			h[0] = k
+			heap.Fix(&h, 0)
+		}
+	}
+	svc.RUnlock()
+	keys := []string(h)
+	sort.Strings(keys)
+	if len(keys) > l.limit {
+		keys = keys[:l.limit]
+		return keys, base64.RawURLEncoding.EncodeToString([]byte(keys[len(keys)-1]))
+	}
+	return keys, ""
+}
+
+// keyHeap is a max-heap of keys.
+type keyHeap []string
+
+func (h keyHeap) Len() int           { return len(h) }
+func (h keyHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h keyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *keyHeap) Push(x any)        { *h = append(*h, x.(string)) }
+func (h *keyHeap) Pop() any {
+	old := *h
+	k := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return k
+}
+
+// streamEntries reads keys' entries listChunk at a time, hands those keep
+// accepts to emit and flushes after each chunk. It stops when emit fails,
+// as it does once the client has gone.
+func streamEntries(w http.ResponseWriter, keys []string, keep func(Entry) bool, emit func(KV) error) {
+	rc := http.NewResponseController(w)
+	for start := 0; start < len(keys); start += listChunk {
+		chunk := keys[start:min(start+listChunk, len(keys))]
+		kvs := make([]KV, 0, len(chunk))
+		svc.RLock()
+		for _, k := range chunk {
+			if e, ok := svc.data[k]; ok && (keep == nil || keep(e)) {
+				kvs = append(kvs, KV{Key: k, Entry: e})
+			}
+		}
+		svc.RUnlock()
+		for _, kv := range kvs {
+			if emit(kv) != nil {
+				return
+			}
+		}
+		rc.Flush()
+	}
+}
+
+// liveNow keeps entries with a value that has not expired.
+func liveNow(e Entry) bool { return !e.Deleted && !e.expired(time.Now().UnixNano()) }
+
+// keysHandler lists this node's live keys, without their entries, as a
+// JSON array.
+func keysHandler(w http.ResponseWriter, r *http.Request) {
+	l, ok := parseListing(w, r)
+	if !ok {
+		return
+	}
+	keys, next := l.keys(liveNow)
+	if next != "" {
+		w.Header().Set(cursorHeader, next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	rc := http.NewResponseController(w)
+	w.Write([]byte("["))
+	for i, k := range keys {
+		if i > 0 {
+			w.Write([]byte(","))
+		}
+		bs, _ := json.Marshal(k)
+		if _, err := w.Write(bs); err != nil {
+			return
+		}
+		if (i+1)%listChunk == 0 {
+			rc.Flush()
+		}
+	}
+	w.Write([]byte("]"))
+}
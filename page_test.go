@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"kvstore/client"
+)
+
+func TestListingsPageByCursor(t *testing.T) {
+	port := 9165
+	n := startNode(t, port, nil, true, 1, 1, 1)
+	defer n.Process.Kill()
+	waitReady(t, port)
+	base := fmt.Sprintf("http://localhost:%d", port)
+
+	var want []string
+	for i := range 7 {
+		k := fmt.Sprintf("p/%02d", i)
+		resp, err := http.Post(base+"/set?key="+k+"&value=v", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if i != 3 {
+			want = append(want, k)
+		}
+	}
+	resp, err := http.Post(base+"/delete?key=p/03", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// /keys, three at a time
+	var got []string
+	pages := 0
+	for cursor := ""; ; pages++ {
+		resp, err := http.Get(base + "/keys?prefix=p/&limit=3&cursor=" + url.QueryEscape(cursor))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		err = json.NewDecoder(resp.Body).Decode(&keys)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, keys...)
+		if cursor = resp.Header.Get(cursorHeader); cursor == "" {
+			break
+		}
+	}
+	if !slices.Equal(got, want) || pages != 1 {
+		t.Fatalf("/keys pages = %v over %d+1 requests, want %v over 2", got, pages, want)
+	}
+
+	// /scan through the client, two at a time
+	got = nil
+	c := client.New(fmt.Sprintf("localhost:%d", port))
+	err = c.ScanEach(context.Background(), "p/", 2, func(kv client.KV) error {
+		got = append(got, kv.Key)
+		return nil
+	})
+	if err != nil || !slices.Equal(got, want) {
+		t.Fatalf("ScanEach = %v, %v; want %v", got, err, want)
+	}
+
+	// /dump pages include the tombstone
+	resp, err = http.Get(base + "/dump?prefix=p/&limit=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	for sc := bufio.NewScanner(resp.Body); sc.Scan(); {
+		lines++
+	}
+	resp.Body.Close()
+	cursor := resp.Header.Get(cursorHeader)
+	if lines != 4 || cursor == "" {
+		t.Fatalf("first dump page: %d lines, cursor %q", lines, cursor)
+	}
+	resp, err = http.Get(base + "/dump?prefix=p/&limit=4&cursor=" + url.QueryEscape(cursor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = 0
+	for sc := bufio.NewScanner(resp.Body); sc.Scan(); {
+		lines++
+	}
+	resp.Body.Close()
+	if lines != 3 || resp.Header.Get(cursorHeader) != "" {
+		t.Fatalf("last dump page: %d lines, cursor %q", lines, resp.Header.Get(cursorHeader))
+	}
+
+	resp, err = http.Get(base + "/scan?cursor=%25%25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad cursor = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestListingKeysPagesThroughAHeap(t *testing.T) {
+	var want []string
+	svc.Lock()
+	for _, i := range rand.Perm(50) {
+		k := fmt.Sprintf("heap/%02d", i)
+		svc.data[k] = Entry{Value: "v", Timestamp: 1, Deleted: i%10 == 9}
+	}
+	svc.Unlock()
+	defer func() {
+		svc.Lock()
+		for k := range svc.data {
+			if strings.HasPrefix(k, "heap/") {
+				delete(svc.data, k)
+			}
+		}
+		svc.Unlock()
+	}()
+	for i := range 50 {
+		if i%10 != 9 {
+			want = append(want, fmt.Sprintf("heap/%02d", i))
+		}
+	}
+
+	var got []string
+	pages := 0
+	for l := (listing{prefix: "heap/", limit: 7}); ; pages++ {
+		keys, next := l.keys(liveNow)
+		if len(keys) > l.limit {
+			t.Fatalf("page of %d keys with limit %d", len(keys), l.limit)
+		}
+		got = append(got, keys...)
+		if next == "" {
+			break
+		}
+		bs, _ := base64.RawURLEncoding.DecodeString(next)
+		l.after = string(bs)
+	}
+	if !slices.Equal(got, want) || pages != 6 {
+		t.Fatalf("pages = %v over %d+1 listings, want %v over 7", got, pages, want)
+	}
+}
+
+func TestListingPageSizeLimits(t *testing.T) {
+	for q, want := range map[string]int{
+		"":            defaultPageSize,
+		"limit=5":     5,
+		"limit=0":     0,
+		"limit=99999": maxPageSize,
+	} {
+		l, ok := parseListing(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/scan?"+q, nil))
+		if !ok || l.limit != want {
+			t.Errorf("/scan?%s: limit %d, want %d", q, l.limit, want)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -MODE=paxos makes every key a register written by single-decree Paxos,
+// so linearizable per-key writes can be compared with quorum
+// last-write-wins. Any node coordinates (no -LEADER). Each write is one
+// Paxos round over the key's replicas, this node included:
+//
+//	prepare  the proposer picks a ballot above any it has seen for the key
+//	         and asks every replica to promise it (/paxos/prepare); each
+//	         answers with the value it last accepted, if any
+//	accept   once a majority has promised, the proposer takes the value
+//	         accepted under the highest ballot as the key's current one,
+//	         checks the write's condition (CAS, APPEND, ...) against it
+//	         and asks every replica to accept the new value under its
+//	         ballot (/paxos/accept); a majority of accepts chooses it
+//
+// A replica promised a higher ballot refuses either phase, and the
+// proposer retries with a higher one after a random pause, up to
+// paxosRounds times; then the write fails with 409, or with 500 if too
+// few replicas answered at all. Because the condition is checked against
+// the chosen value, a CAS cannot be lost to a racing write as it can under
+// last-write-wins. Accepted values go into the store, so reads and
+// replication tools see them as usual; promises live in memory only.
+// Each phase pays the per-follower delay, and
+// kv_paxos_phase_seconds{phase="prepare"|"accept"} on /metrics times them.
+
+const (
+	modePaxos   = "paxos"
+	paxosRounds = 5
+	paxosPause  = 20 * time.Millisecond // most a preempted proposer waits before retrying
+)
+
+func paxosMode() bool { return mode == modePaxos }
+
+// ballot orders proposals: by round, then by proposer.
+type ballot struct {
+	Round int64  `json:"round"`
+	Node  string `json:"node,omitempty"`
+}
+
+func (b ballot) less(o ballot) bool {
+	if b.Round != o.Round {
+		return b.Round < o.Round
+	}
+	return b.Node < o.Node
+}
+
+func (b ballot) query() string {
+	return fmt.Sprintf("round=%d&by=%s", b.Round, url.QueryEscape(b.Node))
+}
+
+// acceptor is one replica's Paxos state for a key.
+type acceptor struct {
+	promised, accepted ballot
+}
+
+// promise is an acceptor's answer to a prepare.
+type promise struct {
+	OK       bool   `json:"ok"`
+	Promised ballot `json:"promised"` // the highest ballot it has promised
+	Accepted ballot `json:"accepted"`
+	Entry    *Entry `json:"entry,omitempty"` // the value it holds, if any
+}
+
+var (
+	acceptors = struct {
+		sync.Mutex
+		byKey map[string]*acceptor
+	}{byKey: map[string]*acceptor{}}
+
+	paxosChosen, paxosPreempted, paxosFailed atomic.Int64
+)
+
+func acceptorFor(key string) *acceptor {
+	a := acceptors.byKey[key]
+	if a == nil {
+		a = &acceptor{}
+		acceptors.byKey[key] = a
+	}
+	return a
+}
+
+// prepareKey promises b for key unless a higher ballot was promised. The
+// value held is the store's copy, accepted under a.accepted (the zero
+// ballot for data written before, or outside, Paxos).
+func prepareKey(key string, b ballot) promise {
+	acceptors.Lock()
+	defer acceptors.Unlock()
+	a := acceptorFor(key)
+	if b.less(a.promised) {
+		return promise{Promised: a.promised}
+	}
+	a.promised = b
+	p := promise{OK: true, Promised: b, Accepted: a.accepted}
+	svc.RLock()
+	if e, ok := svc.intactCopy(key); ok {
+		p.Entry = &e
+	}
+	svc.RUnlock()
+	return p
+}
+
+// acceptKey stores e for key under b unless a higher ballot was promised,
+// returning the highest promise.
+func acceptKey(key string, b ballot, e Entry) (ballot, bool) {
+	acceptors.Lock()
+	defer acceptors.Unlock()
+	a := acceptorFor(key)
+	if b.less(a.promised) {
+		return a.promised, false
+	}
+	a.promised, a.accepted = b, b
+	svc.Lock()
+	svc.put(key, e)
+	svc.Unlock()
+	return b, true
+}
+
+// nextBallot is a ballot for key above every one this node has seen.
+func nextBallot(key string) ballot {
+	acceptors.Lock()
+	defer acceptors.Unlock()
+	return ballot{acceptorFor(key).promised.Round + 1, self}
+}
+
+// seeBallot raises this node's promise for key to b, so its next ballot
+// outbids a proposer that preempted it.
+func seeBallot(key string, b ballot) {
+	acceptors.Lock()
+	defer acceptors.Unlock()
+	if a := acceptorFor(key); a.promised.less(b) {
+		a.promised = b
+	}
+}
+
+// paxosWrite chooses e as key's next value over targets, answering done
+// once a majority has accepted it.
+func paxosWrite(w http.ResponseWriter, tr *reqTrace, ws *writeStatus, targets []string, key string, e Entry, cond writeCond, done int) {
+	majority := (len(targets)+selfAcks(key))/2 + 1
+	tr.setQuorum(majority)
+	ctx, cancel := writeBudget()
+	defer cancel()
+	var replies, acks int
+	for round := 0; round < paxosRounds && ctx.Err() == nil; round++ {
+		if round > 0 {
+			paxosPreempted.Add(1)
+			pause(ctx, time.Duration(rand.Int63n(int64(paxosPause))))
+		}
+		b := nextBallot(key)
+
+		start := time.Now()
+		local := func() (ballot, bool, any, error) {
+			pr := prepareKey(key, b)
+			return pr.Promised, pr.OK, pr, nil
+		}
+		promises := paxosPhase(ctx, tr, targets, majority, onSelf(key, local), func(p string) (ballot, bool, any, error) {
+			pr, err := preparePeer(p, key, b)
+			return pr.Promised, pr.OK, pr, err
+		})
+		observePhase(modePaxos, "prepare", start)
+		replies = promises.answered
+		if !promises.chosen(majority) {
+			seeBallot(key, promises.highest)
+			continue
+		}
+
+		// the value accepted under the highest ballot is the key's current one
+		var cur Entry
+		var have bool
+		var under ballot
+		for _, v := range promises.values {
+			if pr := v.(promise); pr.Entry != nil && (!have || under.less(pr.Accepted) || (under == pr.Accepted && pr.Entry.newerThan(cur))) {
+				cur, have, under = *pr.Entry, true, pr.Accepted
+			}
+		}
+		next := e
+		if cond != nil && !cond(cur, have, &next) {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		// keep timestamps in the chosen order, so last-write-wins tools agree
+		if have && next.Timestamp <= cur.Timestamp {
+			next.Timestamp = cur.Timestamp + 1
+		}
+		stampClock(cur, have, &next)
+
+		start = time.Now()
+		local = func() (ballot, bool, any, error) {
+			promised, ok := acceptKey(key, b, next)
+			return promised, ok, nil, nil
+		}
+		accepts := paxosPhase(ctx, tr, targets, majority, onSelf(key, local), func(p string) (ballot, bool, any, error) {
+			promised, ok, err := acceptPeer(p, key, b, next)
+			return promised, ok, nil, err
+		})
+		observePhase(modePaxos, "accept", start)
+		replies, acks = accepts.answered, accepts.yes
+		if !accepts.chosen(majority) {
+			seeBallot(key, accepts.highest)
+			continue
+		}
+
+		paxosChosen.Add(1)
+		e = next
+		noteWrite(e.Timestamp)
+		if accepts.from[self] {
+			tr.servedLocally()
+		}
+		tr.setEntry(e.Timestamp)
+		ws.begin()
+		for p, ok := range accepts.from {
+			if p != self {
+				ws.replicated(p, ok)
+			}
+		}
+		go accepts.settle(ws)
+		w.Header().Set(writeIDHeader, ws.ID)
+		if !syncLocal(w, e) {
+			return
+		}
+		w.WriteHeader(done)
+		return
+	}
+
+	paxosFailed.Add(1)
+	w.Header().Set(acksHeader, strconv.Itoa(acks))
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		httpError(w, http.StatusGatewayTimeout, "", fmt.Sprintf("paxos: no value chosen within %s", writeTimeout))
+	case replies < majority:
+		httpError(w, http.StatusInternalServerError, "", fmt.Sprintf("paxos: %d of %d replicas answered, a majority is %d", replies, len(targets)+selfAcks(key), majority))
+	default:
+		httpError(w, http.StatusConflict, "", fmt.Sprintf("paxos: preempted by competing proposers %d times", paxosRounds))
+	}
+}
+
+// phaseAnswer is one replica's answer in a Paxos phase.
+type phaseAnswer struct {
+	peer     string
+	start    time.Time
+	promised ballot
+	ok       bool
+	value    any
+	err      error
+}
+
+// phaseResult is what one Paxos phase heard back.
+type phaseResult struct {
+	answered int             // replicas that answered, yes or no
+	yes      int             // replicas that promised or accepted
+	from     map[string]bool // replica -> whether it said yes, for those that answered
+	values   []any           // the answers of those that said yes
+	highest  ballot          // highest promise among the refusals
+
+	late    <-chan phaseAnswer // answers still to come
+	waiting int
+}
+
+func (r phaseResult) chosen(majority int) bool { return r.yes >= majority }
+
+// settle records on ws the accepts that arrive after the value was chosen.
+func (r phaseResult) settle(ws *writeStatus) {
+	for range r.waiting {
+		a := <-r.late
+		ws.replicated(a.peer, a.err == nil && a.ok)
+	}
+}
+
+// phaseCall runs one phase on a replica: its highest promise, whether it
+// said yes, its answer, and an error if it could not be asked.
+type phaseCall func() (ballot, bool, any, error)
+
+// onSelf is local when this node is a replica of key, else nil.
+func onSelf(key string, local phaseCall) phaseCall {
+	if selfAcks(key) == 0 {
+		return nil
+	}
+	return local
+}
+
+// paxosPhase runs one phase on this node (local, unless nil) and on every
+// target, returning once a majority said yes or every replica answered.
+func paxosPhase(ctx context.Context, tr *reqTrace, targets []string, majority int, local phaseCall, remote func(string) (ballot, bool, any, error)) phaseResult {
+	res := phaseResult{from: map[string]bool{}}
+	note := func(a phaseAnswer) {
+		if a.err != nil {
+			res.from[a.peer] = false
+			return
+		}
+		res.answered++
+		res.from[a.peer] = a.ok
+		if a.ok {
+			res.yes++
+			res.values = append(res.values, a.value)
+		} else if res.highest.less(a.promised) {
+			res.highest = a.promised
+		}
+	}
+	if local != nil {
+		b, ok, v, err := local()
+		note(phaseAnswer{self, time.Now(), b, ok, v, err})
+	}
+	answers := make(chan phaseAnswer, len(targets))
+	res.late, res.waiting = answers, len(targets)
+	for _, p := range targets {
+		go func(p string) {
+			start := time.Now()
+			nodeClock.Sleep(LeaderDelayPerFollower)
+			b, ok, v, err := remote(p)
+			answers <- phaseAnswer{p, start, b, ok, v, err}
+		}(p)
+	}
+	for ; res.waiting > 0 && res.yes < majority; res.waiting-- {
+		select {
+		case a := <-answers:
+			tr.peerAck(a.peer, a.start, a.ok)
+			note(a)
+		case <-ctx.Done():
+			return res
+		}
+	}
+	return res
+}
+
+// preparePeer asks peer to promise b for key.
+func preparePeer(peer, key string, b ballot) (promise, error) {
+	path := fmt.Sprintf("/paxos/prepare?key=%s&%s&epoch=%d", url.QueryEscape(key), b.query(), currentEpoch.Load())
+	resp, err := peerNet.Send(context.Background(), peer, path, nil, nil)
+	if err != nil {
+		return promise{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return promise{}, responseError(resp)
+	}
+	var p promise
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return promise{}, err
+	}
+	if p.Entry != nil {
+		if err := checkReceived(key, *p.Entry); err != nil {
+			return promise{}, err
+		}
+	}
+	return p, nil
+}
+
+// acceptPeer asks peer to accept e for key under b, returning its highest
+// promise when it refuses.
+func acceptPeer(peer, key string, b ballot, e Entry) (ballot, bool, error) {
+	path := fmt.Sprintf("/paxos/accept?%s&%s&epoch=%d", entryQuery(peer, key, e), b.query(), currentEpoch.Load())
+	resp, err := peerNet.Send(context.Background(), peer, path, nil, nil)
+	if err != nil {
+		noteReplicationFailed(peer, err)
+		return ballot{}, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		noteReplicated(peer, e.Timestamp)
+		return b, true, nil
+	case http.StatusConflict:
+		var promised ballot
+		json.NewDecoder(resp.Body).Decode(&promised)
+		return promised, false, nil
+	}
+	err = responseError(resp)
+	noteReplicationFailed(peer, err)
+	return ballot{}, false, err
+}
+
+func parseBallot(q url.Values) (ballot, error) {
+	round, err := strconv.ParseInt(q.Get("round"), 10, 64)
+	if err != nil || round <= 0 {
+		return ballot{}, fmt.Errorf("invalid ballot round %q", q.Get("round"))
+	}
+	return ballot{round, q.Get("by")}, nil
+}
+
+// paxosPrepareHandler answers a proposer's prepare with a promise, or a
+// refusal naming the higher ballot promised.
+func paxosPrepareHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	b, err := parseBallot(q)
+	epoch, epochErr := parseEpoch(r)
+	if q.Get("key") == "" || err != nil || epochErr != nil {
+		http.Error(w, "invalid prepare args", http.StatusBadRequest)
+		return
+	}
+	if !observeEpoch(epoch) {
+		rejectStaleEpoch(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prepareKey(q.Get("key"), b))
+}
+
+// paxosAcceptHandler accepts a proposer's value under its ballot, or
+// answers 409 with the higher ballot promised.
+func paxosAcceptHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	b, err := parseBallot(q)
+	ts, tsErr := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+	epoch, epochErr := parseEpoch(r)
+	clock, clockErr := parseClock(q.Get("clock"))
+	if q.Get("key") == "" || err != nil || tsErr != nil || epochErr != nil || clockErr != nil {
+		http.Error(w, "invalid accept args", http.StatusBadRequest)
+		return
+	}
+	if !observeEpoch(epoch) {
+		rejectStaleEpoch(w)
+		return
+	}
+	restorable, _ := strconv.ParseInt(q.Get("restorable"), 10, 64)
+	expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+	e := Entry{Value: q.Get("value"), Timestamp: ts, Deleted: q.Get("deleted") == "true", Node: q.Get("node"), Type: q.Get("type"), Clock: clock, Restorable: restorable, Expires: expires, Owner: q.Get("owner")}
+	if err := parseForwarded(q, &e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkQueryChecksum(r, q.Get("key"), e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nodeClock.Sleep(FollowerUpdateSleep)
+	promised, ok := acceptKey(q.Get("key"), b, e)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(promised)
+		return
+	}
+	if err := syncReplica(r); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// writePaxosMetrics renders the round outcomes and phase timings for
+// /metrics.
+func writePaxosMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP kv_paxos_rounds_total Paxos rounds (-MODE=paxos) by outcome: a value chosen, preempted by a higher ballot and retried, or the write given up.")
+	fmt.Fprintln(w, "# TYPE kv_paxos_rounds_total counter")
+	fmt.Fprintf(w, "kv_paxos_rounds_total{result=\"chosen\"} %d\n", paxosChosen.Load())
+	fmt.Fprintf(w, "kv_paxos_rounds_total{result=\"preempted\"} %d\n", paxosPreempted.Load())
+	fmt.Fprintf(w, "kv_paxos_rounds_total{result=\"failed\"} %d\n", paxosFailed.Load())
+	writePhaseMetrics(w, "kv_paxos_phase_seconds", "Time the proposer spent in each phase of a Paxos round.", modePaxos, "prepare", "accept")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPaxosWrites(t *testing.T) {
+	ports := []int{9179, 9180, 9181}
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	var last *os.Process
+	for _, p := range ports {
+		var others []string
+		for _, q := range ports {
+			if q != p {
+				others = append(others, addr(q))
+			}
+		}
+		n := startNode(t, p, others, false, 3, 1, 1, "-MODE", "paxos")
+		defer n.Process.Kill()
+		last = n.Process
+	}
+	waitReady(t, ports...)
+
+	post := func(p int, path string) int {
+		resp, err := http.Post(fmt.Sprintf("http://%s%s", addr(p), path), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(ports[0], "/set?key=k&value=v"); code != http.StatusCreated {
+		t.Fatalf("paxos write = %d", code)
+	}
+	// any node proposes, and a CAS is checked against the chosen value, not
+	// the proposer's own copy
+	if code := post(ports[1], "/cas?key=k&expected=v&value=w"); code != http.StatusCreated {
+		t.Fatalf("cas through a second node = %d", code)
+	}
+	if code := post(ports[2], "/cas?key=k&expected=v&value=x"); code != http.StatusPreconditionFailed {
+		t.Fatalf("cas on a value already replaced = %d", code)
+	}
+	chosen := 0
+	for _, p := range ports {
+		if e, _ := getEntry(t, fmt.Sprintf("http://%s/local_read?key=k", addr(p))); e.Value == "w" {
+			chosen++
+		}
+	}
+	if chosen < 2 {
+		t.Fatalf("%d replicas accepted the write once it was acked, want a majority", chosen)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", addr(ports[1])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{
+		`kv_paxos_rounds_total{result="chosen"} 1`,
+		`kv_paxos_phase_seconds_count{phase="prepare"} 1`,
+		`kv_paxos_phase_seconds_count{phase="accept"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("/metrics lacks %s", want)
+		}
+	}
+
+	// two of three is still a majority
+	last.Kill()
+	if code := post(ports[0], "/set?key=k&value=y"); code != http.StatusCreated {
+		t.Fatalf("paxos write with a replica gone = %d", code)
+	}
+}
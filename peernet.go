@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replication reaches peers through a Transport rather than calling
+// net/http itself, so the quorum, ordering and resync logic does not know
+// how a request travels and can be driven in a test without sockets. The
+// HTTP transport is the one nodes run with; it goes through
+// http.DefaultClient and so peerTransport, which versions and identifies
+// every request. The in-memory one serves each request straight from a
+// handler registered for the peer's address (usually a fake peer in a
+// test) through the same peerTransport, and can be made to delay, reorder,
+// duplicate and drop requests, drawn from a seeded source so a failing
+// run can be replayed. Answers are *http.Response so
+// busyHint, responseError and friends read them the same either way.
+//
+// There is no gRPC transport, by choice rather than for want of a library:
+// the framing etcd.go hand-rolls over h2c could carry one, but with
+// -PEER_H2C the HTTP transport already multiplexes peer requests over one
+// HTTP/2 connection, and gRPC would only re-encode them as protobuf. One
+// would implement Transport and map the paths below onto its methods.
+
+// Transport carries one node's requests to its peers. path includes the
+// query string.
+type Transport interface {
+	// Send POSTs body (which may be nil) to path on peer, with header
+	// added, and returns the answer with its body read.
+	Send(ctx context.Context, peer, path string, header http.Header, body []byte) (*http.Response, error)
+	// Fetch GETs path from peer and returns the answer with its body read.
+	Fetch(ctx context.Context, peer, path string) (*http.Response, error)
+	// Stream GETs path from peer and returns the answer with its body
+	// open, to be read as it arrives and closed by the caller.
+	Stream(ctx context.Context, peer, path string) (*http.Response, error)
+}
+
+// peerNet is the Transport replication uses.
+var peerNet Transport = httpTransport{http.DefaultClient}
+
+// httpTransport sends peer requests over HTTP with client.
+type httpTransport struct{ client *http.Client }
+
+func (t httpTransport) Send(ctx context.Context, peer, path string, header http.Header, body []byte) (*http.Response, error) {
+	return readAnswer(t.do(ctx, http.MethodPost, peer, path, header, body))
+}
+
+func (t httpTransport) Fetch(ctx context.Context, peer, path string) (*http.Response, error) {
+	return readAnswer(t.do(ctx, http.MethodGet, peer, path, nil, nil))
+}
+
+func (t httpTransport) Stream(ctx context.Context, peer, path string) (*http.Response, error) {
+	return t.do(ctx, http.MethodGet, peer, path, nil, nil)
+}
+
+func (t httpTransport) do(ctx context.Context, method, peer, path string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+peer+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if body != nil {
+		req.Body, req.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+	}
+	return t.client.Do(req)
+}
+
+// readAnswer reads resp's body into memory, so the connection is free
+// before the caller looks at it.
+func readAnswer(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	bs, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(bs))
+	return resp, nil
+}
+
+// memNet is an in-memory network: requests to an address are served by
+// the handler attached to it, subject to faults. A sender whose context
+// ends stops waiting, as over HTTP, while the delivery runs on.
+type memNet struct {
+	mu     sync.RWMutex
+	nodes  map[string]http.Handler
+	faults memFaults
+	rng    *rand.Rand
+
+	delivered, dropped, duplicated, reordered atomic.Int64
+}
+
+// memFaults are the ways a memNet misbehaves. Each is a share of requests,
+// drawn per request.
+type memFaults struct {
+	Latency time.Duration // every delivery waits this long
+	Jitter  time.Duration // and up to this much more, so concurrent requests overtake each other
+
+	Reorder     float64 // held back a further Latency (at least 1ms), letting later requests by
+	Duplicate   float64 // delivered twice; the sender sees the first answer
+	DropRequest float64 // lost on the way: the peer never sees them
+	DropReply   float64 // applied by the peer, but the answer is lost
+}
+
+func newMemNet() *memNet {
+	return &memNet{nodes: map[string]http.Handler{}, rng: rand.New(rand.NewSource(1))}
+}
+
+// inject sets n's faults and reseeds the source they are drawn from.
+func (n *memNet) inject(f memFaults, seed int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.faults, n.rng = f, rand.New(rand.NewSource(seed))
+}
+
+// fate draws what happens to one request.
+type fate struct {
+	delay                                time.Duration
+	reorder, dup, dropRequest, dropReply bool
+}
+
+func (n *memNet) draw() fate {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f := n.faults
+	// every request draws the same numbers whatever the rates, so changing
+	// one rate leaves the other decisions of a seeded run alone
+	jitter, reorder, dup, dropReq, dropRep := n.rng.Float64(), n.rng.Float64(), n.rng.Float64(), n.rng.Float64(), n.rng.Float64()
+	d := fate{
+		delay:       f.Latency + time.Duration(jitter*float64(f.Jitter)),
+		reorder:     reorder < f.Reorder,
+		dup:         dup < f.Duplicate,
+		dropRequest: dropReq < f.DropRequest,
+		dropReply:   dropRep < f.DropReply,
+	}
+	if d.reorder {
+		d.delay += max(f.Latency, time.Millisecond)
+	}
+	return d
+}
+
+// attach serves requests to addr with h; a nil h detaches it, and requests
+// to it fail as if it were down.
+func (n *memNet) attach(addr string, h http.Handler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if h == nil {
+		delete(n.nodes, addr)
+		return
+	}
+	n.nodes[addr] = h
+}
+
+// transport is a Transport over n.
+func (n *memNet) transport() Transport {
+	return httpTransport{&http.Client{Transport: peerTransport{n}}}
+}
+
+// RoundTrip serves req with the handler attached to its host, once the
+// request's fate has played out.
+func (n *memNet) RoundTrip(req *http.Request) (*http.Response, error) {
+	n.mu.RLock()
+	h := n.nodes[req.URL.Host]
+	n.mu.RUnlock()
+	if h == nil {
+		return nil, fmt.Errorf("dial %s: no node attached", req.URL.Host)
+	}
+	type answer struct {
+		resp *http.Response
+		err  error
+	}
+	f := n.draw()
+	ch := make(chan answer, 1)
+	go func() {
+		resp, err := n.deliver(h, req, f)
+		ch <- answer{resp, err}
+	}()
+	select {
+	case a := <-ch:
+		return a.resp, a.err
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+// deliver plays out req's fate f against h.
+func (n *memNet) deliver(h http.Handler, req *http.Request, f fate) (*http.Response, error) {
+	if f.delay > 0 {
+		nodeClock.Sleep(f.delay)
+	}
+	if f.reorder {
+		n.reordered.Add(1)
+	}
+	if f.dropRequest {
+		n.dropped.Add(1)
+		return nil, fmt.Errorf("%s %s: request dropped", req.Method, req.URL.Path)
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	rec := n.serve(h, req, body)
+	n.delivered.Add(1)
+	if f.dup {
+		n.duplicated.Add(1)
+		n.serve(h, req, body)
+	}
+	if f.dropReply {
+		n.dropped.Add(1)
+		return nil, fmt.Errorf("%s %s: reply dropped", req.Method, req.URL.Path)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status)),
+		StatusCode:    rec.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.header,
+		Body:          io.NopCloser(&rec.body),
+		ContentLength: int64(rec.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// serve runs one delivery of req, with body, through h.
+func (n *memNet) serve(h http.Handler, req *http.Request, body []byte) *bufferedResponse {
+	r := req.Clone(req.Context())
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	rec := &bufferedResponse{header: make(http.Header)}
+	h.ServeHTTP(rec, r)
+	rec.WriteHeader(http.StatusOK)
+	return rec
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemNet_ReplicatesWithoutSockets(t *testing.T) {
+	mem := newMemNet()
+	old := peerNet
+	peerNet = mem.transport()
+	defer func() { peerNet = old }()
+
+	var got url.Values
+	var protocol string
+	peer := http.NewServeMux()
+	peer.HandleFunc("/replicate", func(w http.ResponseWriter, r *http.Request) {
+		got, protocol = r.URL.Query(), r.Header.Get(protocolHeader)
+	})
+	peer.HandleFunc("/getReplica", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "k" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(Entry{Value: "v", Timestamp: 7})
+	})
+	mem.attach("mem:1", peer)
+
+	if !replicateTo("mem:1", "k", Entry{Value: "v", Timestamp: 7}) {
+		t.Fatal("replicateTo over memNet failed")
+	}
+	if got.Get("key") != "k" || got.Get("value") != "v" || got.Get("timestamp") != "7" || protocol == "" {
+		t.Fatalf("peer got %v, protocol %q", got, protocol)
+	}
+	if e, found, err := fetchReplica("mem:1", "k"); err != nil || !found || e.Value != "v" {
+		t.Fatalf("fetchReplica = %+v, %v, %v", e, found, err)
+	}
+	if _, found, err := fetchReplica("mem:1", "other"); err != nil || found {
+		t.Fatalf("fetchReplica of a missing key = %v, %v", found, err)
+	}
+
+	mem.attach("mem:1", nil)
+	if replicateTo("mem:1", "k", Entry{Value: "v", Timestamp: 8}) {
+		t.Fatal("replicated to a detached node")
+	}
+}
+
+func TestMemNet_SeededFaults(t *testing.T) {
+	fates := func(seed int64) []fate {
+		mem := newMemNet()
+		mem.inject(memFaults{Jitter: time.Millisecond, Reorder: 0.1, Duplicate: 0.1, DropRequest: 0.1, DropReply: 0.1}, seed)
+		out := make([]fate, 1000)
+		for i := range out {
+			out[i] = mem.draw()
+		}
+		return out
+	}
+	a, b := fates(42), fates(42)
+	if !slices.Equal(a, b) {
+		t.Fatal("the same seed drew different fates")
+	}
+	if slices.Equal(a, fates(43)) {
+		t.Fatal("different seeds drew the same fates")
+	}
+	drops := 0
+	for _, f := range a {
+		if f.dropRequest {
+			drops++
+		}
+	}
+	if drops < 60 || drops > 140 {
+		t.Fatalf("%d of 1000 requests dropped at a 10%% rate", drops)
+	}
+}
+
+// fakeReplica is a follower in miniature: it admits /replicate by origin
+// and sequence number and merges what it admits, like replicateHandler.
+type fakeReplica struct {
+	sync.Mutex
+	data    map[string]Entry
+	applied int
+}
+
+func (f *fakeReplica) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	seen, err := seenWrite(q.Get("origin"), q.Get("seq"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if seen {
+		return
+	}
+	defer commitWrite(q.Get("origin"), q.Get("seq"))
+	ts, _ := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+	in := Entry{Value: q.Get("value"), Timestamp: ts, Node: q.Get("node")}
+	f.Lock()
+	defer f.Unlock()
+	cur, ok := f.data[q.Get("key")]
+	if merged, changed := mergeEntry(q.Get("key"), cur, ok, in); changed {
+		f.data[q.Get("key")] = merged
+	}
+	f.applied++
+}
+
+func TestMemNet_ReplicationConvergesUnderFaults(t *testing.T) {
+	mem := newMemNet()
+	old := peerNet
+	peerNet = mem.transport()
+	defer func() { peerNet = old }()
+
+	// each seed: 40 concurrent writes over 4 keys, resent until acked,
+	// through a network that delays, reorders, duplicates and drops
+	for seed := int64(1); seed <= 100; seed++ {
+		peer := fmt.Sprintf("mem:%d", seed)
+		rep := &fakeReplica{data: map[string]Entry{}}
+		mem.attach(peer, rep)
+		mem.inject(memFaults{Jitter: 200 * time.Microsecond, Reorder: 0.2, Duplicate: 0.2, DropRequest: 0.1, DropReply: 0.1}, seed)
+
+		want := map[string]Entry{}
+		var wg sync.WaitGroup
+		for i := range 40 {
+			key := fmt.Sprintf("k%d", i%4)
+			e := Entry{Value: fmt.Sprint(i), Timestamp: int64(seed*1000 + int64(i)), Node: self, Seq: nextSeq()}
+			if e.newerThan(want[key]) {
+				want[key] = e
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for attempt := 0; !replicateTo(peer, key, e); attempt++ {
+					if attempt == 50 {
+						t.Errorf("seed %d: %s never acked", seed, key)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		for key, e := range want {
+			if got := rep.data[key]; got.Value != e.Value || got.Timestamp != e.Timestamp {
+				t.Fatalf("seed %d: %s = %+v, want %+v", seed, key, got, e)
+			}
+		}
+		if rep.applied != 40 {
+			t.Fatalf("seed %d: %d writes applied, want each of the 40 once", seed, rep.applied)
+		}
+		mem.attach(peer, nil)
+	}
+	if mem.duplicated.Load() == 0 || mem.reordered.Load() == 0 || mem.dropped.Load() == 0 {
+		t.Fatalf("faults never fired: %d duplicated, %d reordered, %d dropped", mem.duplicated.Load(), mem.reordered.Load(), mem.dropped.Load())
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// With -PEER_PORT set, replication, heartbeat and admin endpoints move to
+// their own listener and the client port only serves the client API.
+// Peers then know each other by their peer addresses (-PEERS, -SELF) and
+// learn each other's client address from /ping, so leader hints handed to
+// clients still point at a client port.
+var (
+	clientAddr  string
+	clientAddrs sync.Map // peer address -> client address
+)
+
+// separatePeerPort moves internal routes onto their own Server.
+func separatePeerPort() { peerAPI = NewServer() }
+
+func peerPortSeparate() bool { return peerAPI != api }
+
+// defaultClientAddr is SELF's host with the client port.
+func defaultClientAddr(port int) string {
+	host, _, err := net.SplitHostPort(self)
+	if err != nil {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// clientAddrOf maps a node's peer address to the address clients use.
+func clientAddrOf(peer string) string {
+	if peer == self {
+		return clientAddr
+	}
+	if a, ok := clientAddrs.Load(peer); ok {
+		return a.(string)
+	}
+	return peer
+}
+
+// learnClientAddr records the client address a peer reports in /ping.
+func learnClientAddr(peer string, resp *http.Response) {
+	if a := resp.Header.Get("X-Client-Addr"); a != "" {
+		clientAddrs.Store(peer, a)
+	}
+}
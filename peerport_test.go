@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSeparatePeerPort(t *testing.T) {
+	// client ports 9098/9099, peer ports 9100/9101
+	leader := startNode(t, 9098, []string{"localhost:9101"}, true, 2, 1, 1, "-PEER_PORT", "9100")
+	defer leader.Process.Kill()
+	follower := startNode(t, 9099, []string{"localhost:9100"}, false, 2, 1, 1, "-PEER_PORT", "9101")
+	defer follower.Process.Kill()
+	waitReady(t, 9098, 9099)
+
+	status := func(method, url string) (int, *http.Response) {
+		req, _ := http.NewRequest(method, url, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode, resp
+	}
+
+	// internal endpoints are not on the client port, and vice versa
+	if code, _ := status(http.MethodPost, "http://localhost:9099/replicate?key=k&value=v&timestamp=1&epoch=1"); code != http.StatusNotFound {
+		t.Fatalf("client port /replicate = %d, want 404", code)
+	}
+	if code, _ := status(http.MethodPost, "http://localhost:9101/set?key=k&value=v"); code != http.StatusNotFound {
+		t.Fatalf("peer port /set = %d, want 404", code)
+	}
+	if code, _ := status(http.MethodPost, "http://localhost:9099/leader?addr=localhost:9999&epoch=9"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("client port POST /leader = %d, want 405", code)
+	}
+
+	// replication runs over the peer ports
+	if code, _ := status(http.MethodPost, "http://localhost:9098/set?key=pp&value=1"); code != http.StatusCreated {
+		t.Fatalf("set on leader = %d", code)
+	}
+	time.Sleep(500 * time.Millisecond) // W=1 replicates in the background
+	if e, code := getEntry(t, "http://localhost:9099/local_read?key=pp"); code != http.StatusOK || e.Value != "1" {
+		t.Fatalf("follower has %+v (%d)", e, code)
+	}
+
+	// a follower points clients at the leader's client port
+	_, resp := status(http.MethodPost, "http://localhost:9099/set?key=pp&value=2")
+	if l := resp.Header.Get("X-Leader"); l != "localhost:9098" {
+		t.Fatalf("X-Leader = %q, want localhost:9098", l)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// peerStatus tracks how far replication to one peer has progressed.
+type peerStatus struct {
+	sync.Mutex
+	lastReplicated int64 // timestamp of the newest write the peer has acked
+	lastAckAt      time.Time
+	rtt            time.Duration            // smoothed heartbeat round-trip time
+	rtts           [rttWindow]time.Duration // recent round trips, see latency.go
+	rttNext        int                      // heartbeats folded into rtts
+	reachable      bool                     // whether the last heartbeat got through
+	missedPings    int                      // heartbeats failed in a row, see unreachable.go
+	replOK         int64                    // replications the peer acked
+	replFailed     int64                    // replications that errored or were refused
+	lastError      string
+	lastErrorAt    time.Time
+	busy           int64     // busy answers, see backpressure.go
+	busyUntil      time.Time // no sends before then
+	lastSent       int64     // seq of the last write replicated to the peer, see applyorder.go
+}
+
+// PeerInfo is the JSON view of a peer served on /peers.
+type PeerInfo struct {
+	Addr           string            `json:"addr"`
+	LastReplicated int64             `json:"last_replicated_timestamp"`
+	LastAckAt      string            `json:"last_ack_at,omitempty"`
+	LagSeconds     float64           `json:"lag_seconds"`
+	RTTMillis      float64           `json:"rtt_ms"`
+	RTTP99Millis   float64           `json:"rtt_p99_ms"`
+	TimeoutMillis  float64           `json:"timeout_ms,omitempty"` // per-attempt replication timeout, see latency.go
+	Reachable      bool              `json:"reachable"`
+	Replicated     int64             `json:"replications_ok"`
+	Failed         int64             `json:"replications_failed"`
+	LastError      string            `json:"last_error,omitempty"`
+	LastErrorAt    string            `json:"last_error_at,omitempty"`
+	Busy           int64             `json:"busy_responses"`
+	BusyUntil      string            `json:"busy_until,omitempty"`
+	Protocol       int               `json:"protocol,omitempty"` // newest peer protocol it speaks, see protocol.go
+	NodeID         string            `json:"node_id,omitempty"`  // see identity.go
+	Tags           map[string]string `json:"tags,omitempty"`     // see tags.go
+}
+
+var (
+	peerMu    sync.Mutex
+	peerStats = make(map[string]*peerStatus)
+
+	// newestWrite is the timestamp of the newest write this node coordinated.
+	newestWrite atomic.Int64
+	startedAt   = time.Now()
+)
+
+// statusFor returns the tracked state for peer, creating it on first use.
+// Peers start out considered caught up as of node start.
+func statusFor(peer string) *peerStatus {
+	peerMu.Lock()
+	defer peerMu.Unlock()
+	ps, ok := peerStats[peer]
+	if !ok {
+		ps = &peerStatus{lastReplicated: startedAt.UnixNano()}
+		peerStats[peer] = ps
+	}
+	return ps
+}
+
+// noteWrite records ts as a write coordinated by this node.
+func noteWrite(ts int64) {
+	for {
+		cur := newestWrite.Load()
+		if ts <= cur || newestWrite.CompareAndSwap(cur, ts) {
+			return
+		}
+	}
+}
+
+// noteReplicated records that peer acked the write stamped ts.
+func noteReplicated(peer string, ts int64) {
+	ps := statusFor(peer)
+	ps.Lock()
+	if ts > ps.lastReplicated {
+		ps.lastReplicated = ts
+	}
+	ps.lastAckAt = time.Now()
+	ps.replOK++
+	ps.Unlock()
+}
+
+// responseError describes a refused peer request by its status and the
+// start of its body.
+func responseError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if m := strings.TrimSpace(string(msg)); m != "" {
+		return fmt.Errorf("%s: %s", resp.Status, m)
+	}
+	return errors.New(resp.Status)
+}
+
+// noteReplicationFailed records a replication to peer that failed with err.
+func noteReplicationFailed(peer string, err error) {
+	ps := statusFor(peer)
+	ps.Lock()
+	ps.replFailed++
+	ps.lastError = err.Error()
+	ps.lastErrorAt = time.Now()
+	ps.Unlock()
+}
+
+// peerInfos snapshots the replication state of every configured peer.
+func peerInfos() []PeerInfo {
+	newest := newestWrite.Load()
+	infos := make([]PeerInfo, 0, len(cluster().peers))
+	for _, p := range cluster().peers {
+		ps := statusFor(p)
+		ps.Lock()
+		info := PeerInfo{
+			Addr:           p,
+			LastReplicated: ps.lastReplicated,
+			RTTMillis:      float64(ps.rtt) / float64(time.Millisecond),
+			RTTP99Millis:   float64(ps.rttQuantile(0.99)) / float64(time.Millisecond),
+			Reachable:      ps.reachable,
+			Replicated:     ps.replOK,
+			Failed:         ps.replFailed,
+			LastError:      ps.lastError,
+			Busy:           ps.busy,
+		}
+		if ps.busyUntil.After(time.Now()) {
+			info.BusyUntil = ps.busyUntil.Format(time.RFC3339Nano)
+		}
+		if !ps.lastAckAt.IsZero() {
+			info.LastAckAt = ps.lastAckAt.Format(time.RFC3339Nano)
+		}
+		if !ps.lastErrorAt.IsZero() {
+			info.LastErrorAt = ps.lastErrorAt.Format(time.RFC3339Nano)
+		}
+		ps.Unlock()
+		if v, ok := peerProtocols.Load(p); ok {
+			info.Protocol = v.(int)
+		}
+		info.NodeID = peerNodeID(p)
+		info.TimeoutMillis = float64(replicationTimeout(p)) / float64(time.Millisecond)
+		info.Tags = tagsOf(p)
+		if newest > info.LastReplicated {
+			info.LagSeconds = float64(newest-info.LastReplicated) / float64(time.Second)
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Addr < infos[j].Addr })
+	return infos
+}
+
+// peersHandler reports per-peer replication progress as JSON.
+func peersHandler(w http.ResponseWriter, r *http.Request) {
+	match, err := tagFilter(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "tag", err.Error())
+		return
+	}
+	infos := []PeerInfo{}
+	for _, p := range peerInfos() {
+		if match(p.Tags) {
+			infos = append(infos, p)
+		}
+	}
+	bs, _ := json.Marshal(infos)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
+
+// metricsHandler exposes node state in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP kv_newest_write_timestamp_seconds Timestamp of the newest write coordinated by this node.")
+	fmt.Fprintln(w, "# TYPE kv_newest_write_timestamp_seconds gauge")
+	fmt.Fprintf(w, "kv_newest_write_timestamp_seconds %g\n", float64(newestWrite.Load())/float64(time.Second))
+	fmt.Fprintln(w, "# HELP kv_epoch Newest leader epoch seen by this node.")
+	fmt.Fprintln(w, "# TYPE kv_epoch gauge")
+	fmt.Fprintf(w, "kv_epoch %d\n", currentEpoch.Load())
+	fmt.Fprintln(w, "# HELP kv_http_panics_total Handler panics recovered and answered with a 500.")
+	fmt.Fprintln(w, "# TYPE kv_http_panics_total counter")
+	fmt.Fprintf(w, "kv_http_panics_total %d\n", panicCount.Load())
+	fmt.Fprintln(w, "# HELP kv_replication_duplicates_total Replicated writes dropped as already applied or stale.")
+	fmt.Fprintln(w, "# TYPE kv_replication_duplicates_total counter")
+	fmt.Fprintf(w, "kv_replication_duplicates_total %d\n", duplicateWrites.Load())
+	fmt.Fprintln(w, "# HELP kv_replication_busy_total Replications this node answered busy under -REPLICATE_BUSY.")
+	fmt.Fprintln(w, "# TYPE kv_replication_busy_total counter")
+	fmt.Fprintf(w, "kv_replication_busy_total %d\n", busyAnswers.Load())
+	fmt.Fprintln(w, "# HELP kv_replication_reordered_total Replications held back for the write sent before them, by whether it arrived in -APPLY_ORDER_WAIT.")
+	fmt.Fprintln(w, "# TYPE kv_replication_reordered_total counter")
+	fmt.Fprintf(w, "kv_replication_reordered_total{result=\"in_order\"} %d\n", orderedApplies.Load())
+	fmt.Fprintf(w, "kv_replication_reordered_total{result=\"timed_out\"} %d\n", orderTimeouts.Load())
+	fmt.Fprintln(w, "# HELP kv_hedged_reads_total Extra replica reads R>1 reads sent after waiting out -HEDGE_READS.")
+	fmt.Fprintln(w, "# TYPE kv_hedged_reads_total counter")
+	fmt.Fprintf(w, "kv_hedged_reads_total %d\n", hedgedReads.Load())
+	fmt.Fprintln(w, "# HELP kv_rollbacks_total Undo tombstones sent to replicas for W>1 writes that missed their quorum (-ROLLBACK_FAILED_WRITES).")
+	fmt.Fprintln(w, "# TYPE kv_rollbacks_total counter")
+	fmt.Fprintf(w, "kv_rollbacks_total{result=\"ok\"} %d\n", rollbacksSent.Load())
+	fmt.Fprintf(w, "kv_rollbacks_total{result=\"failed\"} %d\n", rollbacksFailed.Load())
+	fmt.Fprintln(w, "# HELP kv_unreachable_total W>1 writes' replicas skipped as down, and writes refused for want of replicas up, by -UNREACHABLE_POLICY.")
+	fmt.Fprintln(w, "# TYPE kv_unreachable_total counter")
+	fmt.Fprintf(w, "kv_unreachable_total{action=\"skipped\"} %d\n", downSkipped.Load())
+	fmt.Fprintf(w, "kv_unreachable_total{action=\"refused\"} %d\n", downRefused.Load())
+	fmt.Fprintln(w, "# HELP kv_hints_total Writes held for replicas that did not take them under retry-then-hint, by what became of them.")
+	fmt.Fprintln(w, "# TYPE kv_hints_total counter")
+	fmt.Fprintf(w, "kv_hints_total{result=\"stored\"} %d\n", hintsStored.Load())
+	fmt.Fprintf(w, "kv_hints_total{result=\"delivered\"} %d\n", hintsDelivered.Load())
+	fmt.Fprintf(w, "kv_hints_total{result=\"dropped\"} %d\n", hintsDropped.Load())
+	fmt.Fprintln(w, "# HELP kv_hints_pending Writes held for replicas until their heartbeats come back.")
+	fmt.Fprintln(w, "# TYPE kv_hints_pending gauge")
+	fmt.Fprintf(w, "kv_hints_pending %d\n", pendingHints())
+	fmt.Fprintln(w, "# HELP kv_digest_reads_total Reads under -READ_STRATEGY=digest, by whether every replica's digest matched the full copy.")
+	fmt.Fprintln(w, "# TYPE kv_digest_reads_total counter")
+	fmt.Fprintf(w, "kv_digest_reads_total{result=\"match\"} %d\n", digestMatches.Load())
+	fmt.Fprintf(w, "kv_digest_reads_total{result=\"mismatch\"} %d\n", digestMismatches.Load())
+	fmt.Fprintln(w, "# HELP kv_digest_full_fetches_total Full copies digest reads fetched from replicas whose digest could be newer.")
+	fmt.Fprintln(w, "# TYPE kv_digest_full_fetches_total counter")
+	fmt.Fprintf(w, "kv_digest_full_fetches_total %d\n", digestFullFetches.Load())
+	fmt.Fprintln(w, "# HELP kv_replication_timeouts_total Replication attempts abandoned at their peer's adaptive timeout (-PEER_TIMEOUT_FACTOR).")
+	fmt.Fprintln(w, "# TYPE kv_replication_timeouts_total counter")
+	fmt.Fprintf(w, "kv_replication_timeouts_total %d\n", peerTimeouts.Load())
+	fmt.Fprintln(w, "# HELP kv_replication_batches_total Replication rounds the leader sent under -BATCH_WINDOW, and the writes they carried.")
+	fmt.Fprintln(w, "# TYPE kv_replication_batches_total counter")
+	fmt.Fprintf(w, "kv_replication_batches_total %d\n", batchBatches.Load())
+	fmt.Fprintf(w, "kv_replication_batch_entries_total %d\n", batchEntries.Load())
+	fmt.Fprintln(w, "# HELP kv_ttl_expired_total Entries whose ?ttl= ran out, turned into tombstones by this node's sweeper.")
+	fmt.Fprintln(w, "# TYPE kv_ttl_expired_total counter")
+	fmt.Fprintf(w, "kv_ttl_expired_total %d\n", ttlExpired.Load())
+	fmt.Fprintln(w, "# HELP kv_repairs_total Stale replica copies repaired, by trigger (read or admin) and result.")
+	fmt.Fprintln(w, "# TYPE kv_repairs_total counter")
+	for _, t := range []struct {
+		name string
+		c    *repairCounters
+	}{{"read", &readRepairs}, {"admin", &adminRepairs}} {
+		fmt.Fprintf(w, "kv_repairs_total{trigger=%q,result=\"ok\"} %d\n", t.name, t.c.ok.Load())
+		fmt.Fprintf(w, "kv_repairs_total{trigger=%q,result=\"failed\"} %d\n", t.name, t.c.failed.Load())
+	}
+	if maxConns > 0 {
+		fmt.Fprintln(w, "# HELP kv_http_open_connections HTTP connections open, out of -MAX_CONNS.")
+		fmt.Fprintln(w, "# TYPE kv_http_open_connections gauge")
+		fmt.Fprintf(w, "kv_http_open_connections %d\n", openConns.Load())
+	}
+	fmt.Fprintln(w, "# HELP kv_cdc_messages_total Writes published to -CDC, by result; failed attempts are retried.")
+	fmt.Fprintln(w, "# TYPE kv_cdc_messages_total counter")
+	fmt.Fprintf(w, "kv_cdc_messages_total{result=\"published\"} %d\n", cdcPublished.Load())
+	fmt.Fprintf(w, "kv_cdc_messages_total{result=\"failed\"} %d\n", cdcFailed.Load())
+	fmt.Fprintln(w, "# HELP kv_checksum_failures_total Entries failing their checksum, by source: local copies or entries received from peers.")
+	fmt.Fprintln(w, "# TYPE kv_checksum_failures_total counter")
+	fmt.Fprintf(w, "kv_checksum_failures_total{source=\"local\"} %d\n", corruption.local.Load())
+	fmt.Fprintf(w, "kv_checksum_failures_total{source=\"received\"} %d\n", corruption.received.Load())
+	fmt.Fprintln(w, "# HELP kv_checksum_refetches_total Corrupt local copies fetched again from the replicas, by result.")
+	fmt.Fprintln(w, "# TYPE kv_checksum_refetches_total counter")
+	fmt.Fprintf(w, "kv_checksum_refetches_total{result=\"ok\"} %d\n", corruption.refetched.Load())
+	fmt.Fprintf(w, "kv_checksum_refetches_total{result=\"failed\"} %d\n", corruption.refetchFailed.Load())
+	if wal != nil {
+		fmt.Fprintln(w, "# HELP kv_wal_fsyncs_total fsyncs of the write-ahead log.")
+		fmt.Fprintln(w, "# TYPE kv_wal_fsyncs_total counter")
+		fmt.Fprintf(w, "kv_wal_fsyncs_total %d\n", wal.fsyncs.Load())
+		if atRest != nil {
+			fmt.Fprintln(w, "# HELP kv_wal_reencrypted_total Log records re-sealed under the active key on access.")
+			fmt.Fprintln(w, "# TYPE kv_wal_reencrypted_total counter")
+			fmt.Fprintf(w, "kv_wal_reencrypted_total %d\n", reencrypted.Load())
+		}
+	}
+	fmt.Fprintln(w, "# HELP kv_merge_hook_runs_total Merge hook runs, by result; failed runs fall back to LWW.")
+	fmt.Fprintln(w, "# TYPE kv_merge_hook_runs_total counter")
+	fmt.Fprintf(w, "kv_merge_hook_runs_total{result=\"ok\"} %d\n", hookOK.Load())
+	fmt.Fprintf(w, "kv_merge_hook_runs_total{result=\"failed\"} %d\n", hookFailed.Load())
+	writeHTTPMetrics(w)
+	writeLatencyMetrics(w)
+	write2PCMetrics(w)
+	writePaxosMetrics(w)
+	writeAckMetrics(w)
+	writeConcurrencyMetrics(w)
+
+	infos := peerInfos()
+	fmt.Fprintln(w, "# HELP kv_peer_last_replicated_timestamp_seconds Timestamp of the newest write acked by the peer.")
+	fmt.Fprintln(w, "# TYPE kv_peer_last_replicated_timestamp_seconds gauge")
+	for _, p := range infos {
+		fmt.Fprintf(w, "kv_peer_last_replicated_timestamp_seconds{peer=%q} %g\n",
+			p.Addr, float64(p.LastReplicated)/float64(time.Second))
+	}
+	fmt.Fprintln(w, "# HELP kv_peer_replication_lag_seconds How far the peer trails this node's newest write.")
+	fmt.Fprintln(w, "# TYPE kv_peer_replication_lag_seconds gauge")
+	for _, p := range infos {
+		fmt.Fprintf(w, "kv_peer_replication_lag_seconds{peer=%q} %g\n", p.Addr, p.LagSeconds)
+	}
+	fmt.Fprintln(w, "# HELP kv_peer_rtt_seconds Smoothed heartbeat round-trip time to the peer.")
+	fmt.Fprintln(w, "# TYPE kv_peer_rtt_seconds gauge")
+	for _, p := range infos {
+		fmt.Fprintf(w, "kv_peer_rtt_seconds{peer=%q} %g\n", p.Addr, p.RTTMillis/1000)
+	}
+	fmt.Fprintln(w, "# HELP kv_peer_replications_total Replications sent to the peer, by result.")
+	fmt.Fprintln(w, "# TYPE kv_peer_replications_total counter")
+	for _, p := range infos {
+		fmt.Fprintf(w, "kv_peer_replications_total{peer=%q,result=\"ok\"} %d\n", p.Addr, p.Replicated)
+		fmt.Fprintf(w, "kv_peer_replications_total{peer=%q,result=\"failed\"} %d\n", p.Addr, p.Failed)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// getPeers fetches and decodes a node's /peers report
+func getPeers(t *testing.T, port int) []PeerInfo {
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/peers", port))
+	if err != nil {
+		t.Fatalf("GET /peers failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var infos []PeerInfo
+	if err := json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+		t.Fatalf("decode /peers: %v", err)
+	}
+	return infos
+}
+
+func TestPeers_ReplicationLagVisibleThenCleared(t *testing.T) {
+	leaderPort, fPort := 9021, 9022
+	leader := startNode(t, leaderPort, []string{fmt.Sprintf("localhost:%d", fPort)}, true, 2, 1, 1)
+	f := startNode(t, fPort, []string{fmt.Sprintf("localhost:%d", leaderPort)}, false, 2, 1, 1)
+	defer leader.Process.Kill()
+	defer f.Process.Kill()
+	waitReady(t, leaderPort, fPort)
+
+	// W=1 acks before replicating, so the follower must show up as lagging
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/set?key=lag&value=1", leaderPort), "", nil)
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 Created from leader, got %v (%v)", resp, err)
+	}
+	infos := getPeers(t, leaderPort)
+	if len(infos) != 1 || infos[0].LagSeconds <= 0 {
+		t.Fatalf("expected follower to lag right after a W=1 write, got %+v", infos)
+	}
+
+	// once the delayed replication lands the lag should be gone
+	time.Sleep(500 * time.Millisecond)
+	infos = getPeers(t, leaderPort)
+	if infos[0].LagSeconds != 0 {
+		t.Errorf("expected lag to clear after replication, got %+v", infos[0])
+	}
+}
+
+func TestPeers_ReplicationCountersAndLastError(t *testing.T) {
+	leaderPort, fPort, deadPort := 9102, 9103, 9104
+	dead, live := fmt.Sprintf("localhost:%d", deadPort), fmt.Sprintf("localhost:%d", fPort)
+	leader := startNode(t, leaderPort, []string{dead, live}, true, 3, 1, 2)
+	f := startNode(t, fPort, []string{fmt.Sprintf("localhost:%d", leaderPort)}, false, 3, 1, 2)
+	defer leader.Process.Kill()
+	defer f.Process.Kill()
+	waitReady(t, leaderPort, fPort)
+
+	// the dead peer is tried first and fails; the live one makes W=2
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/set?key=cnt&value=1", leaderPort), "", nil)
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 Created from leader, got %v (%v)", resp, err)
+	}
+	byAddr := map[string]PeerInfo{}
+	for _, p := range getPeers(t, leaderPort) {
+		byAddr[p.Addr] = p
+	}
+	if d := byAddr[dead]; d.Failed != 1 || d.Replicated != 0 || d.LastError == "" || d.LastErrorAt == "" {
+		t.Errorf("dead peer: %+v", d)
+	}
+	if l := byAddr[live]; l.Replicated != 1 || l.Failed != 0 || l.LastError != "" {
+		t.Errorf("live peer: %+v", l)
+	}
+}
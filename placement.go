@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// -REPLICAS sets how many nodes hold each key. By default (0) that is
+// every member and writes go to all peers as before. Set below N, the
+// coordinator sends a key only to the nodes on its preference list, the
+// first -REPLICAS members clockwise from the key on the hash ring, so the
+// cluster can grow past the replication factor. R and W then count copies
+// among those replicas and are capped at -REPLICAS. The coordinator keeps
+// its own copy even when it is not a replica, but only counts it towards
+// W when it is; reads on a node that is not a replica ask the replicas.
+// Anti-entropy only exchanges the keys both sides own.
+
+// replicationFactor is -REPLICAS (0 = every member).
+var replicationFactor int
+
+// replicas is how many nodes hold each key.
+func replicas() int { return replicasOf(cluster().N) }
+
+// replicasOf is how many nodes hold each key in a cluster of n.
+func replicasOf(n int) int {
+	if replicationFactor <= 0 || replicationFactor > n {
+		return n
+	}
+	return replicationFactor
+}
+
+// partitioned reports whether keys live on fewer nodes than the cluster.
+func partitioned() bool { return replicationFactor > 0 && replicas() < len(members()) }
+
+// owners lists the nodes that hold key in preference-list order.
+func owners(key string) []string {
+	if cluster().ring == nil {
+		return members()
+	}
+	n := len(members())
+	if partitioned() {
+		n = replicas()
+	}
+	return cluster().ring.PreferenceList(key, n, zoneOf)
+}
+
+// ownedBy reports whether member is one of key's replicas.
+func ownedBy(key, member string) bool {
+	return !partitioned() || slices.Contains(owners(key), member)
+}
+
+// replicaPeers lists the peers a write of key goes to, in -PEERS order
+// when every peer holds every key and preference-list order otherwise.
+func replicaPeers(key string) []string {
+	if !partitioned() {
+		return cluster().peers
+	}
+	var out []string
+	for _, m := range owners(key) {
+		if m != self {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// selfAcks is what the coordinator's own copy of key counts towards a
+// quorum.
+func selfAcks(key string) int {
+	if ownedBy(key, self) {
+		return 1
+	}
+	return 0
+}
+
+// replicaQuorum caps quorum q at the replicas a key has.
+func replicaQuorum(q int) int {
+	if !partitioned() {
+		return q
+	}
+	return min(q, replicas())
+}
+
+type ringMember struct {
+	VNodes int     `json:"vnodes"`
+	Share  float64 `json:"share"` // fraction of the hash space it owns tokens for
+}
+
+type ringReport struct {
+	Replicas int                   `json:"replicas"`
+	Members  map[string]ringMember `json:"members"`
+	Ranges   []TokenRange          `json:"ranges"`
+}
+
+// ringHandler reports the hash ring: every token range and its owner,
+// and each member's vnode count and share of the key space.
+func ringHandler(w http.ResponseWriter, r *http.Request) {
+	report := ringReport{Replicas: replicas(), Members: map[string]ringMember{}, Ranges: cluster().ring.Ranges()}
+	for _, m := range members() {
+		report.Members[m] = ringMember{}
+	}
+	for _, tr := range report.Ranges {
+		m := report.Members[tr.Owner]
+		size := float64(tr.To - tr.From)
+		if tr.From == tr.To {
+			size = 1 << 64 // a lone token owns the whole ring
+		}
+		m.VNodes++
+		m.Share += size / (1 << 64)
+		report.Members[tr.Owner] = m
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+type ownerReport struct {
+	Key    string   `json:"key"`
+	Hash   uint64   `json:"hash"`
+	Owners []string `json:"owners"` // key's preference list, best first
+	Local  bool     `json:"local"`  // whether this node holds key
+}
+
+// ownerHandler reports which nodes hold ?key=.
+func ownerHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	report := ownerReport{Key: key, Hash: hashKey(key), Owners: owners(key), Local: ownedBy(key, self)}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPlacement_WritesGoToThePreferenceListOnly(t *testing.T) {
+	ports := []int{9137, 9138, 9139, 9140}
+	var all []string
+	for _, p := range ports {
+		all = append(all, fmt.Sprintf("localhost:%d", p))
+	}
+	for i, p := range ports {
+		others := slices.Delete(slices.Clone(all), i, i+1)
+		n := startNode(t, p, others, false, 4, 1, 2, "-LEADERLESS", "-REPLICAS=2")
+		defer n.Process.Kill()
+	}
+	waitReady(t, ports...)
+
+	// the nodes' ring, to pick a key the coordinator does not hold
+	r := NewRing(all, 64)
+	sameZone := func(string) string { return "" }
+	var key string
+	var owners []string
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("k%d", i)
+		if owners = r.PreferenceList(key, 2, sameZone); !slices.Contains(owners, all[0]) {
+			break
+		}
+	}
+
+	var owner ownerReport
+	if err := getJSON(fmt.Sprintf("http://%s/owner?key=%s", all[0], key), &owner); err != nil || !slices.Equal(owner.Owners, owners) || owner.Local {
+		t.Fatalf("/owner = %+v, %v; want %v", owner, err, owners)
+	}
+	var rr ringReport
+	if err := getJSON(fmt.Sprintf("http://%s/ring", all[0]), &rr); err != nil || rr.Replicas != 2 || len(rr.Members) != 4 || len(rr.Ranges) != len(r.tokens) {
+		t.Fatalf("/ring = %d members, %d ranges, %v", len(rr.Members), len(rr.Ranges), err)
+	}
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/set?key=%s&value=v", all[0], key), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("W=2 write through a non-replica = %d", resp.StatusCode)
+	}
+	time.Sleep(2 * LeaderDelayPerFollower)
+	for _, m := range all[1:] {
+		e, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=%s", m, key))
+		if slices.Contains(owners, m) != (code == http.StatusOK && e.Value == "v") {
+			t.Fatalf("%s (replica: %v) has %+v (%d)", m, slices.Contains(owners, m), e, code)
+		}
+	}
+
+	// an R=1 read on a node without a copy asks a replica
+	for _, m := range all[1:] {
+		if slices.Contains(owners, m) {
+			continue
+		}
+		if e, code := getEntry(t, fmt.Sprintf("http://%s/get?key=%s", m, key)); code != http.StatusOK || e.Value != "v" {
+			t.Fatalf("read through non-replica %s = %+v (%d)", m, e, code)
+		}
+	}
+}
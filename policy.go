@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Replication policies give a key prefix its own write quorum and
+// durability, -WRITE_POLICIES="cache/=1/async,billing/=quorum/fsync", so
+// one cluster can hold a scratch cache and a ledger. The quorum is a
+// number of replicas, "quorum" (a majority of N) or "all", and is worked
+// out against N at write time, so /config changes carry over; either part
+// may be left empty to keep the node's W or -DURABILITY. The coordinator
+// looks up the longest matching prefix when it takes a write: the quorum
+// replaces W, and the durability replaces -DURABILITY as the default. A
+// policy's fsync is a floor, so ?durability=async cannot lower it.
+// ?consistency= levels and primary-backup mode, which always waits for
+// every backup, are unaffected.
+
+type writePolicy struct {
+	Prefix     string `json:"prefix"`
+	Quorum     string `json:"quorum,omitempty"`
+	Durability string `json:"durability,omitempty"`
+}
+
+// writePolicies are longest prefix first.
+var writePolicies []writePolicy
+
+// configurePolicies applies -WRITE_POLICIES.
+func configurePolicies(spec string) error {
+	writePolicies = nil
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		prefix, rest, ok := strings.Cut(item, "=")
+		if !ok || prefix == "" {
+			return fmt.Errorf("write policy %q: want prefix=quorum/durability", item)
+		}
+		quorum, durability, _ := strings.Cut(rest, "/")
+		switch quorum {
+		case "", "quorum", "all":
+		default:
+			if n, err := strconv.Atoi(quorum); err != nil || n < 1 {
+				return fmt.Errorf("write policy %q: quorum must be a positive number, quorum or all", item)
+			}
+		}
+		if durability != "" && durability != durabilityAsync && durability != durabilityFsync {
+			return fmt.Errorf("write policy %q: %v", item, errUnknownDurability)
+		}
+		writePolicies = append(writePolicies, writePolicy{prefix, quorum, durability})
+	}
+	sort.SliceStable(writePolicies, func(i, j int) bool {
+		return len(writePolicies[i].Prefix) > len(writePolicies[j].Prefix)
+	})
+	return nil
+}
+
+// policyFor returns the policy covering key, if any.
+func policyFor(key string) (writePolicy, bool) {
+	for _, p := range writePolicies {
+		if strings.HasPrefix(key, p.Prefix) {
+			return p, true
+		}
+	}
+	return writePolicy{}, false
+}
+
+// writeQuorum is the W a write of key waits for.
+func writeQuorum(key string) int {
+	p, _ := policyFor(key)
+	switch p.Quorum {
+	case "":
+		return cluster().W
+	case "quorum":
+		return cluster().N/2 + 1
+	case "all":
+		return cluster().N
+	}
+	n, _ := strconv.Atoi(p.Quorum)
+	return n
+}
+
+// writeDurability is parseDurability for a write of key, with its
+// policy's durability as the default and its fsync as a floor.
+func writeDurability(r *http.Request, key string) (bool, error) {
+	p, _ := policyFor(key)
+	d := r.URL.Query().Get("durability")
+	if d == "" || p.Durability == durabilityFsync {
+		d = p.Durability
+	}
+	if d == "" {
+		d = defaultDurability
+	}
+	return checkDurability(d)
+}
+
+// checkPolicies fails for fsync policies on a node without a WAL.
+func checkPolicies() error {
+	for _, p := range writePolicies {
+		if p.Durability == durabilityFsync && wal == nil {
+			return fmt.Errorf("write policy for %q: %v", p.Prefix, errNoWAL)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWritePolicies_Lookup(t *testing.T) {
+	defer configurePolicies("")
+	withCluster(t, func(c *membership) { c.N, c.W = 5, 2 })
+	if err := configurePolicies("cache/=1/async,billing/=quorum/fsync,billing/audit/=all"); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]int{"cache/x": 1, "billing/x": 3, "billing/audit/x": 5, "user/x": 2} {
+		if got := writeQuorum(key); got != want {
+			t.Errorf("writeQuorum(%q) = %d, want %d", key, got, want)
+		}
+	}
+
+	// fsync is a floor: without a WAL even ?durability=async is refused
+	r := httptest.NewRequest(http.MethodPost, "/set?key=billing/x&durability=async", nil)
+	if _, err := writeDurability(r, "billing/x"); err != errNoWAL {
+		t.Errorf("billing write durability err = %v, want %v", err, errNoWAL)
+	}
+	if err := checkPolicies(); err == nil {
+		t.Error("fsync policy accepted without a WAL")
+	}
+	r = httptest.NewRequest(http.MethodPost, "/set?key=cache/x", nil)
+	if fsync, err := writeDurability(r, "cache/x"); err != nil || fsync {
+		t.Errorf("cache write durability = %v, %v", fsync, err)
+	}
+
+	for _, bad := range []string{"=1", "cache/", "cache/=0", "cache/=most", "cache/=1/sometimes"} {
+		if err := configurePolicies(bad); err == nil {
+			t.Errorf("configurePolicies(%q) accepted", bad)
+		}
+	}
+}
+
+func TestWritePolicies_QuorumPerPrefix(t *testing.T) {
+	// the follower never starts, so only writes needing one ack succeed
+	p1, p2 := 9161, 9162
+	a := startNode(t, p1, []string{fmt.Sprintf("localhost:%d", p2)}, true, 2, 1, 2, "-WRITE_POLICIES", "cache/=1", "-WRITE_TIMEOUT", "500ms")
+	defer a.Process.Kill()
+	waitReady(t, p1)
+
+	post := func(key string) int {
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/set?key=%s&value=v", p1, key), "", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post("cache/page"); code != http.StatusCreated {
+		t.Errorf("cache/ write with W=1 policy = %d, want 201", code)
+	}
+	if code := post("user/1"); code == http.StatusCreated {
+		t.Errorf("W=2 write with the only follower down = %d, want a failure", code)
+	}
+}
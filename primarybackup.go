@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Primary-backup mode: the primary (the -LEADER node) numbers every write
+// and pushes it to each backup in order, acking the client once every
+// reachable backup has applied it. Backups apply strictly in sequence and
+// promote themselves when the primary stops heartbeating.
+
+const modePrimaryBackup = "primary-backup"
+
+var (
+	mode            string
+	heartbeatEvery  time.Duration
+	failoverTimeout time.Duration
+
+	pbMu           sync.Mutex // serializes the write stream on both sides
+	pbSeq          int64      // last sequence number written (primary) or applied (backup)
+	pbEpoch        int64      // epoch that produced pbSeq on a backup
+	pbLastContactM sync.Mutex
+	pbLastContact  time.Time
+)
+
+func primaryBackup() bool { return mode == modePrimaryBackup }
+
+func touchPrimary() {
+	pbLastContactM.Lock()
+	pbLastContact = time.Now()
+	pbLastContactM.Unlock()
+}
+
+func sincePrimary() time.Duration {
+	pbLastContactM.Lock()
+	defer pbLastContactM.Unlock()
+	return time.Since(pbLastContact)
+}
+
+// startPrimaryBackup launches the heartbeat and failure-detection loops.
+func startPrimaryBackup() {
+	touchPrimary()
+	pbEpoch = currentEpoch.Load()
+	go func() {
+		wasPrimary := isLeader.Load()
+		for range time.Tick(heartbeatEvery) {
+			if wasPrimary && !isLeader.Load() {
+				touchPrimary() // just stepped down; give the new primary time to show up
+			}
+			wasPrimary = isLeader.Load()
+			if wasPrimary {
+				for _, p := range cluster().peers {
+					go sendHeartbeat(p)
+				}
+				continue
+			}
+			// stagger promotion by rank so backups don't all take over at once
+			if sincePrimary() > failoverTimeout*time.Duration(1+promotionRank()) {
+				promote()
+			}
+		}
+	}()
+}
+
+// promotionRank orders the nodes other than the known primary by address.
+func promotionRank() int {
+	primary := currentLeader()
+	var candidates []string
+	for _, m := range append([]string{self}, cluster().peers...) {
+		if m != primary {
+			candidates = append(candidates, m)
+		}
+	}
+	sort.Strings(candidates)
+	return sort.SearchStrings(candidates, self)
+}
+
+func promote() {
+	pbMu.Lock()
+	defer pbMu.Unlock()
+	old := currentLeader()
+	epoch := currentEpoch.Load() + 1
+	observeEpoch(epoch)
+	isLeader.Store(true)
+	setLeader(self)
+	log.Printf("primary %q silent for %v, promoted to primary at epoch %d (seq %d)",
+		old, sincePrimary(), epoch, pbSeq)
+	for _, p := range cluster().peers {
+		go announceLeader(p, epoch)
+	}
+}
+
+func sendHeartbeat(peer string) {
+	url := fmt.Sprintf("http://%s/pb/heartbeat?from=%s&epoch=%d", peer, self, currentEpoch.Load())
+	postOK(url, "", nil)
+}
+
+// pbHeartbeatHandler records that the primary is alive.
+func pbHeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	epoch, err := parseEpoch(r)
+	if err != nil {
+		http.Error(w, "invalid epoch", http.StatusBadRequest)
+		return
+	}
+	if !observeEpoch(epoch) {
+		rejectStaleEpoch(w)
+		return
+	}
+	setLeader(r.URL.Query().Get("from"))
+	touchPrimary()
+	w.WriteHeader(http.StatusOK)
+}
+
+// pbWrite applies a write on the primary and streams it to every backup.
+// It reports false, writing nothing, if cond rejects the current entry.
+func pbWrite(tr *reqTrace, ws *writeStatus, key string, e Entry, cond writeCond) bool {
+	pbMu.Lock()
+	defer pbMu.Unlock()
+	if !applyLocal(key, &e, cond) {
+		return false
+	}
+	tr.servedLocally()
+	tr.setEntry(e.Timestamp)
+	pbSeq++
+	ws.begin()
+
+	for _, peer := range cluster().peers {
+		start := time.Now()
+		nodeClock.Sleep(LeaderDelayPerFollower)
+		err := pbApplyTo(peer, key, e, pbSeq)
+		tr.peerAck(peer, start, err == nil)
+		ws.replicated(peer, err == nil)
+		if err != nil {
+			noteReplicationFailed(peer, err)
+			log.Printf("backup %s dropped from seq %d: %v", peer, pbSeq, err)
+		}
+	}
+	return true
+}
+
+// pbApplyTo sends one write to a backup, resyncing it first if it has
+// fallen out of sequence.
+func pbApplyTo(peer, key string, e Entry, seq int64) error {
+	path := fmt.Sprintf("/pb/apply?%s&seq=%d&epoch=%d", entryQuery(peer, key, e), seq, currentEpoch.Load())
+	resp, err := peerNet.Send(context.Background(), peer, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		noteReplicated(peer, e.Timestamp)
+		return nil
+	case http.StatusPreconditionFailed:
+		// the local write is already in the store, so a resync covers it
+		if err := pbResync(peer, seq); err != nil {
+			return err
+		}
+		noteReplicated(peer, e.Timestamp)
+		return nil
+	default:
+		observeRejection(resp)
+		return fmt.Errorf("apply returned %d", resp.StatusCode)
+	}
+}
+
+// pbResync replaces a backup's store with the primary's as of seq.
+func pbResync(peer string, seq int64) error {
+	bs, _ := json.Marshal(svc.snapshot())
+	return postBatch(peer, fmt.Sprintf("/pb/resync?seq=%d&epoch=%d", seq, currentEpoch.Load()), bs)
+}
+
+// pbApplyHandler applies the next write in the primary's stream. Out of
+// order writes, and the first write from a new primary, answer 412 so the
+// primary resyncs this backup.
+func pbApplyHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ts, err := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+	seq, seqErr := strconv.ParseInt(q.Get("seq"), 10, 64)
+	epoch, epochErr := parseEpoch(r)
+	clock, clockErr := parseClock(q.Get("clock"))
+	if q.Get("key") == "" || err != nil || seqErr != nil || epochErr != nil || clockErr != nil {
+		http.Error(w, "invalid apply args", http.StatusBadRequest)
+		return
+	}
+	if !observeEpoch(epoch) {
+		rejectStaleEpoch(w)
+		return
+	}
+	touchPrimary()
+
+	pbMu.Lock()
+	defer pbMu.Unlock()
+	if epoch == pbEpoch && seq <= pbSeq {
+		w.WriteHeader(http.StatusOK) // duplicate delivery
+		return
+	}
+	if epoch != pbEpoch || seq != pbSeq+1 {
+		w.Header().Set("X-Seq", strconv.FormatInt(pbSeq, 10))
+		http.Error(w, "out of sequence", http.StatusPreconditionFailed)
+		return
+	}
+	restorable, _ := strconv.ParseInt(q.Get("restorable"), 10, 64)
+	expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+	e := Entry{Value: q.Get("value"), Timestamp: ts, Deleted: q.Get("deleted") == "true", Node: q.Get("node"), Type: q.Get("type"), Clock: clock, Restorable: restorable, Expires: expires, Owner: q.Get("owner")}
+	if err := parseForwarded(q, &e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkQueryChecksum(r, q.Get("key"), e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nodeClock.Sleep(FollowerUpdateSleep)
+	svc.Lock()
+	// merging as the primary did keeps any siblings in step with it
+	cur, ok := svc.intactCopy(q.Get("key"))
+	e, _ = mergeEntry(q.Get("key"), cur, ok, e)
+	svc.put(q.Get("key"), e)
+	svc.Unlock()
+	pbSeq = seq
+	if err := syncReplica(r); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// pbResyncHandler replaces the local store with the primary's snapshot.
+func pbResyncHandler(w http.ResponseWriter, r *http.Request) {
+	seq, err := strconv.ParseInt(r.URL.Query().Get("seq"), 10, 64)
+	epoch, epochErr := parseEpoch(r)
+	if err != nil || epochErr != nil {
+		http.Error(w, "invalid resync args", http.StatusBadRequest)
+		return
+	}
+	if !observeEpoch(epoch) {
+		rejectStaleEpoch(w)
+		return
+	}
+	var entries map[string]Entry
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil || entries == nil {
+		http.Error(w, "invalid resync body", http.StatusBadRequest)
+		return
+	}
+	for k, e := range entries {
+		if err := checkReceived(k, e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	touchPrimary()
+
+	pbMu.Lock()
+	svc.Lock()
+	for k, e := range entries {
+		if cur, ok := svc.data[k]; !ok || !reflect.DeepEqual(cur, e) {
+			changes.publish(k, e)
+		}
+	}
+	svc.data = entries
+	recountUsage(entries)
+	reindex(entries)
+	retrackExpiry(entries)
+	wal.reset(entries)
+	svc.Unlock()
+	pbSeq, pbEpoch = seq, epoch
+	pbMu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPrimaryBackup_OrderedStreamAndPromotion(t *testing.T) {
+	pPort, b1Port, b2Port := 9051, 9052, 9053
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	pb := []string{"-MODE", "primary-backup", "-HEARTBEAT", "50ms", "-FAILOVER_TIMEOUT", "300ms"}
+
+	primary := startNode(t, pPort, []string{addr(b1Port), addr(b2Port)}, true, 3, 1, 3, pb...)
+	b1 := startNode(t, b1Port, []string{addr(pPort), addr(b2Port)}, false, 3, 1, 3, pb...)
+	b2 := startNode(t, b2Port, []string{addr(pPort), addr(b1Port)}, false, 3, 1, 3, pb...)
+	defer primary.Process.Kill()
+	defer b1.Process.Kill()
+	defer b2.Process.Kill()
+	waitReady(t, pPort, b1Port, b2Port)
+
+	set := func(port int, key, val string) int {
+		resp, err := http.Post(fmt.Sprintf("http://%s/set?key=%s&value=%s", addr(port), key, val), "", nil)
+		if err != nil {
+			t.Fatalf("POST /set to %d failed: %v", port, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := set(b1Port, "k", "x"); code != http.StatusBadRequest {
+		t.Errorf("backup accepted a write: got %d", code)
+	}
+	for i := 1; i <= 3; i++ {
+		if code := set(pPort, "k", fmt.Sprint(i)); code != http.StatusCreated {
+			t.Fatalf("write %d: expected 201, got %d", i, code)
+		}
+	}
+	// the primary acks only after every backup applied the write
+	for _, p := range []int{b1Port, b2Port} {
+		if e, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=k", addr(p))); code != http.StatusOK || e.Value != "3" {
+			t.Errorf("backup %d: expected k=3, got %+v (code %d)", p, e, code)
+		}
+	}
+
+	// kill the primary; the lowest-ranked backup should take over
+	primary.Process.Kill()
+	time.Sleep(time.Second)
+	if code := set(b1Port, "k", "4"); code != http.StatusCreated {
+		t.Fatalf("promoted backup: expected 201, got %d", code)
+	}
+	if e, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=k", addr(b2Port))); code != http.StatusOK || e.Value != "4" {
+		t.Errorf("remaining backup: expected k=4 from new primary, got %+v (code %d)", e, code)
+	}
+}
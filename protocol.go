@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Peer requests carry the protocol version they are written in, and every
+// response the versions its node speaks, so a cluster can be upgraded one
+// node at a time. Each side learns from the other's responses (and the
+// startup /handshake) what its peers speak and writes to each in the
+// newest version both understand. A node reads requests from
+// minPeerProtocol up to its own peerProtocol and refuses the rest with
+// 426, naming what it speaks, so the sender can step down and retry.
+// Requests without a version, i.e. from clients, are always served.
+//
+// A change to what peers send each other bumps peerProtocol; the sender
+// checks peerProtocolFor before using it. minPeerProtocol goes up only
+// once no release older than the previous one needs to be upgraded from.
+const (
+	protocolBase        = 1 // replication by query parameters and JSON bodies
+	protocolChecksums   = 2 // entries carry checksums (?crc=, "checksum")
+	protocolSource      = 3 // /replicate names its coordinator (?from=)
+	protocolCompression = 4 // batches may be Content-Encoding: snappy
+	protocolBatches     = 5 // leaders may send /replicate_batch, see batch.go
+	protocolSchema      = 6 // entries keep a newer schema's fields (?schema=, ?extra=), see schema.go
+
+	peerProtocol    = protocolSchema
+	minPeerProtocol = protocolBase
+
+	protocolHeader    = "X-KV-Protocol"
+	minProtocolHeader = "X-KV-Min-Protocol"
+)
+
+// peerProtocols maps a peer's host:port to the newest version it speaks.
+var peerProtocols sync.Map
+
+// peerProtocolFor is the version to write to peer in: the newest both
+// speak, or ours until we know better.
+func peerProtocolFor(peer string) int {
+	if v, ok := peerProtocols.Load(peer); ok {
+		return min(v.(int), peerProtocol)
+	}
+	return peerProtocol
+}
+
+// learnProtocol records the version a peer's response says it speaks.
+func learnProtocol(peer string, resp *http.Response) {
+	v, err := strconv.Atoi(resp.Header.Get(protocolHeader))
+	if err != nil {
+		return
+	}
+	if old, loaded := peerProtocols.Swap(peer, v); loaded && old.(int) == v {
+		return
+	}
+	if v < minPeerProtocol {
+		log.Printf("peer %s speaks protocol %d, older than our oldest (%d); upgrade it", peer, v, minPeerProtocol)
+	} else {
+		log.Printf("peer %s speaks protocol %d; writing to it in %d", peer, v, min(v, peerProtocol))
+	}
+}
+
+// peerTransport stamps outgoing requests with the protocol version for
+// their peer and this node's identity, and learns both from the
+// responses, failing those from another cluster. Requests a node sends
+// itself (e.g. /admin/experiment's writes) skip the identity check: their
+// answer carries this node's own ID, the mark of a copied node.
+type peerTransport struct{ base http.RoundTripper }
+
+func (t peerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	toSelf := req.URL.Host == self || req.URL.Host == clientAddr
+	req = req.Clone(req.Context())
+	req.Header.Set(protocolHeader, strconv.Itoa(peerProtocolFor(req.URL.Host)))
+	if !toSelf {
+		setIdentityHeaders(req.Header)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	learnProtocol(req.URL.Host, resp)
+	if toSelf {
+		return resp, nil
+	}
+	if err := learnIdentity(req.URL.Host, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// usePeerProtocol routes this node's outgoing requests through
+// peerTransport.
+func usePeerProtocol() {
+	http.DefaultClient.Transport = peerTransport{http.DefaultTransport}
+}
+
+// checkPeerProtocol refuses peer requests written in a version this node
+// cannot read.
+func checkPeerProtocol(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(protocolHeader, strconv.Itoa(peerProtocol))
+		w.Header().Set(minProtocolHeader, strconv.Itoa(minPeerProtocol))
+		if v := r.Header.Get(protocolHeader); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < minPeerProtocol || n > peerProtocol {
+				httpError(w, http.StatusUpgradeRequired, protocolHeader,
+					fmt.Sprintf("peer protocol %s not supported; this node speaks %d to %d", v, minPeerProtocol, peerProtocol))
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+type handshake struct {
+	Node        string            `json:"node"`
+	Protocol    int               `json:"protocol"`
+	MinProtocol int               `json:"min_protocol"`
+	Tags        map[string]string `json:"tags,omitempty"` // see tags.go
+	nodeIdentity
+}
+
+func handshakeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(handshake{self, peerProtocol, minPeerProtocol, nodeTags(), identity()})
+}
+
+// handshakePeers asks every peer once, at startup, which versions it
+// speaks and which cluster it is in, retrying those that are not up yet,
+// and then bootstraps the cluster if no one has. Heartbeats keep the
+// answer current after that, e.g. when a peer is upgraded.
+func handshakePeers() {
+	var wg sync.WaitGroup
+	for _, p := range cluster().peers {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			for {
+				var hs handshake
+				err := getJSON("http://"+p+"/handshake", &hs)
+				if err == nil {
+					if hs.Tags != nil {
+						noteTags(p, hs.Tags)
+					}
+					if hs.Protocol < minPeerProtocol || hs.MinProtocol > peerProtocol {
+						log.Printf("peer %s speaks protocol %d to %d, we speak %d to %d: the two cannot replicate",
+							p, hs.MinProtocol, hs.Protocol, minPeerProtocol, peerProtocol)
+					}
+					return
+				}
+				time.Sleep(time.Second)
+			}
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		bootstrapCluster()
+	}()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPeerProtocolCheck(t *testing.T) {
+	h := checkPeerProtocol(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for v, want := range map[string]int{"": 200, "1": 200, "2": 200, "3": 200, "4": 200, "5": 200, "6": 200, "7": http.StatusUpgradeRequired, "0": http.StatusUpgradeRequired, "x": http.StatusUpgradeRequired} {
+		req := httptest.NewRequest(http.MethodPost, "/replicate", nil)
+		if v != "" {
+			req.Header.Set(protocolHeader, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want || rec.Header().Get(protocolHeader) != "6" {
+			t.Errorf("protocol %q: status %d, X-KV-Protocol %q", v, rec.Code, rec.Header().Get(protocolHeader))
+		}
+	}
+}
+
+func TestPeerTransportStepsDown(t *testing.T) {
+	var sent []string
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.Header.Get(protocolHeader))
+		w.Header().Set(protocolHeader, "1") // a node one release behind
+	}))
+	defer old.Close()
+	c := &http.Client{Transport: peerTransport{http.DefaultTransport}}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(old.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	host := strings.TrimPrefix(old.URL, "http://")
+	if strings.Join(sent, ",") != "6,1" || peerProtocolFor(host) != 1 {
+		t.Fatalf("sent %v, now writing %d to it", sent, peerProtocolFor(host))
+	}
+	if q := entryQuery(host, "k", Entry{Value: "v"}); strings.Contains(q, "crc=") {
+		t.Fatalf("checksum sent to a protocol 1 peer: %s", q)
+	}
+}
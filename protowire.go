@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Minimal protobuf wire-format helpers, enough to hand-encode the few
+// messages the gRPC front-end speaks without generated code.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errBadProto = errors.New("malformed protobuf message")
+
+// protoBuf accumulates an encoded message.
+type protoBuf []byte
+
+func (b *protoBuf) tag(field, wire int) {
+	*b = binary.AppendUvarint(*b, uint64(field)<<3|uint64(wire))
+}
+
+// uint writes a varint field, skipping the proto3 default of zero.
+func (b *protoBuf) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, wireVarint)
+	*b = binary.AppendUvarint(*b, v)
+}
+
+func (b *protoBuf) int(field int, v int64) { b.uint(field, uint64(v)) }
+
+func (b *protoBuf) bool(field int, v bool) {
+	if v {
+		b.uint(field, 1)
+	}
+}
+
+// bytes writes a length-delimited field, skipping empty values.
+func (b *protoBuf) bytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	b.message(field, v)
+}
+
+// message writes an embedded message, even an empty one.
+func (b *protoBuf) message(field int, v []byte) {
+	b.tag(field, wireBytes)
+	*b = binary.AppendUvarint(*b, uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+// protoField is one decoded field: num is the field number, and either
+// varint (for varint and fixed wire types) or data (length-delimited) is
+// set.
+type protoField struct {
+	num    int
+	wire   int
+	varint uint64
+	data   []byte
+}
+
+// parseProto splits msg into its fields, in wire order.
+func parseProto(msg []byte) ([]protoField, error) {
+	var out []protoField
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, errBadProto
+		}
+		msg = msg[n:]
+		f := protoField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return nil, errBadProto
+			}
+			f.varint, msg = v, msg[n:]
+		case wireFixed64:
+			if len(msg) < 8 {
+				return nil, errBadProto
+			}
+			f.varint, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case wireFixed32:
+			if len(msg) < 4 {
+				return nil, errBadProto
+			}
+			f.varint, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return nil, errBadProto
+			}
+			f.data, msg = msg[n:n+int(l)], msg[n+int(l):]
+		default:
+			return nil, errBadProto
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
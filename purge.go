@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// /admin/purge erases keys outright, e.g. to honour an erasure request.
+// Unlike /delete it leaves no tombstone: every replica drops the entry,
+// its siblings and its history, and rewrites its WAL without them before
+// confirming. It also drops the keys from the recent changes /ui shows
+// and from the hints held for down peers, and the read-only replicas in
+// this node's -READ_REPLICAS purge them too, so run it on a node that
+// lists them. A replica that could not be reached still holds the key and
+// can hand it back through repair or anti-entropy, so rerun the purge
+// until every node confirms.
+
+type purgeReport struct {
+	Nodes    []nodePurge `json:"nodes"`
+	Complete bool        `json:"complete"` // every node confirmed
+}
+
+type nodePurge struct {
+	Node   string `json:"node"`
+	Purged int    `json:"purged"` // entries removed there
+	Error  string `json:"error,omitempty"`
+}
+
+// purgeTarget is the ?key= keys and the keys under ?prefix=.
+type purgeTarget struct {
+	keys   map[string]bool
+	prefix *string
+}
+
+func (t purgeTarget) has(key string) bool {
+	return t.keys[key] || t.prefix != nil && strings.HasPrefix(key, *t.prefix)
+}
+
+func parsePurgeTarget(q url.Values) (purgeTarget, error) {
+	t := purgeTarget{keys: map[string]bool{}}
+	for _, k := range q["key"] {
+		if err := checkKey(k); err != nil {
+			return t, err
+		}
+		t.keys[k] = true
+	}
+	if p, ok := q["prefix"]; ok {
+		if p[0] == "" {
+			// an empty prefix would match, and erase, every key
+			return t, fmt.Errorf("prefix must not be empty")
+		}
+		t.prefix = &p[0]
+	} else if len(t.keys) == 0 {
+		return t, fmt.Errorf("key or prefix required")
+	}
+	return t, nil
+}
+
+// purgeLocal removes the target's entries from the store and the WAL, the
+// recent changes and the held hints.
+func purgeLocal(t purgeTarget) (int, error) {
+	changes.forget(t.has)
+	dropHints(t.has)
+	svc.Lock()
+	defer svc.Unlock()
+	n := 0
+	for k := range svc.data {
+		if t.has(k) {
+			account(k, svc.data[k], -1)
+			indexEntry(k, svc.data[k], -1)
+			delete(svc.data, k)
+			delete(expiring, k)
+			n++
+		}
+	}
+	if n == 0 || wal == nil {
+		return n, nil
+	}
+	// under the store lock, so no write slips in between
+	return n, wal.rewrite(svc.data)
+}
+
+// purgeHandler purges the local copies for a peer running /admin/purge.
+func purgeHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := parsePurgeTarget(r.URL.Query())
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "key", err.Error())
+		return
+	}
+	n, err := purgeLocal(t)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodePurge{Node: self, Purged: n})
+}
+
+// adminPurgeHandler purges ?key= keys, or the keys under ?prefix=, on
+// this node, every peer and every read-only replica, waiting for each to
+// confirm.
+func adminPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := parsePurgeTarget(r.URL.Query())
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "key", err.Error())
+		return
+	}
+	report := purgeReport{Complete: true}
+	var mu sync.Mutex
+	done := func(np nodePurge) {
+		mu.Lock()
+		defer mu.Unlock()
+		report.Nodes = append(report.Nodes, np)
+		report.Complete = report.Complete && np.Error == ""
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range append(slices.Clone(cluster().peers), readReplicas...) {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			np := nodePurge{Node: p}
+			resp, err := http.Post(fmt.Sprintf("http://%s/purge?%s", p, r.URL.RawQuery), "", nil)
+			if err == nil {
+				if resp.StatusCode == http.StatusOK {
+					err = json.NewDecoder(resp.Body).Decode(&np)
+				} else {
+					err = responseError(resp)
+				}
+				resp.Body.Close()
+			}
+			if err != nil {
+				np.Error = err.Error()
+			}
+			np.Node = p
+			done(np)
+		}(p)
+	}
+	np := nodePurge{Node: self}
+	if np.Purged, err = purgeLocal(t); err != nil {
+		np.Error = err.Error()
+	}
+	done(np)
+	wg.Wait()
+
+	sort.Slice(report.Nodes, func(i, j int) bool { return report.Nodes[i].Node < report.Nodes[j].Node })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
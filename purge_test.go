@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPurgeRemovesEveryTrace(t *testing.T) {
+	p1, p2, p3 := 9118, 9119, 9186
+	walPath := filepath.Join(t.TempDir(), "b.wal")
+	a := startNode(t, p1, []string{fmt.Sprintf("localhost:%d", p2)}, true, 2, 1, 2, fmt.Sprintf("-READ_REPLICAS=localhost:%d", p3))
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{fmt.Sprintf("localhost:%d", p1)}, false, 2, 1, 2, "-WAL", walPath)
+	defer b.Process.Kill()
+	c := startNode(t, p3, []string{fmt.Sprintf("localhost:%d", p1), fmt.Sprintf("localhost:%d", p2)}, false, 3, 1, 1, "-READ_ONLY")
+	defer c.Process.Kill()
+	waitReady(t, p1, p2, p3)
+
+	for _, q := range []string{"/set?key=user/1&value=alice", "/set?key=user/2&value=bob", "/delete?key=user/2", "/set?key=keep&value=k"} {
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d%s", p1, q), "", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		resp.Body.Close()
+	}
+	time.Sleep(2 * LeaderDelayPerFollower) // the read replica is fed in the background
+
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/admin/purge?prefix=user/", p1), "", nil)
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	var report purgeReport
+	json.NewDecoder(resp.Body).Decode(&report)
+	resp.Body.Close()
+	if !report.Complete || len(report.Nodes) != 3 {
+		t.Fatalf("report = %+v, want all three nodes to confirm", report)
+	}
+	for _, np := range report.Nodes {
+		if np.Purged != 2 {
+			t.Errorf("%s purged %d entries, want 2", np.Node, np.Purged)
+		}
+	}
+
+	for _, port := range []int{p1, p2, p3} {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/dump", port))
+		if err != nil {
+			t.Fatalf("dump: %v", err)
+		}
+		bs, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if strings.Contains(string(bs), "user/") || !strings.Contains(string(bs), `"keep"`) {
+			t.Errorf("dump of %d after purge = %s", port, bs)
+		}
+	}
+	if bs, _ := os.ReadFile(walPath); strings.Contains(string(bs), "alice") || strings.Contains(string(bs), "user/2") {
+		t.Errorf("WAL still holds purged keys: %s", bs)
+	}
+}
+
+func TestPurgeDropsRecentChangesAndHints(t *testing.T) {
+	changes.publish("gone/1", Entry{Value: "a", Timestamp: 1})
+	changes.publish("kept/1", Entry{Value: "b", Timestamp: 2})
+	holdHint("localhost:1", "gone/1", Entry{Value: "a", Timestamp: 1})
+	holdHint("localhost:1", "kept/1", Entry{Value: "b", Timestamp: 2})
+	defer dropHints(func(string) bool { return true })
+
+	prefix := "gone/"
+	if _, err := purgeLocal(purgeTarget{prefix: &prefix}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range changes.latest() {
+		if c.Key == "gone/1" {
+			t.Errorf("/ui's recent changes still show %q", c.Key)
+		}
+	}
+	hints.Lock()
+	_, gone := hints.byPeer["localhost:1"]["gone/1"]
+	_, kept := hints.byPeer["localhost:1"]["kept/1"]
+	hints.Unlock()
+	if gone || !kept {
+		t.Errorf("held hints after purge: gone/1 %v, kept/1 %v", gone, kept)
+	}
+}
+
+func TestParsePurgeTargetNeedsAKeyOrPrefix(t *testing.T) {
+	for _, q := range []string{"", "prefix=", "key=&prefix=user/"} {
+		v, _ := url.ParseQuery(q)
+		if _, err := parsePurgeTarget(v); err == nil {
+			t.Errorf("parsePurgeTarget(%q) accepted it", q)
+		}
+	}
+	v, _ := url.ParseQuery("prefix=user/")
+	if tg, err := parsePurgeTarget(v); err != nil || !tg.has("user/1") || tg.has("other") {
+		t.Errorf("parsePurgeTarget(prefix=user/) = %v", err)
+	}
+}
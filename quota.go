@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Quotas cap the live keys and bytes (key plus value) held under a key
+// prefix, -NAMESPACE_QUOTAS="user/=1000/10MB,tmp/=/1MB", or written by an
+// API token, -TOKEN_QUOTAS="sha256:1f2e3d4c5b6a=500/5MB", tokens given by
+// the fingerprint the audit trail shows. Either limit may be left empty.
+// A write that would add a key past the limit is answered 429, one that
+// would grow past the byte limit 413; deletes always go through. Every
+// entry remembers the token that last wrote it (Entry.Owner), so each
+// replica can count per-token usage from its own store. The check runs on
+// the coordinator before the write, against its own counts, so concurrent
+// writes near a limit can overshoot it slightly.
+
+type quotaLimit struct {
+	Keys  int64 `json:"max_keys,omitempty"`
+	Bytes int64 `json:"max_bytes,omitempty"`
+}
+
+type quotaUsage struct {
+	Keys  int64 `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+type prefixQuota struct {
+	prefix string
+	quotaLimit
+}
+
+// Usage is updated by Store.put under the store lock.
+var (
+	namespaceQuotas []prefixQuota
+	tokenQuotas     = map[string]quotaLimit{}
+	namespaceUsage  = map[string]*quotaUsage{}
+	tokenUsage      = map[string]*quotaUsage{}
+)
+
+func entrySize(key string, e Entry) int64 {
+	n := int64(len(key))
+	for _, v := range e.versions() {
+		n += int64(len(v.Value))
+	}
+	return n
+}
+
+// account adds (sign 1) or removes (sign -1) e's share of the usage.
+func account(key string, e Entry, sign int64) {
+	if !e.live() {
+		return
+	}
+	size := entrySize(key, e)
+	add := func(m map[string]*quotaUsage, name string) {
+		u := m[name]
+		if u == nil {
+			u = &quotaUsage{}
+			m[name] = u
+		}
+		u.Keys += sign
+		u.Bytes += sign * size
+	}
+	for _, q := range namespaceQuotas {
+		if strings.HasPrefix(key, q.prefix) {
+			add(namespaceUsage, q.prefix)
+		}
+	}
+	if e.Owner != "" {
+		add(tokenUsage, e.Owner)
+	}
+}
+
+// recountUsage recomputes the usage after the store was replaced.
+func recountUsage(data map[string]Entry) {
+	clear(namespaceUsage)
+	clear(tokenUsage)
+	for k, e := range data {
+		account(k, e, 1)
+	}
+}
+
+// checkQuota reports the status to reject e with when writing it to key
+// would exceed a quota, or 0.
+func checkQuota(key string, e Entry) (int, error) {
+	if e.Deleted {
+		return 0, nil
+	}
+	svc.RLock()
+	defer svc.RUnlock()
+	cur, ok := svc.data[key]
+	live := ok && cur.live()
+	size := entrySize(key, e)
+	over := func(what string, lim quotaLimit, u *quotaUsage, mine bool) (int, error) {
+		if u == nil {
+			u = &quotaUsage{}
+		}
+		keys, bytes := u.Keys+1, u.Bytes+size
+		if mine {
+			keys, bytes = u.Keys, bytes-entrySize(key, cur)
+		}
+		if lim.Keys > 0 && keys > lim.Keys {
+			return http.StatusTooManyRequests, fmt.Errorf("%s is at its quota of %d keys", what, lim.Keys)
+		}
+		if lim.Bytes > 0 && bytes > lim.Bytes && bytes > u.Bytes {
+			return http.StatusRequestEntityTooLarge, fmt.Errorf("%s would exceed its quota of %d bytes", what, lim.Bytes)
+		}
+		return 0, nil
+	}
+	for _, q := range namespaceQuotas {
+		if strings.HasPrefix(key, q.prefix) {
+			if status, err := over("namespace "+strconv.Quote(q.prefix), q.quotaLimit, namespaceUsage[q.prefix], live); err != nil {
+				return status, err
+			}
+		}
+	}
+	if lim, limited := tokenQuotas[e.Owner]; limited && e.Owner != "" {
+		return over("token "+e.Owner, lim, tokenUsage[e.Owner], live && cur.Owner == e.Owner)
+	}
+	return 0, nil
+}
+
+// parseQuotas reads a comma-separated list of name=keys/bytes.
+func parseQuotas(spec string, each func(name string, lim quotaLimit)) error {
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, limits, ok := strings.Cut(item, "=")
+		keys, bytes, ok2 := strings.Cut(limits, "/")
+		if !ok || !ok2 || name == "" {
+			return fmt.Errorf("quota %q: want name=keys/bytes", item)
+		}
+		var lim quotaLimit
+		var err error
+		if keys != "" {
+			if lim.Keys, err = strconv.ParseInt(keys, 10, 64); err != nil || lim.Keys < 0 {
+				return fmt.Errorf("quota %q: bad key limit", item)
+			}
+		}
+		if bytes != "" {
+			if lim.Bytes, err = parseBytes(bytes); err != nil {
+				return fmt.Errorf("quota %q: %v", item, err)
+			}
+		}
+		each(name, lim)
+	}
+	return nil
+}
+
+// parseBytes reads a size such as 512, 64KB, 10MB or 1GB (powers of 1024).
+func parseBytes(s string) (int64, error) {
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}} {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, mult = n, u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad byte limit %q", s)
+	}
+	return n * mult, nil
+}
+
+func configureQuotas(namespaces, tokens string) error {
+	namespaceQuotas = nil
+	err := parseQuotas(namespaces, func(prefix string, lim quotaLimit) {
+		namespaceQuotas = append(namespaceQuotas, prefixQuota{prefix, lim})
+	})
+	if err != nil {
+		return err
+	}
+	clear(tokenQuotas)
+	return parseQuotas(tokens, func(token string, lim quotaLimit) { tokenQuotas[token] = lim })
+}
+
+type quotaStat struct {
+	Name string `json:"name"`
+	quotaUsage
+	quotaLimit
+}
+
+type storeStats struct {
+	Namespaces []quotaStat  `json:"namespaces"`
+	Tokens     []quotaStat  `json:"tokens"`
+	Store      *storeSeries `json:"store,omitempty"` // see stats.go
+}
+
+// statsHandler reports this node's usage per namespace and token, with
+// their quotas, and the stats collector's samples.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	out := storeStats{Namespaces: []quotaStat{}, Tokens: []quotaStat{}}
+	svc.RLock()
+	for _, q := range namespaceQuotas {
+		st := quotaStat{Name: q.prefix, quotaLimit: q.quotaLimit}
+		if u := namespaceUsage[q.prefix]; u != nil {
+			st.quotaUsage = *u
+		}
+		out.Namespaces = append(out.Namespaces, st)
+	}
+	for tok, u := range tokenUsage {
+		out.Tokens = append(out.Tokens, quotaStat{Name: tok, quotaUsage: *u, quotaLimit: tokenQuotas[tok]})
+	}
+	for tok, lim := range tokenQuotas {
+		if tokenUsage[tok] == nil {
+			out.Tokens = append(out.Tokens, quotaStat{Name: tok, quotaLimit: lim})
+		}
+	}
+	svc.RUnlock()
+	sort.Slice(out.Tokens, func(i, j int) bool { return out.Tokens[i].Name < out.Tokens[j].Name })
+	out.Store = recentStats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
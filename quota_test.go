@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestQuotas(t *testing.T) {
+	oldData := svc.data
+	defer func() {
+		svc.data = oldData
+		configureQuotas("", "")
+		recountUsage(svc.data)
+	}()
+	if err := configureQuotas("tmp/=2/1KB", "sha256:abc=/10"); err != nil {
+		t.Fatal(err)
+	}
+	svc.data = map[string]Entry{}
+	recountUsage(svc.data)
+
+	svc.Lock()
+	svc.put("tmp/a", Entry{Value: "x"})
+	svc.put("tmp/b", Entry{Value: "y", Owner: "sha256:abc"})
+	svc.Unlock()
+
+	if status, _ := checkQuota("tmp/c", Entry{Value: "z"}); status != http.StatusTooManyRequests {
+		t.Fatalf("third key under tmp/ = %d, want 429", status)
+	}
+	if status, err := checkQuota("tmp/a", Entry{Value: "overwrite"}); status != 0 {
+		t.Fatalf("overwriting an existing key = %d %v", status, err)
+	}
+	if status, _ := checkQuota("tmp/a", Entry{Value: string(make([]byte, 2000))}); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("value past the byte quota = %d, want 413", status)
+	}
+	if status, _ := checkQuota("other", Entry{Value: "0123456789", Owner: "sha256:abc"}); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("token past its byte quota = %d, want 413", status)
+	}
+	if status, _ := checkQuota("tmp/c", Entry{Deleted: true}); status != 0 {
+		t.Fatalf("delete refused with %d", status)
+	}
+
+	svc.Lock()
+	svc.put("tmp/a", Entry{Deleted: true})
+	svc.Unlock()
+	if u := namespaceUsage["tmp/"]; u.Keys != 1 || u.Bytes != int64(len("tmp/b")+1) {
+		t.Fatalf("usage after delete = %+v", u)
+	}
+}
+
+func TestParseQuotas(t *testing.T) {
+	for _, bad := range []string{"tmp/", "tmp/=x/1", "tmp/=1/1TB", "=1/1"} {
+		if err := configureQuotas(bad, ""); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+	configureQuotas("", "")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Read-only replicas are a cheap tier for reads and analytics: they get
+// every write but take no part in writing. A voting node lists them in
+// -READ_REPLICAS; they are not in N, the hash ring or any quorum, and the
+// coordinator of each write sends it to them in the background once it is
+// applied, the way W=1 sends to followers (quorum mode only; a
+// primary-backup stream has no room for them). The replica itself runs with
+// -READ_ONLY and -PEERS naming the voting nodes (plus -LEADERLESS if they
+// use it, so it takes replication from any of them): it serves reads and
+// refuses client writes with 403, and anti-entropy on it only pulls.
+
+var (
+	readReplicas []string // -READ_REPLICAS
+	readOnly     bool     // -READ_ONLY
+)
+
+func parseReadReplicas(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// rejectReadOnlyWrite answers a client write sent to a read-only replica.
+func rejectReadOnlyWrite(w http.ResponseWriter) {
+	w.Header().Set(notLeaderHeader, "true")
+	if l := currentLeader(); l != "" && l != self {
+		w.Header().Set("X-Leader", clientAddrOf(l))
+	}
+	httpError(w, http.StatusForbidden, "", "this node is a read-only replica; write to a voting node")
+}
+
+// feedReadReplicas sends e, applied here, to every read-only replica
+// without waiting. They are not voting replicas, so /write_status leaves
+// them out.
+func feedReadReplicas(key string, e Entry) {
+	for _, rr := range readReplicas {
+		asyncRepl.Add(1)
+		go func(p string) {
+			defer asyncRepl.Done()
+			nodeClock.Sleep(LeaderDelayPerFollower)
+			replicateTo(p, key, e)
+		}(rr)
+	}
+}
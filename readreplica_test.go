@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReadReplicaGetsWritesButNoVote(t *testing.T) {
+	p1, p2, p3 := 9150, 9151, 9152
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	a := startNode(t, p1, []string{addr(p2)}, true, 2, 1, 2, "-READ_REPLICAS="+addr(p3))
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{addr(p1)}, false, 2, 1, 2)
+	defer b.Process.Kill()
+	c := startNode(t, p3, []string{addr(p1), addr(p2)}, false, 3, 1, 1, "-READ_ONLY")
+	defer c.Process.Kill()
+	waitReady(t, p1, p2, p3)
+
+	post := func(node int, q string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(fmt.Sprintf("http://%s/set?%s", addr(node), q), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := post(p1, "key=k&value=v"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("write = %d", resp.StatusCode)
+	}
+	time.Sleep(2 * LeaderDelayPerFollower)
+	if e, code := getEntry(t, fmt.Sprintf("http://%s/get?key=k", addr(p3))); code != http.StatusOK || e.Value != "v" {
+		t.Fatalf("read replica has %+v (%d)", e, code)
+	}
+	if resp := post(p3, "key=k&value=w"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("write to the read replica = %d", resp.StatusCode)
+	}
+
+	// with the only other voter down, the replica's copy does not make W=2
+	b.Process.Kill()
+	time.Sleep(100 * time.Millisecond)
+	if resp := post(p1, "key=k&value=x"); resp.StatusCode/100 == 2 || resp.Header.Get(acksHeader) != "1" {
+		t.Fatalf("W=2 with one voter = %d, %s acks", resp.StatusCode, resp.Header.Get(acksHeader))
+	}
+}
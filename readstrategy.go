@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// -READ_STRATEGY picks how an R>1 read fans out to the replicas:
+//
+//	first-r     ask the R nearest (by heartbeat RTT), fall back to the next
+//	            one when a replica fails or lacks the key; the default
+//	all-wait-r  ask every replica at once and take the first R answers,
+//	            spending bandwidth on the extra reads to hide slow ones
+//	digest      fetch the entry from the nearest replica (this node, when
+//	            it holds the key) and only a digest from R-1 more
+//
+// so the latency and bandwidth of each can be compared on one cluster.
+// Reads with R=1 on a replica only ever read locally.
+//
+// A digest is a copy's checksum and (timestamp, node). Digests that match
+// the full copy end the read. One that differs is fetched in full only
+// when it could hold something the full copy lacks; under last-write-wins
+// an older digest cannot, so that replica is just repaired with the full
+// copy (-READ_REPAIR), like every other copy the merged result changes.
+
+const (
+	readFirstR   = "first-r"
+	readAllWaitR = "all-wait-r"
+	readDigest   = "digest"
+
+	digestHeader = "X-Digest"
+)
+
+var (
+	readStrategy = readFirstR // -READ_STRATEGY
+
+	digestMatches, digestMismatches atomic.Int64 // digest reads by whether every digest matched
+	digestFullFetches               atomic.Int64 // full copies a digest mismatch fetched
+)
+
+// configureReadStrategy checks and sets -READ_STRATEGY.
+func configureReadStrategy(s string) error {
+	switch s {
+	case readFirstR, readAllWaitR, readDigest:
+		readStrategy = s
+		return nil
+	}
+	return fmt.Errorf("-READ_STRATEGY must be %s, %s or %s, not %q", readFirstR, readAllWaitR, readDigest, s)
+}
+
+// digest stands in for a replica's copy of a key on a digest read.
+type digest struct {
+	Checksum  uint32 `json:"checksum"`
+	Timestamp int64  `json:"timestamp"`
+	Node      string `json:"node,omitempty"`
+}
+
+func digestOf(key string, e Entry) digest {
+	sum := e.Checksum
+	if sum == 0 {
+		sum = e.sum(key)
+	}
+	return digest{sum, e.Timestamp, e.Node}
+}
+
+// staleBy reports whether d is a copy best already supersedes, so that
+// reading it in full could not change the result.
+func (d digest) staleBy(key string, best Entry) bool {
+	if best.Type != "" || resolverFor(key) != (lww{}) {
+		return false // CRDTs and siblings merge; anything may add to best
+	}
+	return best.newerThan(Entry{Timestamp: d.Timestamp, Node: d.Node})
+}
+
+// writeDigest answers a /getReplica?digest=true with e's digest alone.
+func writeDigest(w http.ResponseWriter, key string, e Entry) {
+	w.Header().Set(digestHeader, "true")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(digestOf(key, e))
+}
+
+// fetchDigest reads the digest of peer's copy of key. found is false,
+// with a nil error, when the peer does not have the key. A peer that
+// predates digests answers with its whole entry, which does as well.
+func fetchDigest(peer, key string) (d digest, found bool, err error) {
+	resp, err := peerNet.Fetch(context.Background(), peer, "/getReplica?digest=true&key="+url.QueryEscape(key))
+	if err != nil {
+		return digest{}, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return digest{}, false, nil
+	default:
+		return digest{}, false, responseError(resp)
+	}
+	if resp.Header.Get(digestHeader) != "true" {
+		var e Entry
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+			return digest{}, false, err
+		}
+		return digestOf(key, e), true, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return digest{}, false, err
+	}
+	return d, true, nil
+}
+
+// digestRead is readKey under -READ_STRATEGY=digest. candidates are the
+// peers holding key, nearest first.
+func digestRead(tr *reqTrace, key string, rq int, local bool, candidates []string) (Entry, bool) {
+	tr.setQuorum(rq)
+	var source replicaCopy
+	have := false
+	if local {
+		tr.servedLocally()
+		e, ok := localCopy(key)
+		source, have = replicaCopy{"", e, ok}, true
+	}
+	for !have && len(candidates) > 0 {
+		p := candidates[0]
+		candidates = candidates[1:]
+		start := time.Now()
+		e, ok, err := fetchReplica(p, key)
+		tr.peerAck(p, start, ok)
+		if err != nil {
+			tr.retried()
+			continue
+		}
+		source, have = replicaCopy{p, e, ok}, true
+	}
+	if !have {
+		return Entry{}, false
+	}
+	best, found := source.e, source.found
+
+	// digests from rq-1 more, falling back to farther replicas for any
+	// that cannot be reached
+	type answer struct {
+		peer  string
+		d     digest
+		found bool
+		err   error
+	}
+	want := digestOf(key, best)
+	var differ []answer
+	for need := rq - 1; need > 0 && len(candidates) > 0; {
+		ask := candidates[:min(need, len(candidates))]
+		candidates = candidates[len(ask):]
+		ch := make(chan answer, len(ask))
+		for _, p := range ask {
+			go func() {
+				start := time.Now()
+				d, ok, err := fetchDigest(p, key)
+				tr.peerAck(p, start, err == nil)
+				ch <- answer{p, d, ok, err}
+			}()
+		}
+		for range ask {
+			a := <-ch
+			if a.err != nil {
+				tr.retried()
+				continue
+			}
+			need--
+			if a.found != found || (found && a.d.Checksum != want.Checksum) {
+				differ = append(differ, a)
+			}
+		}
+	}
+	if len(differ) == 0 {
+		digestMatches.Add(1)
+		return best, found && best.live()
+	}
+	digestMismatches.Add(1)
+
+	// read in full the copies that could add to best; the rest are stale
+	// and only need repairing
+	copies := []replicaCopy{source}
+	for _, a := range differ {
+		if !a.found || (found && a.d.staleBy(key, best)) {
+			copies = append(copies, replicaCopy{a.peer, Entry{}, false})
+			continue
+		}
+		digestFullFetches.Add(1)
+		if e, ok, err := fetchReplica(a.peer, key); err == nil {
+			copies = append(copies, replicaCopy{a.peer, e, ok})
+			if ok {
+				best, _ = mergeEntry(key, best, found, e)
+				found = true
+			}
+		}
+	}
+	if found && readRepairOn {
+		go repairStale(&readRepairs, key, best, copies)
+	}
+	return best, found && best.live()
+}
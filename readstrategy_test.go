@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadStrategies(t *testing.T) {
+	mem := newMemNet()
+	oldNet, oldData, oldRepair, oldStrategy := peerNet, svc.data, readRepairOn, readStrategy
+	defer func() {
+		peerNet, svc.data, readRepairOn, readStrategy = oldNet, oldData, oldRepair, oldStrategy
+	}()
+	peerNet, readRepairOn = mem.transport(), false
+	withCluster(t, func(c *membership) { c.peers = []string{"rs:1", "rs:2", "rs:3"} })
+	svc.data = map[string]Entry{}
+	svc.put("k", Entry{Value: "v1", Timestamp: 1})
+
+	// each peer serves its own copy, counts full and digest reads, and
+	// merges repairs pushed to /catchup
+	var mu sync.Mutex
+	copies := map[string]Entry{}
+	full, digests := map[string]int{}, map[string]int{}
+	for i, p := range cluster().peers {
+		notePing(p, time.Duration(i+1)*time.Millisecond, true)
+		copies[p] = svc.data["k"]
+		mem.attach(p, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			if r.URL.Path == "/catchup" {
+				var in map[string]Entry
+				json.NewDecoder(r.Body).Decode(&in)
+				copies[p] = in["k"]
+				mu.Unlock()
+				return
+			}
+			e := copies[p]
+			if r.URL.Query().Get("digest") == "true" {
+				digests[p]++
+				mu.Unlock()
+				writeDigest(w, "k", e)
+				return
+			}
+			full[p]++
+			mu.Unlock()
+			json.NewEncoder(w).Encode(e)
+		}))
+	}
+	read := func(strategy string) (string, string) {
+		mu.Lock()
+		clear(full)
+		clear(digests)
+		mu.Unlock()
+		readStrategy = strategy
+		e, ok := readKey(nil, "k", 2)
+		if !ok {
+			t.Fatalf("%s read found nothing", strategy)
+		}
+		time.Sleep(20 * time.Millisecond) // let reads the quorum did not wait for land
+		mu.Lock()
+		defer mu.Unlock()
+		return e.Value, fmt.Sprintf("full %v digests %v", full, digests)
+	}
+
+	for _, c := range []struct{ strategy, value, reads string }{
+		{readFirstR, "v1", "full map[rs:1:1] digests map[]"},
+		{readAllWaitR, "v1", "full map[rs:1:1 rs:2:1 rs:3:1] digests map[]"},
+		{readDigest, "v1", "full map[] digests map[rs:1:1]"},
+	} {
+		if v, reads := read(c.strategy); v != c.value || reads != c.reads {
+			t.Errorf("%s: %q with %s; want %q with %s", c.strategy, v, reads, c.value, c.reads)
+		}
+	}
+
+	// the nearest peer has a newer write: its digest differs and is newer,
+	// so the digest read fetches it in full
+	mu.Lock()
+	e := Entry{Value: "v2", Timestamp: 2}
+	e.Checksum = e.sum("k")
+	copies["rs:1"] = e
+	mu.Unlock()
+	if v, reads := read(readDigest); v != "v2" || reads != "full map[rs:1:1] digests map[rs:1:1]" {
+		t.Errorf("digest read of a newer copy: %q with %s", v, reads)
+	}
+
+	// now it holds an older one: its digest says so, so it is repaired
+	// with the full copy without being read
+	mu.Lock()
+	copies["rs:1"] = Entry{Value: "v0"}
+	mu.Unlock()
+	readRepairOn = true
+	mismatches := digestMismatches.Load()
+	if v, reads := read(readDigest); v != "v1" || reads != "full map[] digests map[rs:1:1]" {
+		t.Errorf("digest read of a stale copy: %q with %s", v, reads)
+	}
+	if digestMismatches.Load() != mismatches+1 {
+		t.Error("mismatch not counted")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if copies["rs:1"].Value != "v1" {
+		t.Errorf("stale copy not repaired: %+v", copies["rs:1"])
+	}
+}
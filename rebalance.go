@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A membership change moves keys: a node that joins becomes a replica of
+// some of them, and a removed node's keys need new replicas. After every
+// change each node walks its store in the background and sends every key
+// whose replica set gained a member to the new replicas, through /catchup
+// in batches and at most -REBALANCE_RATE keys a second. Of a key's old
+// replicas only the first one still in the cluster sends it (the first
+// old replica at all when none is left, which lets a removed node hand off
+// what only it had), so each key moves once. A change that lands while a
+// rebalance is running restarts it from the layout the first one started
+// from. Old copies are left where they are. GET /admin/rebalance reports
+// progress.
+
+// rebalanceRate caps keys sent per second, set by -REBALANCE_RATE (0 = no
+// cap).
+var rebalanceRate = 1000
+
+const rebalanceBatch = 100
+
+// layout is where keys live: the ring and members, and how many copies of
+// each key there are.
+type layout struct {
+	ring    *Ring
+	members []string
+	copies  int
+}
+
+func currentLayout() layout { return layout{cluster().ring, members(), replicas()} }
+
+// owners lists key's replicas in preference-list order.
+func (l layout) owners(key string) []string {
+	if l.ring == nil {
+		return nil
+	}
+	return l.ring.PreferenceList(key, min(l.copies, len(l.members)), zoneOf)
+}
+
+type rebalanceStatus struct {
+	Running  bool              `json:"running"`
+	Started  time.Time         `json:"started,omitzero"`
+	Finished time.Time         `json:"finished,omitzero"`
+	Scanned  int               `json:"scanned"` // keys looked at
+	Moved    int               `json:"moved"`   // key copies sent
+	Pending  int               `json:"pending"` // key copies still to send
+	Rate     int               `json:"rate"`    // keys a second, 0 = no cap
+	Failed   map[string]string `json:"failed,omitempty"`
+}
+
+var rebalance struct {
+	sync.Mutex
+	status rebalanceStatus
+	from   layout // where the running rebalance started from
+	cancel context.CancelFunc
+}
+
+// startRebalance moves keys from where from placed them to where to does.
+func startRebalance(from, to layout) {
+	rebalance.Lock()
+	defer rebalance.Unlock()
+	if rebalance.status.Running {
+		rebalance.cancel()
+		from = rebalance.from
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rebalance.from, rebalance.cancel = from, cancel
+	rebalance.status = rebalanceStatus{Running: true, Started: time.Now().UTC(), Rate: rebalanceRate, Failed: map[string]string{}}
+	go runRebalance(ctx, from, to)
+}
+
+// rebalancePlan lists, per new replica, the keys this node sends it.
+func rebalancePlan(data map[string]Entry, from, to layout) map[string]map[string]Entry {
+	plan := map[string]map[string]Entry{}
+	for k, e := range data {
+		old := from.owners(k)
+		if len(old) == 0 {
+			continue
+		}
+		sender := old[0]
+		if i := slices.IndexFunc(old, func(m string) bool { return slices.Contains(to.members, m) }); i >= 0 {
+			sender = old[i]
+		}
+		if sender != self {
+			continue
+		}
+		for _, m := range to.owners(k) {
+			if m == self || slices.Contains(old, m) {
+				continue
+			}
+			if plan[m] == nil {
+				plan[m] = map[string]Entry{}
+			}
+			plan[m][k] = e
+		}
+	}
+	return plan
+}
+
+func runRebalance(ctx context.Context, from, to layout) {
+	data := svc.snapshot()
+	plan := rebalancePlan(data, from, to)
+	targets := make([]string, 0, len(plan))
+	pending := 0
+	for m, keys := range plan {
+		targets = append(targets, m)
+		pending += len(keys)
+	}
+	sort.Strings(targets)
+	update := func(f func(*rebalanceStatus)) {
+		rebalance.Lock()
+		defer rebalance.Unlock()
+		if ctx.Err() == nil {
+			f(&rebalance.status)
+		}
+	}
+	update(func(s *rebalanceStatus) { s.Scanned, s.Pending = len(data), pending })
+	if pending > 0 {
+		log.Printf("rebalance: sending %d keys to %v", pending, targets)
+	}
+
+	for _, m := range targets {
+		keys := make([]string, 0, len(plan[m]))
+		for k := range plan[m] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for len(keys) > 0 {
+			n := min(rebalanceBatch, len(keys))
+			if rebalanceRate > 0 && !pause(ctx, time.Duration(n)*time.Second/time.Duration(rebalanceRate)) {
+				return
+			}
+			batch := make(map[string]Entry, n)
+			for _, k := range keys[:n] {
+				batch[k] = plan[m][k]
+			}
+			if err := sendRebalanceBatch(m, batch); err != nil {
+				log.Printf("rebalance to %s: %v", m, err)
+				update(func(s *rebalanceStatus) { s.Failed[m] = err.Error() })
+				break
+			}
+			keys = keys[n:]
+			update(func(s *rebalanceStatus) { s.Moved, s.Pending = s.Moved+n, s.Pending-n })
+		}
+	}
+	update(func(s *rebalanceStatus) { s.Running, s.Finished = false, time.Now().UTC() })
+}
+
+func sendRebalanceBatch(peer string, batch map[string]Entry) error {
+	bs, _ := json.Marshal(batch)
+	return postBatch(peer, fmt.Sprintf("/catchup?epoch=%d", currentEpoch.Load()), bs)
+}
+
+// rebalanceHandler reports the running or last rebalance.
+func rebalanceHandler(w http.ResponseWriter, r *http.Request) {
+	rebalance.Lock()
+	st := rebalance.status
+	st.Failed = maps.Clone(st.Failed)
+	rebalance.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
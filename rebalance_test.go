@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRebalancePlanMovesEachKeyOnce(t *testing.T) {
+	oldSelf, oldRF := self, replicationFactor
+	defer func() { self, replicationFactor = oldSelf, oldRF }()
+	replicationFactor = 2
+
+	data := map[string]Entry{}
+	for i := 0; i < 200; i++ {
+		data[fmt.Sprintf("k%d", i)] = Entry{Value: "v", Timestamp: 1}
+	}
+	three := []string{"a", "b", "c"}
+	four := []string{"a", "b", "c", "d"}
+	from := layout{NewRing(three, 16), three, 2}
+	to := layout{NewRing(four, 16), four, 2}
+
+	sent := map[string]int{} // key -> copies sent to new replicas
+	for _, m := range three {
+		self = m
+		for target, keys := range rebalancePlan(data, from, to) {
+			for k := range keys {
+				if target == m || slices.Contains(from.owners(k), target) {
+					t.Fatalf("%s sends %s to %s, an old replica", m, k, target)
+				}
+				sent[k]++
+			}
+		}
+	}
+	for k := range data {
+		gained := 0
+		for _, m := range to.owners(k) {
+			if !slices.Contains(from.owners(k), m) {
+				gained++
+			}
+		}
+		if sent[k] != gained {
+			t.Fatalf("%s: %d copies sent for %d new replicas", k, sent[k], gained)
+		}
+	}
+
+	// a removed node hands off what only it held
+	self = "c"
+	two := []string{"a", "b"}
+	plan := rebalancePlan(data, layout{NewRing(three, 16), three, 1}, layout{NewRing(two, 16), two, 1})
+	if len(plan["a"])+len(plan["b"]) == 0 {
+		t.Fatal("removed node sends nothing")
+	}
+}
+
+func TestRebalanceCopiesKeysToAJoiningNode(t *testing.T) {
+	p1, p2, p3 := 9141, 9142, 9143
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	a := startNode(t, p1, []string{addr(p2)}, true, 0, 1, 2)
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{addr(p1)}, false, 0, 1, 2)
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+	for i := 0; i < 5; i++ {
+		resp, err := http.Post(fmt.Sprintf("http://%s/set?key=k%d&value=v", addr(p1), i), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("set = %d", resp.StatusCode)
+		}
+	}
+	c := startNode(t, p3, []string{addr(p1), addr(p2)}, false, 0, 1, 1)
+	defer c.Process.Kill()
+	waitReady(t, p3)
+	resp, err := http.Post(fmt.Sprintf("http://%s/admin/members?add=%s", addr(p1), addr(p3)), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for i := 0; i < 5; i++ {
+		for {
+			e, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=k%d", addr(p3), i))
+			if code == http.StatusOK && e.Value == "v" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("k%d never reached the new node (%d)", i, code)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	// the startup sync may have got the keys there before the rebalance did
+	moved := 0
+	for _, p := range []int{p1, p2} {
+		var st rebalanceStatus
+		for {
+			err := getJSON(fmt.Sprintf("http://%s/admin/rebalance", addr(p)), &st)
+			if err == nil && !st.Running {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d: %+v, %v", p, st, err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		moved += st.Moved
+	}
+	if moved != 5 {
+		t.Fatalf("%d copies moved, want one per key", moved)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// An R>1 read that finds a replica holding an older copy of the key, or
+// none at all, pushes it the merged result in the background (-READ_REPAIR).
+// /admin/repair does the same on demand, comparing every replica rather
+// than R of them.
+
+var readRepairOn = true
+
+// repairCounters counts replica copies brought up to date, and pushes
+// that failed.
+type repairCounters struct{ ok, failed atomic.Int64 }
+
+var readRepairs, adminRepairs repairCounters
+
+// replicaCopy is what one replica holds for a key; peer is "" for this
+// node.
+type replicaCopy struct {
+	peer  string
+	e     Entry
+	found bool
+}
+
+// repairStale brings every copy that best would change up to date,
+// returning how many were repaired and the peers that could not be.
+func repairStale(c *repairCounters, key string, best Entry, copies []replicaCopy) (int, []string) {
+	repaired := 0
+	var failed []string
+	for _, cp := range copies {
+		if _, changed := mergeEntry(key, cp.e, cp.found, best); !changed {
+			continue
+		}
+		if cp.peer == "" {
+			repairLocal(key, best)
+		} else if err := pushRepair(cp.peer, key, best); err != nil {
+			c.failed.Add(1)
+			failed = append(failed, cp.peer)
+			log.Printf("repair of %q on %s: %v", key, cp.peer, err)
+			continue
+		}
+		c.ok.Add(1)
+		repaired++
+	}
+	return repaired, failed
+}
+
+func repairLocal(key string, e Entry) {
+	svc.Lock()
+	defer svc.Unlock()
+	cur, ok := svc.intactCopy(key)
+	if merged, changed := mergeEntry(key, cur, ok, e); changed {
+		svc.put(key, merged)
+	}
+}
+
+// pushRepair merges e into peer's copy through /catchup, which keeps
+// siblings intact where /replicate would carry just one version.
+func pushRepair(peer, key string, e Entry) error {
+	bs, _ := json.Marshal(map[string]Entry{key: e})
+	return postBatch(peer, fmt.Sprintf("/catchup?epoch=%d", currentEpoch.Load()), bs)
+}
+
+// allCopies reads key from this node and every peer holding it. Peers that
+// could not be asked are returned separately.
+func allCopies(key string) ([]replicaCopy, []string) {
+	svc.RLock()
+	e, ok := svc.intactCopy(key)
+	svc.RUnlock()
+	copies := []replicaCopy{{"", e, ok}}
+	var unreachable []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range replicaPeers(key) {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			e, found, err := fetchReplica(p, key)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				unreachable = append(unreachable, p)
+				return
+			}
+			copies = append(copies, replicaCopy{p, e, found})
+		}(p)
+	}
+	wg.Wait()
+	return copies, unreachable
+}
+
+// prefixKeys lists the keys starting with prefix held here, tombstones
+// included, or live on any peer.
+func prefixKeys(prefix string) map[string]bool {
+	keys := map[string]bool{}
+	for k := range svc.snapshot() {
+		if strings.HasPrefix(k, prefix) {
+			keys[k] = true
+		}
+	}
+	for _, p := range cluster().peers {
+		q := url.Values{"prefix": {prefix}, "limit": {strconv.Itoa(maxPageSize)}}
+		for {
+			resp, err := http.Get(fmt.Sprintf("http://%s/scan?%s", clientAddrOf(p), q.Encode()))
+			if err != nil {
+				break
+			}
+			var kvs []KV
+			json.NewDecoder(resp.Body).Decode(&kvs)
+			resp.Body.Close()
+			for _, kv := range kvs {
+				keys[kv.Key] = true
+			}
+			next := resp.Header.Get(cursorHeader)
+			if next == "" {
+				break
+			}
+			q.Set("cursor", next)
+		}
+	}
+	return keys
+}
+
+type repairReport struct {
+	Keys        int      `json:"keys"`
+	Repaired    int      `json:"repaired"`
+	Failed      []string `json:"failed,omitempty"`      // key@peer pushes that failed
+	Unreachable []string `json:"unreachable,omitempty"` // peers that could not be read
+}
+
+// repairHandler compares every replica's copy of the ?key= keys, and of
+// the keys under ?prefix=, and repairs the stale ones.
+func repairHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	keys := map[string]bool{}
+	for _, k := range q["key"] {
+		if err := checkKey(k); err != nil {
+			httpError(w, http.StatusBadRequest, "key", err.Error())
+			return
+		}
+		keys[k] = true
+	}
+	if prefix, ok := q["prefix"]; ok {
+		for k := range prefixKeys(prefix[0]) {
+			keys[k] = true
+		}
+	} else if len(keys) == 0 {
+		httpError(w, http.StatusBadRequest, "key", "key or prefix required")
+		return
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	report := repairReport{Keys: len(sorted)}
+	unreachable := map[string]bool{}
+	for _, k := range sorted {
+		copies, down := allCopies(k)
+		for _, p := range down {
+			unreachable[p] = true
+		}
+		var best Entry
+		have := false
+		for _, cp := range copies {
+			if cp.found {
+				best, _ = mergeEntry(k, best, have, cp.e)
+				have = true
+			}
+		}
+		if !have {
+			continue
+		}
+		n, failed := repairStale(&adminRepairs, k, best, copies)
+		report.Repaired += n
+		for _, p := range failed {
+			report.Failed = append(report.Failed, k+"@"+p)
+		}
+	}
+	for p := range unreachable {
+		report.Unreachable = append(report.Unreachable, p)
+	}
+	sort.Strings(report.Unreachable)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRepairAndAdminRepair(t *testing.T) {
+	p1, p2 := 9112, 9113
+	a := startNode(t, p1, []string{fmt.Sprintf("localhost:%d", p2)}, true, 2, 1, 1)
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{fmt.Sprintf("localhost:%d", p1)}, false, 2, 1, 1)
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+
+	// plant a write on one replica only
+	plant := func(port int, key, value string) {
+		line := fmt.Sprintf(`{"key":%q,"value":%q,"timestamp":%d}`, key, value, time.Now().UnixNano())
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/applyDump", port), formatNDJSON, strings.NewReader(line))
+		if err != nil {
+			t.Fatalf("applyDump: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("plant %s on %d = %d", key, port, resp.StatusCode)
+		}
+	}
+	local := func(port int, key string) string {
+		e, code := getEntry(t, fmt.Sprintf("http://localhost:%d/local_read?key=%s", port, key))
+		if code != http.StatusOK {
+			return ""
+		}
+		return e.Value
+	}
+
+	plant(p1, "drift", "v1")
+	if e, _ := getEntry(t, fmt.Sprintf("http://localhost:%d/get?key=drift&R=2", p1)); e.Value != "v1" {
+		t.Fatalf("R=2 read = %+v", e)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if v := local(p2, "drift"); v != "v1" {
+		t.Errorf("read repair left follower with %q", v)
+	}
+
+	plant(p2, "p/1", "x")
+	plant(p2, "p/2", "y")
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/admin/repair?prefix=p/", p1), "", nil)
+	if err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	var report repairReport
+	json.NewDecoder(resp.Body).Decode(&report)
+	resp.Body.Close()
+	if report.Keys != 2 || report.Repaired != 2 {
+		t.Errorf("report = %+v, want 2 keys repaired", report)
+	}
+	if local(p1, "p/1") != "x" || local(p1, "p/2") != "y" {
+		t.Errorf("admin repair did not fill in the leader")
+	}
+
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/metrics", p1))
+	if err != nil {
+		t.Fatalf("metrics: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{`kv_repairs_total{trigger="read",result="ok"} 1`, `kv_repairs_total{trigger="admin",result="ok"} 2`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Reads and writes say in their response headers how they were served, so
+// a client or test can check without parsing the body:
+//
+//	X-Served-By            the node that coordinated the request
+//	X-Replicas-Contacted   replicas it reached, itself included when it
+//	                       used its own copy, as in the envelope's meta
+//	X-Quorum-Required      replicas the request waited for (R or W)
+//	X-Entry-Timestamp      timestamp of the entry read or written
+//	X-Unreachable-Policy   the -UNREACHABLE_POLICY a W>1 write ran under
+//	X-Unreachable-Peers    peer=skipped|hinted|down for each replica that
+//	                       policy gave up on (see unreachable.go)
+//
+// Requests routed to another node carry that node's headers. The last
+// three are left out until the request has touched a replica, e.g. on a
+// 400 for a bad parameter.
+
+const (
+	servedByHeader          = "X-Served-By"
+	replicasContactedHeader = "X-Replicas-Contacted"
+	quorumRequiredHeader    = "X-Quorum-Required"
+	entryTimestampHeader    = "X-Entry-Timestamp"
+	unreachablePolicyHeader = "X-Unreachable-Policy"
+	unreachablePeersHeader  = "X-Unreachable-Peers"
+)
+
+// annotated adds the consistency headers to h's response.
+func annotated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(&annotatedWriter{ResponseWriter: w, tr: traceOf(r)}, r)
+	}
+}
+
+// annotatedWriter sets the headers from the trace as the response starts.
+type annotatedWriter struct {
+	http.ResponseWriter
+	tr      *reqTrace
+	started bool
+}
+
+func (a *annotatedWriter) start() {
+	if a.started {
+		return
+	}
+	a.started = true
+	h := a.Header()
+	h.Set(servedByHeader, clientAddrOf(self))
+	if a.tr == nil {
+		return
+	}
+	a.tr.mu.Lock()
+	need, stamp := max(a.tr.need, 1), a.tr.stamp
+	policy, down := a.tr.policy, strings.Join(a.tr.down, ",")
+	a.tr.mu.Unlock()
+	if n := a.tr.contacted(); n > 0 {
+		h.Set(replicasContactedHeader, strconv.Itoa(n))
+		h.Set(quorumRequiredHeader, strconv.Itoa(need))
+	}
+	if stamp != 0 {
+		h.Set(entryTimestampHeader, strconv.FormatInt(stamp, 10))
+	}
+	if policy != "" {
+		h.Set(unreachablePolicyHeader, policy)
+	}
+	if down != "" {
+		h.Set(unreachablePeersHeader, down)
+	}
+}
+
+func (a *annotatedWriter) WriteHeader(code int) {
+	a.start()
+	a.ResponseWriter.WriteHeader(code)
+}
+
+func (a *annotatedWriter) Write(p []byte) (int, error) {
+	a.start()
+	return a.ResponseWriter.Write(p)
+}
+
+func (a *annotatedWriter) Flush() {
+	a.start()
+	http.NewResponseController(a.ResponseWriter).Flush()
+}
+
+func (a *annotatedWriter) Unwrap() http.ResponseWriter { return a.ResponseWriter }
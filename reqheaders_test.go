@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestConsistencyHeaders(t *testing.T) {
+	p1, p2 := 9174, 9175
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	a := startNode(t, p1, []string{addr(p2)}, true, 2, 2, 2)
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{addr(p1)}, false, 2, 2, 2)
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+
+	headers := func(method, path string) http.Header {
+		req, _ := http.NewRequest(method, "http://"+addr(p1)+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header
+	}
+	check := func(what string, h http.Header, contacted, quorum string) {
+		t.Helper()
+		if h.Get(servedByHeader) != addr(p1) || h.Get(replicasContactedHeader) != contacted || h.Get(quorumRequiredHeader) != quorum {
+			t.Errorf("%s: served by %q, %q contacted, %q required; want %s, %s, %s", what,
+				h.Get(servedByHeader), h.Get(replicasContactedHeader), h.Get(quorumRequiredHeader), addr(p1), contacted, quorum)
+		}
+	}
+
+	set := headers(http.MethodPost, "/set?key=k&value=v")
+	check("W=2 write", set, "2", "2")
+	written, err := strconv.ParseInt(set.Get(entryTimestampHeader), 10, 64)
+	if err != nil || written == 0 {
+		t.Fatalf("write timestamp %q", set.Get(entryTimestampHeader))
+	}
+
+	get := headers(http.MethodGet, "/get?key=k")
+	check("R=2 read", get, "2", "2")
+	if get.Get(entryTimestampHeader) != strconv.FormatInt(written, 10) {
+		t.Errorf("read timestamp %q, want the write's %d", get.Get(entryTimestampHeader), written)
+	}
+	check("R=1 read", headers(http.MethodGet, "/get?key=k&R=1"), "1", "1")
+	if h := headers(http.MethodGet, "/mget?key=k"); h.Get(entryTimestampHeader) != "" {
+		t.Errorf("mget carries an entry timestamp %q", h.Get(entryTimestampHeader))
+	}
+}
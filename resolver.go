@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ConflictResolver decides what a replica keeps when a version of a key
+// meets the one it already has: on local writes, on replication and
+// catch-up, and when a read combines the copies of several replicas.
+// Resolve must give the same answer whatever order versions arrive in, or
+// replicas will not converge.
+type ConflictResolver interface {
+	Resolve(current, incoming Entry) Entry
+}
+
+// MergeFunc adapts a plain function to ConflictResolver.
+type MergeFunc func(current, incoming Entry) Entry
+
+func (f MergeFunc) Resolve(current, incoming Entry) Entry { return f(current, incoming) }
+
+// lww keeps the newest version by (timestamp, node).
+type lww struct{}
+
+func (lww) Resolve(current, incoming Entry) Entry {
+	if incoming.newerThan(current) {
+		return incoming
+	}
+	return current
+}
+
+// highestNode keeps the version coordinated by the highest node ID, then
+// the newest from that node, so one node's writes override the rest.
+type highestNode struct{}
+
+func (highestNode) Resolve(current, incoming Entry) Entry {
+	if incoming.Node != current.Node {
+		if incoming.Node > current.Node {
+			return incoming
+		}
+		return current
+	}
+	return lww{}.Resolve(current, incoming)
+}
+
+// keepSiblings keeps every version whose vector clock no other version
+// descends from. The newest of those is the entry itself, the others ride
+// along in Siblings until a write carrying their context replaces them.
+type keepSiblings struct{}
+
+func (keepSiblings) Resolve(current, incoming Entry) Entry {
+	versions := append(current.versions(), incoming.versions()...)
+	var all []Entry
+	for i, v := range versions {
+		if !supersededIn(versions, i) {
+			all = append(all, v)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].newerThan(all[j]) })
+	head := all[0]
+	head.Siblings = nil
+	if len(all) > 1 {
+		head.Siblings = all[1:]
+	}
+	return head
+}
+
+// supersededIn reports whether another of versions replaces versions[i]:
+// one that descends from it, or one with an equal clock that is newer or,
+// for copies of the same version, comes first.
+func supersededIn(versions []Entry, i int) bool {
+	v := versions[i]
+	for j, o := range versions {
+		if j == i || !o.Clock.descends(v.Clock) {
+			continue
+		}
+		if !v.Clock.descends(o.Clock) || o.newerThan(v) || !v.newerThan(o) && j < i {
+			return true
+		}
+	}
+	return false
+}
+
+// versions flattens an entry and its siblings.
+func (e Entry) versions() []Entry {
+	head := e
+	head.Siblings = nil
+	return append([]Entry{head}, e.Siblings...)
+}
+
+// maxInt keeps the larger integer value, for counters and high-water
+// marks that only move up. Integers outrank anything else, tombstones
+// included, so a max key cannot be deleted out from under a higher value;
+// among non-integers LWW decides.
+func maxInt(current, incoming Entry) Entry {
+	a, okA := intValue(current)
+	b, okB := intValue(incoming)
+	switch {
+	case okA != okB:
+		if okB {
+			return incoming
+		}
+		return current
+	case okA && a != b:
+		if b > a {
+			return incoming
+		}
+		return current
+	}
+	return lww{}.Resolve(current, incoming)
+}
+
+func intValue(e Entry) (int64, bool) {
+	if e.Deleted {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(e.Value, 10, 64)
+	return n, err == nil
+}
+
+var (
+	resolversMu sync.RWMutex
+	resolvers   = map[string]ConflictResolver{
+		"lww":          lww{},
+		"highest-node": highestNode{},
+		"siblings":     keepSiblings{},
+		"max":          MergeFunc(maxInt),
+	}
+
+	defaultResolver ConflictResolver = lww{}
+	// prefixResolvers picks a resolver by key prefix, longest first.
+	prefixResolvers []prefixResolver
+)
+
+type prefixResolver struct {
+	prefix string
+	r      ConflictResolver
+}
+
+// RegisterResolver adds a named strategy for -CONFLICT and
+// -CONFLICT_PREFIXES to select.
+func RegisterResolver(name string, r ConflictResolver) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+	resolvers[name] = r
+}
+
+func lookupResolver(name string) (ConflictResolver, error) {
+	if command, ok := strings.CutPrefix(name, execPrefix); ok {
+		return newExecResolver(command)
+	}
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+	r, ok := resolvers[name]
+	if !ok {
+		names := make([]string, 0, len(resolvers))
+		for n := range resolvers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown conflict resolver %q (have %s, or exec:<command>)", name, strings.Join(names, ", "))
+	}
+	return r, nil
+}
+
+// configureResolvers applies -CONFLICT and -CONFLICT_PREFIXES, the latter a
+// comma-separated list of prefix=resolver namespaces.
+func configureResolvers(def, prefixes string) error {
+	r, err := lookupResolver(def)
+	if err != nil {
+		return err
+	}
+	defaultResolver = r
+	prefixResolvers = nil
+	for _, spec := range strings.Split(prefixes, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		prefix, name, ok := strings.Cut(spec, "=")
+		if !ok || prefix == "" {
+			return fmt.Errorf("conflict prefix %q: want prefix=resolver", spec)
+		}
+		r, err := lookupResolver(name)
+		if err != nil {
+			return err
+		}
+		prefixResolvers = append(prefixResolvers, prefixResolver{prefix, r})
+	}
+	sort.Slice(prefixResolvers, func(i, j int) bool {
+		return len(prefixResolvers[i].prefix) > len(prefixResolvers[j].prefix)
+	})
+	return nil
+}
+
+func resolverFor(key string) ConflictResolver {
+	for _, p := range prefixResolvers {
+		if strings.HasPrefix(key, p.prefix) {
+			return p.r
+		}
+	}
+	return defaultResolver
+}
+
+// mergeEntry combines incoming with the current entry for key, if any,
+// and reports whether the result differs from what was there.
+func mergeEntry(key string, cur Entry, ok bool, incoming Entry) (Entry, bool) {
+	// checksums are reset by Store.put, so leave them out of the comparison
+	cur, incoming = cur.unsealed(), incoming.unsealed()
+	if !ok {
+		return incoming, true
+	}
+	merged := merge(resolverFor(key), cur, incoming)
+	return merged, !reflect.DeepEqual(merged, cur)
+}
+
+// merge is the replica merge function: two versions of a key combine
+// under r, except versions of the same CRDT type, which merge by type (see
+// crdt.go). It depends on nothing but its arguments, and must be
+// commutative, associative and idempotent for replicas to converge
+// whatever order writes reach them in; merge_test.go checks that.
+func merge(r ConflictResolver, cur, incoming Entry) Entry {
+	if merged, ok := mergeCRDT(cur, incoming); ok {
+		return merged
+	}
+	return r.Resolve(cur, incoming)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// resolveAll folds versions into one entry the way a replica would as they
+// arrive.
+func resolveAll(r ConflictResolver, in ...Entry) Entry {
+	cur := in[0]
+	for _, e := range in[1:] {
+		cur = r.Resolve(cur, e)
+	}
+	return cur
+}
+
+func TestResolversIgnoreArrivalOrder(t *testing.T) {
+	a := Entry{Value: "7", Timestamp: 300, Node: "kv1:8000", Clock: vclock{"kv1:8000": 2}}
+	b := Entry{Value: "9", Timestamp: 100, Node: "kv2:8000", Clock: vclock{"kv2:8000": 1}}
+	c := Entry{Value: "x", Timestamp: 200, Node: "kv1:8000", Clock: vclock{"kv1:8000": 1}}
+	for name, r := range resolvers {
+		x, y := resolveAll(r, a, b, c), resolveAll(r, c, b, a)
+		if !reflect.DeepEqual(x, y) {
+			t.Errorf("%s: arrival order changed the result: %+v vs %+v", name, x, y)
+		}
+	}
+}
+
+func TestResolverStrategies(t *testing.T) {
+	a := Entry{Value: "7", Timestamp: 300, Node: "kv1:8000"}
+	b := Entry{Value: "9", Timestamp: 100, Node: "kv2:8000"}
+
+	if got := (lww{}).Resolve(b, a); got.Value != "7" {
+		t.Errorf("lww kept %q, want the newer 7", got.Value)
+	}
+	if got := (highestNode{}).Resolve(a, b); got.Value != "9" {
+		t.Errorf("highest-node kept %q, want kv2's 9", got.Value)
+	}
+	if got := maxInt(a, b); got.Value != "9" {
+		t.Errorf("max kept %q, want 9", got.Value)
+	}
+	if got := maxInt(a, Entry{Value: "n/a", Timestamp: 400}); got.Value != "7" {
+		t.Errorf("max let a newer non-integer replace an integer: %q", got.Value)
+	}
+	if got := maxInt(Entry{Value: "n/a", Timestamp: 400}, Entry{Value: "b", Timestamp: 500}); got.Value != "b" {
+		t.Errorf("max between non-integers did not fall back to lww: %q", got.Value)
+	}
+
+	a.Clock, b.Clock = vclock{"kv1:8000": 1}, vclock{"kv2:8000": 1}
+	got := (keepSiblings{}).Resolve(a, b)
+	if got.Value != "7" || len(got.Siblings) != 1 || got.Siblings[0].Value != "9" {
+		t.Fatalf("siblings = %+v, want 7 with sibling 9", got)
+	}
+	// a later write from kv1 without context supersedes kv1's version only
+	got = (keepSiblings{}).Resolve(got, Entry{Value: "8", Timestamp: 400, Node: "kv1:8000", Clock: vclock{"kv1:8000": 2}})
+	if got.Value != "8" || len(got.Siblings) != 1 || got.Siblings[0].Value != "9" {
+		t.Fatalf("siblings after overwrite = %+v, want 8 with sibling 9", got)
+	}
+	// a write carrying the context of both resolves them
+	ctx := clockOf(got)
+	resolved := Entry{Value: "17", Timestamp: 50, Node: "kv2:8000", Clock: ctx.merge(vclock{"kv2:8000": 2})}
+	got = (keepSiblings{}).Resolve(got, resolved)
+	if got.Value != "17" || len(got.Siblings) != 0 {
+		t.Fatalf("after resolving = %+v, want just 17", got)
+	}
+	// and a stale sibling arriving late stays resolved
+	if late := (keepSiblings{}).Resolve(got, b); late.Value != "17" || len(late.Siblings) != 0 {
+		t.Fatalf("stale sibling came back: %+v", late)
+	}
+}
+
+func TestConfigureResolversByPrefix(t *testing.T) {
+	defer configureResolvers("lww", "")
+	if err := configureResolvers("lww", "count/=max, count/exact/=highest-node"); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]ConflictResolver{
+		"user/1":        lww{},
+		"count/hits":    resolvers["max"],
+		"count/exact/a": highestNode{},
+	} {
+		if got := resolverFor(key); reflect.TypeOf(got) != reflect.TypeOf(want) {
+			t.Errorf("resolverFor(%q) = %T, want %T", key, got, want)
+		}
+	}
+
+	if err := configureResolvers("nope", ""); err == nil {
+		t.Error("unknown default resolver accepted")
+	}
+	if err := configureResolvers("lww", "count/"); err == nil {
+		t.Error("prefix without a resolver accepted")
+	}
+}
+
+func TestMergeEntryReportsChange(t *testing.T) {
+	cur := Entry{Value: "new", Timestamp: 200, Node: "kv1:8000"}
+	if _, changed := mergeEntry("k", cur, true, Entry{Value: "old", Timestamp: 100}); changed {
+		t.Error("an older version under lww reported a change")
+	}
+	if got, changed := mergeEntry("k", Entry{}, false, cur); !changed || got.Value != "new" {
+		t.Errorf("first version = %+v, %v", got, changed)
+	}
+}
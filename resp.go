@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The RESP front-end lets Redis clients (redis-cli, redis-benchmark) talk
+// to the cluster. Commands are translated into requests against the
+// node's own HTTP handlers, so they get exactly the replication behavior
+// of /set, /get and /delete.
+
+// serveRESP accepts Redis connections on addr until the listener fails.
+func serveRESP(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("RESP listener: %v", err)
+	}
+	log.Printf("RESP front-end listening on %s", addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("RESP accept: %v", err)
+			return
+		}
+		go handleRESP(conn)
+	}
+}
+
+func handleRESP(conn net.Conn) {
+	defer conn.Close()
+	awaitReady()
+	rd := bufio.NewReader(conn)
+	wr := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				writeError(wr, "ERR protocol error: "+err.Error())
+				wr.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := strings.EqualFold(args[0], "QUIT")
+		execRESP(wr, args)
+		// flush once the client has no more pipelined commands queued
+		if rd.Buffered() == 0 || quit {
+			if err := wr.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// maxRESPArgs bounds a command's array length, as Redis does; a bulk
+// string is bounded by maxBodyBytes, like an HTTP body.
+const maxRESPArgs = 1 << 20
+
+// readCommand reads one command, either a RESP array of bulk strings or an
+// inline command line.
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := readLine(rd)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxRESPArgs {
+		return nil, fmt.Errorf("bad array length %q", line)
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		hdr, err := readLine(rd)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(hdr, "$") {
+			return nil, fmt.Errorf("expected bulk string, got %q", hdr)
+		}
+		size, err := strconv.Atoi(hdr[1:])
+		if err != nil || size < 0 || size > maxBodyBytes {
+			return nil, fmt.Errorf("bad bulk length %q", hdr)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readLine(rd *bufio.Reader) (string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeError(w *bufio.Writer, msg string) { fmt.Fprintf(w, "-%s\r\n", msg) }
+func writeSimple(w *bufio.Writer, s string)  { fmt.Fprintf(w, "+%s\r\n", s) }
+func writeInt(w *bufio.Writer, n int)        { fmt.Fprintf(w, ":%d\r\n", n) }
+func writeNil(w *bufio.Writer)               { w.WriteString("$-1\r\n") }
+func writeBulk(w *bufio.Writer, s string)    { fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s) }
+
+func execRESP(w *bufio.Writer, args []string) {
+	cmd := strings.ToUpper(args[0])
+	argc := map[string]int{"GET": 2, "SET": 3, "TTL": 2, "ECHO": 2, "APPEND": 3, "GETSET": 3}
+	if want, ok := argc[cmd]; ok && len(args) < want {
+		writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+		return
+	}
+	switch cmd {
+	case "PING":
+		writeSimple(w, "PONG")
+	case "ECHO":
+		writeBulk(w, args[1])
+	case "QUIT", "SELECT":
+		writeSimple(w, "OK")
+	case "COMMAND", "CONFIG":
+		w.WriteString("*0\r\n")
+	case "GET":
+		status, body := dispatch(http.MethodGet, "/get", url.Values{"key": {args[1]}})
+		switch status {
+		case http.StatusOK:
+			var e Entry
+			json.Unmarshal(body, &e)
+			writeBulk(w, e.Value)
+		case http.StatusNotFound:
+			writeNil(w)
+		default:
+			writeError(w, "ERR "+strings.TrimSpace(string(body)))
+		}
+	case "SET":
+		q := url.Values{"key": {args[1]}, "value": {args[2]}}
+		switch opt := args[3:]; {
+		case len(opt) == 0:
+		case len(opt) == 2 && (strings.EqualFold(opt[0], "EX") || strings.EqualFold(opt[0], "PX")):
+			n, err := strconv.Atoi(opt[1])
+			if err != nil || n <= 0 {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+			unit := time.Second
+			if strings.EqualFold(opt[0], "PX") {
+				unit = time.Millisecond
+			}
+			q.Set("ttl", (time.Duration(n) * unit).String())
+		default:
+			writeError(w, "ERR SET options other than EX and PX are not supported")
+			return
+		}
+		status, body := dispatch(http.MethodPost, "/set", q)
+		if status == http.StatusCreated {
+			writeSimple(w, "OK")
+			return
+		}
+		writeError(w, "ERR "+strings.TrimSpace(string(body)))
+	case "APPEND":
+		// answers the length of the value after the append
+		status, body := dispatch(http.MethodPost, "/append", url.Values{"key": {args[1]}, "value": {args[2]}})
+		if status != http.StatusCreated {
+			writeError(w, "ERR "+strings.TrimSpace(string(body)))
+			return
+		}
+		_, body = dispatch(http.MethodGet, "/get", url.Values{"key": {args[1]}})
+		var e Entry
+		json.Unmarshal(body, &e)
+		writeInt(w, len(e.Value))
+	case "GETSET":
+		status, body := dispatch(http.MethodPost, "/getset", url.Values{"key": {args[1]}, "value": {args[2]}})
+		if status != http.StatusCreated {
+			writeError(w, "ERR "+strings.TrimSpace(string(body)))
+			return
+		}
+		var old *Entry
+		json.Unmarshal(body, &old)
+		if old == nil {
+			writeNil(w)
+			return
+		}
+		writeBulk(w, old.Value)
+	case "DEL", "EXISTS":
+		n := 0
+		for _, k := range args[1:] {
+			if status, _ := dispatch(http.MethodGet, "/get", url.Values{"key": {k}}); status != http.StatusOK {
+				continue
+			}
+			if cmd == "DEL" {
+				if status, body := dispatch(http.MethodPost, "/delete", url.Values{"key": {k}}); status != http.StatusOK {
+					writeError(w, "ERR "+strings.TrimSpace(string(body)))
+					return
+				}
+			}
+			n++
+		}
+		writeInt(w, n)
+	case "TTL":
+		// seconds left for a key set with EX or PX, -1 for a live key
+		// without a deadline, -2 for a missing one
+		status, body := dispatch(http.MethodGet, "/get", url.Values{"key": {args[1]}})
+		if status != http.StatusOK {
+			writeInt(w, -2)
+			return
+		}
+		var e Entry
+		json.Unmarshal(body, &e)
+		if e.Expires == 0 {
+			writeInt(w, -1)
+			return
+		}
+		writeInt(w, int((time.Until(time.Unix(0, e.Expires))+time.Second/2)/time.Second))
+	default:
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+}
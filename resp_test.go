@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRESP_BasicCommands(t *testing.T) {
+	node := startNode(t, 9081, nil, true, 1, 1, 1, "-RESP_PORT", "9082")
+	defer node.Process.Kill()
+	waitReady(t, 9081)
+
+	conn, err := net.Dial("tcp", "localhost:9082")
+	if err != nil {
+		t.Fatalf("dial RESP port: %v", err)
+	}
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+
+	// send as a RESP array, like redis-cli does
+	send := func(args ...string) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+		}
+		conn.Write([]byte(b.String()))
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%v: reading reply: %v", args, err)
+		}
+		if strings.HasPrefix(line, "$") && line != "$-1\r\n" {
+			body, _ := rd.ReadString('\n')
+			line += body
+		}
+		return line
+	}
+
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PING"}, "+PONG\r\n"},
+		{[]string{"GET", "user"}, "$-1\r\n"},
+		{[]string{"SET", "user", "Alice Smith"}, "+OK\r\n"},
+		{[]string{"GET", "user"}, "$11\r\nAlice Smith\r\n"},
+		{[]string{"EXISTS", "user", "nobody"}, ":1\r\n"},
+		{[]string{"TTL", "user"}, ":-1\r\n"},
+		{[]string{"DEL", "user", "nobody"}, ":1\r\n"},
+		{[]string{"TTL", "user"}, ":-2\r\n"},
+		{[]string{"SET", "session", "s1", "EX", "60"}, "+OK\r\n"},
+		{[]string{"TTL", "session"}, ":60\r\n"},
+		{[]string{"SET", "session", "s1", "EX", "0"}, "-ERR invalid expire time in 'set' command\r\n"},
+		{[]string{"APPEND", "log", "ab"}, ":2\r\n"},
+		{[]string{"APPEND", "log", "c"}, ":3\r\n"},
+		{[]string{"GETSET", "log", "d"}, "$3\r\nabc\r\n"},
+		{[]string{"GETSET", "fresh", "d"}, "$-1\r\n"},
+		{[]string{"SET", "session", "s1", "NX"}, "-ERR SET options other than EX and PX are not supported\r\n"},
+		{[]string{"FLUSHALL"}, "-ERR unknown command 'FLUSHALL'\r\n"},
+	}
+	for _, c := range cases {
+		if got := send(c.args...); got != c.want {
+			t.Errorf("%v: expected %q, got %q", c.args, c.want, got)
+		}
+	}
+
+	// inline commands, as typed over telnet
+	conn.Write([]byte("PING\r\n"))
+	if line, _ := rd.ReadString('\n'); line != "+PONG\r\n" {
+		t.Errorf("inline PING: got %q", line)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Ring is a consistent-hash ring. Each member owns vnodes tokens; a key
+// belongs to the members found walking clockwise from the key's hash.
+type Ring struct {
+	tokens []uint64
+	owner  map[uint64]string
+}
+
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// NewRing places vnodes tokens for every member.
+func NewRing(members []string, vnodes int) *Ring {
+	r := &Ring{owner: make(map[uint64]string)}
+	for _, m := range members {
+		for i := 0; i < vnodes; i++ {
+			t := hashKey(fmt.Sprintf("%s#%d", m, i))
+			if _, taken := r.owner[t]; taken {
+				continue
+			}
+			r.owner[t] = m
+			r.tokens = append(r.tokens, t)
+		}
+	}
+	sort.Slice(r.tokens, func(i, j int) bool { return r.tokens[i] < r.tokens[j] })
+	return r
+}
+
+// walk visits owners clockwise from key's position, once per token.
+func (r *Ring) walk(key string, visit func(member string) bool) {
+	if len(r.tokens) == 0 {
+		return
+	}
+	h := hashKey(key)
+	start := sort.Search(len(r.tokens), func(i int) bool { return r.tokens[i] >= h })
+	for i := 0; i < len(r.tokens); i++ {
+		if !visit(r.owner[r.tokens[(start+i)%len(r.tokens)]]) {
+			return
+		}
+	}
+}
+
+// PreferenceList returns the n members responsible for key. Members in
+// zones not yet represented are preferred, so replicas spread across as
+// many zones as exist before any zone holds a second copy.
+func (r *Ring) PreferenceList(key string, n int, zoneOf func(string) string) []string {
+	var out []string
+	picked := make(map[string]bool)
+	zones := make(map[string]bool)
+	r.walk(key, func(m string) bool {
+		if !picked[m] && !zones[zoneOf(m)] {
+			picked[m] = true
+			zones[zoneOf(m)] = true
+			out = append(out, m)
+		}
+		return len(out) < n
+	})
+	// fewer zones than replicas: fill up in ring order
+	if len(out) < n {
+		r.walk(key, func(m string) bool {
+			if !picked[m] {
+				picked[m] = true
+				out = append(out, m)
+			}
+			return len(out) < n
+		})
+	}
+	return out
+}
+
+// TokenRange is the stretch of the hash space after From, up to and
+// including To, whose keys start their walk at Owner's token To.
+type TokenRange struct {
+	From  uint64 `json:"from"`
+	To    uint64 `json:"to"`
+	Owner string `json:"owner"`
+}
+
+// Ranges lists the ring's token ranges in token order; the first wraps
+// around from the last token.
+func (r *Ring) Ranges() []TokenRange {
+	out := make([]TokenRange, len(r.tokens))
+	for i, t := range r.tokens {
+		out[i] = TokenRange{From: r.tokens[(i+len(r.tokens)-1)%len(r.tokens)], To: t, Owner: r.owner[t]}
+	}
+	return out
+}
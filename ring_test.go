@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRing_PreferenceListSpreadsAcrossZones(t *testing.T) {
+	zones := map[string]string{
+		"a1": "a", "a2": "a",
+		"b1": "b", "b2": "b",
+		"c1": "c", "c2": "c",
+	}
+	members := make([]string, 0, len(zones))
+	for m := range zones {
+		members = append(members, m)
+	}
+	r := NewRing(members, 32)
+	zoneOf := func(m string) string { return zones[m] }
+
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("key%d", i)
+		pl := r.PreferenceList(key, 3, zoneOf)
+		if len(pl) != 3 {
+			t.Fatalf("%s: expected 3 replicas, got %v", key, pl)
+		}
+		seen := map[string]bool{}
+		for _, m := range pl {
+			seen[zones[m]] = true
+		}
+		if len(seen) != 3 {
+			t.Errorf("%s: replicas %v share a zone", key, pl)
+		}
+		if again := r.PreferenceList(key, 3, zoneOf); fmt.Sprint(again) != fmt.Sprint(pl) {
+			t.Errorf("%s: preference list not stable: %v vs %v", key, pl, again)
+		}
+	}
+
+	// more replicas than zones still yields distinct members
+	pl := r.PreferenceList("key0", 5, zoneOf)
+	distinct := map[string]bool{}
+	for _, m := range pl {
+		distinct[m] = true
+	}
+	if len(pl) != 5 || len(distinct) != 5 {
+		t.Errorf("expected 5 distinct replicas, got %v", pl)
+	}
+}
+
+func TestRing_RangesCoverTheHashSpace(t *testing.T) {
+	r := NewRing([]string{"a", "b", "c"}, 16)
+	ranges := r.Ranges()
+	if len(ranges) != len(r.tokens) {
+		t.Fatalf("%d ranges for %d tokens", len(ranges), len(r.tokens))
+	}
+	var total uint64
+	for i, tr := range ranges {
+		total += tr.To - tr.From
+		if next := ranges[(i+1)%len(ranges)]; next.From != tr.To {
+			t.Fatalf("range %d ends at %d, the next starts at %d", i, tr.To, next.From)
+		}
+	}
+	if total != 0 {
+		t.Fatalf("ranges add up to %d, not the whole (wrapping) space", total)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// With -ROLLBACK_FAILED_WRITES a W>1 write that misses its quorum is
+// undone where it got: the coordinator writes a tombstone one nanosecond
+// newer than the failed version, here if its copy is still that version,
+// and sends it to every replica that accepted the write or may yet.
+// Otherwise the failed value would stay the newest version on those
+// replicas, and an R=1 reader would see a write its client was told
+// failed. The tombstone loses to any later write, and beats the failed
+// one whichever order a replica gets them in. The key then reads as
+// deleted: the value it had before is not brought back. CRDT updates,
+// which merge rather than replace, are not rolled back.
+
+var (
+	rollbackFailed bool // -ROLLBACK_FAILED_WRITES
+
+	rollbacksSent, rollbacksFailed atomic.Int64
+)
+
+// rollbackWrite undoes e, the write ws tracks, on the replicas that have
+// or may get it, returning the peers the undo is sent to.
+func rollbackWrite(ws *writeStatus, e Entry) []string {
+	if !rollbackFailed || e.Type != "" {
+		return nil
+	}
+	undo := Entry{Deleted: true, Timestamp: e.Timestamp + 1, Node: e.Node, Clock: e.Clock, Seq: nextSeq()}
+	still := func(cur Entry, ok bool, _ *Entry) bool {
+		return ok && cur.Timestamp == e.Timestamp && cur.Node == e.Node
+	}
+	if !applyLocal(ws.Key, &undo, still) {
+		stampClock(e, true, &undo)
+	}
+	byState := ws.replicasIn()
+	var targets []string
+	for _, p := range append(byState[replicaAcked], byState[replicaPending]...) {
+		if p != self {
+			targets = append(targets, p)
+		}
+	}
+	for _, p := range targets {
+		asyncRepl.Add(1)
+		go func(p string) {
+			defer asyncRepl.Done()
+			if replicateTo(p, ws.Key, undo) {
+				rollbacksSent.Add(1)
+			} else {
+				rollbacksFailed.Add(1)
+			}
+		}(p)
+	}
+	return targets
+}
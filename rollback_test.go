@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestRollbackFailedWrite(t *testing.T) {
+	mem := newMemNet()
+	oldNet, oldDelay := peerNet, LeaderDelayPerFollower
+	wasLeader := isLeader.Load()
+	defer func() {
+		peerNet, LeaderDelayPerFollower = oldNet, oldDelay
+		isLeader.Store(wasLeader)
+		rollbackFailed = false
+		svc.Lock()
+		delete(svc.data, "rb")
+		svc.Unlock()
+	}()
+	peerNet, LeaderDelayPerFollower = mem.transport(), 0
+	withCluster(t, func(c *membership) { c.peers, c.N, c.W = []string{"took:1", "refused:1"}, 3, 3 })
+	isLeader.Store(true)
+	rollbackFailed = true
+
+	var mu sync.Mutex
+	var got []Entry
+	mem.attach("took:1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		ts, _ := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+		mu.Lock()
+		got = append(got, Entry{Value: q.Get("value"), Timestamp: ts, Deleted: q.Get("deleted") == "true"})
+		mu.Unlock()
+	}))
+	mem.attach("refused:1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusInternalServerError)
+	}))
+
+	rec := httptest.NewRecorder()
+	coordinateWrite(rec, httptest.NewRequest(http.MethodPost, "/set?key=rb&value=v", nil), "rb", Entry{Value: "v"}, nil)
+	asyncRepl.Wait()
+	var partial partialWrite
+	json.NewDecoder(rec.Body).Decode(&partial)
+	if rec.Code != http.StatusInternalServerError || !slices.Equal(partial.RolledBack, []string{"took:1"}) {
+		t.Fatalf("W=3 with a replica refusing = %d, %+v", rec.Code, partial)
+	}
+
+	undone := partial.Timestamp + 1
+	if e := svc.snapshot()["rb"]; !e.Deleted || e.Timestamp != undone {
+		t.Errorf("local copy %+v, want a tombstone at %d", e, undone)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 || got[0].Value != "v" || !got[1].Deleted || got[1].Timestamp != undone {
+		t.Fatalf("replica got %+v, want the write then its undo", got)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// With -REPLICAS below N a node can get a request for a key it does not
+// hold. -ROUTING decides what it does with one: coordinate it anyway (the
+// default, see placement.go), "proxy" it to the key's first reachable
+// replica and relay the answer, or "redirect" the client there with a
+// 307 whose X-KV-Owners header lists every replica. Only requests naming
+// their key in ?key= are routed, and writes only while every node may
+// coordinate them; under a leader, writes still go to the leader. A
+// proxied request carries X-KV-Routed so it is not routed again.
+//
+// -KEY_COORDINATOR gives each key a coordinator of its own in a leaderless
+// cluster: a node forwards a write to the key's first replica (its
+// primary), so writes to one key are ordered by one node and conflict
+// less, while no node coordinates every key. If the primary does not
+// answer the next replica is tried, down to this node, which then
+// coordinates the write itself.
+
+const (
+	routeProxy    = "proxy"
+	routeRedirect = "redirect"
+
+	routedHeader = "X-KV-Routed"
+	ownersHeader = "X-KV-Owners"
+)
+
+var (
+	routingMode    string // -ROUTING: "" (coordinate here), proxy or redirect
+	keyCoordinator bool   // -KEY_COORDINATOR
+)
+
+func parseRouting(s string) (string, error) {
+	switch s {
+	case "", "off":
+		return "", nil
+	case routeProxy, routeRedirect:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown -ROUTING %q (want off, proxy or redirect)", s)
+}
+
+// routed sends single-key requests to the node that should serve them, as
+// -ROUTING and -KEY_COORDINATOR say.
+func routed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" || r.Header.Get(routedHeader) != "" {
+			h(w, r)
+			return
+		}
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead
+		list, local := owners(key), ownedBy(key, self)
+		var targets []string
+		switch {
+		case write && keyCoordinator && leaderlessCluster():
+			// the replicas ahead of this one, all of them if it is none
+			for _, m := range list {
+				if m == self {
+					break
+				}
+				targets = append(targets, m)
+			}
+		case routingMode != "" && !local && (!write || leaderlessCluster()):
+			targets = list
+		}
+		if len(targets) == 0 {
+			h(w, r)
+			return
+		}
+
+		var addrs []string
+		for _, m := range list {
+			addrs = append(addrs, clientAddrOf(m))
+		}
+		w.Header().Set(ownersHeader, strings.Join(addrs, ","))
+		if routingMode == routeRedirect && !local {
+			http.Redirect(w, r, "http://"+clientAddrOf(targets[0])+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "", err.Error())
+			return
+		}
+		err = forward(w, r, body, targets)
+		switch {
+		case err == nil:
+		case local || routingMode == "":
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			h(w, r)
+		default:
+			http.Error(w, fmt.Sprintf("no replica of the key answered: %v", err), http.StatusBadGateway)
+		}
+	}
+}
+
+// forward relays r, with body, to the first of targets that answers. It
+// writes nothing to w when none does.
+func forward(w http.ResponseWriter, r *http.Request, body []byte, targets []string) error {
+	var lastErr error
+	for _, m := range targets {
+		out, err := http.NewRequest(r.Method, "http://"+clientAddrOf(m)+r.URL.RequestURI(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		out.Header = r.Header.Clone()
+		out.Header.Set(routedHeader, self)
+		resp, err := http.DefaultClient.Do(out)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		defer resp.Body.Close()
+		for k, vs := range resp.Header {
+			w.Header()[k] = vs
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return nil
+	}
+	return lastErr
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRouting_ProxyAndRedirectToTheOwner(t *testing.T) {
+	ports := []int{9144, 9145, 9146}
+	var all []string
+	for _, p := range ports {
+		all = append(all, fmt.Sprintf("localhost:%d", p))
+	}
+	for i, p := range ports {
+		routing := "-ROUTING=proxy"
+		if i == 2 {
+			routing = "-ROUTING=redirect"
+		}
+		others := slices.Delete(slices.Clone(all), i, i+1)
+		n := startNode(t, p, others, false, 3, 1, 1, "-LEADERLESS", "-REPLICAS=1", routing)
+		defer n.Process.Kill()
+	}
+	waitReady(t, ports...)
+
+	// a key only the second node holds
+	r := NewRing(all, 64)
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("k%d", i)
+		if r.PreferenceList(key, 1, func(string) string { return "" })[0] == all[1] {
+			break
+		}
+	}
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/set?key=%s&value=v", all[0], key), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get(ownersHeader) != all[1] {
+		t.Fatalf("proxied write = %d, owners %q", resp.StatusCode, resp.Header.Get(ownersHeader))
+	}
+	if _, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=%s", all[0], key)); code != http.StatusNotFound {
+		t.Fatalf("the entry node kept a copy (%d)", code)
+	}
+	if e, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=%s", all[1], key)); code != http.StatusOK || e.Value != "v" {
+		t.Fatalf("owner has %+v (%d)", e, code)
+	}
+	if e, code := getEntry(t, fmt.Sprintf("http://%s/get?key=%s", all[0], key)); code != http.StatusOK || e.Value != "v" {
+		t.Fatalf("proxied read = %+v (%d)", e, code)
+	}
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err = noFollow.Get(fmt.Sprintf("http://%s/get?key=%s", all[2], key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); resp.StatusCode != http.StatusTemporaryRedirect || !strings.HasPrefix(loc, "http://"+all[1]+"/get?") {
+		t.Fatalf("redirect = %d to %q", resp.StatusCode, loc)
+	}
+}
+
+func TestRouting_KeyCoordinatorOrdersWritesAtThePrimary(t *testing.T) {
+	ports := []int{9147, 9148, 9149}
+	var all []string
+	for _, p := range ports {
+		all = append(all, fmt.Sprintf("localhost:%d", p))
+	}
+	nodes := map[string]*exec.Cmd{}
+	for i, p := range ports {
+		others := slices.Delete(slices.Clone(all), i, i+1)
+		nodes[all[i]] = startNode(t, p, others, false, 3, 1, 2, "-LEADERLESS", "-KEY_COORDINATOR")
+		defer nodes[all[i]].Process.Kill()
+	}
+	waitReady(t, ports...)
+
+	// a key whose primary is the first node
+	r := NewRing(all, 64)
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("k%d", i)
+		if r.PreferenceList(key, 3, func(string) string { return "" })[0] == all[0] {
+			break
+		}
+	}
+	write := func(value string) {
+		t.Helper()
+		resp, err := http.Post(fmt.Sprintf("http://%s/set?key=%s&value=%s", all[2], key, value), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("write %s = %d", value, resp.StatusCode)
+		}
+	}
+
+	write("v1")
+	// the primary's own copy: W=2 needs only one of the other two
+	if e, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=%s", all[0], key)); code != http.StatusOK || e.Node != all[0] {
+		t.Fatalf("write coordinated by %q, want the primary %s (%d)", e.Node, all[0], code)
+	}
+
+	// with the primary down the next replica takes over
+	nodes[all[0]].Process.Kill()
+	write("v2")
+	if e, code := getEntry(t, fmt.Sprintf("http://%s/local_read?key=%s", all[2], key)); code != http.StatusOK || e.Value != "v2" || e.Node == all[0] {
+		t.Fatalf("after the primary died: %+v (%d)", e, code)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// KV is one key and its entry in a /scan listing.
+type KV struct {
+	Key string `json:"key"`
+	Entry
+}
+
+// MarshalJSON puts the key in front of the entry's fields, which Entry's
+// own MarshalJSON would otherwise leave out.
+func (kv KV) MarshalJSON() ([]byte, error) {
+	e, err := json.Marshal(kv.Entry)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := json.Marshal(kv.Key)
+	return append(append(append([]byte(`{"key":`), key...), ','), e[1:]...), nil
+}
+
+func (kv *KV) UnmarshalJSON(bs []byte) error {
+	var k struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(bs, &k); err != nil {
+		return err
+	}
+	kv.Key = k.Key
+	return json.Unmarshal(bs, &kv.Entry)
+}
+
+// scanHandler lists this node's live entries whose key starts with
+// ?prefix=, sorted by key, a page at a time (see page.go).
+func scanHandler(w http.ResponseWriter, r *http.Request) {
+	l, ok := parseListing(w, r)
+	if !ok {
+		return
+	}
+	keys, next := l.keys(liveNow)
+	if next != "" {
+		w.Header().Set(cursorHeader, next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("["))
+	first := true
+	streamEntries(w, keys, liveNow, func(kv KV) error {
+		if !first {
+			w.Write([]byte(","))
+		}
+		first = false
+		bs, _ := json.Marshal(kv)
+		_, err := w.Write(bs)
+		return err
+	})
+	w.Write([]byte("]"))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Entries are versioned so their format can grow without breaking a
+// mixed-version cluster. Schema names the format an entry was written in;
+// 0 is the one this release writes, so nothing stored or sent so far
+// changes. A release that adds fields bumps entrySchema (and
+// peerProtocol), and appends the fields to the end of Entry.
+//
+// A node keeps the fields of a newer entry it does not know in Extra and
+// writes them back out after its own, in name order, wherever the entry
+// goes next: the WAL, dumps, replica reads and replication to peers that
+// keep them too (protocolSchema). Its encoding of the entry is then the
+// one its writer made, so the checksum still holds and nothing is lost
+// on the way to a node that understands it.
+
+const entrySchema = 0 // the format this node writes
+
+// entryFields are the JSON names this node knows in Entry.
+var entryFields = func() map[string]bool {
+	known := map[string]bool{}
+	t := reflect.TypeOf(Entry{})
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+	return known
+}()
+
+// entryJSON is Entry without its methods, for encoding the known fields.
+type entryJSON Entry
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	bs, err := json.Marshal(entryJSON(e))
+	if err != nil || len(e.Extra) == 0 {
+		return bs, err
+	}
+	names := make([]string, 0, len(e.Extra))
+	for name := range e.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.Write(bs[:len(bs)-1])
+	for _, name := range names {
+		n, _ := json.Marshal(name)
+		buf.WriteByte(',')
+		buf.Write(n)
+		buf.WriteByte(':')
+		buf.Write(e.Extra[name])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (e *Entry) UnmarshalJSON(bs []byte) error {
+	if err := json.Unmarshal(bs, (*entryJSON)(e)); err != nil {
+		return err
+	}
+	if e.Schema <= entrySchema {
+		return nil // nothing we do not know
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(bs, &all); err != nil {
+		return err
+	}
+	for name, raw := range all {
+		if entryFields[name] {
+			continue
+		}
+		if e.Extra == nil {
+			e.Extra = map[string]json.RawMessage{}
+		}
+		e.Extra[name] = raw
+	}
+	return nil
+}
+
+// forwardQuery adds what entryQuery carries of an entry from a newer
+// schema: its version and, for peers that keep them, its unknown fields.
+// It returns e as the peer will rebuild it, for the checksum.
+func forwardQuery(q url.Values, peer string, e Entry) Entry {
+	if e.Schema == 0 {
+		return e
+	}
+	if peerProtocolFor(peer) < protocolSchema {
+		e.Schema, e.Extra = 0, nil // not sent
+		return e
+	}
+	q.Set("schema", strconv.Itoa(e.Schema))
+	if len(e.Extra) > 0 {
+		extra, _ := json.Marshal(e.Extra)
+		q.Set("extra", string(extra))
+	}
+	return e
+}
+
+// parseForwarded reads forwardQuery's parameters into e.
+func parseForwarded(q url.Values, e *Entry) error {
+	if v := q.Get("schema"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid schema %q", v)
+		}
+		e.Schema = n
+	}
+	if v := q.Get("extra"); v != "" {
+		if err := json.Unmarshal([]byte(v), &e.Extra); err != nil {
+			return fmt.Errorf("invalid extra: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEntrySchema_KeepsNewerFields(t *testing.T) {
+	// an entry as a release with schema 1 might write it, fields appended
+	newer := []byte(`{"value":"v","timestamp":7,"node":"n2","schema":1,"region":"eu","tier":{"class":2}}`)
+	var e Entry
+	if err := json.Unmarshal(newer, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Value != "v" || e.Schema != 1 || string(e.Extra["region"]) != `"eu"` || string(e.Extra["tier"]) != `{"class":2}` {
+		t.Fatalf("decoded %+v", e)
+	}
+	if bs, _ := json.Marshal(e); string(bs) != string(newer) {
+		t.Fatalf("re-encoded as\n%s\nwant\n%s", bs, newer)
+	}
+
+	// the checksum its writer made still holds here, and over /replicate
+	// to a peer that keeps the fields
+	e.Checksum = e.sum("k")
+	sealed, _ := json.Marshal(e)
+	var back Entry
+	json.Unmarshal(sealed, &back)
+	if err := checkReceived("k", back); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/replicate?"+entryQuery("schema:1", "k", e), nil)
+	var in Entry
+	in.Value, in.Timestamp, in.Node = r.URL.Query().Get("value"), 7, r.URL.Query().Get("node")
+	if err := parseForwarded(r.URL.Query(), &in); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkQueryChecksum(r, "k", in); err != nil || string(in.Extra["region"]) != `"eu"` {
+		t.Fatalf("replicated %+v: %v", in, err)
+	}
+
+	// a peer that predates schemas gets the entry without them, and a
+	// checksum to match
+	peerProtocols.Store("old:1", protocolBatches)
+	defer peerProtocols.Delete("old:1")
+	r = httptest.NewRequest("POST", "/replicate?"+entryQuery("old:1", "k", e), nil)
+	if q := r.URL.Query(); q.Has("schema") || q.Has("extra") {
+		t.Fatalf("sent %v to an old peer", q)
+	}
+	if err := checkQueryChecksum(r, "k", Entry{Value: "v", Timestamp: 7, Node: "n2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries in this release's format are encoded as before
+	if bs, _ := json.Marshal(KV{Key: "k", Entry: Entry{Value: "v", Timestamp: 1}}); string(bs) != `{"key":"k","value":"v","timestamp":1}` {
+		t.Fatalf("KV encoded as %s", bs)
+	}
+}
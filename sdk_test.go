@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"kvstore/client"
+)
+
+func TestClient_RedirectCASAndDelete(t *testing.T) {
+	lPort, fPort := 9071, 9072
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	// N=3 W=2 keeps the follower from coordinating writes itself
+	leader := startNode(t, lPort, []string{addr(fPort)}, true, 3, 1, 2)
+	f := startNode(t, fPort, []string{addr(lPort)}, false, 3, 1, 2)
+	defer leader.Process.Kill()
+	defer f.Process.Kill()
+	waitReady(t, lPort, fPort)
+
+	// the follower redirects writes to the leader once heartbeats name it,
+	// and until then refuses them as not the leader, so the client retries
+	ctx := context.Background()
+	c := client.New(addr(fPort))
+	if err := c.Set(ctx, "name", "a b&c"); err != nil {
+		t.Fatalf("Set via follower: %v", err)
+	}
+	e, err := c.Get(ctx, "name", client.ReadQuorum(2))
+	if err != nil || e.Value != "a b&c" {
+		t.Fatalf("Get: expected %q, got %+v (%v)", "a b&c", e, err)
+	}
+
+	if ok, err := c.CAS(ctx, "name", "wrong", "z"); ok || err != nil {
+		t.Errorf("CAS with wrong expectation: ok=%v err=%v", ok, err)
+	}
+	if ok, err := c.CAS(ctx, "name", "a b&c", "z"); !ok || err != nil {
+		t.Errorf("CAS with matching expectation: ok=%v err=%v", ok, err)
+	}
+
+	if err := c.Delete(ctx, "name"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := c.Get(ctx, "name", client.ReadQuorum(2)); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Get after Delete: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_BadRequestIsFinal(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "key too long", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL)
+	err := c.Set(context.Background(), "k", "v")
+	if err == nil || calls.Load() != 1 {
+		t.Fatalf("Set answered 400 without X-Leader: err=%v after %d requests, want an error after 1", err, calls.Load())
+	}
+}
+
+func TestClient_NextEndpointWhenFirstKnowsNoLeader(t *testing.T) {
+	lPort := 9187
+	leader := startNode(t, lPort, nil, true, 1, 1, 1)
+	defer leader.Process.Kill()
+	waitReady(t, lPort)
+
+	// a node that knows no leader, like a follower before its first
+	// heartbeat or a leader just deposed
+	was := currentLeader()
+	setLeader("")
+	defer setLeader(was)
+	var calls atomic.Int32
+	lost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		rejectNonLeaderWrite(w)
+	}))
+	defer lost.Close()
+
+	c := client.New(lost.URL, fmt.Sprintf("localhost:%d", lPort))
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := c.Set(ctx, "k", "v"); err != nil {
+			t.Fatalf("Set %d: %v", i, err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("the node without a leader got %d writes, want 1 before the client moved on", n)
+	}
+}
+
+func TestSteppingDownForgetsSelfAsLeader(t *testing.T) {
+	wasLeader, was, epoch := isLeader.Load(), currentLeader(), currentEpoch.Load()
+	defer func() {
+		isLeader.Store(wasLeader)
+		setLeader(was)
+		currentEpoch.Store(epoch)
+	}()
+	isLeader.Store(true)
+	setLeader(self)
+
+	observeEpoch(epoch + 1)
+	if isLeader.Load() || currentLeader() != "" {
+		t.Fatalf("after a newer epoch: leader=%v, following %q", isLeader.Load(), currentLeader())
+	}
+	rec := httptest.NewRecorder()
+	rejectNonLeaderWrite(rec)
+	if rec.Code != http.StatusBadRequest || rec.Header().Get(notLeaderHeader) == "" || rec.Header().Get("X-Leader") != "" {
+		t.Errorf("deposed leader's refusal = %d, %v", rec.Code, rec.Header())
+	}
+}
+
+func TestClient_Scan(t *testing.T) {
+	port := 9191
+	node := startNode(t, port, nil, true, 1, 1, 1)
+	defer node.Process.Kill()
+	waitReady(t, port)
+
+	ctx := context.Background()
+	c := client.New(fmt.Sprintf("localhost:%d", port))
+	for _, k := range []string{"user:c", "user:a", "other", "user:b"} {
+		if err := c.Set(ctx, k, "v-"+k); err != nil {
+			t.Fatalf("Set %s: %v", k, err)
+		}
+	}
+	if err := c.Delete(ctx, "user:b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	keys := func(kvs []client.KV) []string {
+		var out []string
+		for _, kv := range kvs {
+			out = append(out, kv.Key)
+		}
+		return out
+	}
+	kvs, err := c.Scan(ctx, "user:", 0)
+	if err != nil || fmt.Sprint(keys(kvs)) != "[user:a user:c]" {
+		t.Fatalf("Scan(user:) = %v, %v; want [user:a user:c]", keys(kvs), err)
+	}
+	if kvs[0].Value != "v-user:a" || kvs[0].Timestamp == 0 {
+		t.Errorf("Scan entry %+v, want user:a's value and timestamp", kvs[0])
+	}
+	if kvs, err := c.Scan(ctx, "", 2); err != nil || fmt.Sprint(keys(kvs)) != "[other user:a]" {
+		t.Errorf("Scan limit 2 = %v, %v; want [other user:a]", keys(kvs), err)
+	}
+	if kvs, err := c.Scan(ctx, "nobody:", 0); err != nil || len(kvs) != 0 {
+		t.Errorf("Scan of an empty prefix = %v, %v; want none", keys(kvs), err)
+	}
+}
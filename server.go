@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Middleware wraps a handler with a cross-cutting concern.
+type Middleware func(http.Handler) http.Handler
+
+// Server owns a set of routes and the middleware every request to them
+// passes through. The client API and, with -PEER_PORT, the peer API are
+// each a Server.
+type Server struct {
+	mux   *http.ServeMux
+	chain []Middleware
+}
+
+// NewServer returns a Server with the default chain: gzip, access log,
+// request metrics, peer protocol and cluster identity checks, and panic
+// recovery, outermost first.
+func NewServer() *Server {
+	s := &Server{mux: http.NewServeMux()}
+	s.Use(gzipHandler, accessLog, requestMetrics, checkPeerProtocol, checkClusterIdentity, recoverHandler)
+	return s
+}
+
+var (
+	api     = NewServer()
+	peerAPI = api // replaced by separatePeerPort
+)
+
+// Use appends middleware to the chain; earlier middleware sees the
+// request first.
+func (s *Server) Use(mw ...Middleware) { s.chain = append(s.chain, mw...) }
+
+func (s *Server) HandleFunc(pattern string, h http.HandlerFunc) { s.mux.HandleFunc(pattern, h) }
+
+// Handler is the server's routes wrapped in its middleware chain.
+func (s *Server) Handler() http.Handler {
+	var h http.Handler = s.mux
+	for i := len(s.chain) - 1; i >= 0; i-- {
+		h = s.chain[i](h)
+	}
+	return h
+}
+
+// Limits every HTTPServer applies, set by the -HTTP_* flags. The header
+// limit covers the request line, so it leaves room for a maximal value
+// sent URL-encoded in /set?value=.
+var (
+	readHeaderTimeout = 5 * time.Second
+	httpWriteTimeout  = 30 * time.Second
+	idleTimeout       = 2 * time.Minute
+	maxHeaderBytes    = 4 << 20
+)
+
+// HTTPServer serves s on addr over HTTP/1.1 and HTTP/2, the latter over
+// TLS when certificates are given and as h2c otherwise.
+func (s *Server) HTTPServer(addr string) *http.Server {
+	var protos http.Protocols
+	protos.SetHTTP1(true)
+	protos.SetHTTP2(true)
+	protos.SetUnencryptedHTTP2(true)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		Protocols:         &protos,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      httpWriteTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+}
+
+// serve accepts srv's connections on ln, over TLS when cert is set, within
+// the -MAX_CONNS limit.
+func serve(srv *http.Server, ln net.Listener, cert, key string) error {
+	ln = limitConns(ln)
+	if cert != "" {
+		return srv.ServeTLS(ln, cert, key)
+	}
+	return srv.Serve(ln)
+}
+
+// maxConns caps the connections open across all of the node's HTTP
+// listeners, set by -MAX_CONNS (0 = unlimited). Past it, accepting waits
+// for a connection to close and new ones queue in the kernel backlog.
+var (
+	maxConns  int
+	connSlots chan struct{}
+	openConns atomic.Int64
+)
+
+func limitConns(ln net.Listener) net.Listener {
+	if maxConns <= 0 {
+		return ln
+	}
+	if connSlots == nil {
+		connSlots = make(chan struct{}, maxConns)
+	}
+	return &limitedListener{Listener: ln, slots: connSlots}
+}
+
+type limitedListener struct {
+	net.Listener
+	slots chan struct{}
+}
+
+func (l *limitedListener) Accept() (net.Conn, error) {
+	l.slots <- struct{}{}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	openConns.Add(1)
+	return &limitedConn{Conn: c, release: func() { openConns.Add(-1); <-l.slots }}, nil
+}
+
+// limitedConn gives its slot back when closed, once.
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
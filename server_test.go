@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerMiddlewareChain(t *testing.T) {
+	s := NewServer()
+	var order []string
+	tag := func(name string) Middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	s.Use(tag("a"), tag("b"))
+	s.HandleFunc("/chain/{id}", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/chain/7", nil))
+	if rec.Code != http.StatusTeapot || strings.Join(order, ",") != "a,b,handler" {
+		t.Fatalf("status %d, order %v", rec.Code, order)
+	}
+
+	// requestMetrics labels by the matched pattern, not the raw path
+	var out bytes.Buffer
+	writeHTTPMetrics(&out)
+	if want := `kv_http_requests_total{route="/chain/{id}",code="418"} 1`; !strings.Contains(out.String(), want) {
+		t.Fatalf("metrics missing %s:\n%s", want, out.String())
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	maxConns, connSlots = 1, nil
+	defer func() { maxConns, connSlots = 0, nil }()
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := limitConns(raw)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", raw.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("second connection accepted past -MAX_CONNS=1")
+	case <-time.After(100 * time.Millisecond):
+	}
+	first.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("second connection not accepted once the first closed")
+	}
+}
+
+func TestSlowHeadersTimeOut(t *testing.T) {
+	old := readHeaderTimeout
+	readHeaderTimeout = 100 * time.Millisecond
+	defer func() { readHeaderTimeout = old }()
+	srv := NewServer().HTTPServer("")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serve(srv, ln, "", "")
+	defer srv.Close()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("GET /health HTTP/1.1\r\nHost: x\r\n")) // and never finish
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1)
+	for {
+		if _, err := c.Read(buf); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("slow-loris connection still open past ReadHeaderTimeout")
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// /delete?soft=true hides a key like any delete but leaves its value in
+// the tombstone, restorable until the entry's Restorable time, which is
+// -SOFT_DELETE_RETENTION after the delete. /restore writes the kept value
+// back as a fresh write. Both are ordinary coordinated writes, so they
+// replicate and resolve like /set and /delete. Once the window has passed
+// every node strips the kept value on its own, leaving a plain tombstone.
+
+var softDeleteRetention = 24 * time.Hour
+
+// softDelete keeps the current value in the tombstone e.
+func softDelete(cur Entry, ok bool, e *Entry) bool {
+	if !ok || cur.Deleted || cur.expired(time.Now().UnixNano()) {
+		return false
+	}
+	e.Value, e.Type = cur.Value, cur.Type
+	e.Restorable = time.Now().Add(softDeleteRetention).UnixNano()
+	return true
+}
+
+// restorable reports whether cur is a soft delete still in its window.
+func restorable(cur Entry, ok bool) bool {
+	return ok && cur.Deleted && cur.Restorable > time.Now().UnixNano()
+}
+
+// restoreHandler brings back a soft-deleted key's value.
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	cond := func(cur Entry, ok bool, e *Entry) bool {
+		if !restorable(cur, ok) {
+			return false
+		}
+		e.Value, e.Type = cur.Value, cur.Type
+		return true
+	}
+	coordinateWrite(w, r, key, Entry{}, cond)
+}
+
+// startSoftDeleteSweeper strips the kept values of soft deletes whose
+// window has passed.
+func startSoftDeleteSweeper() {
+	every := min(softDeleteRetention, time.Minute)
+	go func() {
+		for range time.Tick(every) {
+			now := time.Now().UnixNano()
+			svc.Lock()
+			for k, e := range svc.data {
+				if e.Deleted && e.Restorable != 0 && e.Restorable <= now {
+					e.Value, e.Type, e.Restorable = "", "", 0
+					svc.put(k, e)
+				}
+			}
+			svc.Unlock()
+		}
+	}()
+}
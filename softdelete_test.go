@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	p1, p2 := 9120, 9121
+	a := startNode(t, p1, []string{fmt.Sprintf("localhost:%d", p2)}, true, 2, 1, 2, "-SOFT_DELETE_RETENTION", "1s")
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{fmt.Sprintf("localhost:%d", p1)}, false, 2, 1, 2, "-SOFT_DELETE_RETENTION", "1s")
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+
+	post := func(q string) int {
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d%s", p1, q), "", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	post("/set?key=doc&value=draft")
+	post("/set?key=old&value=gone")
+	if code := post("/delete?key=doc&soft=true"); code != http.StatusOK {
+		t.Fatalf("soft delete = %d", code)
+	}
+	if _, code := getEntry(t, fmt.Sprintf("http://localhost:%d/local_read?key=doc", p2)); code != http.StatusNotFound {
+		t.Fatalf("soft-deleted key visible on the follower: %d", code)
+	}
+	if code := post("/restore?key=doc"); code != http.StatusCreated {
+		t.Fatalf("restore = %d", code)
+	}
+	if e, _ := getEntry(t, fmt.Sprintf("http://localhost:%d/local_read?key=doc", p2)); e.Value != "draft" {
+		t.Fatalf("follower after restore = %+v", e)
+	}
+
+	// past the window the kept value is dropped and restore refused
+	post("/delete?key=old&soft=true")
+	time.Sleep(2500 * time.Millisecond)
+	if code := post("/restore?key=old"); code != http.StatusPreconditionFailed {
+		t.Fatalf("restore after the window = %d, want 412", code)
+	}
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/dump?prefix=old", p2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(bs), "gone") {
+		t.Fatalf("expired soft delete still holds its value: %s", bs)
+	}
+}
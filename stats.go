@@ -0,0 +1,168 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Every -STATS_INTERVAL a collector samples the store for /stats: live
+// keys and their bytes (as quotas count them), the rate of coordinated
+// reads and writes (those in kv_op_latency_seconds), and how often taking
+// the store lock had to wait. The store is one map under one lock, so
+// that lock is its only shard. /stats shows the newest sample and the
+// last statsWindow of them, oldest first.
+
+const statsWindow = 60 // samples kept
+
+var statsInterval = time.Second // -STATS_INTERVAL, 0 = off
+
+// lockCounters count acquisitions of a lock, those that found it held,
+// and the time they waited.
+type lockCounters struct {
+	acquired, contended, waitedNanos atomic.Int64
+}
+
+var storeLock lockCounters
+
+func (c *lockCounters) note(free bool, start time.Time) {
+	c.acquired.Add(1)
+	if !free {
+		c.contended.Add(1)
+		c.waitedNanos.Add(int64(time.Since(start)))
+	}
+}
+
+// Lock and RLock take the store lock, counting contention for /stats.
+func (s *Store) Lock() {
+	if s.RWMutex.TryLock() {
+		storeLock.note(true, time.Time{})
+		return
+	}
+	start := time.Now()
+	s.RWMutex.Lock()
+	storeLock.note(false, start)
+}
+
+func (s *Store) RLock() {
+	if s.RWMutex.TryRLock() {
+		storeLock.note(true, time.Time{})
+		return
+	}
+	start := time.Now()
+	s.RWMutex.RLock()
+	storeLock.note(false, start)
+}
+
+// lockStats is one lock's contention over a sampling interval.
+type lockStats struct {
+	Acquired  int64   `json:"acquired"`
+	Contended int64   `json:"contended"`
+	WaitedMs  float64 `json:"waited_ms"`
+}
+
+type statsSample struct {
+	Time         time.Time            `json:"time"`
+	Keys         int64                `json:"keys"`
+	Bytes        int64                `json:"bytes"`
+	ReadsPerSec  float64              `json:"reads_per_sec"`
+	WritesPerSec float64              `json:"writes_per_sec"`
+	Locks        map[string]lockStats `json:"locks"`
+}
+
+// storeSeries is the collector's part of /stats.
+type storeSeries struct {
+	IntervalMs int64         `json:"interval_ms"`
+	Current    *statsSample  `json:"current"`
+	Series     []statsSample `json:"series"`
+}
+
+var statsSamples struct {
+	sync.Mutex
+	ring  [statsWindow]statsSample
+	count int
+}
+
+// totals the collector turns into rates between samples.
+type statsTotals struct {
+	at                                     time.Time
+	reads, writes, acquired, contended, ns int64
+}
+
+func currentTotals() statsTotals {
+	t := statsTotals{at: time.Now()}
+	opLatency.Lock()
+	for k, h := range opLatency.byOp {
+		switch k[0] {
+		case "read":
+			t.reads += h.count
+		case "write":
+			t.writes += h.count
+		}
+	}
+	opLatency.Unlock()
+	t.acquired, t.contended, t.ns = storeLock.acquired.Load(), storeLock.contended.Load(), storeLock.waitedNanos.Load()
+	return t
+}
+
+func startStatsCollector() {
+	if statsInterval <= 0 {
+		return
+	}
+	go func() {
+		prev := currentTotals()
+		for range time.Tick(statsInterval) {
+			prev = collectStats(prev)
+		}
+	}()
+}
+
+// collectStats records a sample of the interval since prev, returning the
+// totals that start the next one.
+func collectStats(prev statsTotals) statsTotals {
+	var keys, bytes int64
+	svc.RLock()
+	for k, e := range svc.data {
+		if e.live() {
+			keys++
+			bytes += entrySize(k, e)
+		}
+	}
+	svc.RUnlock()
+	now := currentTotals()
+	secs := now.at.Sub(prev.at).Seconds()
+	s := statsSample{
+		Time:         now.at.UTC(),
+		Keys:         keys,
+		Bytes:        bytes,
+		ReadsPerSec:  float64(now.reads-prev.reads) / secs,
+		WritesPerSec: float64(now.writes-prev.writes) / secs,
+		Locks: map[string]lockStats{"store": {
+			Acquired:  now.acquired - prev.acquired,
+			Contended: now.contended - prev.contended,
+			WaitedMs:  float64(now.ns-prev.ns) / 1e6,
+		}},
+	}
+	statsSamples.Lock()
+	statsSamples.ring[statsSamples.count%statsWindow] = s
+	statsSamples.count++
+	statsSamples.Unlock()
+	return now
+}
+
+// recentStats is the collector's samples for /stats, nil while it is off.
+func recentStats() *storeSeries {
+	if statsInterval <= 0 {
+		return nil
+	}
+	statsSamples.Lock()
+	defer statsSamples.Unlock()
+	out := &storeSeries{IntervalMs: statsInterval.Milliseconds(), Series: []statsSample{}}
+	for i := max(0, statsSamples.count-statsWindow); i < statsSamples.count; i++ {
+		out.Series = append(out.Series, statsSamples.ring[i%statsWindow])
+	}
+	if n := len(out.Series); n > 0 {
+		out.Current = &out.Series[n-1]
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsCollector(t *testing.T) {
+	oldData := svc.data
+	defer func() { svc.data = oldData }()
+	svc.data = map[string]Entry{}
+	svc.put("a", Entry{Value: "12345", Timestamp: 1})
+	svc.put("b", Entry{Value: "x", Timestamp: 1, Deleted: true})
+
+	prev := currentTotals()
+	prev.at = prev.at.Add(-2 * time.Second) // a two-second interval
+	for range 4 {
+		observeOp("read", "one", time.Now())
+	}
+	observeOp("write", "one", time.Now())
+
+	// a writer that has to wait for a reader
+	svc.RLock()
+	locked := make(chan struct{})
+	go func() {
+		svc.Lock()
+		svc.Unlock()
+		close(locked)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	svc.RUnlock()
+	<-locked
+
+	collectStats(prev)
+	st := recentStats()
+	s := st.Current
+	if s == nil || s.Keys != 1 || s.Bytes != 6 {
+		t.Fatalf("sample %+v, want the one live key of 6 bytes", s)
+	}
+	if s.ReadsPerSec < 1.9 || s.ReadsPerSec > 2.1 || s.WritesPerSec < 0.4 || s.WritesPerSec > 0.6 {
+		t.Errorf("%.2f reads/s, %.2f writes/s; want 2 and 0.5", s.ReadsPerSec, s.WritesPerSec)
+	}
+	if l := s.Locks["store"]; l.Contended < 1 || l.WaitedMs < 10 || l.Acquired < l.Contended {
+		t.Errorf("store lock %+v, want the writer's wait counted", l)
+	}
+
+	for range statsWindow + 5 {
+		prev = collectStats(prev)
+	}
+	if st := recentStats(); len(st.Series) != statsWindow || st.Series[statsWindow-1].Time != st.Current.Time {
+		t.Fatalf("%d samples kept, want the last %d", len(st.Series), statsWindow)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// -STRICT refuses quorum settings that cannot give read-your-writes: R+W
+// must exceed N (or -REPLICAS, when fewer nodes hold each key) so every
+// read quorum overlaps every write quorum, and N
+// must count exactly this node and its -PEERS. Such a node will not start,
+// and /config answers 400 without changing anything. Without -STRICT any
+// settings are taken, with a warning, so broken configurations can be
+// demonstrated.
+
+var strictQuorum bool
+
+// checkQuorumConfig reports why n, r and w are unsafe for a cluster of
+// members nodes, or nil if they are not.
+func checkQuorumConfig(n, r, w, members int) error {
+	copies, name := n, "N"
+	if replicationFactor > 0 && replicationFactor < n {
+		copies, name = replicationFactor, "REPLICAS"
+	}
+	switch {
+	case r+w <= copies:
+		return fmt.Errorf("R+W=%d does not exceed %s=%d, so reads can miss acknowledged writes", r+w, name, copies)
+	case members != n:
+		return fmt.Errorf("N=%d but the cluster has %d nodes (this one and %d peers)", n, members, members-1)
+	}
+	return nil
+}
+
+// checkStartupQuorum applies -STRICT to the settings the node started with.
+func checkStartupQuorum() {
+	c := cluster()
+	err := checkQuorumConfig(c.N, c.R, c.W, len(c.peers)+1)
+	switch {
+	case err == nil:
+	case strictQuorum:
+		log.Fatalf("-STRICT: %v", err)
+	default:
+		log.Printf("warning: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStrictConfigRefusesUnsafeQuorums(t *testing.T) {
+	oldStrict := strictQuorum
+	defer func() { strictQuorum = oldStrict }()
+	withCluster(t, func(c *membership) { c.N, c.R, c.W, c.peers = 3, 2, 2, []string{"kv2:8000", "kv3:8000"} })
+
+	config := func(q string) int {
+		rec := httptest.NewRecorder()
+		configHandler(rec, httptest.NewRequest(http.MethodPost, "/config?"+q, nil))
+		return rec.Code
+	}
+	strictQuorum = true
+	for _, q := range []string{"W=1", "R=1", "N=4", "N=5&R=3&W=3"} {
+		if code := config(q); code != http.StatusBadRequest {
+			t.Errorf("strict /config?%s = %d, want 400", q, code)
+		}
+	}
+	if cluster().N != 3 || cluster().R != 2 || cluster().W != 2 {
+		t.Fatalf("refused changes applied: N=%d R=%d W=%d", cluster().N, cluster().R, cluster().W)
+	}
+	if code := config("R=1&W=3"); code != http.StatusOK || cluster().R != 1 || cluster().W != 3 {
+		t.Fatalf("strict /config?R=1&W=3 = %d, R=%d W=%d", code, cluster().R, cluster().W)
+	}
+
+	strictQuorum = false
+	if code := config("R=1&W=1"); code != http.StatusOK || cluster().R != 1 || cluster().W != 1 {
+		t.Fatalf("relaxed /config?R=1&W=1 = %d, R=%d W=%d", code, cluster().R, cluster().W)
+	}
+}
+
+func TestStrictNodeRefusesToStart(t *testing.T) {
+	node := startNode(t, 9129, nil, true, 3, 1, 1, "-STRICT")
+	done := make(chan error, 1)
+	go func() { done <- node.Wait() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("node with R+W<=N under -STRICT exited cleanly")
+		}
+	case <-time.After(5 * time.Second):
+		node.Process.Kill()
+		t.Fatal("node with R+W<=N under -STRICT started")
+	}
+}
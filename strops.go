@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// /append and /getset change a key from its current value on the node
+// coordinating the write, under the store lock, the way /cas checks it:
+// /append adds ?value= to the end of the value (a missing key starts
+// empty), and /getset replaces the value and answers with the entry it
+// replaced. Replicas receive the resulting value as an ordinary write, so
+// it resolves like any other; under a leader every such update is
+// serialized, while in a leaderless cluster two nodes appending to the
+// same key at once still race and the later one wins. CRDT values and
+// appends that would grow past the value limit are refused with 412.
+
+// appendHandler appends ?value= to key's value.
+func appendHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key, suffix := q.Get("key"), q.Get("value")
+	if key == "" {
+		http.Error(w, "key required", http.StatusBadRequest)
+		return
+	}
+	cond := func(cur Entry, ok bool, e *Entry) bool {
+		if ok && cur.Type != "" {
+			return false
+		}
+		if ok && liveNow(cur) {
+			e.Value = cur.Value
+		}
+		e.Value += suffix
+		return len(e.Value) <= maxValueBytes
+	}
+	coordinateWrite(w, r, key, Entry{}, cond)
+}
+
+// getsetHandler sets key to ?value= and answers with the entry it replaced,
+// or null when there was none.
+func getsetHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := q.Get("key")
+	if key == "" {
+		http.Error(w, "key required", http.StatusBadRequest)
+		return
+	}
+	var old *Entry
+	cond := func(cur Entry, ok bool, _ *Entry) bool {
+		if ok && cur.Type != "" {
+			return false
+		}
+		if ok && liveNow(cur) {
+			prev := cur.unsealed()
+			prev.Siblings = nil
+			old = &prev
+		}
+		return true
+	}
+	rec := &statusRecorder{ResponseWriter: w}
+	rec.Header().Set("Content-Type", "application/json")
+	coordinateWrite(rec, r, key, Entry{Value: q.Get("value")}, cond)
+	if rec.status == http.StatusCreated {
+		json.NewEncoder(w).Encode(old)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestAppendAndGetSet(t *testing.T) {
+	port := 9168
+	n := startNode(t, port, nil, true, 1, 1, 1)
+	defer n.Process.Kill()
+	waitReady(t, port)
+	base := fmt.Sprintf("http://localhost:%d", port)
+
+	post := func(path string, v any) int {
+		resp, err := http.Post(base+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	// concurrent appends all land, in some order
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if code := post("/append?key=log&value=x", nil); code != http.StatusCreated {
+				t.Errorf("append = %d, want 201", code)
+			}
+		}()
+	}
+	wg.Wait()
+	if e, _ := getEntry(t, base+"/get?key=log"); len(e.Value) != 20 {
+		t.Fatalf("value after 20 appends = %q", e.Value)
+	}
+
+	var old *Entry
+	if code := post("/getset?key=name&value=a", &old); code != http.StatusCreated || old != nil {
+		t.Fatalf("getset on a missing key = %d, %+v; want 201, null", code, old)
+	}
+	if code := post("/getset?key=name&value=b", &old); code != http.StatusCreated || old == nil || old.Value != "a" {
+		t.Fatalf("getset = %d, %+v; want 201 with the old value a", code, old)
+	}
+	if e, _ := getEntry(t, base+"/get?key=name"); e.Value != "b" {
+		t.Fatalf("value after getset = %q, want b", e.Value)
+	}
+
+	post("/crdt/incr?key=hits", nil)
+	if code := post("/append?key=hits&value=1", nil); code != http.StatusPreconditionFailed {
+		t.Fatalf("append to a counter = %d, want 412", code)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Nodes carry free-form tags, -TAGS=rack=r7,tier=ssd,version=1.4, next to
+// the dc and zone tags their location gives them (-DC, -ZONE). A node
+// sends its tags in its /handshake answer and with every heartbeat
+// (X-KV-Tags), so peers, including members added later, learn them and
+// see changes after a restart. /peers, /node and /cluster/status show
+// them, and ?tag=name=value (repeatable, all must match) narrows /peers,
+// /cluster/status and /admin/anti_entropy to the members carrying them.
+
+const tagsHeader = "X-KV-Tags"
+
+var (
+	localTags map[string]string // -TAGS
+
+	peerTagsMu sync.Mutex
+	peerTags   = map[string]map[string]string{} // peer address -> its tags
+)
+
+// parseTags reads name=value,... for -TAGS.
+func parseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("tag %q: want name=value", item)
+		}
+		tags[name] = value
+	}
+	return tags, nil
+}
+
+// nodeTags is this node's tags, its location included.
+func nodeTags() map[string]string {
+	tags := map[string]string{"dc": localDC}
+	if localZone != "" {
+		tags["zone"] = localZone
+	}
+	maps.Copy(tags, localTags)
+	return tags
+}
+
+// encodeTags writes tags for the X-KV-Tags header.
+func encodeTags(tags map[string]string) string {
+	q := url.Values{}
+	for k, v := range tags {
+		q.Set(k, v)
+	}
+	return q.Encode()
+}
+
+// noteTags records the tags peer reported.
+func noteTags(peer string, tags map[string]string) {
+	peerTagsMu.Lock()
+	defer peerTagsMu.Unlock()
+	peerTags[peer] = tags
+}
+
+// learnTags records the tags a peer sends with a heartbeat answer.
+func learnTags(peer string, resp *http.Response) {
+	q, err := url.ParseQuery(resp.Header.Get(tagsHeader))
+	if err != nil || len(q) == 0 {
+		return
+	}
+	tags := make(map[string]string, len(q))
+	for k := range q {
+		tags[k] = q.Get(k)
+	}
+	noteTags(peer, tags)
+}
+
+// tagsOf returns member's tags as this node knows them.
+func tagsOf(member string) map[string]string {
+	if member == self {
+		return nodeTags()
+	}
+	peerTagsMu.Lock()
+	defer peerTagsMu.Unlock()
+	return peerTags[member]
+}
+
+// tagFilter reads ?tag=name=value selectors; the filter it returns
+// matches tags carrying every one of them.
+func tagFilter(r *http.Request) (func(map[string]string) bool, error) {
+	want := map[string]string{}
+	for _, s := range r.URL.Query()["tag"] {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("tag %q: want name=value", s)
+		}
+		want[name] = value
+	}
+	return func(tags map[string]string) bool {
+		for k, v := range want {
+			if got, ok := tags[k]; !ok || got != v {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// taggedPeers is the peers r's ?tag= selectors pick.
+func taggedPeers(r *http.Request) ([]string, error) {
+	match, err := tagFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, p := range cluster().peers {
+		if match(tagsOf(p)) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
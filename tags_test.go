@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTagsAndFilter(t *testing.T) {
+	tags, err := parseTags("rack=r7, tier=ssd,")
+	if err != nil || len(tags) != 2 || tags["tier"] != "ssd" {
+		t.Fatalf("parseTags = %v, %v", tags, err)
+	}
+	if _, err := parseTags("rack"); err == nil {
+		t.Fatal("tag without a value accepted")
+	}
+	match, err := tagFilter(httptest.NewRequest("GET", "/peers?tag=tier=ssd&tag=rack=r7", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match(tags) || match(map[string]string{"tier": "ssd"}) {
+		t.Fatal("filter must need every selector")
+	}
+}
+
+func TestPeersLearnEachOthersTags(t *testing.T) {
+	p1, p2 := 9157, 9158
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	a := startNode(t, p1, []string{addr(p2)}, true, 2, 1, 1, "-TAGS", "tier=ssd,version=2")
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{addr(p1)}, false, 2, 1, 1, "-TAGS", "tier=hdd", "-ZONE", "z1")
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+
+	// b may handshake before a listens; then a heartbeat brings the tags
+	var infos []PeerInfo
+	for deadline := time.Now().Add(3 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if err := getJSON(fmt.Sprintf("http://%s/peers", addr(p2)), &infos); err != nil || len(infos) != 1 {
+			t.Fatalf("/peers = %+v, %v", infos, err)
+		}
+		tags := infos[0].Tags
+		if tags["tier"] == "ssd" && tags["version"] == "2" && tags["dc"] == "default" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tags of %s = %v", addr(p1), tags)
+		}
+	}
+	if err := getJSON(fmt.Sprintf("http://%s/peers?tag=tier=hdd", addr(p2)), &infos); err != nil || len(infos) != 0 {
+		t.Fatalf("/peers?tag=tier=hdd = %+v, %v", infos, err)
+	}
+	var st NodeStats
+	if err := getJSON(fmt.Sprintf("http://%s/node", addr(p2)), &st); err != nil || st.Tags["zone"] != "z1" || st.Tags["tier"] != "hdd" {
+		t.Fatalf("/node = %+v, %v", st, err)
+	}
+}
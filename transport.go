@@ -0,0 +1,130 @@
+package main
+
+import (
+	"compress/gzip"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipMinBytes is the smallest response worth compressing; single-key
+// reads stay uncompressed while scans and mgets get gzipped.
+const gzipMinBytes = 1024
+
+// gzipHandler compresses responses of at least gzipMinBytes for clients
+// that send Accept-Encoding: gzip.
+func gzipHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipWriter{ResponseWriter: w, status: http.StatusOK}
+		defer gw.finish()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		f, err := strconv.ParseFloat(q, 64)
+		return err == nil && f > 0
+	}
+	return false
+}
+
+// gzipWriter holds back the first gzipMinBytes of a response so small
+// bodies can go out as-is, then switches to gzip once that is exceeded.
+type gzipWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	gz      *gzip.Writer
+	started bool
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if !g.started {
+		g.status = code
+	}
+}
+
+func (g *gzipWriter) Write(p []byte) (int, error) {
+	if g.started {
+		if g.gz != nil {
+			return g.gz.Write(p)
+		}
+		return g.ResponseWriter.Write(p)
+	}
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= gzipMinBytes {
+		g.start(true)
+	}
+	return len(p), nil
+}
+
+// start sends the header and any buffered bytes, compressed or not.
+// Handlers that set their own Content-Encoding are passed through.
+func (g *gzipWriter) start(compress bool) {
+	g.started = true
+	h := g.Header()
+	if compress && h.Get("Content-Encoding") == "" && g.status != http.StatusNoContent {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+	if len(g.buf) == 0 {
+		return
+	}
+	if g.gz != nil {
+		g.gz.Write(g.buf)
+	} else {
+		g.ResponseWriter.Write(g.buf)
+	}
+	g.buf = nil
+}
+
+func (g *gzipWriter) finish() {
+	if !g.started {
+		g.start(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+func (g *gzipWriter) Flush() {
+	if !g.started {
+		g.start(len(g.buf) >= gzipMinBytes)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+func (g *gzipWriter) Unwrap() http.ResponseWriter { return g.ResponseWriter }
+
+// usePeerH2C switches outgoing peer requests to prior-knowledge h2c so
+// replication to each peer shares one multiplexed connection.
+func usePeerH2C() {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		log.Printf("peer h2c: default transport is %T, leaving HTTP/1.1", http.DefaultTransport)
+		return
+	}
+	var protos http.Protocols
+	protos.SetUnencryptedHTTP2(true)
+	t.Protocols = &protos
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGzipLargeResponses(t *testing.T) {
+	node := startNode(t, 9093, nil, true, 1, 1, 1)
+	defer node.Process.Kill()
+	waitReady(t, 9093)
+
+	for i := 0; i < 100; i++ {
+		http.Post(fmt.Sprintf("http://localhost:9093/set?key=gz%03d&value=%s", i, strings.Repeat("x", 20)), "", nil)
+	}
+
+	// a transport that leaves Content-Encoding for us to inspect
+	c := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	get := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:9093"+path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return resp
+	}
+
+	resp := get("/scan?prefix=gz")
+	defer resp.Body.Close()
+	if ce := resp.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("scan Content-Encoding = %q, want gzip", ce)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	var kvs []KV
+	if err := json.NewDecoder(zr).Decode(&kvs); err != nil || len(kvs) != 100 {
+		t.Fatalf("decoded %d entries, err %v", len(kvs), err)
+	}
+
+	small := get("/get?key=gz000")
+	io.Copy(io.Discard, small.Body)
+	small.Body.Close()
+	if ce := small.Header.Get("Content-Encoding"); ce != "" {
+		t.Fatalf("single get Content-Encoding = %q, want none", ce)
+	}
+}
+
+func TestHTTP2Peers(t *testing.T) {
+	leader := startNode(t, 9094, []string{"localhost:9095"}, true, 2, 1, 2, "-PEER_H2C")
+	defer leader.Process.Kill()
+	follower := startNode(t, 9095, []string{"localhost:9094"}, false, 2, 1, 2, "-PEER_H2C")
+	defer follower.Process.Kill()
+	waitReady(t, 9094, 9095)
+
+	// grpcClient is a plain prior-knowledge h2c client
+	resp, err := grpcClient().Post("http://localhost:9094/set?key=h2&value=yes", "", nil)
+	if err != nil {
+		t.Fatalf("h2c set: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("set over %s answered %d", resp.Proto, resp.StatusCode)
+	}
+
+	// W=2, so the follower already has it, replicated over h2c
+	e, code := getEntry(t, "http://localhost:9095/local_read?key=h2")
+	if code != http.StatusOK || e.Value != "yes" {
+		t.Fatalf("follower has %+v (%d)", e, code)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// /set?ttl=30s gives a key a time to live: the entry carries Expires, the
+// Unix nanosecond it stops being readable, and replicates with it. Reads
+// treat an expired entry as missing straight away (lazy expiry); the
+// sweeper, every -TTL_SWEEP, then replaces it with a tombstone. That
+// tombstone is built from the entry alone, carrying its timestamp, node
+// and clock, so every replica sweeping on its own comes to the same one.
+// It beats the expired value on any merge (a tombstone wins the tie
+// between copies of one version, see newerThan), and loses to any later
+// write, even one a replica that swept had not yet seen. Stamping it at
+// the deadline instead would let it delete such a write. The node that
+// coordinated the write also replicates it, which reaches replicas that
+// missed the write, and publishes it to the change feed, where CDC and
+// webhook events mark it "expired". A write after the deadline is newer
+// than the tombstone and wins as usual.
+//
+// The sweeper only looks at the keys in expiring, which put keeps to the
+// entries with a deadline, and reads them under the store's read lock,
+// taking the write lock only when some have run out. A node without ?ttl=
+// writes never blocks a request to sweep.
+
+var (
+	ttlSweep = time.Second // -TTL_SWEEP
+
+	ttlExpired atomic.Int64 // entries this node's sweeper turned into tombstones
+
+	// expiring is the keys whose entry has a deadline, under the store lock
+	expiring = map[string]struct{}{}
+)
+
+// trackExpiry notes whether key's entry, now e, has a deadline. The caller
+// holds the store lock.
+func trackExpiry(key string, e Entry) {
+	if !e.Deleted && e.Expires != 0 {
+		expiring[key] = struct{}{}
+	} else {
+		delete(expiring, key)
+	}
+}
+
+// retrackExpiry rebuilds expiring from the whole store, as reindex does the
+// indexes.
+func retrackExpiry(data map[string]Entry) {
+	expiring = map[string]struct{}{}
+	for k, e := range data {
+		trackExpiry(k, e)
+	}
+}
+
+// parseTTL reads ?ttl= as a deadline in Unix nanoseconds, 0 without one.
+func parseTTL(r *http.Request) (int64, error) {
+	s := r.URL.Query().Get("ttl")
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("ttl must be a positive duration")
+	}
+	return time.Now().Add(d).UnixNano(), nil
+}
+
+// expired reports whether e is a value whose time to live has run out.
+func (e Entry) expired(now int64) bool {
+	return !e.Deleted && e.Expires != 0 && e.Expires <= now
+}
+
+// expiryOf is the tombstone that replaces the expired entry e.
+func expiryOf(e Entry) Entry {
+	return Entry{Deleted: true, Timestamp: e.Timestamp, Node: e.Node, Owner: e.Owner, Clock: clockOf(e), Expires: e.Expires}
+}
+
+// startTTLSweeper turns expired entries into tombstones every -TTL_SWEEP.
+func startTTLSweeper() {
+	if ttlSweep <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(ttlSweep) {
+			sweepExpired()
+		}
+	}()
+}
+
+// sweepExpired replaces every expired entry with its tombstone, and
+// replicates the tombstones of writes this node coordinated.
+func sweepExpired() {
+	now := time.Now().UnixNano()
+	// a sibling without a deadline keeps the key alive
+	due := func(e Entry, ok bool) bool { return ok && e.expired(now) && !e.live() }
+	var expired []string
+	svc.RLock()
+	for k := range expiring {
+		if e, ok := svc.data[k]; due(e, ok) {
+			expired = append(expired, k)
+		}
+	}
+	svc.RUnlock()
+	if len(expired) == 0 {
+		return
+	}
+
+	mine := map[string]Entry{}
+	svc.Lock()
+	for _, k := range expired {
+		e, ok := svc.data[k]
+		if !due(e, ok) {
+			continue // rewritten since
+		}
+		tomb := expiryOf(e)
+		svc.put(k, tomb)
+		ttlExpired.Add(1)
+		if e.Node == self {
+			mine[k] = tomb
+		}
+	}
+	svc.Unlock()
+	if primaryBackup() {
+		return // every backup sweeps the same tombstones from the primary's stream
+	}
+	for k, tomb := range mine {
+		feedReadReplicas(k, tomb)
+		for _, p := range replicaPeers(k) {
+			asyncRepl.Add(1)
+			go func(p string) {
+				defer asyncRepl.Done()
+				replicateTo(p, k, tomb)
+			}(p)
+		}
+	}
+}
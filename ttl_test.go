@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTTL_ExpiresAndReplicatesTombstone(t *testing.T) {
+	p1, p2 := 9159, 9160
+	a := startNode(t, p1, []string{fmt.Sprintf("localhost:%d", p2)}, true, 2, 1, 2, "-TTL_SWEEP", "100ms")
+	defer a.Process.Kill()
+	// b never sweeps, so its tombstone can only come from a
+	b := startNode(t, p2, []string{fmt.Sprintf("localhost:%d", p1)}, false, 2, 1, 2, "-TTL_SWEEP", "0")
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+
+	set := func(q string) {
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/set?%s", p1, q), "", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("%s: %d", q, resp.StatusCode)
+		}
+	}
+	set("key=session&value=s1&ttl=1s")
+	set("key=keep&value=k")
+	if e, code := getEntry(t, fmt.Sprintf("http://localhost:%d/local_read?key=session", p2)); code != http.StatusOK || e.Expires == 0 {
+		t.Fatalf("follower before expiry = %d %+v", code, e)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e, code := getEntry(t, fmt.Sprintf("http://localhost:%d/getReplica?key=session", p2))
+		if code == http.StatusOK && e.Deleted {
+			if e.Timestamp >= e.Expires || e.Value != "" {
+				t.Fatalf("replicated expiry = %+v, want a tombstone stamped as the version it expires", e)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("follower never got the expiry tombstone: %d %+v", code, e)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, code := getEntry(t, fmt.Sprintf("http://localhost:%d/get?key=session", p1)); code != http.StatusNotFound {
+		t.Fatalf("expired key read = %d, want 404", code)
+	}
+	if e, code := getEntry(t, fmt.Sprintf("http://localhost:%d/get?key=keep", p1)); code != http.StatusOK || e.Value != "k" {
+		t.Fatalf("key without ttl = %d %+v", code, e)
+	}
+
+	// a write after the deadline brings the key back
+	set("key=session&value=s2")
+	if e, code := getEntry(t, fmt.Sprintf("http://localhost:%d/local_read?key=session", p2)); code != http.StatusOK || e.Value != "s2" || e.Expires != 0 {
+		t.Fatalf("rewrite after expiry = %d %+v", code, e)
+	}
+}
+
+func TestTTL_ExpiredReadsAsMissing(t *testing.T) {
+	now := time.Now().UnixNano()
+	e := Entry{Value: "v", Timestamp: now - 2e9, Node: "a", Expires: now - 1e9}
+	if e.live() {
+		t.Fatal("expired entry is live")
+	}
+	tomb := expiryOf(e)
+	if !tomb.Deleted || tomb.Timestamp != e.Timestamp || !tomb.newerThan(e) || e.newerThan(tomb) || tomb.expired(now) {
+		t.Fatalf("expiryOf = %+v", tomb)
+	}
+	if ev := eventOf(Change{Key: "k", Entry: tomb}); !ev.Expired || !ev.Deleted {
+		t.Fatalf("event for the expiry = %+v", ev)
+	}
+	if ev := eventOf(Change{Key: "k", Entry: Entry{Deleted: true, Timestamp: now}}); ev.Expired {
+		t.Fatalf("plain delete reported as expired: %+v", ev)
+	}
+}
+
+// a replica that missed a later write without a ttl must not delete it by
+// sweeping the version it still has
+func TestTTL_ExpiryLosesToNewerWrite(t *testing.T) {
+	now := time.Now().UnixNano()
+	v1 := Entry{Value: "v1", Timestamp: now - 2e9, Node: "a", Expires: now + 60e9}
+	v2 := Entry{Value: "v2", Timestamp: now - 1e9, Node: "a"}
+	tomb := expiryOf(v1)
+	for _, got := range []Entry{
+		merge(lww{}, v2, tomb),
+		merge(lww{}, tomb, v2),
+	} {
+		if got.Deleted || got.Value != "v2" {
+			t.Fatalf("expiry of v1 merged with v2 = %+v, want v2", got)
+		}
+	}
+	if got, _ := mergeEntry("k", v2, true, tomb); got.Deleted {
+		t.Fatalf("mergeEntry kept the expiry over v2: %+v", got)
+	}
+	if got := merge(lww{}, v1, tomb); !got.Deleted {
+		t.Fatalf("expiry merged with the version it expires = %+v", got)
+	}
+}
+
+// the sweeper only visits keys with a deadline, and drops them once swept
+func TestSweepVisitsOnlyExpiringKeys(t *testing.T) {
+	oldData, oldExpiring := svc.data, expiring
+	defer func() { svc.data, expiring = oldData, oldExpiring }()
+	svc.data, expiring = map[string]Entry{}, map[string]struct{}{}
+
+	now := time.Now().UnixNano()
+	svc.put("forever", Entry{Value: "v", Timestamp: 1, Node: "elsewhere"})
+	svc.put("due", Entry{Value: "v", Timestamp: 1, Node: "elsewhere", Expires: now - 1})
+	svc.put("later", Entry{Value: "v", Timestamp: 1, Node: "elsewhere", Expires: now + int64(time.Hour)})
+	if _, ok := expiring["forever"]; ok || len(expiring) != 2 {
+		t.Fatalf("expected only due and later tracked, got %v", expiring)
+	}
+
+	sweepExpired()
+	if e := svc.data["due"]; !e.Deleted {
+		t.Errorf("expected due swept to a tombstone, got %+v", e)
+	}
+	if e := svc.data["later"]; e.Deleted {
+		t.Errorf("expected later kept, got %+v", e)
+	}
+	if _, ok := expiring["due"]; ok || len(expiring) != 1 {
+		t.Errorf("expected only later still tracked, got %v", expiring)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -MODE=2pc runs writes as two-phase commit, as one more model to compare
+// against quorum and primary-backup writes. The -LEADER node stages the
+// write itself and sends it to every replica of the key in
+// /2pc/prepare; a replica stages it too and votes yes, or no while
+// another transaction holds the key. Once W replicas, the leader included,
+// have voted yes the leader applies the write and sends /2pc/commit to
+// each replica that voted yes (a late yes is committed as it arrives),
+// answering once W have applied it. Short of W votes it sends /2pc/abort
+// and the write fails with 500 (504 past -WRITE_TIMEOUT), applied
+// nowhere. A staged write no decision reaches within stagedTimeout is
+// dropped, as an abort. Each phase pays the per-follower delay, and
+// kv_2pc_phase_seconds{phase="prepare"|"commit"} on /metrics times them
+// apart from the whole write's kv_op_latency_seconds{level="2pc"}.
+
+const (
+	mode2PC       = "2pc"
+	stagedTimeout = 10 * time.Second
+)
+
+func twoPhaseCommit() bool { return mode == mode2PC }
+
+// stagedWrite is a prepared write waiting for its decision.
+type stagedWrite struct {
+	key string
+	e   Entry
+	at  time.Time
+}
+
+var (
+	staged = struct {
+		sync.Mutex
+		byTx  map[string]stagedWrite
+		byKey map[string]string // key -> transaction holding it
+	}{byTx: map[string]stagedWrite{}, byKey: map[string]string{}}
+
+	// phaseLatency times the phases of 2pc and paxos writes
+	phaseLatency = struct {
+		sync.Mutex
+		byPhase map[[2]string]*histogram // {mode, phase}
+	}{byPhase: map[[2]string]*histogram{}}
+
+	txCommitted, txAborted, txVotesNo atomic.Int64
+)
+
+// stage prepares tx's write of e to key, reporting false if another live
+// transaction holds the key.
+func stage(tx, key string, e Entry) bool {
+	staged.Lock()
+	defer staged.Unlock()
+	if holder, ok := staged.byKey[key]; ok && holder != tx {
+		if time.Since(staged.byTx[holder].at) < stagedTimeout {
+			return false
+		}
+		delete(staged.byTx, holder) // presumed aborted
+	}
+	staged.byTx[tx] = stagedWrite{key, e, time.Now()}
+	staged.byKey[key] = tx
+	return true
+}
+
+// unstage removes tx's staged write, reporting it if there was one.
+func unstage(tx string) (stagedWrite, bool) {
+	staged.Lock()
+	defer staged.Unlock()
+	sw, ok := staged.byTx[tx]
+	if !ok {
+		return stagedWrite{}, false
+	}
+	delete(staged.byTx, tx)
+	if staged.byKey[sw.key] == tx {
+		delete(staged.byKey, sw.key)
+	}
+	return sw, true
+}
+
+// commitStaged applies tx's staged write to the store.
+func commitStaged(tx string) bool {
+	sw, ok := unstage(tx)
+	if !ok {
+		return false
+	}
+	svc.Lock()
+	defer svc.Unlock()
+	cur, had := svc.intactCopy(sw.key)
+	if merged, changed := mergeEntry(sw.key, cur, had, sw.e); changed {
+		svc.put(sw.key, merged)
+	}
+	return true
+}
+
+// observePhase records one phase of a write under mode that started at
+// start.
+func observePhase(mode, phase string, start time.Time) {
+	secs := time.Since(start).Seconds()
+	phaseLatency.Lock()
+	defer phaseLatency.Unlock()
+	k := [2]string{mode, phase}
+	h := phaseLatency.byPhase[k]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(latencyBuckets)+1)}
+		phaseLatency.byPhase[k] = h
+	}
+	h.observe(secs)
+}
+
+// writePhaseMetrics renders mode's phase timings as histogram name.
+func writePhaseMetrics(w io.Writer, name, help, mode string, phases ...string) {
+	phaseLatency.Lock()
+	defer phaseLatency.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, phase := range phases {
+		if h := phaseLatency.byPhase[[2]string{mode, phase}]; h != nil {
+			writeHistogram(w, name, fmt.Sprintf("phase=%q", phase), h)
+		}
+	}
+}
+
+type vote struct {
+	peer  string
+	start time.Time
+	yes   bool
+}
+
+// twoPhaseWrite coordinates e as a transaction over targets, answering
+// done once wq replicas have committed it.
+func twoPhaseWrite(w http.ResponseWriter, tr *reqTrace, ws *writeStatus, targets []string, key string, e Entry, cond writeCond, wq, done int) {
+	wq = replicaQuorum(wq)
+	tr.setQuorum(wq)
+	tx := ws.ID
+	if !stage(tx, key, Entry{}) {
+		txVotesNo.Add(1)
+		httpError(w, http.StatusConflict, "key", "another transaction holds the key")
+		return
+	}
+	svc.RLock()
+	cur, ok := svc.intactCopy(key)
+	svc.RUnlock()
+	if cond != nil && !cond(cur, ok, &e) {
+		unstage(tx)
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	stampClock(cur, ok, &e)
+	stage(tx, key, e)
+	tr.servedLocally()
+	tr.setEntry(e.Timestamp)
+
+	ctx, cancel := writeBudget()
+	defer cancel()
+	start := time.Now()
+	votes := make(chan vote, len(targets))
+	for _, p := range targets {
+		go func(p string) {
+			begin := time.Now()
+			nodeClock.Sleep(LeaderDelayPerFollower)
+			votes <- vote{p, begin, prepareOn(p, tx, key, e)}
+		}(p)
+	}
+	yes := selfAcks(key)
+	var prepared, refused []string
+	answered := 0
+	for ; yes < wq && answered < len(targets) && ctx.Err() == nil; answered++ {
+		select {
+		case v := <-votes:
+			tr.peerAck(v.peer, v.start, v.yes)
+			if v.yes {
+				yes++
+				prepared = append(prepared, v.peer)
+			} else {
+				refused = append(refused, v.peer)
+				txVotesNo.Add(1)
+			}
+		case <-ctx.Done():
+			answered-- // the budget ran out before this vote
+		}
+	}
+	observePhase(mode2PC, "prepare", start)
+	commit := yes >= wq
+	// votes still to come get the decision as they arrive
+	late := func() {
+		for range len(targets) - answered {
+			v := <-votes
+			switch {
+			case v.yes:
+				decide(v.peer, tx, commit, ws, e.Timestamp)
+			case commit:
+				ws.replicated(v.peer, false)
+				txVotesNo.Add(1)
+			default:
+				txVotesNo.Add(1)
+			}
+		}
+	}
+
+	if !commit {
+		unstage(tx)
+		txAborted.Add(1)
+		for _, p := range prepared {
+			go decide(p, tx, false, ws, e.Timestamp)
+		}
+		go late()
+		w.Header().Set(acksHeader, strconv.Itoa(yes))
+		status := http.StatusInternalServerError
+		if ctx.Err() == context.DeadlineExceeded {
+			status = http.StatusGatewayTimeout
+		}
+		httpError(w, status, "", fmt.Sprintf("prepare quorum not met: %d of %d votes; aborted", yes, wq))
+		return
+	}
+
+	start = time.Now()
+	commitStaged(tx)
+	noteWrite(e.Timestamp)
+	if !syncLocal(w, e) {
+		go late()
+		return
+	}
+	txCommitted.Add(1)
+	ws.begin()
+	w.Header().Set(writeIDHeader, ws.ID)
+	feedReadReplicas(key, e)
+	for _, p := range refused {
+		ws.replicated(p, false)
+	}
+	go late()
+	acks := make(chan bool, len(prepared))
+	for _, p := range prepared {
+		go func(p string) { acks <- decide(p, tx, true, ws, e.Timestamp) }(p)
+	}
+	got := selfAcks(key)
+	for i := 0; i < len(prepared) && got < wq && ctx.Err() == nil; i++ {
+		select {
+		case ok := <-acks:
+			if ok {
+				got++
+			}
+		case <-ctx.Done():
+		}
+	}
+	observePhase(mode2PC, "commit", start)
+	if got < wq {
+		quorumFailed(w, ctx, ws, e, got, wq)
+		return
+	}
+	w.WriteHeader(done)
+}
+
+// prepareOn asks peer to stage tx's write, reporting its vote.
+func prepareOn(peer, tx, key string, e Entry) bool {
+	path := fmt.Sprintf("/2pc/prepare?%s&tx=%s&epoch=%d", entryQuery(peer, key, e), url.QueryEscape(tx), currentEpoch.Load())
+	resp, err := peerNet.Send(context.Background(), peer, path, nil, nil)
+	if err != nil {
+		noteReplicationFailed(peer, err)
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode == http.StatusOK
+}
+
+// decide sends peer the outcome of tx, a write stamped ts, reporting
+// whether a commit was applied there.
+func decide(peer, tx string, commit bool, ws *writeStatus, ts int64) bool {
+	phase := "abort"
+	if commit {
+		phase = "commit"
+		nodeClock.Sleep(LeaderDelayPerFollower)
+	}
+	path := fmt.Sprintf("/2pc/%s?tx=%s&epoch=%d", phase, url.QueryEscape(tx), currentEpoch.Load())
+	resp, err := peerNet.Send(context.Background(), peer, path, nil, nil)
+	ok := err == nil && resp.StatusCode == http.StatusOK
+	if err == nil {
+		resp.Body.Close()
+	}
+	if commit {
+		ws.replicated(peer, ok)
+		if ok {
+			noteReplicated(peer, ts)
+		} else if err != nil {
+			noteReplicationFailed(peer, err)
+		}
+	}
+	return ok
+}
+
+// prepareHandler stages a leader's write and votes on it: 200 for yes,
+// 409 for no.
+func prepareHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ts, err := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+	epoch, epochErr := parseEpoch(r)
+	clock, clockErr := parseClock(q.Get("clock"))
+	if q.Get("key") == "" || q.Get("tx") == "" || err != nil || epochErr != nil || clockErr != nil {
+		http.Error(w, "invalid prepare args", http.StatusBadRequest)
+		return
+	}
+	if !observeEpoch(epoch) {
+		rejectStaleEpoch(w)
+		return
+	}
+	restorable, _ := strconv.ParseInt(q.Get("restorable"), 10, 64)
+	expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+	e := Entry{Value: q.Get("value"), Timestamp: ts, Deleted: q.Get("deleted") == "true", Node: q.Get("node"), Type: q.Get("type"), Clock: clock, Restorable: restorable, Expires: expires, Owner: q.Get("owner")}
+	if err := parseForwarded(q, &e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkQueryChecksum(r, q.Get("key"), e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !stage(q.Get("tx"), q.Get("key"), e) {
+		http.Error(w, "another transaction holds the key", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// decisionHandler applies (/2pc/commit) or drops (/2pc/abort) a staged
+// write. A commit for a transaction not staged here, or dropped since,
+// answers 410.
+func decisionHandler(commit bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		epoch, err := parseEpoch(r)
+		if err != nil {
+			http.Error(w, "invalid epoch", http.StatusBadRequest)
+			return
+		}
+		if !observeEpoch(epoch) {
+			rejectStaleEpoch(w)
+			return
+		}
+		tx := r.URL.Query().Get("tx")
+		if !commit {
+			unstage(tx)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		nodeClock.Sleep(FollowerUpdateSleep)
+		if !commitStaged(tx) {
+			http.Error(w, "transaction not prepared", http.StatusGone)
+			return
+		}
+		if err := syncReplica(r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// write2PCMetrics renders the phase timings and outcomes for /metrics.
+func write2PCMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP kv_2pc_total Two-phase commit writes (-MODE=2pc) by outcome, and the no votes replicas cast.")
+	fmt.Fprintln(w, "# TYPE kv_2pc_total counter")
+	fmt.Fprintf(w, "kv_2pc_total{result=\"committed\"} %d\n", txCommitted.Load())
+	fmt.Fprintf(w, "kv_2pc_total{result=\"aborted\"} %d\n", txAborted.Load())
+	fmt.Fprintf(w, "kv_2pc_total{result=\"vote_no\"} %d\n", txVotesNo.Load())
+	writePhaseMetrics(w, "kv_2pc_phase_seconds", "Time the leader spent in each phase of a two-phase commit write.", mode2PC, "prepare", "commit")
+}
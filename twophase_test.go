@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTwoPhaseCommitWrites(t *testing.T) {
+	ports := []int{9176, 9177, 9178}
+	addr := func(p int) string { return fmt.Sprintf("localhost:%d", p) }
+	var last *os.Process
+	for i, p := range ports {
+		var others []string
+		for _, q := range ports {
+			if q != p {
+				others = append(others, addr(q))
+			}
+		}
+		n := startNode(t, p, others, i == 0, 3, 1, 2, "-MODE", "2pc")
+		defer n.Process.Kill()
+		last = n.Process
+	}
+	waitReady(t, ports...)
+
+	post := func(p int, query string) int {
+		resp, err := http.Post(fmt.Sprintf("http://%s/set?%s", addr(p), query), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(ports[0], "key=k&value=v"); code != http.StatusCreated {
+		t.Fatalf("2pc write = %d", code)
+	}
+	if code := post(ports[1], "key=k&value=w"); code/100 == 2 {
+		t.Fatalf("2pc write through a follower = %d", code)
+	}
+	committed := 0
+	for _, p := range ports {
+		if e, _ := getEntry(t, fmt.Sprintf("http://%s/local_read?key=k", addr(p))); e.Value == "v" {
+			committed++
+		}
+	}
+	if committed < 2 {
+		t.Fatalf("%d replicas have the write once it was acked, want W=2", committed)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", addr(ports[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{
+		`kv_2pc_total{result="committed"} 1`,
+		`kv_2pc_phase_seconds_count{phase="prepare"} 1`,
+		`kv_2pc_phase_seconds_count{phase="commit"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("/metrics lacks %s", want)
+		}
+	}
+
+	// with W=3 and a replica gone the prepare falls short and nothing is applied
+	time.Sleep(500 * time.Millisecond) // the last commit lands
+	last.Kill()
+	cfg, err := http.Post(fmt.Sprintf("http://%s/config?W=3", addr(ports[0])), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Body.Close()
+	if code := post(ports[0], "key=k&value=x"); code != http.StatusInternalServerError {
+		t.Fatalf("2pc write short of votes = %d", code)
+	}
+	for _, p := range ports[:2] {
+		if e, _ := getEntry(t, fmt.Sprintf("http://%s/local_read?key=k", addr(p))); e.Value != "v" {
+			t.Fatalf("%s has %+v after an aborted write", addr(p), e)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//go:embed ui/index.html
+var dashboardHTML []byte
+
+// NodeStats is one node's summary, served on /node and gathered by
+// /ui/status and /cluster/status from every member.
+type NodeStats struct {
+	Addr       string             `json:"addr"`
+	ClientAddr string             `json:"client_addr"`
+	NodeID     string             `json:"node_id,omitempty"`
+	State      string             `json:"state,omitempty"` // lifecycle state, see lifecycle.go
+	Leader     string             `json:"leader"`
+	IsLeader   bool               `json:"is_leader"`
+	Epoch      int64              `json:"epoch"`
+	Mode       string             `json:"mode"`
+	Leaderless bool               `json:"leaderless,omitempty"`
+	ReadOnly   bool               `json:"read_only,omitempty"`
+	DC         string             `json:"dc,omitempty"`
+	N          int                `json:"n"`
+	R          int                `json:"r"`
+	W          int                `json:"w"`
+	Replicas   int                `json:"replicas"`                 // copies of each key, as -REPLICAS makes it
+	Policies   []writePolicy      `json:"write_policies,omitempty"` // see policy.go
+	Protocol   int                `json:"protocol,omitempty"`
+	Keys       int                `json:"keys"` // live keys
+	Tombstones int                `json:"tombstones"`
+	Revision   int64              `json:"revision"`                          // newest timestamp applied locally
+	Clock      int64              `json:"clock"`                             // timestamp a write would get now, for skew checks
+	Lag        map[string]float64 `json:"replication_lag_seconds,omitempty"` // by peer, as this node sees it
+	Tags       map[string]string  `json:"tags,omitempty"`                    // see tags.go
+	Error      string             `json:"error,omitempty"`
+}
+
+func localStats() NodeStats {
+	st := NodeStats{
+		Addr:       self,
+		ClientAddr: clientAddr,
+		NodeID:     identity().NodeID,
+		State:      currentState(),
+		Leader:     currentLeader(),
+		IsLeader:   isLeader.Load(),
+		Epoch:      currentEpoch.Load(),
+		Mode:       mode,
+		Leaderless: leaderlessCluster(),
+		ReadOnly:   readOnly,
+		DC:         localDC,
+		N:          cluster().N,
+		R:          cluster().R,
+		W:          cluster().W,
+		Replicas:   replicas(),
+		Policies:   writePolicies,
+		Protocol:   peerProtocol,
+		Revision:   changes.revision.Load(),
+		Clock:      writeClock(),
+		Tags:       nodeTags(),
+	}
+	for _, p := range peerInfos() {
+		if st.Lag == nil {
+			st.Lag = map[string]float64{}
+		}
+		st.Lag[p.Addr] = p.LagSeconds
+	}
+	svc.RLock()
+	for _, e := range svc.data {
+		if e.Deleted {
+			st.Tombstones++
+		} else {
+			st.Keys++
+		}
+	}
+	svc.RUnlock()
+	return st
+}
+
+func nodeHandler(w http.ResponseWriter, r *http.Request) {
+	bs, _ := json.Marshal(localStats())
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
+
+// fetchStats asks peer for its /node summary.
+func fetchStats(ctx context.Context, peer string) NodeStats {
+	st := NodeStats{Addr: peer}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+peer+"/node", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		st.Error, st.State = resp.Status, resp.Header.Get(stateHeader)
+		return st
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		st.Error = err.Error()
+	}
+	return st
+}
+
+type recentWrite struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+	Deleted   bool   `json:"deleted,omitempty"`
+}
+
+// uiStatusHandler gathers everything the dashboard shows in one call.
+func uiStatusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 500*time.Millisecond)
+	defer cancel()
+	nodes := make([]NodeStats, len(cluster().peers)+1)
+	nodes[0] = localStats()
+	var wg sync.WaitGroup
+	for i, p := range cluster().peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodes[i+1] = fetchStats(ctx, p)
+		}()
+	}
+	wg.Wait()
+
+	var recent []recentWrite
+	for _, c := range changes.latest() {
+		recent = append(recent, recentWrite{c.Key, c.Entry.Value, c.Entry.Timestamp, c.Entry.Deleted})
+	}
+	bs, _ := json.Marshal(map[string]any{
+		"self":   self,
+		"nodes":  nodes,
+		"peers":  peerInfos(),
+		"recent": recent,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
+
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+// antiEntropyHandler reconciles this node with every peer by Merkle tree
+// (?range= and ?depth= as for /admin/merkle), so replicas that missed
+// writes, in either direction, catch up.
+func antiEntropyHandler(w http.ResponseWriter, r *http.Request) {
+	kr, depth, err := parseMerkleQuery(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "range", err.Error())
+		return
+	}
+	targets, err := taggedPeers(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "tag", err.Error())
+		return
+	}
+	failed, exchanged := 0, 0
+	for _, p := range targets {
+		n, err := merkleSync(p, kr, depth)
+		exchanged += n
+		if err != nil {
+			failed++
+			log.Printf("anti-entropy with %s: %v", p, err)
+		}
+	}
+	if failed > 0 {
+		http.Error(w, fmt.Sprintf("synced with %d of %d peers", len(targets)-failed, len(targets)), http.StatusBadGateway)
+		return
+	}
+	fmt.Fprintf(w, "synced with %d peers, %d keys exchanged\n", len(targets), exchanged)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDashboardStatus(t *testing.T) {
+	leader := startNode(t, 9105, []string{"localhost:9106"}, true, 2, 1, 1)
+	defer leader.Process.Kill()
+	follower := startNode(t, 9106, []string{"localhost:9105"}, false, 2, 1, 1)
+	defer follower.Process.Kill()
+	waitReady(t, 9105, 9106)
+
+	resp, err := http.Get("http://localhost:9105/ui")
+	if err != nil {
+		t.Fatalf("GET /ui: %v", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "kv dashboard") {
+		t.Fatalf("/ui did not serve the dashboard")
+	}
+
+	// a key the follower only gets through anti-entropy
+	http.Post("http://localhost:9106/replicate?key=ae&value=1&timestamp=1&epoch=1&from=localhost:9105", "", nil)
+	resp, err = http.Post("http://localhost:9106/admin/anti_entropy", "", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("anti-entropy: %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get("http://localhost:9105/ui/status")
+	if err != nil {
+		t.Fatalf("GET /ui/status: %v", err)
+	}
+	defer resp.Body.Close()
+	var st struct {
+		Nodes  []NodeStats
+		Recent []recentWrite
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(st.Nodes) != 2 || !st.Nodes[0].IsLeader || st.Nodes[1].Error != "" || st.Nodes[1].IsLeader {
+		t.Fatalf("nodes = %+v", st.Nodes)
+	}
+	for _, n := range st.Nodes {
+		if n.Keys != 1 {
+			t.Errorf("%s has %d keys, want 1", n.Addr, n.Keys)
+		}
+	}
+	if len(st.Recent) != 1 || st.Recent[0].Key != "ae" {
+		t.Errorf("recent = %+v", st.Recent)
+	}
+}
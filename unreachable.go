@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -UNREACHABLE_POLICY decides what a W>1 write does about replicas that do
+// not answer. The failure detector is the heartbeat (latency.go): a peer
+// is down once downAfterMisses pings in a row have failed, and up again
+// with the next one that gets through.
+//
+//	wait             try every replica alike, as before: a dead one costs
+//	                 the per-follower delay and a failed attempt; the default
+//	skip-fast        skip replicas that are down without trying them
+//	retry-then-hint  retry a failed replication hintRetries times, then keep
+//	                 the write as a hint for the peer, handed over on its
+//	                 first heartbeat back; a hint does not count towards W
+//	fail-fast        refuse the write with 503, before applying it anywhere,
+//	                 when too few replicas are up to make W
+//
+// The response names the policy in X-Unreachable-Policy and, in
+// X-Unreachable-Peers, what it did about each replica it gave up on
+// (peer=skipped, hinted or down). Per-datacenter ?consistency= levels and
+// primary-backup writes are unaffected; with -BATCH_WINDOW only skip-fast
+// and fail-fast apply.
+
+const (
+	unreachableWait = "wait"
+	unreachableSkip = "skip-fast"
+	unreachableHint = "retry-then-hint"
+	unreachableFail = "fail-fast"
+
+	downAfterMisses = 3 // failed heartbeats in a row before a peer is down
+	hintRetries     = 2
+	hintRetryDelay  = 50 * time.Millisecond
+	maxHintsPerPeer = 10000 // distinct keys held for one peer
+)
+
+var (
+	unreachablePolicy = unreachableWait // -UNREACHABLE_POLICY
+
+	hints struct {
+		sync.Mutex
+		byPeer map[string]map[string]Entry
+	}
+
+	downSkipped, downRefused                  atomic.Int64 // replicas skipped, writes refused
+	hintsStored, hintsDelivered, hintsDropped atomic.Int64
+)
+
+// configureUnreachablePolicy checks and sets -UNREACHABLE_POLICY.
+func configureUnreachablePolicy(s string) error {
+	switch s {
+	case unreachableWait, unreachableSkip, unreachableHint, unreachableFail:
+		unreachablePolicy = s
+		return nil
+	}
+	return fmt.Errorf("-UNREACHABLE_POLICY must be %s, %s, %s or %s, not %q", unreachableWait, unreachableSkip, unreachableHint, unreachableFail, s)
+}
+
+// peerDown reports whether the heartbeats have given up on peer.
+func peerDown(peer string) bool {
+	ps := statusFor(peer)
+	ps.Lock()
+	defer ps.Unlock()
+	return ps.missedPings >= downAfterMisses
+}
+
+// refuseUnreachable answers, under fail-fast, a W>1 write of key that the
+// replicas up cannot make, before it is applied. It reports whether it
+// did.
+func refuseUnreachable(w http.ResponseWriter, tr *reqTrace, key string, targets []string, wq int) bool {
+	if wq <= 1 {
+		return false
+	}
+	tr.setPolicy(unreachablePolicy)
+	if unreachablePolicy != unreachableFail {
+		return false
+	}
+	wq = replicaQuorum(wq)
+	up := selfAcks(key)
+	var down []string
+	for _, p := range targets {
+		if peerDown(p) {
+			down = append(down, p)
+		} else {
+			up++
+		}
+	}
+	if up >= wq {
+		return false
+	}
+	for _, p := range down {
+		tr.unreachable(p, "down")
+	}
+	downRefused.Add(1)
+	http.Error(w, fmt.Sprintf("write quorum unreachable: %d of %d replicas up", up, wq), http.StatusServiceUnavailable)
+	return true
+}
+
+// skipDown drops, under skip-fast, the targets that are down, marking
+// them skipped.
+func skipDown(tr *reqTrace, ws *writeStatus, targets []string) []string {
+	if unreachablePolicy != unreachableSkip {
+		return targets
+	}
+	up := make([]string, 0, len(targets))
+	for _, p := range targets {
+		if !peerDown(p) {
+			up = append(up, p)
+			continue
+		}
+		ws.skip(p)
+		tr.unreachable(p, "skipped")
+		downSkipped.Add(1)
+	}
+	return up
+}
+
+// replicateOrHint is replicateWithin under the policy: retry-then-hint
+// retries a failed replication and, if peer still has not taken the
+// write, holds it for peer.
+func replicateOrHint(ctx context.Context, tr *reqTrace, peer, key string, e Entry) bool {
+	ok := replicateWithin(ctx, peer, key, e)
+	if ok || unreachablePolicy != unreachableHint {
+		return ok
+	}
+	for i := 0; i < hintRetries && !ok && pause(ctx, hintRetryDelay); i++ {
+		ok = replicateWithin(ctx, peer, key, e)
+	}
+	if !ok && holdHint(peer, key, e) {
+		hintsStored.Add(1)
+		tr.unreachable(peer, "hinted")
+	}
+	return ok
+}
+
+// holdHint keeps e for peer, merged with any write of key already held,
+// reporting false if peer has maxHintsPerPeer other keys held.
+func holdHint(peer, key string, e Entry) bool {
+	hints.Lock()
+	defer hints.Unlock()
+	if hints.byPeer == nil {
+		hints.byPeer = map[string]map[string]Entry{}
+	}
+	held := hints.byPeer[peer]
+	if held == nil {
+		held = map[string]Entry{}
+		hints.byPeer[peer] = held
+	}
+	cur, ok := held[key]
+	if !ok && len(held) >= maxHintsPerPeer {
+		hintsDropped.Add(1)
+		return false
+	}
+	held[key], _ = mergeEntry(key, cur, ok, e)
+	return true
+}
+
+// deliverHints hands peer the writes held for it, as one catch-up batch.
+// If it does not take them they are held for its next heartbeat.
+func deliverHints(peer string) {
+	hints.Lock()
+	held := hints.byPeer[peer]
+	delete(hints.byPeer, peer)
+	hints.Unlock()
+	if len(held) == 0 {
+		return
+	}
+	bs, _ := json.Marshal(held)
+	if err := postBatch(peer, fmt.Sprintf("/catchup?epoch=%d", currentEpoch.Load()), bs); err != nil {
+		log.Printf("hints for %s: %v; holding %d", peer, err, len(held))
+		for k, e := range held {
+			holdHint(peer, k, e)
+		}
+		return
+	}
+	hintsDelivered.Add(int64(len(held)))
+}
+
+// dropHints discards the held writes to keys drop matches.
+func dropHints(drop func(key string) bool) {
+	hints.Lock()
+	defer hints.Unlock()
+	for _, held := range hints.byPeer {
+		maps.DeleteFunc(held, func(k string, _ Entry) bool { return drop(k) })
+	}
+}
+
+// pendingHints counts the writes held across peers.
+func pendingHints() int {
+	hints.Lock()
+	defer hints.Unlock()
+	n := 0
+	for _, held := range hints.byPeer {
+		n += len(held)
+	}
+	return n
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestUnreachablePolicies(t *testing.T) {
+	mem := newMemNet()
+	oldNet, oldDelay, oldPolicy := peerNet, LeaderDelayPerFollower, unreachablePolicy
+	wasLeader := isLeader.Load()
+	defer func() {
+		peerNet, LeaderDelayPerFollower, unreachablePolicy = oldNet, oldDelay, oldPolicy
+		isLeader.Store(wasLeader)
+		peerMu.Lock()
+		delete(peerStats, "dead:1")
+		delete(peerStats, "live:1")
+		peerMu.Unlock()
+		svc.Lock()
+		for _, k := range []string{"wait", "skip", "hint", "ok", "refused"} {
+			delete(svc.data, k)
+		}
+		svc.Unlock()
+	}()
+	peerNet, LeaderDelayPerFollower = mem.transport(), 0
+	withCluster(t, func(c *membership) { c.peers, c.N, c.W = []string{"dead:1", "live:1"}, 3, 2 })
+	isLeader.Store(true)
+
+	var mu sync.Mutex
+	deadTried, deadUp := 0, false
+	caughtUp := map[string]Entry{}
+	mem.attach("dead:1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !deadUp {
+			deadTried++
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path == "/catchup" {
+			json.NewDecoder(r.Body).Decode(&caughtUp)
+		}
+	}))
+	mem.attach("live:1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for range downAfterMisses {
+		notePing("dead:1", 0, false)
+	}
+
+	write := func(policy, key string) *httptest.ResponseRecorder {
+		t.Helper()
+		unreachablePolicy = policy
+		mu.Lock()
+		deadTried = 0
+		mu.Unlock()
+		rec := httptest.NewRecorder()
+		accessLog(annotated(setHandler)).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/set?key="+key+"&value=v", nil))
+		if got := rec.Header().Get(unreachablePolicyHeader); got != policy {
+			t.Errorf("%s: policy header %q", policy, got)
+		}
+		return rec
+	}
+	tried := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return deadTried
+	}
+
+	if rec := write(unreachableWait, "wait"); rec.Code != http.StatusCreated || tried() != 1 || rec.Header().Get(unreachablePeersHeader) != "" {
+		t.Errorf("wait: %d, dead peer tried %d times, peers %q", rec.Code, tried(), rec.Header().Get(unreachablePeersHeader))
+	}
+	if rec := write(unreachableSkip, "skip"); rec.Code != http.StatusCreated || tried() != 0 || rec.Header().Get(unreachablePeersHeader) != "dead:1=skipped" {
+		t.Errorf("skip-fast: %d, dead peer tried %d times, peers %q", rec.Code, tried(), rec.Header().Get(unreachablePeersHeader))
+	}
+	if rec := write(unreachableHint, "hint"); rec.Code != http.StatusCreated || tried() != 1+hintRetries || rec.Header().Get(unreachablePeersHeader) != "dead:1=hinted" {
+		t.Errorf("retry-then-hint: %d, dead peer tried %d times, peers %q", rec.Code, tried(), rec.Header().Get(unreachablePeersHeader))
+	}
+	if rec := write(unreachableFail, "ok"); rec.Code != http.StatusCreated {
+		t.Errorf("fail-fast with W reachable: %d", rec.Code)
+	}
+
+	updateCluster(func(c *membership) { c.W = 3 })
+	if rec := write(unreachableFail, "refused"); rec.Code != http.StatusServiceUnavailable || tried() != 0 || rec.Header().Get(unreachablePeersHeader) != "dead:1=down" {
+		t.Errorf("fail-fast: %d, dead peer tried %d times, peers %q", rec.Code, tried(), rec.Header().Get(unreachablePeersHeader))
+	}
+	if _, ok := svc.snapshot()["refused"]; ok {
+		t.Error("refused write was applied")
+	}
+
+	// the peer comes back and its next heartbeat delivers the hint
+	mu.Lock()
+	deadUp = true
+	mu.Unlock()
+	notePing("dead:1", 1, true)
+	deliverHints("dead:1")
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := caughtUp["hint"]; !ok || len(caughtUp) != 1 || pendingHints() != 0 {
+		t.Fatalf("delivered %v, %d hints still held", caughtUp, pendingHints())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits enforced on client input before it reaches a handler.
+const (
+	maxKeyBytes   = 1024
+	maxValueBytes = 1 << 20
+)
+
+// apiError is the JSON body of a 400/405 produced by request validation.
+type apiError struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+	Field  string `json:"field,omitempty"`
+}
+
+func httpError(w http.ResponseWriter, status int, field, msg string) {
+	bs, _ := json.Marshal(apiError{Status: status, Error: msg, Field: field})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(append(bs, '\n'))
+}
+
+// allow rejects requests whose method is not listed with 405. Allowing
+// GET also allows HEAD.
+func allow(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	ok := map[string]bool{}
+	for _, m := range methods {
+		ok[m] = true
+		if m == http.MethodGet {
+			ok[http.MethodHead] = true
+		}
+	}
+	allowed := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ok[r.Method] {
+			w.Header().Set("Allow", allowed)
+			httpError(w, http.StatusMethodNotAllowed, "", r.Method+" not allowed, use "+allowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// checkKey reports why key cannot be stored, or nil if it can.
+func checkKey(key string) error {
+	switch {
+	case key == "":
+		return fmt.Errorf("key required")
+	case len(key) > maxKeyBytes:
+		return fmt.Errorf("key is %d bytes, limit is %d", len(key), maxKeyBytes)
+	case !utf8.ValidString(key):
+		return fmt.Errorf("key is not valid UTF-8")
+	case strings.IndexFunc(key, isControl) >= 0:
+		return fmt.Errorf("key contains control characters")
+	}
+	return nil
+}
+
+func isControl(r rune) bool { return r < 0x20 || r == 0x7f }
+
+// keyed validates the ?key= (every one, for /mget), ?value= and
+// ?expected= parameters. A request whose key travels in a negotiated body
+// may leave ?key= out; the handler checks the decoded key itself.
+func keyed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		keys := q["key"]
+		if len(keys) == 0 && requestFormat(r) == "" {
+			httpError(w, http.StatusBadRequest, "key", "key required")
+			return
+		}
+		for _, k := range keys {
+			if err := checkKey(k); err != nil {
+				httpError(w, http.StatusBadRequest, "key", err.Error())
+				return
+			}
+		}
+		for _, field := range []string{"value", "expected"} {
+			if n := len(q.Get(field)); n > maxValueBytes {
+				httpError(w, http.StatusBadRequest, field, fmt.Sprintf("%s is %d bytes, limit is %d", field, n, maxValueBytes))
+				return
+			}
+		}
+		h(w, r)
+	}
+}
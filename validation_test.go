@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidationMiddleware(t *testing.T) {
+	h := allow(keyed(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}), http.MethodPost)
+
+	cases := []struct {
+		method, target string
+		status         int
+		field          string
+	}{
+		{http.MethodPost, "/set?key=ok&value=v", http.StatusCreated, ""},
+		{http.MethodGet, "/set?key=ok&value=v", http.StatusMethodNotAllowed, ""},
+		{http.MethodPost, "/set?value=v", http.StatusBadRequest, "key"},
+		{http.MethodPost, "/set?key=a%00b", http.StatusBadRequest, "key"},
+		{http.MethodPost, "/set?key=%ff", http.StatusBadRequest, "key"},
+		{http.MethodPost, "/set?key=" + strings.Repeat("k", maxKeyBytes+1), http.StatusBadRequest, "key"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(c.method, c.target, nil))
+		if rec.Code != c.status {
+			t.Errorf("%s %.40s = %d, want %d", c.method, c.target, rec.Code, c.status)
+			continue
+		}
+		if c.status < 400 {
+			continue
+		}
+		var ae apiError
+		if err := json.Unmarshal(rec.Body.Bytes(), &ae); err != nil || ae.Status != c.status || ae.Field != c.field {
+			t.Errorf("%s %.40s: error body %q", c.method, c.target, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Every coordinated write carries a vector clock: for each node, how many
+// writes to the key it has coordinated that this version descends from.
+// The siblings resolver uses clocks to tell a version that supersedes
+// another from a concurrent one, and keeps every version no other version
+// descends from.
+//
+// /get hands the clock of everything it returned back as an opaque context
+// token (X-Context). A write passing it as ?context= descends from all of
+// those versions and so replaces them, Riak-style. A write without one
+// descends from nothing, and under the siblings resolver becomes a sibling
+// of whatever is there.
+type vclock map[string]int64
+
+// descends reports whether c has seen every event in o.
+func (c vclock) descends(o vclock) bool {
+	for n, v := range o {
+		if c[n] < v {
+			return false
+		}
+	}
+	return true
+}
+
+// merge returns the pointwise maximum of c and o.
+func (c vclock) merge(o vclock) vclock {
+	if len(c) == 0 && len(o) == 0 {
+		return nil
+	}
+	out := make(vclock, len(c)+len(o))
+	for n, v := range c {
+		out[n] = v
+	}
+	for n, v := range o {
+		if v > out[n] {
+			out[n] = v
+		}
+	}
+	return out
+}
+
+// clockOf merges the clocks of e and its siblings.
+func clockOf(e Entry) vclock {
+	var c vclock
+	for _, v := range e.versions() {
+		c = c.merge(v.Clock)
+	}
+	return c
+}
+
+// stampClock gives a write coordinated here its clock: the context it
+// names, plus one more event at this node than any version of the key
+// this node holds.
+func stampClock(cur Entry, ok bool, e *Entry) {
+	n := e.Clock[self]
+	if ok {
+		n = max(n, clockOf(cur)[self])
+	}
+	e.Clock = e.Clock.merge(vclock{self: n + 1})
+}
+
+func (c vclock) token() string {
+	if len(c) == 0 {
+		return ""
+	}
+	bs, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func parseContext(token string) (vclock, error) {
+	if token == "" {
+		return nil, nil
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(token)
+	var c vclock
+	if err == nil {
+		err = json.Unmarshal(bs, &c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid context token")
+	}
+	return c, nil
+}
+
+// parseClock reads the ?clock= of a replicated write.
+func parseClock(s string) (vclock, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var c vclock
+	if err := json.Unmarshal([]byte(s), &c); err != nil {
+		return nil, fmt.Errorf("invalid clock %q", s)
+	}
+	return c, nil
+}
+
+func (c vclock) String() string {
+	bs, _ := json.Marshal(c)
+	return string(bs)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestVClock(t *testing.T) {
+	a := vclock{"kv1": 2, "kv2": 1}
+	b := vclock{"kv1": 1, "kv3": 4}
+	if !a.descends(vclock{"kv1": 1}) || a.descends(b) || b.descends(a) {
+		t.Fatalf("descends wrong for %v and %v", a, b)
+	}
+	m := a.merge(b)
+	if !m.descends(a) || !m.descends(b) || m["kv3"] != 4 {
+		t.Fatalf("merge = %v", m)
+	}
+	c, err := parseContext(m.token())
+	if err != nil || !c.descends(m) || !m.descends(c) {
+		t.Fatalf("token round trip = %v, %v", c, err)
+	}
+	if _, err := parseContext("not a token"); err == nil {
+		t.Error("garbage context accepted")
+	}
+}
+
+func TestSiblingsResolvedWithContext(t *testing.T) {
+	p1, p2 := 9110, 9111
+	n1 := startNode(t, p1, []string{fmt.Sprintf("localhost:%d", p2)}, false, 2, 1, 2, "-CONFLICT", "siblings")
+	defer n1.Process.Kill()
+	n2 := startNode(t, p2, []string{fmt.Sprintf("localhost:%d", p1)}, false, 2, 1, 2, "-CONFLICT", "siblings")
+	defer n2.Process.Kill()
+	waitReady(t, p1, p2)
+
+	set := func(port int, value, context string) {
+		q := url.Values{"key": {"cart"}, "value": {value}}
+		if context != "" {
+			q.Set("context", context)
+		}
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/set?%s", port, q.Encode()), "", nil)
+		if err != nil {
+			t.Fatalf("set: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("set %s on %d = %d", value, port, resp.StatusCode)
+		}
+	}
+	get := func(port int) (Entry, string) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/get?key=cart", port))
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		defer resp.Body.Close()
+		var e Entry
+		json.NewDecoder(resp.Body).Decode(&e)
+		return e, resp.Header.Get("X-Context")
+	}
+
+	// two blind writes through different coordinators are concurrent
+	set(p1, "apple", "")
+	set(p2, "pear", "")
+	for _, port := range []int{p1, p2} {
+		if e, _ := get(port); len(e.Siblings) != 1 {
+			t.Fatalf("node %d: %+v, want two versions", port, e)
+		}
+	}
+
+	_, ctx := get(p1)
+	if ctx == "" {
+		t.Fatal("no X-Context on /get")
+	}
+	set(p2, "apple,pear", ctx)
+	for _, port := range []int{p1, p2} {
+		if e, _ := get(port); e.Value != "apple,pear" || len(e.Siblings) != 0 {
+			t.Errorf("node %d after resolving: %+v", port, e)
+		}
+	}
+}
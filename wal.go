@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With -WAL=<file> every change to the store is appended to a write-ahead
+// log, one JSON record per line, and replayed on startup; the log is then
+// rewritten as a snapshot of what it replayed so it does not grow across
+// restarts. A record is the stored entry for a key, or a reset marking a
+// full resync that replaced the whole store.
+//
+// How soon a write reaches the disk is its durability: "async" (the
+// default, -DURABILITY) acknowledges once the write is in memory and
+// leaves it to an fsync every -WAL_SYNC; "fsync" acknowledges only after
+// the coordinator, and every replica that acks, has fsynced the log.
+
+const (
+	durabilityAsync = "async"
+	durabilityFsync = "fsync"
+)
+
+var (
+	wal                  *writeAheadLog // nil without -WAL
+	defaultDurability    = durabilityAsync
+	walSyncEvery         = 100 * time.Millisecond
+	errNoWAL             = errors.New("durability=fsync needs a write-ahead log (-WAL)")
+	errUnknownDurability = errors.New("durability must be fsync or async")
+)
+
+type walRecord struct {
+	Key    string `json:"key,omitempty"`
+	Entry  *Entry `json:"entry,omitempty"`
+	Reset  bool   `json:"reset,omitempty"`
+	KeyID  string `json:"kid,omitempty"`    // encryption key sealing Entry, see encryption.go
+	Sealed []byte `json:"sealed,omitempty"` // Entry, encrypted
+}
+
+type writeAheadLog struct {
+	path  string
+	mu    sync.Mutex
+	f     *os.File
+	w     *bufio.Writer
+	dirty bool              // appended since the last fsync
+	err   error             // first failed write; the log is unusable after it
+	kids  map[string]string // encryption key of each key's latest record
+
+	fsyncs atomic.Int64
+}
+
+// openWAL replays path into a fresh store, compacts it and opens it for
+// appending. A torn last record, left by a crash mid-write, is dropped.
+func openWAL(path string) (*writeAheadLog, map[string]Entry, error) {
+	data := make(map[string]Entry)
+	if f, err := os.Open(path); err == nil {
+		dec := json.NewDecoder(bufio.NewReader(f))
+		for n := 1; ; n++ {
+			var rec walRecord
+			err := dec.Decode(&rec)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				log.Printf("wal %s: ignoring record %d onwards: %v", path, n, err)
+				break
+			}
+			if rec.Sealed != nil {
+				if rec.Entry, err = openRecord(rec); err != nil {
+					f.Close()
+					return nil, nil, fmt.Errorf("wal %s record %d: %w", path, n, err)
+				}
+			}
+			switch {
+			case rec.Reset:
+				data = make(map[string]Entry)
+			case rec.Entry != nil && !rec.Entry.intact(rec.Key):
+				corruption.local.Add(1)
+				log.Printf("wal %s: record %d for %q fails its checksum; dropped", path, n, rec.Key)
+			case rec.Entry != nil:
+				data[rec.Key] = *rec.Entry
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	l := &writeAheadLog{path: path, kids: map[string]string{}}
+	if err := l.rewrite(data); err != nil {
+		return nil, nil, err
+	}
+	return l, data, nil
+}
+
+// rewrite replaces the log with a snapshot of data, so nothing else it
+// held survives on disk.
+func (l *writeAheadLog) rewrite(data map[string]Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tmp := l.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	old := l.f
+	l.f, l.w, l.err = f, bufio.NewWriter(f), nil
+	clear(l.kids)
+	for k, e := range data {
+		l.write(walRecord{Key: k, Entry: &e})
+	}
+	if err := l.syncLocked(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		l.err = fmt.Errorf("wal: %w", err)
+		return l.err
+	}
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (l *writeAheadLog) write(rec walRecord) {
+	if l.err != nil {
+		return
+	}
+	if rec.Reset {
+		clear(l.kids)
+	}
+	if atRest != nil && rec.Entry != nil {
+		plain, _ := json.Marshal(rec.Entry)
+		rec.KeyID, rec.Sealed = atRest.seal(rec.Key, plain)
+		rec.Entry = nil
+		l.kids[rec.Key] = rec.KeyID
+	}
+	bs, _ := json.Marshal(rec)
+	if _, err := l.w.Write(append(bs, '\n')); err != nil {
+		l.err = fmt.Errorf("wal: %w", err)
+		log.Print(l.err)
+	}
+	l.dirty = true
+}
+
+// append logs key's new entry. It is a no-op without a log.
+func (l *writeAheadLog) append(key string, e Entry) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.write(walRecord{Key: key, Entry: &e})
+	l.mu.Unlock()
+}
+
+// reseal logs key's entry again if its record is sealed under an older
+// encryption key. The caller holds the store lock, so e is current.
+func (l *writeAheadLog) reseal(key string, e Entry) {
+	if l == nil || atRest == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if kid, ok := l.kids[key]; ok && kid != atRest.current() {
+		l.write(walRecord{Key: key, Entry: &e})
+		reencrypted.Add(1)
+	}
+}
+
+// sealedBy counts the keys whose latest record each encryption key seals.
+func (l *writeAheadLog) sealedBy() map[string]int {
+	out := map[string]int{}
+	if l == nil {
+		return out
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, kid := range l.kids {
+		out[kid]++
+	}
+	return out
+}
+
+// reset logs that the store was replaced wholesale by data.
+func (l *writeAheadLog) reset(data map[string]Entry) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write(walRecord{Reset: true})
+	for k, e := range data {
+		l.write(walRecord{Key: k, Entry: &e})
+	}
+}
+
+// sync flushes and fsyncs everything appended so far. Concurrent callers
+// share one fsync where they can.
+func (l *writeAheadLog) sync() error {
+	if l == nil {
+		return errNoWAL
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.syncLocked()
+}
+
+func (l *writeAheadLog) syncLocked() error {
+	if l.err != nil || !l.dirty {
+		return l.err
+	}
+	if err := l.w.Flush(); err != nil {
+		l.err = fmt.Errorf("wal: %w", err)
+		return l.err
+	}
+	if err := l.f.Sync(); err != nil {
+		l.err = fmt.Errorf("wal: %w", err)
+		return l.err
+	}
+	l.dirty = false
+	l.fsyncs.Add(1)
+	return nil
+}
+
+// syncLoop fsyncs async writes in the background.
+func (l *writeAheadLog) syncLoop() {
+	for range time.Tick(walSyncEvery) {
+		if err := l.sync(); err != nil {
+			log.Printf("background fsync: %v", err)
+		}
+	}
+}
+
+// parseDurability reads a write's ?durability=, falling back to the
+// node's default, and reports whether it must be fsynced.
+func parseDurability(r *http.Request) (bool, error) {
+	d := r.URL.Query().Get("durability")
+	if d == "" {
+		d = defaultDurability
+	}
+	return checkDurability(d)
+}
+
+// checkDurability validates a durability level and reports whether it
+// means fsync.
+func checkDurability(d string) (bool, error) {
+	switch d {
+	case durabilityAsync:
+		return false, nil
+	case durabilityFsync:
+		if wal == nil {
+			return false, errNoWAL
+		}
+		return true, nil
+	}
+	return false, errUnknownDurability
+}
+
+// syncLocal fsyncs a coordinated write that asked for it, answering 500
+// if that fails.
+func syncLocal(w http.ResponseWriter, e Entry) bool {
+	if !e.Sync {
+		return true
+	}
+	if err := wal.sync(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// syncReplica fsyncs a replicated write whose coordinator asked for it.
+// A replica without a log cannot, and fails the write.
+func syncReplica(r *http.Request) error {
+	if r.URL.Query().Get("durability") != durabilityFsync {
+		return nil
+	}
+	return wal.sync()
+}
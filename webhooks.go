@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Webhooks POST each change to keys under a prefix to a URL, the same JSON
+// -CDC publishes, so other systems can react without polling /get.
+// /admin/webhooks registers one on every node; each node then sends the
+// writes it coordinates, so every change is sent once. A delivery that
+// fails or does not answer 2xx is retried with exponential backoff, up to
+// webhookAttempts times; a hook whose queue is full misses the change.
+// Hooks live in memory: a node that restarts has none until they are
+// registered again.
+
+const (
+	webhookQueue    = 1024
+	webhookAttempts = 5
+	webhookIDHeader = "X-Webhook-ID"
+)
+
+type webhook struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Prefix string `json:"prefix"`
+
+	Delivered int64 `json:"delivered"`
+	Failed    int64 `json:"failed"` // given up after webhookAttempts
+	Dropped   int64 `json:"dropped"`
+
+	queue chan cdcEvent
+	stop  chan struct{}
+	stats struct{ delivered, failed, dropped atomic.Int64 }
+}
+
+var webhooks struct {
+	sync.Mutex
+	byID    map[string]*webhook
+	started bool
+}
+
+// webhookBackoff is the wait before the first retry; it doubles each time.
+var webhookBackoff = 200 * time.Millisecond
+
+// addWebhook registers a hook here, replacing one with the same ID.
+func addWebhook(id, target, prefix string) {
+	h := &webhook{ID: id, URL: target, Prefix: prefix, queue: make(chan cdcEvent, webhookQueue), stop: make(chan struct{})}
+	webhooks.Lock()
+	defer webhooks.Unlock()
+	if webhooks.byID == nil {
+		webhooks.byID = map[string]*webhook{}
+	}
+	if old, ok := webhooks.byID[id]; ok {
+		close(old.stop)
+	}
+	webhooks.byID[id] = h
+	if !webhooks.started {
+		webhooks.started = true
+		_, feed := changes.subscribe(webhookQueue)
+		go dispatchWebhooks(feed)
+	}
+	go h.deliverLoop()
+}
+
+func removeWebhook(id string) bool {
+	webhooks.Lock()
+	defer webhooks.Unlock()
+	h, ok := webhooks.byID[id]
+	if ok {
+		close(h.stop)
+		delete(webhooks.byID, id)
+	}
+	return ok
+}
+
+// dispatchWebhooks queues each change this node coordinated for the hooks
+// whose prefix it falls under.
+func dispatchWebhooks(feed <-chan Change) {
+	for c := range feed {
+		if c.Entry.Node != self {
+			continue // replicated here; its coordinator sends it
+		}
+		ev := eventOf(c)
+		webhooks.Lock()
+		for _, h := range webhooks.byID {
+			if !strings.HasPrefix(c.Key, h.Prefix) {
+				continue
+			}
+			select {
+			case h.queue <- ev:
+			default:
+				h.stats.dropped.Add(1)
+			}
+		}
+		webhooks.Unlock()
+	}
+}
+
+func (h *webhook) deliverLoop() {
+	for {
+		select {
+		case <-h.stop:
+			return
+		case ev := <-h.queue:
+			if h.deliver(ev) {
+				h.stats.delivered.Add(1)
+			} else {
+				h.stats.failed.Add(1)
+			}
+		}
+	}
+}
+
+// deliver POSTs ev, retrying with backoff; it reports whether it got through.
+func (h *webhook) deliver(ev cdcEvent) bool {
+	body, _ := json.Marshal(ev)
+	wait := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err := postWebhook(h, body)
+		if err == nil {
+			return true
+		}
+		if attempt == webhookAttempts {
+			log.Printf("webhook %s: giving up on %q after %d attempts: %v", h.ID, ev.Key, attempt, err)
+			return false
+		}
+		select {
+		case <-h.stop:
+			return false
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func postWebhook(h *webhook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookIDHeader, h.ID)
+	resp, err := callbackClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("answered %s", resp.Status)
+	}
+	return nil
+}
+
+func listWebhooks() []webhook {
+	webhooks.Lock()
+	defer webhooks.Unlock()
+	out := make([]webhook, 0, len(webhooks.byID))
+	for _, h := range webhooks.byID {
+		out = append(out, webhook{ID: h.ID, URL: h.URL, Prefix: h.Prefix,
+			Delivered: h.stats.delivered.Load(), Failed: h.stats.failed.Load(), Dropped: h.stats.dropped.Load()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// webhookHandler registers (?id=, ?url=, ?prefix=) or, with ?remove=true,
+// removes (?id=) a hook on this node for a peer running /admin/webhooks.
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("id") == "" {
+		httpError(w, http.StatusBadRequest, "id", "id required")
+		return
+	}
+	if q.Get("remove") == "true" {
+		removeWebhook(q.Get("id"))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err := checkWebhookURL(q.Get("url")); err != nil {
+		httpError(w, http.StatusBadRequest, "url", err.Error())
+		return
+	}
+	addWebhook(q.Get("id"), q.Get("url"), q.Get("prefix"))
+	w.WriteHeader(http.StatusOK)
+}
+
+func checkWebhookURL(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http(s) URL")
+	}
+	return nil
+}
+
+// webhookReport is what /admin/webhooks answers a registration or
+// removal with.
+type webhookReport struct {
+	ID       string            `json:"id"`
+	Nodes    []string          `json:"nodes"`            // nodes that applied it
+	Failed   map[string]string `json:"failed,omitempty"` // node -> error
+	Complete bool              `json:"complete"`
+}
+
+// adminWebhooksHandler lists this node's hooks (GET) or registers
+// (?url=, ?prefix=) or removes (?remove=<id>) one on every node (POST).
+func adminWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		json.NewEncoder(w).Encode(listWebhooks())
+		return
+	}
+	q := r.URL.Query()
+	peerQ := url.Values{}
+	id := q.Get("remove")
+	if id != "" {
+		peerQ.Set("remove", "true")
+	} else {
+		if err := checkWebhookURL(q.Get("url")); err != nil {
+			httpError(w, http.StatusBadRequest, "url", err.Error())
+			return
+		}
+		id = randomID()[:12]
+		peerQ.Set("url", q.Get("url"))
+		peerQ.Set("prefix", q.Get("prefix"))
+	}
+	peerQ.Set("id", id)
+
+	report := webhookReport{ID: id, Nodes: []string{self}, Failed: map[string]string{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range cluster().peers {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			err := postOK(fmt.Sprintf("http://%s/webhooks?%s", p, peerQ.Encode()), "", nil)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Failed[p] = err.Error()
+			} else {
+				report.Nodes = append(report.Nodes, p)
+			}
+		}(p)
+	}
+	if peerQ.Get("remove") == "true" {
+		removeWebhook(id)
+	} else {
+		addWebhook(id, q.Get("url"), q.Get("prefix"))
+	}
+	wg.Wait()
+	sort.Strings(report.Nodes)
+	report.Complete = len(report.Failed) == 0
+	if peerQ.Get("remove") != "true" {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookRetriesAndFilters(t *testing.T) {
+	old := webhookBackoff
+	webhookBackoff = 10 * time.Millisecond
+	defer func() { webhookBackoff = old }()
+
+	var calls atomic.Int32
+	got := make(chan cdcEvent, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable) // the first try fails
+			return
+		}
+		var ev cdcEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		if r.Header.Get(webhookIDHeader) != "h1" {
+			t.Errorf("X-Webhook-ID %q", r.Header.Get(webhookIDHeader))
+		}
+		got <- ev
+	}))
+	defer srv.Close()
+	addWebhook("h1", srv.URL, "users/")
+	defer removeWebhook("h1")
+
+	changes.publish("other", Entry{Value: "skip", Node: self})
+	changes.publish("users/1", Entry{Value: "replicated", Node: "elsewhere:1"})
+	changes.publish("users/2", Entry{Value: "alice", Timestamp: 3, Node: self})
+
+	select {
+	case ev := <-got:
+		if ev.Key != "users/2" || ev.Value != "alice" {
+			t.Fatalf("delivered %+v", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not delivered")
+	}
+	time.Sleep(50 * time.Millisecond) // for the hook to count the delivery
+	if hs := listWebhooks(); len(hs) != 1 || hs[0].Delivered != 1 || calls.Load() != 2 {
+		t.Fatalf("hooks %+v after %d calls", hs, calls.Load())
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Every coordinated write gets an ID, returned as X-Write-ID, and a record
+// of where it has got: /write_status?id= shows each replica as pending,
+// acked, failed, or skipped when a quorum write stopped before trying it.
+// That is how a client learns when a W=1 write, acknowledged before it
+// reaches the other replicas, has actually reached them all; with
+// ?callback=<url> the coordinator also POSTs the status to the URL once no
+// replica is pending. The newest writeStatusKeep writes are kept.
+//
+// /metrics counts how many copies each write had when its client was
+// answered and once it settled (kv_write_acks), which is the durability a
+// W=1 write really gets: one copy at the answer, N once the background
+// replication lands.
+
+const (
+	writeStatusKeep = 10000
+	writeIDHeader   = "X-Write-ID"
+)
+
+// Per-replica states of a tracked write.
+const (
+	replicaPending = "pending"
+	replicaAcked   = "acked"
+	replicaFailed  = "failed"
+	replicaSkipped = "skipped" // never tried: the quorum was met, or the budget spent, first, or it was down (see unreachable.go)
+)
+
+type writeStatus struct {
+	ID       string            `json:"id"`
+	Key      string            `json:"key"`
+	Started  time.Time         `json:"started"`
+	Finished *time.Time        `json:"finished,omitempty"` // once no replica is pending
+	Complete bool              `json:"complete"`           // every replica acked
+	Replicas map[string]string `json:"replicas"`           // peer -> state; the coordinator itself is acked
+	callback string
+	pending  int
+	level    string // for kv_write_acks, as writeLevel names it
+}
+
+var writes struct {
+	sync.Mutex
+	byID  map[string]*writeStatus
+	order []string // ring of the newest writeStatusKeep IDs
+	next  int
+}
+
+// callbackClient posts to client callback URLs; unlike peer requests they
+// go out without this node's protocol and identity headers.
+var callbackClient = &http.Client{Timeout: 5 * time.Second}
+
+func parseCallback(r *http.Request) (string, error) {
+	cb := r.URL.Query().Get("callback")
+	if cb == "" {
+		return "", nil
+	}
+	u, err := url.Parse(cb)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("callback must be an http(s) URL")
+	}
+	return cb, nil
+}
+
+// newWrite describes a write of key to be replicated to replicas.
+func newWrite(key string, replicas []string, callback string) *writeStatus {
+	ws := &writeStatus{
+		ID:       randomID()[:16],
+		Key:      key,
+		Replicas: map[string]string{self: replicaAcked},
+		callback: callback,
+		pending:  len(replicas),
+	}
+	for _, p := range replicas {
+		ws.Replicas[p] = replicaPending
+	}
+	return ws
+}
+
+// begin starts tracking ws, once the coordinator has applied the write.
+func (ws *writeStatus) begin() {
+	ws.Started = time.Now().UTC()
+	writes.Lock()
+	defer writes.Unlock()
+	if writes.byID == nil {
+		writes.byID = map[string]*writeStatus{}
+		writes.order = make([]string, writeStatusKeep)
+	}
+	if old := writes.order[writes.next]; old != "" {
+		delete(writes.byID, old)
+	}
+	writes.order[writes.next] = ws.ID
+	writes.next = (writes.next + 1) % writeStatusKeep
+	writes.byID[ws.ID] = ws
+	if ws.pending == 0 {
+		ws.finish()
+	}
+}
+
+// answered records how many replicas had the write when the client got
+// its answer. A write that never began (a failed precondition) is not
+// counted.
+func (ws *writeStatus) answered() {
+	writes.Lock()
+	defer writes.Unlock()
+	if !ws.Started.IsZero() {
+		observeAcks("response", ws.level, ws.acked())
+	}
+}
+
+// acked counts the replicas, the coordinator included, that have the
+// write. The caller holds writes' lock.
+func (ws *writeStatus) acked() int {
+	n := 0
+	for _, st := range ws.Replicas {
+		if st == replicaAcked {
+			n++
+		}
+	}
+	return n
+}
+
+// replicated records peer's answer to the write.
+func (ws *writeStatus) replicated(peer string, ok bool) {
+	writes.Lock()
+	defer writes.Unlock()
+	if ws.Replicas[peer] != replicaPending {
+		return
+	}
+	ws.Replicas[peer] = replicaFailed
+	if ok {
+		ws.Replicas[peer] = replicaAcked
+	}
+	if ws.pending--; ws.pending == 0 {
+		ws.finish()
+	}
+}
+
+// skip marks peer skipped, for a write whose coordinator will not try it.
+func (ws *writeStatus) skip(peer string) {
+	writes.Lock()
+	defer writes.Unlock()
+	if ws.Replicas[peer] != replicaPending {
+		return
+	}
+	ws.Replicas[peer] = replicaSkipped
+	if ws.pending--; ws.pending == 0 {
+		ws.finish()
+	}
+}
+
+// skipRest marks the replicas still pending as skipped, for a write whose
+// coordinator will not try them.
+func (ws *writeStatus) skipRest() {
+	writes.Lock()
+	defer writes.Unlock()
+	if ws.pending == 0 {
+		return
+	}
+	for p, st := range ws.Replicas {
+		if st == replicaPending {
+			ws.Replicas[p] = replicaSkipped
+		}
+	}
+	ws.pending = 0
+	ws.finish()
+}
+
+// finish marks the write settled and fires its callback. The caller holds
+// writes' lock.
+func (ws *writeStatus) finish() {
+	now := time.Now().UTC()
+	ws.Finished = &now
+	acked := ws.acked()
+	ws.Complete = acked == len(ws.Replicas)
+	observeAcks("final", ws.level, acked)
+	if ws.callback != "" {
+		go postCallback(ws.callback, ws.snapshot())
+	}
+}
+
+// replicasIn lists ws's replicas by state, sorted.
+func (ws *writeStatus) replicasIn() map[string][]string {
+	writes.Lock()
+	defer writes.Unlock()
+	out := map[string][]string{}
+	for p, st := range ws.Replicas {
+		out[st] = append(out[st], p)
+	}
+	for _, ps := range out {
+		sort.Strings(ps)
+	}
+	return out
+}
+
+// snapshot copies ws for encoding. The caller holds writes' lock.
+func (ws *writeStatus) snapshot() writeStatus {
+	cp := *ws
+	cp.Replicas = make(map[string]string, len(ws.Replicas))
+	for p, st := range ws.Replicas {
+		cp.Replicas[p] = st
+	}
+	return cp
+}
+
+func postCallback(target string, ws writeStatus) {
+	body, _ := json.Marshal(ws)
+	resp, err := callbackClient.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("write %s: callback %s: %v", ws.ID, target, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("write %s: callback %s answered %s", ws.ID, target, resp.Status)
+	}
+}
+
+func writeStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	writes.Lock()
+	ws, ok := writes.byID[id]
+	var cp writeStatus
+	if ok {
+		cp = ws.snapshot()
+	}
+	writes.Unlock()
+	if !ok {
+		httpError(w, http.StatusNotFound, "id", "unknown or expired write ID")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cp)
+}
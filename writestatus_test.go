@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAsyncWriteStatusAndCallback(t *testing.T) {
+	p1, p2 := 9123, 9124
+	a := startNode(t, p1, []string{fmt.Sprintf("localhost:%d", p2)}, true, 2, 1, 1)
+	defer a.Process.Kill()
+	b := startNode(t, p2, []string{fmt.Sprintf("localhost:%d", p1)}, false, 2, 1, 1)
+	defer b.Process.Kill()
+	waitReady(t, p1, p2)
+
+	called := make(chan writeStatus, 1)
+	cb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ws writeStatus
+		json.NewDecoder(r.Body).Decode(&ws)
+		called <- ws
+	}))
+	defer cb.Close()
+
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/set?key=k&value=v&callback=%s", p1, url.QueryEscape(cb.URL)), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	id := resp.Header.Get(writeIDHeader)
+	if resp.StatusCode != http.StatusCreated || id == "" {
+		t.Fatalf("status %d, X-Write-ID %q", resp.StatusCode, id)
+	}
+
+	status := func() (writeStatus, int) {
+		r, err := http.Get(fmt.Sprintf("http://localhost:%d/write_status?id=%s", p1, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		var ws writeStatus
+		json.NewDecoder(r.Body).Decode(&ws)
+		return ws, r.StatusCode
+	}
+	follower := fmt.Sprintf("localhost:%d", p2)
+	if ws, code := status(); code != http.StatusOK || ws.Replicas[follower] != replicaPending || ws.Finished != nil {
+		t.Fatalf("right after the write: %d %+v", code, ws)
+	}
+
+	select {
+	case ws := <-called:
+		if ws.ID != id || !ws.Complete || ws.Replicas[follower] != replicaAcked {
+			t.Fatalf("callback got %+v", ws)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no callback")
+	}
+	if ws, _ := status(); !ws.Complete {
+		t.Fatalf("after the callback: %+v", ws)
+	}
+
+	r, _ := http.Get(fmt.Sprintf("http://localhost:%d/write_status?id=nope", p1))
+	io.Copy(io.Discard, r.Body)
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown ID = %d", r.StatusCode)
+	}
+}
+
+func TestQuorumWriteStatus(t *testing.T) {
+	ws := newWrite("k", []string{"a:1", "b:1", "c:1"}, "")
+	ws.begin()
+	ws.replicated("a:1", false)
+	ws.replicated("b:1", true)
+	ws.skipRest() // W=2 met by self and b
+	ws.replicated("c:1", true)
+	writes.Lock()
+	got := writes.byID[ws.ID].snapshot()
+	writes.Unlock()
+	want := map[string]string{self: replicaAcked, "a:1": replicaFailed, "b:1": replicaAcked, "c:1": replicaSkipped}
+	if fmt.Sprint(got.Replicas) != fmt.Sprint(want) || got.Complete || got.Finished == nil {
+		t.Fatalf("got %+v", got)
+	}
+}